@@ -0,0 +1,1064 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/blob"
+
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ArchiveFormat and ArchiveVersion identify the archives written by
+// ExportArchive. ImportArchive reads archives up to ArchiveVersion.
+const (
+	ArchiveFormat  = "todo-archive"
+	ArchiveVersion = 1
+)
+
+var (
+	ErrArchiveFormat    = errors.New("not a todo archive")
+	ErrArchiveTruncated = errors.New("archive is truncated")
+)
+
+// archiveReferences lists the columns holding the rowid of another table that
+// are not named <table>_id, which refer to <table> by convention.
+var archiveReferences = map[string]map[string]string{
+	"todo":       {"parent_id": "todo", "assignee_id": "user"},
+	"comment":    {"author_id": "user"},
+	"dependency": {"blocker_id": "todo"},
+}
+
+// archiveBlobColumns hold the keys of blobs in the attachment store.
+var archiveBlobColumns = [][2]string{
+	{"attachment", "hash"},
+}
+
+// ArchiveHeader starts an archive.
+type ArchiveHeader struct {
+	Format  string    `json:"format"`
+	Version int       `json:"version"`
+	Created time.Time `json:"created"`
+}
+
+type ArchiveColumn struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// ArchiveTable describes a table: the statement creating it, its columns,
+// the first of which is its rowid, and the tables its columns refer to by
+// rowid. In a JSON archive it also holds the rows.
+type ArchiveTable struct {
+	Name       string            `json:"name"`
+	SQL        string            `json:"sql"`
+	Columns    []ArchiveColumn   `json:"columns"`
+	References map[string]string `json:"references"`
+	Rows       [][]interface{}   `json:"rows,omitempty"`
+}
+
+// ArchiveBlob is the content of a blob referred to by a table.
+type ArchiveBlob struct {
+	Key  string `json:"key"`
+	Data []byte `json:"data"`
+}
+
+// ArchiveChecksum is the number of rows of a table and the SHA-256 hash of
+// their encoding, one row per line.
+type ArchiveChecksum struct {
+	Count  int64  `json:"count"`
+	SHA256 string `json:"sha256"`
+}
+
+// ArchiveChecksums ends an archive. Blobs are checked against their keys,
+// which are hashes of their content.
+type ArchiveChecksums struct {
+	Tables map[string]*ArchiveChecksum `json:"tables"`
+	Blobs  int64                       `json:"blobs"`
+}
+
+// archiveBytes encodes a BLOB value, which JSON cannot tell from TEXT.
+type archiveBytes struct {
+	Base64 []byte `json:"base64"`
+}
+
+// archiveFloat encodes a REAL value so that it is not read back as an
+// INTEGER.
+type archiveFloat float64
+
+func (r archiveFloat) MarshalJSON() ([]byte, error) {
+	data, err := json.Marshal(float64(r))
+	if err == nil && !bytes.ContainsAny(data, ".eE") {
+		data = append(data, ".0"...)
+	}
+	return data, err
+}
+
+// archiveRow returns the encoding of a row, which its table checksum is
+// computed over.
+func archiveRow(values []interface{}) ([]byte, error) {
+	encoded := make([]interface{}, len(values))
+	for i, value := range values {
+		switch v := value.(type) {
+		case float64:
+			encoded[i] = archiveFloat(v)
+		case []byte:
+			encoded[i] = &archiveBytes{v}
+		default:
+			encoded[i] = v
+		}
+	}
+	return json.Marshal(encoded)
+}
+
+// archiveValues converts the values of a decoded row back to those of the
+// database.
+func archiveValues(values []interface{}) ([]interface{}, error) {
+	result := make([]interface{}, len(values))
+	for i, value := range values {
+		switch v := value.(type) {
+		case json.Number:
+			if !strings.ContainsAny(string(v), ".eE") {
+				n, err := v.Int64()
+				if err != nil {
+					return nil, err
+				}
+				result[i] = n
+			} else {
+				f, err := v.Float64()
+				if err != nil {
+					return nil, err
+				}
+				result[i] = f
+			}
+		case map[string]interface{}:
+			s, ok := v["base64"].(string)
+			if !ok || len(v) != 1 {
+				return nil, fmt.Errorf("invalid value %v", v)
+			}
+			data, err := base64.StdEncoding.DecodeString(s)
+			if err != nil {
+				return nil, err
+			}
+			result[i] = data
+		case nil, string:
+			result[i] = v
+		default:
+			return nil, fmt.Errorf("invalid value %v", v)
+		}
+	}
+	return result, nil
+}
+
+func quoteIdentifier(name string) string {
+	return `"` + strings.Replace(name, `"`, `""`, -1) + `"`
+}
+
+// singleStatement reports whether stmt holds one SQL statement, optionally
+// ended by a semicolon. Semicolons in quoted strings, identifiers and
+// comments are not statement separators.
+func singleStatement(stmt string) bool {
+	stmt = strings.TrimRight(stmt, " \t\r\n")
+
+	for i := 0; i < len(stmt); i++ {
+		switch c := stmt[i]; {
+		case c == '\'' || c == '"' || c == '`' || c == '[':
+			end := c
+			if c == '[' {
+				end = ']'
+			}
+			j := strings.IndexByte(stmt[i+1:], end)
+			if j < 0 {
+				return false
+			}
+			i += j + 1
+		case c == '-' && strings.HasPrefix(stmt[i:], "--"):
+			j := strings.IndexByte(stmt[i:], '\n')
+			if j < 0 {
+				return true
+			}
+			i += j
+		case c == '/' && strings.HasPrefix(stmt[i:], "/*"):
+			j := strings.Index(stmt[i+2:], "*/")
+			if j < 0 {
+				return false
+			}
+			i += j + 3
+		case c == ';':
+			return i == len(stmt)-1
+		}
+	}
+
+	return true
+}
+
+// archiveTables describes the tables of the database, each after the tables
+// it refers to so that an import rarely needs to defer references.
+func archiveTables(tx *sql.Tx) ([]*ArchiveTable, error) {
+	rows, err := tx.Query("SELECT name, sql FROM sqlite_master WHERE type = 'table' AND name NOT LIKE 'sqlite_%' ORDER BY name")
+	if err != nil {
+		return nil, err
+	}
+
+	tables := map[string]*ArchiveTable{}
+	names := []string{}
+	for rows.Next() {
+		t := &ArchiveTable{References: map[string]string{}}
+		if err = rows.Scan(&t.Name, &t.SQL); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		tables[t.Name] = t
+		names = append(names, t.Name)
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for _, t := range tables {
+		if t.Columns, err = tableColumns(tx, t.Name); err != nil {
+			return nil, err
+		}
+
+		for _, c := range t.Columns[1:] {
+			if table, ok := archiveReferences[t.Name][c.Name]; ok {
+				t.References[c.Name] = table
+			} else if table := strings.TrimSuffix(c.Name, "_id"); table != c.Name && tables[table] != nil {
+				t.References[c.Name] = table
+			}
+		}
+	}
+
+	result := []*ArchiveTable{}
+	visited := map[string]bool{}
+
+	var visit func(name string)
+	visit = func(name string) {
+		if visited[name] {
+			return
+		}
+		visited[name] = true
+
+		t := tables[name]
+		targets := []string{}
+		for _, target := range t.References {
+			targets = append(targets, target)
+		}
+		sort.Strings(targets)
+
+		for _, target := range targets {
+			visit(target)
+		}
+		result = append(result, t)
+	}
+
+	for _, name := range names {
+		visit(name)
+	}
+
+	return result, nil
+}
+
+// tableColumns returns the rowid and the columns of a table, or nil when it
+// does not exist.
+func tableColumns(tx *sql.Tx, table string) ([]ArchiveColumn, error) {
+	rows, err := tx.Query("PRAGMA table_info(" + quoteIdentifier(table) + ")")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns := []ArchiveColumn{{"rowid", "INTEGER"}}
+	for rows.Next() {
+		var cid, notNull, pk int
+		var dflt interface{}
+		var c ArchiveColumn
+		if err = rows.Scan(&cid, &c.Name, &c.Type, &notNull, &dflt, &pk); err != nil {
+			return nil, err
+		}
+		columns = append(columns, c)
+	}
+
+	if len(columns) == 1 {
+		return nil, rows.Err()
+	}
+
+	return columns, rows.Err()
+}
+
+// archiveWriter writes the records of an archive.
+type archiveWriter interface {
+	Header(h *ArchiveHeader) error
+	Table(t *ArchiveTable) error
+	Row(table string, row []byte) error
+	Blob(b *ArchiveBlob) error
+	Checksums(c *ArchiveChecksums) error
+}
+
+// ndjsonArchiveWriter writes one record per line, each with a type of
+// header, table, row, blob or checksums.
+type ndjsonArchiveWriter struct {
+	w io.Writer
+}
+
+func (r *ndjsonArchiveWriter) write(record interface{}) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = r.w.Write(append(data, '\n'))
+	return err
+}
+
+func (r *ndjsonArchiveWriter) Header(h *ArchiveHeader) error {
+	return r.write(struct {
+		Type string `json:"type"`
+		*ArchiveHeader
+	}{"header", h})
+}
+
+func (r *ndjsonArchiveWriter) Table(t *ArchiveTable) error {
+	return r.write(struct {
+		Type string `json:"type"`
+		*ArchiveTable
+	}{"table", t})
+}
+
+func (r *ndjsonArchiveWriter) Row(table string, row []byte) error {
+	return r.write(struct {
+		Type   string          `json:"type"`
+		Table  string          `json:"table"`
+		Values json.RawMessage `json:"values"`
+	}{"row", table, row})
+}
+
+func (r *ndjsonArchiveWriter) Blob(b *ArchiveBlob) error {
+	return r.write(struct {
+		Type string `json:"type"`
+		*ArchiveBlob
+	}{"blob", b})
+}
+
+func (r *ndjsonArchiveWriter) Checksums(c *ArchiveChecksums) error {
+	return r.write(struct {
+		Type string `json:"type"`
+		*ArchiveChecksums
+	}{"checksums", c})
+}
+
+// jsonArchive is a JSON archive: the header fields, the tables with their
+// rows, the blobs and the checksums.
+type jsonArchive struct {
+	ArchiveHeader
+	Tables    []*ArchiveTable   `json:"tables"`
+	Blobs     []*ArchiveBlob    `json:"blobs"`
+	Checksums *ArchiveChecksums `json:"checksums"`
+}
+
+// jsonArchiveWriter streams a jsonArchive, one row or blob per line.
+type jsonArchiveWriter struct {
+	w      io.Writer
+	tables int
+	rows   int
+	blobs  int
+	// open is the array being written, tables or blobs.
+	open string
+}
+
+func (r *jsonArchiveWriter) write(s string) error {
+	_, err := io.WriteString(r.w, s)
+	return err
+}
+
+// writeOpen writes the encoding of v without its closing brace, to be
+// followed by more fields.
+func (r *jsonArchiveWriter) writeOpen(prefix string, v interface{}, suffix string) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return r.write(prefix + string(data[:len(data)-1]) + suffix)
+}
+
+// close ends the array being written.
+func (r *jsonArchiveWriter) close() error {
+	end := ""
+	switch {
+	case r.open == "tables" && r.tables > 0:
+		end = "]}\n],\n"
+	case r.open == "tables":
+		end = "],\n"
+	case r.open == "blobs":
+		end = "],\n"
+	}
+	r.open = ""
+	return r.write(end)
+}
+
+func (r *jsonArchiveWriter) Header(h *ArchiveHeader) error {
+	r.open = "tables"
+	return r.writeOpen("", h, `,"tables":[`+"\n")
+}
+
+func (r *jsonArchiveWriter) Table(t *ArchiveTable) error {
+	prefix := ""
+	if r.tables > 0 {
+		prefix = "]},\n"
+	}
+	r.tables++
+	r.rows = 0
+	return r.writeOpen(prefix, t, `,"rows":[`+"\n")
+}
+
+func (r *jsonArchiveWriter) Row(table string, row []byte) error {
+	prefix := ""
+	if r.rows > 0 {
+		prefix = ",\n"
+	}
+	r.rows++
+	return r.write(prefix + string(row))
+}
+
+func (r *jsonArchiveWriter) Blob(b *ArchiveBlob) error {
+	prefix := ",\n"
+	if r.open != "blobs" {
+		if err := r.close(); err != nil {
+			return err
+		}
+		r.open = "blobs"
+		prefix = `"blobs":[` + "\n"
+	}
+
+	data, err := json.Marshal(b)
+	if err != nil {
+		return err
+	}
+	return r.write(prefix + string(data))
+}
+
+func (r *jsonArchiveWriter) Checksums(c *ArchiveChecksums) error {
+	if err := r.close(); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+	return r.write(`"checksums":` + string(data) + "}\n")
+}
+
+// ExportArchive writes every table of db, whatever its schema, and the blobs
+// of store its rows refer to as a JSON or, with ndjson set, an NDJSON
+// archive. The tables are read in one transaction so that the archive is
+// consistent while the server is running.
+func ExportArchive(db *sql.DB, store blob.Store, w io.Writer, ndjson bool) error {
+	bw := bufio.NewWriter(w)
+
+	var aw archiveWriter = &jsonArchiveWriter{w: bw}
+	if ndjson {
+		aw = &ndjsonArchiveWriter{bw}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	tables, err := archiveTables(tx)
+	if err != nil {
+		return err
+	}
+
+	if err = aw.Header(&ArchiveHeader{ArchiveFormat, ArchiveVersion, time.Now().UTC()}); err != nil {
+		return err
+	}
+
+	checksums := &ArchiveChecksums{Tables: map[string]*ArchiveChecksum{}}
+	blobs := map[string]bool{}
+
+	for _, t := range tables {
+		if err = aw.Table(t); err != nil {
+			return err
+		}
+
+		if checksums.Tables[t.Name], err = exportTable(tx, t, aw, blobs); err != nil {
+			return err
+		}
+	}
+
+	keys := []string{}
+	for key := range blobs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		data, err := readBlob(store, key)
+		if err == blob.ErrNotFound {
+			continue
+		} else if err != nil {
+			return err
+		}
+
+		if err = aw.Blob(&ArchiveBlob{key, data}); err != nil {
+			return err
+		}
+		checksums.Blobs++
+	}
+
+	if err = aw.Checksums(checksums); err != nil {
+		return err
+	}
+
+	return bw.Flush()
+}
+
+func readBlob(store blob.Store, key string) ([]byte, error) {
+	rc, err := store.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
+
+// exportTable writes the rows of t, adding the keys of the blobs they refer
+// to to blobs. Values are selected through likely(), which returns its
+// argument, so that the driver does not convert TIMESTAMP and BOOLEAN
+// columns but returns them as stored.
+func exportTable(tx *sql.Tx, t *ArchiveTable, aw archiveWriter, blobs map[string]bool) (*ArchiveChecksum, error) {
+	selected := []string{}
+	blobColumns := map[int]bool{}
+	for i, c := range t.Columns {
+		name := quoteIdentifier(c.Name)
+		if i == 0 {
+			name = "rowid"
+		}
+		selected = append(selected, "likely("+name+")", "typeof("+name+")")
+
+		for _, bc := range archiveBlobColumns {
+			if bc[0] == t.Name && bc[1] == c.Name {
+				blobColumns[i] = true
+			}
+		}
+	}
+
+	rows, err := tx.Query("SELECT " + strings.Join(selected, ", ") + " FROM " + quoteIdentifier(t.Name) + " ORDER BY rowid")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	checksum := &ArchiveChecksum{}
+	h := sha256.New()
+
+	for rows.Next() {
+		raw := make([]interface{}, 2*len(t.Columns))
+		dest := make([]interface{}, len(raw))
+		for i := range raw {
+			dest[i] = &raw[i]
+		}
+
+		if err = rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+
+		values := make([]interface{}, len(t.Columns))
+		for i := range values {
+			values[i] = raw[2*i]
+			if string(asBytes(raw[2*i+1])) == "text" {
+				values[i] = string(asBytes(values[i]))
+			}
+
+			if s, ok := values[i].(string); ok && blobColumns[i] && s != "" {
+				blobs[s] = true
+			}
+		}
+
+		row, err := archiveRow(values)
+		if err != nil {
+			return nil, err
+		}
+
+		if err = aw.Row(t.Name, row); err != nil {
+			return nil, err
+		}
+
+		h.Write(append(row, '\n'))
+		checksum.Count++
+	}
+
+	checksum.SHA256 = hex.EncodeToString(h.Sum(nil))
+
+	return checksum, rows.Err()
+}
+
+func asBytes(v interface{}) []byte {
+	switch v := v.(type) {
+	case []byte:
+		return v
+	case string:
+		return []byte(v)
+	}
+	return nil
+}
+
+// ArchiveSummary counts what an import restored.
+type ArchiveSummary struct {
+	Tables map[string]int64 `json:"tables"`
+	Blobs  int64            `json:"blobs"`
+	// Dangling is the number of references to rows missing from the
+	// archive, which are set to NULL.
+	Dangling int64 `json:"dangling"`
+}
+
+// deferredReference is a reference to a table that had not been imported
+// when the referring row was.
+type deferredReference struct {
+	table  string
+	rowid  int64
+	column string
+	target string
+	id     int64
+}
+
+type importTable struct {
+	*ArchiveTable
+	stmt   *sql.Stmt
+	hash   hash.Hash
+	count  int64
+	column map[string]int
+}
+
+// archiveImporter adds the records of an archive to a database in a
+// transaction. Rows get new rowids and the references to them are remapped.
+type archiveImporter struct {
+	tx       *sql.Tx
+	store    blob.Store
+	header   bool
+	current  *importTable
+	tables   map[string]*importTable
+	ids      map[string]map[int64]int64
+	done     map[string]bool
+	deferred []*deferredReference
+	summary  *ArchiveSummary
+	// stored are the keys of the blobs added to store, which are removed
+	// again when the import fails.
+	stored []string
+}
+
+func (r *archiveImporter) Header(h *ArchiveHeader) error {
+	if h.Format != ArchiveFormat {
+		return ErrArchiveFormat
+	}
+	if h.Version < 1 || h.Version > ArchiveVersion {
+		return fmt.Errorf("unsupported archive version %d, expected at most %d", h.Version, ArchiveVersion)
+	}
+	r.header = true
+	return nil
+}
+
+func (r *archiveImporter) finishTable() {
+	if r.current != nil {
+		r.done[r.current.Name] = true
+		r.current.stmt.Close()
+		r.current = nil
+	}
+}
+
+// Table prepares the import of the rows of t, creating the table when the
+// database does not have it. Every column of the archive must exist.
+func (r *archiveImporter) Table(t *ArchiveTable) error {
+	if !r.header {
+		return ErrArchiveFormat
+	}
+
+	r.finishTable()
+
+	if _, ok := r.tables[t.Name]; ok {
+		return fmt.Errorf("table %s: archived twice", t.Name)
+	}
+
+	if len(t.Columns) == 0 || t.Columns[0].Name != "rowid" {
+		return fmt.Errorf("table %s: the first column must be the rowid", t.Name)
+	}
+
+	columns, err := tableColumns(r.tx, t.Name)
+	if err != nil {
+		return err
+	}
+
+	if columns == nil {
+		if !strings.HasPrefix(strings.ToUpper(t.SQL), "CREATE TABLE ") || !singleStatement(t.SQL) {
+			return fmt.Errorf("table %s: invalid statement %q", t.Name, t.SQL)
+		}
+		if _, err = r.tx.Exec(t.SQL); err != nil {
+			return fmt.Errorf("table %s: %s", t.Name, err)
+		}
+		if columns, err = tableColumns(r.tx, t.Name); err != nil {
+			return err
+		}
+	}
+
+	existing := map[string]bool{}
+	for _, c := range columns {
+		existing[c.Name] = true
+	}
+
+	it := &importTable{ArchiveTable: t, hash: sha256.New(), column: map[string]int{}}
+	names, bindvars := []string{}, []string{}
+	for i, c := range t.Columns {
+		if !existing[c.Name] {
+			return fmt.Errorf("table %s: unknown column %s", t.Name, c.Name)
+		}
+
+		it.column[c.Name] = i
+
+		if i > 0 {
+			names = append(names, quoteIdentifier(c.Name))
+			bindvars = append(bindvars, "?")
+		}
+	}
+
+	if it.stmt, err = r.tx.Prepare(fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", quoteIdentifier(t.Name), strings.Join(names, ", "), strings.Join(bindvars, ", "))); err != nil {
+		return fmt.Errorf("table %s: %s", t.Name, err)
+	}
+
+	r.tables[t.Name] = it
+	r.ids[t.Name] = map[int64]int64{}
+	r.current = it
+
+	for _, row := range t.Rows {
+		if err = r.Row(t.Name, row); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Row inserts a row of the current table. References to tables already
+// imported are remapped; the others are set once their table is.
+func (r *archiveImporter) Row(table string, row []interface{}) error {
+	t := r.current
+	if t == nil || t.Name != table {
+		return fmt.Errorf("table %s: row outside of its table", table)
+	}
+
+	data, err := archiveRow(row)
+	if err == nil {
+		row, err = archiveValues(row)
+	}
+	if err == nil && len(row) != len(t.Columns) {
+		err = fmt.Errorf("%d values for %d columns", len(row), len(t.Columns))
+	}
+	if err != nil {
+		return fmt.Errorf("table %s row %d: %s", table, t.count+1, err)
+	}
+
+	t.hash.Write(append(data, '\n'))
+	t.count++
+
+	rowid, ok := row[0].(int64)
+	if !ok {
+		return fmt.Errorf("table %s row %d: invalid rowid %v", table, t.count, row[0])
+	}
+
+	deferred := []*deferredReference{}
+	for column, target := range t.References {
+		i, ok := t.column[column]
+		if !ok {
+			continue
+		}
+
+		id, ok := row[i].(int64)
+		if !ok {
+			continue
+		}
+
+		if r.done[target] {
+			row[i] = r.remap(target, id)
+		} else {
+			row[i] = nil
+			deferred = append(deferred, &deferredReference{table: table, column: column, target: target, id: id})
+		}
+	}
+
+	result, err := t.stmt.Exec(row[1:]...)
+	if err != nil {
+		return fmt.Errorf("table %s row %d: %s", table, t.count, err)
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return err
+	}
+	r.ids[table][rowid] = id
+
+	for _, d := range deferred {
+		d.rowid = id
+	}
+	r.deferred = append(r.deferred, deferred...)
+
+	return nil
+}
+
+// remap returns the new rowid of a row of table, or nil when the archive
+// does not hold it.
+func (r *archiveImporter) remap(table string, id int64) interface{} {
+	if newID, ok := r.ids[table][id]; ok {
+		return newID
+	}
+	r.summary.Dangling++
+	return nil
+}
+
+// Blob stores a blob after checking its content against its key.
+func (r *archiveImporter) Blob(b *ArchiveBlob) error {
+	r.finishTable()
+
+	sum := sha256.Sum256(b.Data)
+	if hex.EncodeToString(sum[:]) != b.Key {
+		return fmt.Errorf("blob %s: checksum mismatch", b.Key)
+	}
+
+	exists, err := r.store.Exists(b.Key)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		if err = r.store.Put(b.Key, bytes.NewReader(b.Data)); err != nil {
+			return err
+		}
+		r.stored = append(r.stored, b.Key)
+	}
+
+	r.summary.Blobs++
+	return nil
+}
+
+// Checksums verifies the tables and blobs read against c and sets the
+// deferred references.
+func (r *archiveImporter) Checksums(c *ArchiveChecksums) error {
+	r.finishTable()
+
+	if !r.header {
+		return ErrArchiveFormat
+	}
+
+	for name, t := range r.tables {
+		checksum, ok := c.Tables[name]
+		if !ok {
+			return fmt.Errorf("table %s: missing checksum", name)
+		}
+		if t.count != checksum.Count {
+			return fmt.Errorf("table %s: %d rows, expected %d", name, t.count, checksum.Count)
+		}
+		if hex.EncodeToString(t.hash.Sum(nil)) != checksum.SHA256 {
+			return fmt.Errorf("table %s: checksum mismatch", name)
+		}
+		r.summary.Tables[name] = t.count
+	}
+
+	for name := range c.Tables {
+		if _, ok := r.tables[name]; !ok {
+			return fmt.Errorf("table %s: %s", name, ErrArchiveTruncated)
+		}
+	}
+
+	if r.summary.Blobs != c.Blobs {
+		return fmt.Errorf("%d blobs, expected %d", r.summary.Blobs, c.Blobs)
+	}
+
+	for _, d := range r.deferred {
+		id := r.remap(d.target, d.id)
+		if id == nil {
+			continue
+		}
+
+		stmt := fmt.Sprintf("UPDATE %s SET %s = ? WHERE rowid = ?", quoteIdentifier(d.table), quoteIdentifier(d.column))
+		if _, err := r.tx.Exec(stmt, id, d.rowid); err != nil {
+			return fmt.Errorf("table %s: %s", d.table, err)
+		}
+	}
+
+	return nil
+}
+
+// ImportArchive adds the tables and blobs of an archive written by
+// ExportArchive, in either format, to db and store. Rows get new rowids and
+// the references between them are remapped, so an archive can be imported
+// into a database that already holds todos. Nothing is imported unless every
+// table matches its checksum; blobs already stored are kept and the blobs
+// added by a failed import are removed.
+func ImportArchive(db *sql.DB, store blob.Store, r io.Reader) (summary *ArchiveSummary, err error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	importer := &archiveImporter{
+		tx:      tx,
+		store:   store,
+		tables:  map[string]*importTable{},
+		ids:     map[string]map[int64]int64{},
+		done:    map[string]bool{},
+		summary: &ArchiveSummary{Tables: map[string]int64{}},
+	}
+	defer importer.finishTable()
+
+	defer func() {
+		if err == nil {
+			return
+		}
+		for _, key := range importer.stored {
+			if e := store.Delete(key); e != nil {
+				log.Printf("ERROR: removing blob %s of a failed import: %s", key, e)
+			}
+		}
+	}()
+
+	decoder := json.NewDecoder(r)
+	decoder.UseNumber()
+
+	var first json.RawMessage
+	if err = decoder.Decode(&first); err != nil {
+		return nil, ErrArchiveFormat
+	}
+
+	var record struct {
+		Type string `json:"type"`
+	}
+	if err = json.Unmarshal(first, &record); err != nil {
+		return nil, ErrArchiveFormat
+	}
+
+	if record.Type == "header" {
+		err = importNDJSON(importer, first, decoder)
+	} else {
+		err = importJSON(importer, first)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return importer.summary, nil
+}
+
+func importJSON(importer *archiveImporter, data json.RawMessage) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	archive := &jsonArchive{}
+	if err := decoder.Decode(archive); err != nil {
+		return err
+	}
+
+	if err := importer.Header(&archive.ArchiveHeader); err != nil {
+		return err
+	}
+
+	for _, t := range archive.Tables {
+		if err := importer.Table(t); err != nil {
+			return err
+		}
+	}
+
+	for _, b := range archive.Blobs {
+		if err := importer.Blob(b); err != nil {
+			return err
+		}
+	}
+
+	if archive.Checksums == nil {
+		return ErrArchiveTruncated
+	}
+
+	return importer.Checksums(archive.Checksums)
+}
+
+func importNDJSON(importer *archiveImporter, first json.RawMessage, decoder *json.Decoder) error {
+	for data := first; ; {
+		var record struct {
+			Type   string        `json:"type"`
+			Table  string        `json:"table"`
+			Values []interface{} `json:"values"`
+		}
+
+		unmarshal := func(v interface{}) error {
+			d := json.NewDecoder(bytes.NewReader(data))
+			d.UseNumber()
+			return d.Decode(v)
+		}
+
+		if err := unmarshal(&record); err != nil {
+			return err
+		}
+
+		var err error
+		switch record.Type {
+		case "header":
+			h := &ArchiveHeader{}
+			if err = unmarshal(h); err == nil {
+				err = importer.Header(h)
+			}
+		case "table":
+			t := &ArchiveTable{}
+			if err = unmarshal(t); err == nil {
+				err = importer.Table(t)
+			}
+		case "row":
+			err = importer.Row(record.Table, record.Values)
+		case "blob":
+			b := &ArchiveBlob{}
+			if err = unmarshal(b); err == nil {
+				err = importer.Blob(b)
+			}
+		case "checksums":
+			c := &ArchiveChecksums{}
+			if err = unmarshal(c); err == nil {
+				err = importer.Checksums(c)
+			}
+			if err == nil && decoder.More() {
+				err = errors.New("records after checksums")
+			}
+			return err
+		default:
+			err = fmt.Errorf("unknown record type %q", record.Type)
+		}
+
+		if err != nil {
+			return err
+		}
+
+		data = nil
+		if err = decoder.Decode(&data); err == io.EOF {
+			return ErrArchiveTruncated
+		} else if err != nil {
+			return err
+		}
+	}
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/apierror"
+
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// requireAdmin checks the bearer token of an admin request against
+// Config.AdminToken.
+func (r *Handler) requireAdmin(req *http.Request) *apierror.Error {
+	if r.Config.AdminToken == "" {
+		return &apierror.Error{Code: http.StatusForbidden, Message: "Admin endpoints are disabled"}
+	}
+
+	token := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(r.Config.AdminToken)) != 1 {
+		return &apierror.Error{Code: http.StatusUnauthorized, Message: "Invalid admin token"}
+	}
+
+	return nil
+}
+
+// AdminExportFunc writes an archive of the whole database and its
+// attachments, as JSON or, with format=ndjson, NDJSON.
+func (r *Handler) AdminExportFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		if ae := r.requireAdmin(req); ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		format := req.URL.Query().Get("format")
+		if format == "" {
+			format = "json"
+		}
+
+		if format != "json" && format != "ndjson" {
+			writeError(w, &apierror.Error{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid query parameters",
+				Errors: []*apierror.ErrorDetail{
+					&apierror.ErrorDetail{Key: "format", Value: format, Message: "value must be json or ndjson"},
+				},
+			})
+			return
+		}
+
+		contentType := "application/json"
+		if format == "ndjson" {
+			contentType = "application/x-ndjson"
+		}
+
+		w.Header().Set("Content-Type", contentType)
+		w.Header().Set("Content-Disposition", `attachment; filename="todo-archive.`+format+`"`)
+		w.WriteHeader(http.StatusOK)
+
+		if err := ExportArchive(r.TM.Database, r.AM.Store, w, format == "ndjson"); err != nil {
+			log.Printf("ERROR: writing archive: %s", err)
+		}
+	}
+}
+
+// AdminImportFunc adds the tables and attachments of an archive in either
+// format to the database and responds with an ArchiveSummary.
+func (r *Handler) AdminImportFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		if ae := r.requireAdmin(req); ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		summary, err := ImportArchive(r.TM.Database, r.AM.Store, req.Body)
+		if err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusBadRequest, Message: "Invalid archive: " + err.Error()})
+			return
+		}
+
+		if err = r.LoadFields(); err != nil {
+			log.Printf("ERROR: loading custom fields: %s", err)
+		}
+
+		writeJSON(w, http.StatusOK, summary)
+	}
+}
@@ -0,0 +1,216 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/blob"
+
+	"github.com/davecgh/go-spew/spew"
+
+	"bytes"
+	"database/sql"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestArchive(t *testing.T) {
+	dir, err := ioutil.TempDir("", "archive")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	open := func(name string) (*sql.DB, *Handler) {
+		db, err := OpenDB(filepath.Join(dir, name+".db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		config := DefaultConfig()
+		config.AttachmentDir = filepath.Join(dir, name)
+		return db, NewHandler(NewManager(db, config), config)
+	}
+
+	db, h := open("source")
+	defer db.Close()
+
+	alice, err := h.UM.Create(map[string]interface{}{"name": "alice"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	roof, err := h.PM.Create(map[string]interface{}{"name": "roof"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = h.FM.Create(map[string]interface{}{"name": "points", "type": "number"}); err != nil {
+		t.Fatal(err)
+	}
+
+	due := time.Date(2019, 11, 20, 9, 30, 0, 0, time.UTC)
+	parent, err := h.TM.Create(TodoMap{"desc": "Fix roof", "state": "todo", "due": due.Format(time.RFC3339), "project_id": roof.ID,
+		"assignee_id": alice.ID, "reminders": []interface{}{int64(3600)}, "custom_fields": map[string]interface{}{"points": 2.0}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child, err := h.TM.Create(TodoMap{"desc": "Buy tiles", "state": "done", "parent_id": parent.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	blocked, err := h.TM.Create(TodoMap{"desc": "Paint ceiling", "state": "todo", "all_day": true, "due": "2019-11-25"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = h.TM.AddDependency(blocked.ID, parent.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = h.CM.Create(parent.ID, &alice.ID, map[string]interface{}{"body": "Needs a ladder"}); err != nil {
+		t.Fatal(err)
+	}
+
+	quote, err := h.AM.Create(parent.ID, "quote.txt", strings.NewReader("120 tiles"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ndjson := range []bool{false, true} {
+		var archive bytes.Buffer
+		if err = ExportArchive(db, h.AM.Store, &archive, ndjson); err != nil {
+			t.Fatal(err)
+		}
+
+		name := "json"
+		if ndjson {
+			name = "ndjson"
+		}
+
+		target, th := open(name)
+		defer target.Close()
+
+		if _, err = th.UM.Create(map[string]interface{}{"name": "bob"}); err != nil {
+			t.Fatal(err)
+		}
+		GenerateTodos(3, th.TM)
+
+		broken := bytes.Replace(archive.Bytes(), []byte("Fix roof"), []byte("Fix door"), 1)
+		if _, err = ImportArchive(target, th.AM.Store, bytes.NewReader(broken)); err == nil || !strings.Contains(err.Error(), "table todo: checksum mismatch") {
+			t.Errorf("%s: tampered archive imported: %v", name, err)
+		}
+
+		truncated := archive.Bytes()[:bytes.LastIndex(bytes.TrimSpace(archive.Bytes()), []byte("\n"))+1]
+		if _, err = ImportArchive(target, th.AM.Store, bytes.NewReader(truncated)); err == nil {
+			t.Errorf("%s: truncated archive imported", name)
+		}
+
+		newer := bytes.Replace(archive.Bytes(), []byte(`"version":1`), []byte(`"version":2`), 1)
+		if _, err = ImportArchive(target, th.AM.Store, bytes.NewReader(newer)); err == nil || !strings.Contains(err.Error(), "unsupported archive version 2") {
+			t.Errorf("%s: newer archive imported: %v", name, err)
+		}
+
+		if list := filterTodoWithURLString(t, th.TM, "/"); len(list) != 3 {
+			t.Fatalf("%s: failed imports left %d todos", name, len(list))
+		}
+
+		if exists, err := th.AM.Store.Exists(quote.Hash); err != nil || exists {
+			t.Errorf("%s: failed imports left blob %s: %v", name, quote.Hash, err)
+		}
+
+		summary, err := ImportArchive(target, th.AM.Store, bytes.NewReader(archive.Bytes()))
+		if err != nil {
+			t.Fatalf("%s: %s\n%s", name, err, archive.String())
+		}
+
+		if summary.Tables["todo"] != 3 || summary.Tables["user"] != 1 || summary.Tables["dependency"] != 1 || summary.Blobs != 1 || summary.Dangling != 0 {
+			t.Errorf("%s: %s", name, spew.Sdump(summary))
+		}
+
+		if err = th.LoadFields(); err != nil {
+			t.Fatal(err)
+		}
+
+		imported := map[string]*Todo{}
+		for _, todo := range filterTodoWithURLString(t, th.TM, "/") {
+			imported[todo.Description] = todo
+		}
+
+		p, c, b := imported["Fix roof"], imported["Buy tiles"], imported["Paint ceiling"]
+		if p == nil || c == nil || b == nil || p.ID == parent.ID {
+			t.Fatalf("%s: %s", name, spew.Sdump(imported))
+		}
+
+		if p, err = th.TM.Get(p.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		project, err := th.PM.Get(*p.ProjectID)
+		if err != nil || project.Name != "roof" {
+			t.Errorf("%s: project %s", name, spew.Sdump(project))
+		}
+
+		assignee, err := th.UM.Get(*p.AssigneeID)
+		if err != nil || assignee.Name != "alice" {
+			t.Errorf("%s: assignee %s", name, spew.Sdump(assignee))
+		}
+
+		if !p.Due.Equal(due) || !p.Created.Equal(*parent.Created) || p.CustomFields["points"] != 2.0 || len(p.Reminders) != 1 || p.Reminders[0] != 3600 {
+			t.Errorf("%s: %s", name, spew.Sdump(p))
+		}
+
+		if c.ParentID == nil || *c.ParentID != p.ID || c.State != child.State || !c.Completed.Equal(*child.Completed) {
+			t.Errorf("%s: child %s", name, spew.Sdump(c))
+		}
+
+		if b, err = th.TM.Get(b.ID); err != nil || !b.AllDay || len(b.BlockedBy) != 1 || b.BlockedBy[0] != p.ID {
+			t.Errorf("%s: blocked %s", name, spew.Sdump(b))
+		}
+
+		var author int64
+		if err = target.QueryRow("SELECT author_id FROM comment WHERE todo_id = ?", p.ID).Scan(&author); err != nil || author != assignee.ID {
+			t.Errorf("%s: comment author %d %v", name, author, err)
+		}
+
+		attachments, err := th.AM.List(p.ID)
+		if err != nil || len(attachments) != 1 {
+			t.Fatalf("%s: attachments %s", name, spew.Sdump(attachments))
+		}
+
+		if data, err := readBlob(th.AM.Store, attachments[0].Hash); err != nil || string(data) != "120 tiles" {
+			t.Errorf("%s: attachment %q %v", name, data, err)
+		}
+
+		if _, err = ImportArchive(target, blob.NewFileStore(dir), bytes.NewReader(archive.Bytes())); err == nil {
+			t.Errorf("%s: imported the same users twice", name)
+		}
+	}
+}
+
+func TestSingleStatement(t *testing.T) {
+	tests := []struct {
+		stmt     string
+		expected bool
+	}{
+		{"CREATE TABLE a (b TEXT)", true},
+		{"CREATE TABLE a (b TEXT);\n", true},
+		{"CREATE TABLE a (b TEXT DEFAULT ';')", true},
+		{"CREATE TABLE \"a;b\" (c)", true},
+		{"CREATE TABLE a (b) -- comment; with a semicolon", true},
+		{"CREATE TABLE a (b); DROP TABLE todo", false},
+		{"CREATE TABLE a (b);;", false},
+		{"CREATE TABLE a (b) -- '\n; DROP TABLE todo; -- '", false},
+		{"CREATE TABLE a (b) /* ' */; DROP TABLE todo", false},
+		{"CREATE TABLE a (b DEFAULT 'unterminated)", false},
+	}
+
+	for _, test := range tests {
+		if actual := singleStatement(test.stmt); actual != test.expected {
+			t.Errorf("%q: %t != %t", test.stmt, actual, test.expected)
+		}
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/blob"
+
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+var (
+	ErrAttachmentTooLarge = errors.New("attachment exceeds the maximum size")
+	ErrAttachmentType     = errors.New("attachment type is not allowed")
+)
+
+const attachmentColumns = "rowid, todo_id, name, content_type, size, hash, created"
+
+type Attachment struct {
+	ID          int64     `db:"id" json:"id"`
+	TodoID      int64     `db:"todo_id" json:"todo_id"`
+	Name        string    `db:"name" json:"name"`
+	ContentType string    `db:"content_type" json:"content_type"`
+	Size        int64     `db:"size" json:"size"`
+	Hash        string    `db:"hash" json:"hash"`
+	Created     time.Time `db:"created" json:"created"`
+}
+
+type AttachmentList []*Attachment
+
+// AttachmentManager stores attachment metadata in the database and content in
+// Store, keyed by its SHA-256 hash so that identical uploads share one blob.
+type AttachmentManager struct {
+	Database *sql.DB
+	Store    blob.Store
+	Config   *Config
+}
+
+func NewAttachmentManager(db *sql.DB, store blob.Store, config *Config) *AttachmentManager {
+	return &AttachmentManager{db, store, config}
+}
+
+func scanAttachment(s scanner) (*Attachment, error) {
+	a := &Attachment{}
+	if err := s.Scan(&a.ID, &a.TodoID, &a.Name, &a.ContentType, &a.Size, &a.Hash, &a.Created); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+func (r *AttachmentManager) Get(todoID int64, id int64) (*Attachment, error) {
+	row := r.Database.QueryRow("SELECT "+attachmentColumns+" FROM attachment WHERE rowid = ? AND todo_id = ?", id, todoID)
+	return scanAttachment(row)
+}
+
+func (r *AttachmentManager) List(todoID int64) (AttachmentList, error) {
+	rows, err := r.Database.Query("SELECT "+attachmentColumns+" FROM attachment WHERE todo_id = ? ORDER BY rowid", todoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := AttachmentList{}
+
+	for rows.Next() {
+		var a *Attachment
+		if a, err = scanAttachment(rows); err != nil {
+			return nil, err
+		}
+		results = append(results, a)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// allowed reports whether contentType matches one of Config.AttachmentTypes.
+// Patterns ending in "/*" match every subtype.
+func (r *AttachmentManager) allowed(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+
+	for _, pattern := range r.Config.AttachmentTypes {
+		if pattern == mediaType || pattern == "*/*" {
+			return true
+		}
+		if strings.HasSuffix(pattern, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(pattern, "*")) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Create streams src into the store and attaches it to the todo identified by
+// todoID. The content type is sniffed from the content rather than trusted
+// from the client.
+func (r *AttachmentManager) Create(todoID int64, name string, src io.Reader) (*Attachment, error) {
+	tmp, err := ioutil.TempFile("", "attachment-")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tmp.Name())
+	defer tmp.Close()
+
+	hash := sha256.New()
+	size, err := io.Copy(io.MultiWriter(tmp, hash), io.LimitReader(src, r.Config.AttachmentMaxSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if size > r.Config.AttachmentMaxSize {
+		return nil, ErrAttachmentTooLarge
+	}
+
+	if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+		return nil, err
+	}
+
+	head := make([]byte, 512)
+	n, err := io.ReadFull(tmp, head)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return nil, err
+	}
+
+	contentType := http.DetectContentType(head[:n])
+	if !r.allowed(contentType) {
+		return nil, ErrAttachmentType
+	}
+
+	key := hex.EncodeToString(hash.Sum(nil))
+
+	if exists, err := r.Store.Exists(key); err != nil {
+		return nil, err
+	} else if !exists {
+		if _, err = tmp.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		if err = r.Store.Put(key, tmp); err != nil {
+			return nil, err
+		}
+	}
+
+	result, err := r.Database.Exec("INSERT INTO attachment(todo_id, name, content_type, size, hash, created) VALUES(?, ?, ?, ?, ?, ?);",
+		todoID, name, contentType, size, key, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Get(todoID, id)
+}
+
+// Open returns the content of a.
+func (r *AttachmentManager) Open(a *Attachment) (io.ReadCloser, error) {
+	return r.Store.Get(a.Hash)
+}
+
+// Delete removes an attachment and its blob unless another attachment shares
+// the same content.
+func (r *AttachmentManager) Delete(a *Attachment) error {
+	if _, err := r.Database.Exec("DELETE FROM attachment WHERE rowid = ?;", a.ID); err != nil {
+		return err
+	}
+	return r.prune(a.Hash)
+}
+
+// DeleteAll removes every attachment of the todo identified by todoID.
+func (r *AttachmentManager) DeleteAll(todoID int64) error {
+	list, err := r.List(todoID)
+	if err != nil {
+		return err
+	}
+
+	for _, a := range list {
+		if err = r.Delete(a); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (r *AttachmentManager) prune(hash string) error {
+	var count int64
+
+	row := r.Database.QueryRow("SELECT COUNT(*) FROM attachment WHERE hash = ?", hash)
+	if err := row.Scan(&count); err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return nil
+	}
+
+	return r.Store.Delete(hash)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/apierror"
+
+	"io"
+	"log"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"strconv"
+)
+
+func (r *Handler) AttachmentListFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		id := pathID(req, "id")
+
+		if _, err := r.TM.Get(id); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+		} else if list, err := r.AM.List(id); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+		} else {
+			writeJSON(w, http.StatusOK, list)
+		}
+	}
+}
+
+// AttachmentCreateFunc streams the "file" part of a multipart/form-data
+// request into the attachment store.
+func (r *Handler) AttachmentCreateFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		id := pathID(req, "id")
+
+		if _, err := r.TM.Get(id); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+			return
+		}
+
+		// Leave room for the multipart framing around the file.
+		req.Body = http.MaxBytesReader(w, req.Body, r.Config.AttachmentMaxSize+1<<20)
+
+		mr, err := req.MultipartReader()
+		if err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusBadRequest, Message: err.Error()})
+			return
+		}
+
+		for {
+			part, err := mr.NextPart()
+			if err == io.EOF {
+				break
+			} else if err != nil {
+				writeError(w, &apierror.Error{Code: http.StatusBadRequest, Message: err.Error()})
+				return
+			}
+
+			if part.FormName() != "file" {
+				part.Close()
+				continue
+			}
+
+			name := filepath.Base(part.FileName())
+			if name == "." || name == string(filepath.Separator) {
+				name = "attachment"
+			}
+
+			a, err := r.AM.Create(id, name, part)
+			part.Close()
+
+			if err != nil {
+				writeError(w, managerError(err))
+			} else {
+				writeJSON(w, http.StatusCreated, a)
+			}
+			return
+		}
+
+		writeError(w, &apierror.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Invalid form",
+			Errors: []*apierror.ErrorDetail{
+				&apierror.ErrorDetail{Key: "file", Message: "file is required"},
+			},
+		})
+	}
+}
+
+func (r *Handler) AttachmentDownloadFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		a, err := r.AM.Get(pathID(req, "id"), pathID(req, "aid"))
+		if err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+			return
+		}
+
+		content, err := r.AM.Open(a)
+		if err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+			return
+		}
+		defer content.Close()
+
+		w.Header().Set("Content-Type", a.ContentType)
+		w.Header().Set("Content-Length", strconv.FormatInt(a.Size, 10))
+		w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.Name}))
+		w.Header().Set("ETag", `"`+a.Hash+`"`)
+		w.WriteHeader(http.StatusOK)
+
+		if _, err = io.Copy(w, content); err != nil {
+			log.Printf("ERROR: streaming attachment %d: %s", a.ID, err)
+		}
+	}
+}
+
+func (r *Handler) AttachmentDeleteFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		a, err := r.AM.Get(pathID(req, "id"), pathID(req, "aid"))
+		if err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+			return
+		}
+
+		if err = r.AM.Delete(a); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+// Package blob stores opaque content addressed by key.
+package blob
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var ErrNotFound = errors.New("blob does not exist")
+
+// Store is implemented by blob backends. Keys are lowercase hex strings such
+// as content hashes.
+type Store interface {
+	// Put stores the content of r under key, replacing any existing blob.
+	Put(key string, r io.Reader) error
+	// Get opens the blob stored under key, returning ErrNotFound when there
+	// is none.
+	Get(key string) (io.ReadCloser, error)
+	Exists(key string) (bool, error)
+	Delete(key string) error
+}
+
+// FileStore keeps each blob in a file below Root, fanned out into
+// directories by the first two characters of its key.
+type FileStore struct {
+	Root string
+}
+
+func NewFileStore(root string) *FileStore {
+	return &FileStore{root}
+}
+
+func (r *FileStore) path(key string) (string, error) {
+	if len(key) < 3 || strings.Trim(key, "0123456789abcdef") != "" {
+		return "", errors.New("Invalid blob key: " + key)
+	}
+	return filepath.Join(r.Root, key[:2], key[2:]), nil
+}
+
+// Put writes the blob to a temporary file first so that readers never see a
+// partial blob.
+func (r *FileStore) Put(key string, src io.Reader) error {
+	path, err := r.path(key)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	if err = os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+
+	if _, err = io.Copy(tmp, src); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err = tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return nil
+}
+
+func (r *FileStore) Get(key string) (io.ReadCloser, error) {
+	path, err := r.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return f, err
+}
+
+func (r *FileStore) Exists(key string) (bool, error) {
+	path, err := r.path(key)
+	if err != nil {
+		return false, err
+	}
+
+	if _, err = os.Stat(path); os.IsNotExist(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+func (r *FileStore) Delete(key string) error {
+	path, err := r.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err = os.Remove(path); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
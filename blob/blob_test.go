@@ -0,0 +1,60 @@
+package blob
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestFileStore(t *testing.T) {
+	root, err := ioutil.TempDir("", "blob")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	store := NewFileStore(root)
+	key := "9f86d081884c7d659a2feaa0c55ad015a3bf4f1b2b0b822cd15d6c15b0f00a08"
+
+	if ok, err := store.Exists(key); err != nil || ok {
+		t.Fatalf("Exists = %v, %v", ok, err)
+	}
+
+	if _, err = store.Get(key); err != ErrNotFound {
+		t.Fatalf("Get err = %v", err)
+	}
+
+	if err = store.Put(key, strings.NewReader("test")); err != nil {
+		t.Fatal(err)
+	}
+
+	if ok, err := store.Exists(key); err != nil || !ok {
+		t.Fatalf("Exists = %v, %v", ok, err)
+	}
+
+	rc, err := store.Get(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	content, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil || string(content) != "test" {
+		t.Errorf("content = %q, %v", content, err)
+	}
+
+	if err = store.Delete(key); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = store.Delete(key); err != nil {
+		t.Errorf("second Delete: %s", err)
+	}
+
+	for _, invalid := range []string{"", "ab", "../../etc/passwd", "ABCDEF"} {
+		if err = store.Put(invalid, strings.NewReader("x")); err == nil {
+			t.Errorf("Put(%q) succeeded", invalid)
+		}
+	}
+}
@@ -0,0 +1,243 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/ical"
+	"github.com/marcgwilson/todo/state"
+
+	"github.com/mattn/go-sqlite3"
+
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUIDConflict          = errors.New("UID is already used by another calendar object")
+	ErrCalendarObjectExists = errors.New("calendar object name is already taken")
+)
+
+// CalendarObject is the CalDAV resource of a todo. Todos created by a CalDAV
+// client keep the resource name and UID the client gave them, other todos are
+// named after their id.
+type CalendarObject struct {
+	TodoID int64
+	Name   string
+	UID    string
+}
+
+func defaultCalendarObject(id int64) *CalendarObject {
+	return &CalendarObject{TodoID: id, Name: fmt.Sprintf("%d.ics", id), UID: todoUID(id)}
+}
+
+// CalendarObjects returns the calendar object of every todo in list by id.
+func (r *TodoManager) CalendarObjects(list TodoList) (map[int64]*CalendarObject, error) {
+	results := map[int64]*CalendarObject{}
+	if len(list) == 0 {
+		return results, nil
+	}
+
+	ids := make([]int64, len(list), len(list))
+	for i, t := range list {
+		results[t.ID] = defaultCalendarObject(t.ID)
+		ids[i] = t.ID
+	}
+
+	bindvars, values := inClause(ids)
+
+	rows, err := r.Database.Query("SELECT todo_id, name, uid FROM calendar_object WHERE todo_id IN "+bindvars, values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		o := &CalendarObject{}
+		if err = rows.Scan(&o.TodoID, &o.Name, &o.UID); err != nil {
+			return nil, err
+		}
+		results[o.TodoID] = o
+	}
+
+	return results, rows.Err()
+}
+
+// CalendarObject returns the calendar object named name, or sql.ErrNoRows.
+func (r *TodoManager) CalendarObject(name string) (*CalendarObject, error) {
+	o := &CalendarObject{}
+
+	row := r.Database.QueryRow("SELECT todo_id, name, uid FROM calendar_object WHERE name = ?", name)
+	if err := row.Scan(&o.TodoID, &o.Name, &o.UID); err != sql.ErrNoRows {
+		return o, err
+	}
+
+	id, err := strconv.ParseInt(strings.TrimSuffix(name, ".ics"), 10, 64)
+	if err != nil || !strings.HasSuffix(name, ".ics") || name != fmt.Sprintf("%d.ics", id) {
+		return nil, sql.ErrNoRows
+	}
+
+	// A todo named by its client is not also reachable by its id.
+	var count int64
+	if err = r.Database.QueryRow("SELECT COUNT(*) FROM todo WHERE rowid = ? AND rowid NOT IN (SELECT todo_id FROM calendar_object)", id).Scan(&count); err != nil {
+		return nil, err
+	} else if count == 0 {
+		return nil, sql.ErrNoRows
+	}
+
+	return defaultCalendarObject(id), nil
+}
+
+// CreateCalendarObject creates a todo from data along with the calendar
+// object o that a CalDAV client gave it, in one transaction. It returns
+// ErrUIDConflict when another calendar object has the UID of o and
+// ErrCalendarObjectExists when one has its name.
+func (r *TodoManager) CreateCalendarObject(data map[string]interface{}, o *CalendarObject) (*Todo, error) {
+	return r.create(data, func(tx *sql.Tx, id int64) error {
+		if err := checkUID(tx, o.UID, id); err != nil {
+			return err
+		}
+
+		_, err := tx.Exec("INSERT INTO calendar_object(todo_id, name, uid) VALUES(?, ?, ?)", id, o.Name, o.UID)
+		if e, ok := err.(sqlite3.Error); ok && e.ExtendedCode == sqlite3.ErrConstraintUnique {
+			return ErrCalendarObjectExists
+		}
+		if err == nil {
+			o.TodoID = id
+		}
+		return err
+	})
+}
+
+// checkUID returns ErrUIDConflict when uid is the UID of the calendar object
+// of a todo other than the one identified by id, including the default UID
+// of a todo without a calendar object.
+func checkUID(tx *sql.Tx, uid string, id int64) error {
+	var count int64
+	if err := tx.QueryRow("SELECT COUNT(*) FROM calendar_object WHERE uid = ? AND todo_id != ?", uid, id).Scan(&count); err != nil {
+		return err
+	} else if count > 0 {
+		return ErrUIDConflict
+	}
+
+	var other int64
+	if _, err := fmt.Sscanf(uid, "todo-%d@todo", &other); err != nil || todoUID(other) != uid || other == id {
+		return nil
+	}
+
+	if err := tx.QueryRow("SELECT COUNT(*) FROM todo WHERE rowid = ? AND rowid NOT IN (SELECT todo_id FROM calendar_object)", other).Scan(&count); err != nil {
+		return err
+	} else if count > 0 {
+		return ErrUIDConflict
+	}
+
+	return nil
+}
+
+// calendarObjectData returns the iCalendar data of t, the calendar object o,
+// and its ETag. DTSTAMP is the time t was last modified so that the data
+// changes only with t.
+func calendarObjectData(t *Todo, o *CalendarObject) ([]byte, string) {
+	stamp := time.Unix(0, 0)
+	if t.Modified != nil {
+		stamp = *t.Modified
+	} else if t.Created != nil {
+		stamp = *t.Created
+	}
+
+	cal := newCalendar()
+	cal.Components = append(cal.Components, vtodo(t, o.UID, stamp))
+
+	data := []byte(cal.String())
+	sum := sha256.Sum256(data)
+	return data, `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+var vtodoStates = map[string]state.State{
+	"NEEDS-ACTION": state.Todo,
+	"IN-PROCESS":   state.InProgress,
+	"COMPLETED":    state.Done,
+	"CANCELLED":    state.Done,
+}
+
+// vtodoData converts c, a VTODO sent by a CalDAV client, into the data of a
+// new todo or of an update of prev. The VTODO is the whole todo as the client
+// sees it, so absent properties clear due and recurrence. An undefined
+// priority leaves the priority of the todo unchanged.
+func vtodoData(c *ical.Component, prev *Todo) (TodoMap, error) {
+	data := TodoMap{"desc": "", "due": nil, "recurrence": nil}
+
+	if p := c.Get("SUMMARY"); p != nil {
+		data["desc"] = p.Text()
+	}
+
+	if p := c.Get("DUE"); p != nil {
+		loc := time.UTC
+		if prev != nil {
+			loc = prev.Location()
+		}
+
+		due, isDate, err := ical.ParseTime(p, loc)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid DUE: %s", err)
+		}
+
+		if isDate {
+			data["due"] = due.Format(dayFormat)
+		} else {
+			data["due"] = due.Format(time.RFC3339Nano)
+			data["all_day"] = false
+			if tzid, ok := p.Params["TZID"]; ok {
+				data["timezone"] = tzid
+			}
+		}
+	}
+
+	data["state"] = string(state.Todo)
+	if p := c.Get("STATUS"); p != nil {
+		s, ok := vtodoStates[strings.ToUpper(p.Value)]
+		if !ok {
+			return nil, fmt.Errorf("Invalid STATUS: %s", p.Value)
+		}
+		data["state"] = string(s)
+	} else if c.Get("COMPLETED") != nil {
+		data["state"] = string(state.Done)
+	}
+
+	if p := c.Get("PRIORITY"); p != nil {
+		n, err := strconv.ParseInt(p.Value, 10, 64)
+		if err != nil || n < 0 || n > 9 {
+			return nil, fmt.Errorf("Invalid PRIORITY: %s", p.Value)
+		}
+		switch {
+		case n == 0:
+		case n <= 2:
+			data["priority"] = int64(4)
+		case n <= 4:
+			data["priority"] = int64(3)
+		case n == 5:
+			data["priority"] = int64(2)
+		case n <= 7:
+			data["priority"] = int64(1)
+		default:
+			data["priority"] = int64(0)
+		}
+	}
+
+	if p := c.Get("RRULE"); p != nil {
+		data["recurrence"] = p.Value
+	}
+
+	if prev == nil {
+		for _, key := range []string{"due", "recurrence"} {
+			if data[key] == nil {
+				delete(data, key)
+			}
+		}
+	}
+
+	return data, nil
+}
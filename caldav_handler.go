@@ -0,0 +1,773 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/apierror"
+	"github.com/marcgwilson/todo/ical"
+	"github.com/marcgwilson/todo/query"
+
+	"github.com/gorilla/mux"
+
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	davNamespace            = "DAV:"
+	caldavNamespace         = "urn:ietf:params:xml:ns:caldav"
+	calendarServerNamespace = "http://calendarserver.org/ns/"
+)
+
+// CalDAVRoot is both the principal of every CalDAV user and their calendar
+// home, which holds the single calendar CalDAVCalendar of all todos.
+const (
+	CalDAVRoot     = "/caldav/"
+	CalDAVCalendar = "/caldav/todos/"
+)
+
+var davPrefixes = map[string]string{
+	davNamespace:            "d",
+	caldavNamespace:         "c",
+	calendarServerNamespace: "cs",
+}
+
+var (
+	davResourceType     = xml.Name{Space: davNamespace, Local: "resourcetype"}
+	davDisplayName      = xml.Name{Space: davNamespace, Local: "displayname"}
+	davETag             = xml.Name{Space: davNamespace, Local: "getetag"}
+	davContentType      = xml.Name{Space: davNamespace, Local: "getcontenttype"}
+	davPrincipal        = xml.Name{Space: davNamespace, Local: "current-user-principal"}
+	davPrincipalURL     = xml.Name{Space: davNamespace, Local: "principal-URL"}
+	davReportSet        = xml.Name{Space: davNamespace, Local: "supported-report-set"}
+	caldavHomeSet       = xml.Name{Space: caldavNamespace, Local: "calendar-home-set"}
+	caldavComponentSet  = xml.Name{Space: caldavNamespace, Local: "supported-calendar-component-set"}
+	caldavCalendarData  = xml.Name{Space: caldavNamespace, Local: "calendar-data"}
+	calendarServerCTag  = xml.Name{Space: calendarServerNamespace, Local: "getctag"}
+	caldavCalendarQuery = xml.Name{Space: caldavNamespace, Local: "calendar-query"}
+	caldavMultiget      = xml.Name{Space: caldavNamespace, Local: "calendar-multiget"}
+)
+
+// calendarObjectType is the content type of a calendar object resource.
+const calendarObjectType = "text/calendar; charset=utf-8; component=VTODO"
+
+type davAny struct {
+	XMLName xml.Name
+}
+
+type davProp struct {
+	Names []davAny `xml:",any"`
+}
+
+// davPropfind is a PROPFIND body. Without prop, as for allprop, all
+// properties are returned.
+type davPropfind struct {
+	Prop *davProp `xml:"DAV: prop"`
+}
+
+type caldavTextMatch struct {
+	Value  string `xml:",chardata"`
+	Negate string `xml:"negate-condition,attr"`
+}
+
+type caldavPropFilter struct {
+	Name         string           `xml:"name,attr"`
+	IsNotDefined *struct{}        `xml:"urn:ietf:params:xml:ns:caldav is-not-defined"`
+	TextMatch    *caldavTextMatch `xml:"urn:ietf:params:xml:ns:caldav text-match"`
+	TimeRange    *caldavTimeRange `xml:"urn:ietf:params:xml:ns:caldav time-range"`
+}
+
+type caldavTimeRange struct {
+	Start string `xml:"start,attr"`
+	End   string `xml:"end,attr"`
+}
+
+type caldavCompFilter struct {
+	Name         string             `xml:"name,attr"`
+	IsNotDefined *struct{}          `xml:"urn:ietf:params:xml:ns:caldav is-not-defined"`
+	TimeRange    *caldavTimeRange   `xml:"urn:ietf:params:xml:ns:caldav time-range"`
+	PropFilters  []caldavPropFilter `xml:"urn:ietf:params:xml:ns:caldav prop-filter"`
+	CompFilters  []caldavCompFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+}
+
+type caldavFilter struct {
+	CompFilter caldavCompFilter `xml:"urn:ietf:params:xml:ns:caldav comp-filter"`
+}
+
+// davReport is a calendar-query or calendar-multiget report.
+type davReport struct {
+	XMLName xml.Name
+	Prop    *davProp      `xml:"DAV: prop"`
+	Hrefs   []string      `xml:"DAV: href"`
+	Filter  *caldavFilter `xml:"urn:ietf:params:xml:ns:caldav filter"`
+}
+
+// davResponse is a resource in a multistatus response. Props hold the inner
+// XML of each property; Status is set instead for resources that were not
+// found.
+type davResponse struct {
+	Href   string
+	Status int
+	Props  map[xml.Name]string
+}
+
+// davObject is a todo as a calendar object resource.
+type davObject struct {
+	Todo   *Todo
+	Object *CalendarObject
+	Data   []byte
+	ETag   string
+}
+
+func newDAVObject(t *Todo, o *CalendarObject) *davObject {
+	data, etag := calendarObjectData(t, o)
+	return &davObject{Todo: t, Object: o, Data: data, ETag: etag}
+}
+
+func (r *davObject) Href() string {
+	return CalDAVCalendar + url.PathEscape(r.Object.Name)
+}
+
+func (r *davObject) response() *davResponse {
+	return &davResponse{Href: r.Href(), Props: map[xml.Name]string{
+		davResourceType:    "",
+		davETag:            davText(r.ETag),
+		davContentType:     davText(calendarObjectType),
+		caldavCalendarData: davText(string(r.Data)),
+	}}
+}
+
+func davText(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func davHref(href string) string {
+	return "<d:href>" + davText(href) + "</d:href>"
+}
+
+func davStatus(code int) string {
+	return fmt.Sprintf("<d:status>HTTP/1.1 %d %s</d:status>", code, http.StatusText(code))
+}
+
+// davElement returns name as an element holding inner.
+func davElement(name xml.Name, inner string) string {
+	tag, xmlns := name.Local, ""
+	if prefix, ok := davPrefixes[name.Space]; ok {
+		tag = prefix + ":" + name.Local
+	} else if name.Space != "" {
+		tag, xmlns = "x:"+name.Local, ` xmlns:x="`+davText(name.Space)+`"`
+	}
+
+	if inner == "" {
+		return "<" + tag + xmlns + "/>"
+	}
+	return "<" + tag + xmlns + ">" + inner + "</" + tag + ">"
+}
+
+// writeMultistatus writes the properties names of responses, or all their
+// properties but calendar-data when names is nil.
+func writeMultistatus(w http.ResponseWriter, responses []*davResponse, names []xml.Name) {
+	var b strings.Builder
+
+	b.WriteString(xml.Header)
+	b.WriteString(`<d:multistatus xmlns:d="DAV:" xmlns:c="urn:ietf:params:xml:ns:caldav" xmlns:cs="http://calendarserver.org/ns/">`)
+
+	for _, res := range responses {
+		b.WriteString("<d:response>" + davHref(res.Href))
+
+		if res.Status != 0 {
+			b.WriteString(davStatus(res.Status) + "</d:response>")
+			continue
+		}
+
+		wanted := names
+		if wanted == nil {
+			for name := range res.Props {
+				if name != caldavCalendarData {
+					wanted = append(wanted, name)
+				}
+			}
+			sort.Slice(wanted, func(i, j int) bool {
+				return wanted[i].Space+wanted[i].Local < wanted[j].Space+wanted[j].Local
+			})
+		}
+
+		var found, missing strings.Builder
+		for _, name := range wanted {
+			if value, ok := res.Props[name]; ok {
+				found.WriteString(davElement(name, value))
+			} else {
+				missing.WriteString(davElement(name, ""))
+			}
+		}
+
+		if found.Len() > 0 {
+			b.WriteString("<d:propstat><d:prop>" + found.String() + "</d:prop>" + davStatus(http.StatusOK) + "</d:propstat>")
+		}
+
+		if missing.Len() > 0 {
+			b.WriteString("<d:propstat><d:prop>" + missing.String() + "</d:prop>" + davStatus(http.StatusNotFound) + "</d:propstat>")
+		}
+
+		b.WriteString("</d:response>")
+	}
+
+	b.WriteString("</d:multistatus>")
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, b.String())
+}
+
+// propNames returns the names in prop, or nil for all properties.
+func propNames(prop *davProp) []xml.Name {
+	if prop == nil {
+		return nil
+	}
+
+	names := []xml.Name{}
+	for _, p := range prop.Names {
+		names = append(names, p.XMLName)
+	}
+	return names
+}
+
+// davCaller authenticates a CalDAV client by HTTP basic authentication with
+// a user name and the user's calendar feed token as the password.
+func (r *Handler) davCaller(w http.ResponseWriter, req *http.Request) (*User, bool) {
+	name, token, ok := req.BasicAuth()
+	if ok {
+		if u, err := r.UM.GetByFeedToken(token); err == nil && u.Name == name {
+			return u, true
+		} else if err != nil && err != sql.ErrNoRows {
+			writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+			return nil, false
+		}
+	}
+
+	w.Header().Set("WWW-Authenticate", `Basic realm="todo"`)
+	writeError(w, &apierror.Error{Code: http.StatusUnauthorized, Message: "A user name and calendar feed token are required"})
+	return nil, false
+}
+
+// davObjects returns every todo in list as a calendar object.
+func (r *Handler) davObjects(list TodoList) ([]*davObject, error) {
+	objects, err := r.TM.CalendarObjects(list)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*davObject, len(list), len(list))
+	for i, t := range list {
+		results[i] = newDAVObject(t, objects[t.ID])
+	}
+	return results, nil
+}
+
+// davObject returns the calendar object named name, or sql.ErrNoRows.
+func (r *Handler) davObject(name string) (*davObject, error) {
+	o, err := r.TM.CalendarObject(name)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := r.TM.Get(o.TodoID)
+	if err != nil {
+		return nil, err
+	}
+
+	return newDAVObject(t, o), nil
+}
+
+// calendarCTag changes whenever a calendar object is added, changed or
+// removed.
+func calendarCTag(objects []*davObject) string {
+	hash := sha256.New()
+	for _, o := range objects {
+		io.WriteString(hash, o.Object.Name+" "+o.ETag+"\n")
+	}
+	return `"` + hex.EncodeToString(hash.Sum(nil)[:16]) + `"`
+}
+
+func (r *Handler) davRootResponse(u *User) *davResponse {
+	return &davResponse{Href: CalDAVRoot, Props: map[xml.Name]string{
+		davResourceType: davElement(xml.Name{Space: davNamespace, Local: "collection"}, "") +
+			davElement(xml.Name{Space: davNamespace, Local: "principal"}, ""),
+		davDisplayName:  davText(u.Name),
+		davPrincipal:    davHref(CalDAVRoot),
+		davPrincipalURL: davHref(CalDAVRoot),
+		caldavHomeSet:   davHref(CalDAVRoot),
+	}}
+}
+
+func (r *Handler) davCalendarResponse(objects []*davObject) *davResponse {
+	ctag := davText(calendarCTag(objects))
+	reports := ""
+	for _, name := range []xml.Name{caldavCalendarQuery, caldavMultiget} {
+		reports += "<d:supported-report><d:report>" + davElement(name, "") + "</d:report></d:supported-report>"
+	}
+
+	return &davResponse{Href: CalDAVCalendar, Props: map[xml.Name]string{
+		davResourceType: davElement(xml.Name{Space: davNamespace, Local: "collection"}, "") +
+			davElement(xml.Name{Space: caldavNamespace, Local: "calendar"}, ""),
+		davDisplayName:     "Todos",
+		davPrincipal:       davHref(CalDAVRoot),
+		davETag:            ctag,
+		davReportSet:       reports,
+		caldavComponentSet: `<c:comp name="VTODO"/>`,
+		calendarServerCTag: ctag,
+	}}
+}
+
+// CalDAVWellKnownFunc redirects CalDAV service discovery to CalDAVRoot.
+func (r *Handler) CalDAVWellKnownFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		http.Redirect(w, req, CalDAVRoot, http.StatusMovedPermanently)
+	}
+}
+
+func (r *Handler) CalDAVOptionsFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		w.Header().Set("DAV", "1, calendar-access")
+		w.Header().Set("Allow", "OPTIONS, GET, PUT, DELETE, PROPFIND, REPORT")
+		w.WriteHeader(http.StatusOK)
+	}
+}
+
+// CalDAVPropfindFunc serves PROPFIND requests for CalDAVRoot, CalDAVCalendar
+// and the calendar objects in it. Any depth other than 0 is treated as 1.
+func (r *Handler) CalDAVPropfindFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		u, ok := r.davCaller(w, req)
+		if !ok {
+			return
+		}
+
+		body, err := ioutil.ReadAll(req.Body)
+		if err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusBadRequest, Message: err.Error()})
+			return
+		}
+
+		propfind := &davPropfind{}
+		if len(strings.TrimSpace(string(body))) > 0 {
+			if err = xml.Unmarshal(body, propfind); err != nil {
+				writeError(w, &apierror.Error{Code: http.StatusBadRequest, Message: "Invalid PROPFIND body: " + err.Error()})
+				return
+			}
+		}
+
+		names := propNames(propfind.Prop)
+		deep := req.Header.Get("Depth") != "0"
+
+		if name, ok := mux.Vars(req)["name"]; ok {
+			o, err := r.davObject(name)
+			if err == sql.ErrNoRows {
+				writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+			} else if err != nil {
+				writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+			} else {
+				writeMultistatus(w, []*davResponse{o.response()}, names)
+			}
+			return
+		}
+
+		if req.URL.Path == CalDAVRoot && !deep {
+			writeMultistatus(w, []*davResponse{r.davRootResponse(u)}, names)
+			return
+		}
+
+		list, err := r.TM.Query(query.All())
+		if err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+			return
+		}
+
+		objects, err := r.davObjects(list)
+		if err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+			return
+		}
+
+		responses := []*davResponse{}
+		if req.URL.Path == CalDAVRoot {
+			responses = append(responses, r.davRootResponse(u))
+		}
+
+		responses = append(responses, r.davCalendarResponse(objects))
+
+		if req.URL.Path == CalDAVCalendar && deep {
+			for _, o := range objects {
+				responses = append(responses, o.response())
+			}
+		}
+
+		writeMultistatus(w, responses, names)
+	}
+}
+
+// calendarQueryFilter converts the filter of a calendar-query into a filter
+// expression over todos. It returns false when no todo can match.
+func calendarQueryFilter(f *caldavCompFilter) (string, bool, *apierror.Error) {
+	unsupported := func(message string) *apierror.Error {
+		return &apierror.Error{Code: http.StatusForbidden, Message: "Unsupported filter: " + message}
+	}
+
+	if !strings.EqualFold(f.Name, "VCALENDAR") {
+		return "", false, unsupported("the filter must select VCALENDAR")
+	}
+
+	if len(f.CompFilters) == 0 {
+		return "", true, nil
+	}
+
+	if len(f.CompFilters) > 1 || !strings.EqualFold(f.CompFilters[0].Name, "VTODO") || f.CompFilters[0].IsNotDefined != nil {
+		return "", false, nil
+	}
+
+	vtodo := f.CompFilters[0]
+	clauses := []string{}
+
+	if tr := vtodo.TimeRange; tr != nil {
+		within := []string{}
+		for _, bound := range []struct {
+			value string
+			op    string
+		}{{tr.Start, ">"}, {tr.End, "<="}} {
+			if bound.value == "" {
+				continue
+			}
+			t, err := time.Parse(ical.DateTimeFormat, bound.value)
+			if err != nil {
+				return "", false, &apierror.Error{Code: http.StatusBadRequest, Message: "Invalid time-range: " + bound.value}
+			}
+			within = append(within, fmt.Sprintf("due %s %q", bound.op, t.Format(time.RFC3339)))
+		}
+		if len(within) > 0 {
+			clauses = append(clauses, "(due is null or ("+strings.Join(within, " and ")+"))")
+		}
+	}
+
+	for _, p := range vtodo.PropFilters {
+		defined := p.IsNotDefined == nil
+
+		switch name := strings.ToUpper(p.Name); {
+		case p.TimeRange != nil:
+			return "", false, unsupported("time-range of " + name)
+		case name == "COMPLETED" && p.TextMatch == nil:
+			if defined {
+				clauses = append(clauses, `state = "done"`)
+			} else {
+				clauses = append(clauses, `state != "done"`)
+			}
+		case name == "DUE" && p.TextMatch == nil:
+			if defined {
+				clauses = append(clauses, "due is not null")
+			} else {
+				clauses = append(clauses, "due is null")
+			}
+		case name == "STATUS" && p.TextMatch != nil:
+			s, ok := vtodoStates[strings.ToUpper(strings.TrimSpace(p.TextMatch.Value))]
+			negate := p.TextMatch.Negate == "yes"
+			if !ok && !negate {
+				return "", false, nil
+			} else if ok && negate {
+				clauses = append(clauses, fmt.Sprintf("state != %q", string(s)))
+			} else if ok {
+				clauses = append(clauses, fmt.Sprintf("state = %q", string(s)))
+			}
+		case name == "STATUS" && !defined:
+			return "", false, nil
+		case name == "STATUS":
+			// Every todo has a STATUS.
+		default:
+			return "", false, unsupported("prop-filter " + name)
+		}
+	}
+
+	return strings.Join(clauses, " and "), true, nil
+}
+
+// CalDAVReportFunc serves the calendar-query and calendar-multiget reports of
+// CalDAVCalendar.
+func (r *Handler) CalDAVReportFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		if _, ok := r.davCaller(w, req); !ok {
+			return
+		}
+
+		report := &davReport{}
+		if err := xml.NewDecoder(req.Body).Decode(report); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusBadRequest, Message: "Invalid REPORT body: " + err.Error()})
+			return
+		}
+
+		names := propNames(report.Prop)
+		responses := []*davResponse{}
+
+		switch report.XMLName {
+		case caldavMultiget:
+			for _, href := range report.Hrefs {
+				res := &davResponse{Href: href, Status: http.StatusNotFound}
+
+				if u, err := url.Parse(strings.TrimSpace(href)); err == nil && path.Dir(u.Path)+"/" == CalDAVCalendar {
+					if o, err := r.davObject(path.Base(u.Path)); err == nil {
+						res = o.response()
+					} else if err != sql.ErrNoRows {
+						writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+						return
+					}
+				}
+
+				responses = append(responses, res)
+			}
+		case caldavCalendarQuery:
+			if report.Filter == nil {
+				writeError(w, &apierror.Error{Code: http.StatusBadRequest, Message: "A calendar-query requires a filter"})
+				return
+			}
+
+			expr, ok, ae := calendarQueryFilter(&report.Filter.CompFilter)
+			if ae != nil {
+				writeError(w, ae)
+				return
+			} else if !ok {
+				writeMultistatus(w, responses, names)
+				return
+			}
+
+			values := url.Values{}
+			if expr != "" {
+				values.Set("filter", expr)
+			}
+
+			result, ae := r.parseValues(req, values)
+			if ae != nil {
+				writeError(w, ae)
+				return
+			}
+
+			list, err := r.TM.Query(result.Depaginate().Query())
+			if err != nil {
+				writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+				return
+			}
+
+			objects, err := r.davObjects(list)
+			if err != nil {
+				writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+				return
+			}
+
+			for _, o := range objects {
+				responses = append(responses, o.response())
+			}
+		default:
+			writeError(w, &apierror.Error{Code: http.StatusForbidden, Message: "Unsupported report: " + report.XMLName.Local})
+			return
+		}
+
+		writeMultistatus(w, responses, names)
+	}
+}
+
+// checkPreconditions applies the If-Match and If-None-Match headers of req to
+// o, which is nil for a resource that does not exist.
+func checkPreconditions(req *http.Request, o *davObject) *apierror.Error {
+	failed := &apierror.Error{Code: http.StatusPreconditionFailed, Message: "The calendar object has changed"}
+
+	if match := req.Header.Get("If-Match"); match != "" {
+		if o == nil || (match != "*" && match != o.ETag) {
+			return failed
+		}
+	}
+
+	if noneMatch := req.Header.Get("If-None-Match"); noneMatch != "" && o != nil {
+		if noneMatch == "*" || noneMatch == o.ETag {
+			return failed
+		}
+	}
+
+	return nil
+}
+
+// lookupDAVObject is davObject for the resource named in the URL of req. It
+// writes an error and returns false when the lookup fails; a missing resource
+// is nil.
+func (r *Handler) lookupDAVObject(w http.ResponseWriter, req *http.Request) (*davObject, bool) {
+	o, err := r.davObject(mux.Vars(req)["name"])
+	if err == sql.ErrNoRows {
+		return nil, true
+	} else if err != nil {
+		writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+		return nil, false
+	}
+	return o, true
+}
+
+func (r *Handler) CalDAVGetFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		if _, ok := r.davCaller(w, req); !ok {
+			return
+		}
+
+		o, ok := r.lookupDAVObject(w, req)
+		if !ok {
+			return
+		} else if o == nil {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+			return
+		}
+
+		w.Header().Set("Content-Type", calendarObjectType)
+		w.Header().Set("ETag", o.ETag)
+		w.WriteHeader(http.StatusOK)
+		w.Write(o.Data)
+	}
+}
+
+// CalDAVPutFunc creates or replaces a todo from a VTODO. The todo does not
+// keep properties it has no field for, so no ETag is returned and clients
+// fetch the stored calendar object again. A new todo cannot take the UID of
+// another, the CalDAV no-uid-conflict precondition.
+func (r *Handler) CalDAVPutFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		u, ok := r.davCaller(w, req)
+		if !ok {
+			return
+		}
+
+		if mediaType, _, err := mime.ParseMediaType(req.Header.Get("Content-Type")); err != nil || mediaType != "text/calendar" {
+			writeError(w, &apierror.Error{Code: http.StatusUnsupportedMediaType, Message: "Content-Type must be text/calendar"})
+			return
+		}
+
+		o, ok := r.lookupDAVObject(w, req)
+		if !ok {
+			return
+		}
+
+		if ae := checkPreconditions(req, o); ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		cal, err := ical.Decode(req.Body)
+		if err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusBadRequest, Message: "Invalid calendar data: " + err.Error()})
+			return
+		}
+
+		vtodos := cal.Children("VTODO")
+		if cal.Name != "VCALENDAR" || len(vtodos) != 1 || len(cal.Components) != len(vtodos)+len(cal.Children("VTIMEZONE")) {
+			writeError(w, &apierror.Error{Code: http.StatusForbidden, Message: "A calendar object must hold a single VTODO"})
+			return
+		}
+
+		uid := vtodos[0].Get("UID")
+		if uid == nil || uid.Value == "" {
+			writeError(w, &apierror.Error{Code: http.StatusBadRequest, Message: "A VTODO requires a UID"})
+			return
+		}
+
+		var prev *Todo
+		if o != nil {
+			if uid.Value != o.Object.UID {
+				writeError(w, &apierror.Error{Code: http.StatusBadRequest, Message: "The UID of a calendar object cannot change"})
+				return
+			}
+			prev = o.Todo
+		}
+
+		data, err := vtodoData(vtodos[0], prev)
+		if err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusBadRequest, Message: err.Error()})
+			return
+		}
+
+		create, update := r.validators()
+
+		if prev != nil {
+			if ae := validate(update, data); ae != nil {
+				writeError(w, ae)
+			} else if t, err := r.TM.Update(prev.ID, data); err != nil {
+				writeError(w, managerError(err))
+			} else {
+				r.TM.NotifyChanged(t, &u.ID)
+				w.WriteHeader(http.StatusNoContent)
+			}
+			return
+		}
+
+		if ae := validate(create, data); ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		name := mux.Vars(req)["name"]
+		if _, err = r.TM.CreateCalendarObject(data, &CalendarObject{Name: name, UID: uid.Value}); err == ErrUIDConflict {
+			writeError(w, &apierror.Error{Code: http.StatusForbidden, Message: err.Error()})
+			return
+		} else if err == ErrCalendarObjectExists {
+			writeError(w, &apierror.Error{Code: http.StatusConflict, Message: err.Error()})
+			return
+		} else if err != nil {
+			writeError(w, managerError(err))
+			return
+		}
+
+		w.Header().Set("Location", CalDAVCalendar+url.PathEscape(name))
+		w.WriteHeader(http.StatusCreated)
+	}
+}
+
+func (r *Handler) CalDAVDeleteFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		if _, ok := r.davCaller(w, req); !ok {
+			return
+		}
+
+		o, ok := r.lookupDAVObject(w, req)
+		if !ok {
+			return
+		} else if o == nil {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+			return
+		}
+
+		if ae := checkPreconditions(req, o); ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		if err := r.AM.DeleteAll(o.Todo.ID); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+		} else if err := r.TM.Delete(o.Todo.ID); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/apierror"
+
+	"database/sql"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// FeedResponse is the secret calendar feed of a user.
+type FeedResponse struct {
+	Token string `json:"token"`
+	URL   string `json:"url"`
+}
+
+// CalendarFunc serves the todos matching the list filters as an iCalendar
+// feed. Calendar clients cannot send the X-User header, so the feed is
+// authorized by the token query parameter, and assignee=me names its owner.
+func (r *Handler) CalendarFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		values := req.URL.Query()
+
+		u, err := r.UM.GetByFeedToken(values.Get("token"))
+		if err == sql.ErrNoRows {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+			return
+		} else if err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+			return
+		}
+
+		values.Del("token")
+		values.Del("group_by")
+		values.Del("items")
+
+		for i, value := range values["assignee"] {
+			if value == "me" {
+				values["assignee"][i] = strconv.FormatInt(u.ID, 10)
+			}
+		}
+
+		result, ae := r.parseValues(req, values)
+		if ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		list, err := r.TM.Query(result.Depaginate().Query())
+		if err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusBadRequest, Message: err.Error()})
+			return
+		}
+
+		objects, err := r.TM.CalendarObjects(list)
+		if err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+			return
+		}
+
+		now := time.Now()
+		cal := newCalendar()
+		for _, t := range list {
+			cal.Components = append(cal.Components, vtodo(t, objects[t.ID].UID, now))
+		}
+
+		w.Header().Set("Content-Type", "text/calendar; charset=utf-8")
+		w.WriteHeader(http.StatusOK)
+
+		if err = cal.Encode(w); err != nil {
+			log.Printf("ERROR: writing calendar: %s", err)
+		}
+	}
+}
+
+// UserFeedFunc issues the caller a new calendar feed URL, revoking the
+// previous one.
+func (r *Handler) UserFeedFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		id := pathID(req, "id")
+
+		if _, err := r.UM.Get(id); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+			return
+		}
+
+		u, ae := r.requireCaller(req)
+		if ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		if u.ID != id {
+			writeError(w, &apierror.Error{Code: http.StatusForbidden, Message: "Only a user can reset their calendar feed"})
+			return
+		}
+
+		token, err := r.UM.ResetFeedToken(id)
+		if err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+			return
+		}
+
+		writeJSON(w, http.StatusOK, &FeedResponse{
+			Token: token,
+			URL:   "/calendar.ics?" + url.Values{"token": {token}}.Encode(),
+		})
+	}
+}
@@ -0,0 +1,203 @@
+// Package codec encodes responses and decodes request bodies in the media
+// types clients ask for with the Accept and Content-Type headers.
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ErrUnsupported is returned by codecs for values or bodies they cannot
+// encode or decode, such as a request body in a tabular format.
+var ErrUnsupported = errors.New("codec: unsupported value")
+
+// Codec is implemented by media type encodings.
+type Codec interface {
+	// MediaTypes lists the types the codec is registered under. The first
+	// is the Content-Type of what it encodes.
+	MediaTypes() []string
+	Encode(w io.Writer, v interface{}) error
+	Decode(r io.Reader, v interface{}) error
+}
+
+// Registry selects codecs by media type.
+type Registry struct {
+	codecs []Codec
+	types  map[string]Codec
+}
+
+// NewRegistry returns a registry of codecs. The first is used when a client
+// accepts any type.
+func NewRegistry(codecs ...Codec) *Registry {
+	r := &Registry{types: map[string]Codec{}}
+	for _, c := range codecs {
+		r.Register(c)
+	}
+	return r
+}
+
+// Register adds c, replacing the codecs of its media types.
+func (r *Registry) Register(c Codec) {
+	for _, t := range c.MediaTypes() {
+		if old, ok := r.types[t]; ok {
+			r.remove(old)
+		}
+		r.types[t] = c
+	}
+	r.codecs = append(r.codecs, c)
+}
+
+func (r *Registry) remove(c Codec) {
+	for i, other := range r.codecs {
+		if other == c {
+			r.codecs = append(r.codecs[:i], r.codecs[i+1:]...)
+			break
+		}
+	}
+	for t, other := range r.types {
+		if other == c {
+			delete(r.types, t)
+		}
+	}
+}
+
+// MediaTypes returns the primary media type of each codec.
+func (r *Registry) MediaTypes() []string {
+	result := []string{}
+	for _, c := range r.codecs {
+		result = append(result, c.MediaTypes()[0])
+	}
+	return result
+}
+
+// Lookup returns the codec of a Content-Type header value, or nil.
+func (r *Registry) Lookup(contentType string) Codec {
+	t, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return nil
+	}
+	return r.types[t]
+}
+
+// Negotiate returns the codecs of the types accepted by an Accept header
+// value, most preferred first, or none when no type is acceptable. An empty
+// header accepts any type.
+func (r *Registry) Negotiate(accept string) []Codec {
+	if strings.TrimSpace(accept) == "" {
+		accept = "*/*"
+	}
+
+	type mediaRange struct {
+		t string
+		q float64
+	}
+
+	ranges := []mediaRange{}
+	for _, part := range strings.Split(accept, ",") {
+		t, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if value, ok := params["q"]; ok {
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				continue
+			}
+		}
+
+		if q > 0 {
+			ranges = append(ranges, mediaRange{t, q})
+		}
+	}
+
+	sort.SliceStable(ranges, func(i, j int) bool { return ranges[i].q > ranges[j].q })
+
+	result := []Codec{}
+	seen := map[Codec]bool{}
+	add := func(c Codec) {
+		if !seen[c] {
+			seen[c] = true
+			result = append(result, c)
+		}
+	}
+
+	for _, mr := range ranges {
+		if c, ok := r.types[mr.t]; ok {
+			add(c)
+			continue
+		}
+
+		if !strings.HasSuffix(mr.t, "/*") {
+			continue
+		}
+
+		prefix := strings.TrimSuffix(mr.t, "*")
+		for _, c := range r.codecs {
+			for _, t := range c.MediaTypes() {
+				if prefix == "*/" || strings.HasPrefix(t, prefix) {
+					add(c)
+					break
+				}
+			}
+		}
+	}
+
+	return result
+}
+
+// Generic returns v as the maps, slices, strings, numbers and booleans of
+// its JSON encoding, so that other encodings use the same field names and
+// value formats. Integers are int64 and other numbers float64.
+func Generic(v interface{}) (interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+
+	var result interface{}
+	if err = decoder.Decode(&result); err != nil {
+		return nil, err
+	}
+
+	return numbers(result), nil
+}
+
+func numbers(v interface{}) interface{} {
+	switch v := v.(type) {
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return i
+		}
+		f, _ := v.Float64()
+		return f
+	case map[string]interface{}:
+		for key, value := range v {
+			v[key] = numbers(value)
+		}
+	case []interface{}:
+		for i, value := range v {
+			v[i] = numbers(value)
+		}
+	}
+	return v
+}
+
+// fromGeneric stores a value decoded by another encoding in v as
+// json.Unmarshal would.
+func fromGeneric(generic interface{}, v interface{}) error {
+	data, err := json.Marshal(generic)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
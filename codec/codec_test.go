@@ -0,0 +1,101 @@
+package codec
+
+import (
+	"github.com/davecgh/go-spew/spew"
+
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+type csv struct{}
+
+func (csv) MediaTypes() []string                    { return []string{"text/csv"} }
+func (csv) Encode(w io.Writer, v interface{}) error { return ErrUnsupported }
+func (csv) Decode(r io.Reader, v interface{}) error { return ErrUnsupported }
+
+func TestNegotiate(t *testing.T) {
+	registry := NewRegistry(JSON{}, YAML{}, MessagePack{}, csv{})
+
+	tests := []struct {
+		accept   string
+		expected []Codec
+	}{
+		{"", []Codec{JSON{}, YAML{}, MessagePack{}, csv{}}},
+		{"*/*", []Codec{JSON{}, YAML{}, MessagePack{}, csv{}}},
+		{"text/csv", []Codec{csv{}}},
+		{"text/*", []Codec{YAML{}, csv{}}},
+		{"application/x-yaml", []Codec{YAML{}}},
+		{"application/msgpack;q=0.5, application/yaml", []Codec{YAML{}, MessagePack{}}},
+		{"text/html, application/xml;q=0.9, */*;q=0.1", []Codec{JSON{}, YAML{}, MessagePack{}, csv{}}},
+		{"application/vnd.msgpack, */*;q=0", []Codec{MessagePack{}}},
+		{"text/csv, application/json;q=0.5, application/yaml;q=0.5", []Codec{csv{}, JSON{}, YAML{}}},
+		{"text/html", []Codec{}},
+		{"application/json;q=0", []Codec{}},
+	}
+
+	for _, test := range tests {
+		if actual := registry.Negotiate(test.accept); !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%q: %#v != %#v", test.accept, actual, test.expected)
+		}
+	}
+
+	if c := registry.Lookup("application/json; charset=utf-8"); c != (JSON{}) {
+		t.Errorf("Lookup: %#v", c)
+	}
+
+	registry.Register(JSON{})
+	if types := registry.MediaTypes(); !reflect.DeepEqual(types, []string{"application/yaml", "application/msgpack", "text/csv", "application/json"}) {
+		t.Errorf("MediaTypes: %s", types)
+	}
+}
+
+func TestFormats(t *testing.T) {
+	type todo struct {
+		ID     int64      `json:"id"`
+		Desc   string     `json:"desc"`
+		Due    *time.Time `json:"due"`
+		Weight float64    `json:"weight"`
+		Tags   []string   `json:"tags"`
+	}
+
+	due := time.Date(2019, 11, 20, 9, 30, 0, 0, time.UTC)
+	v := &todo{ID: 1000000, Desc: "Fix roof", Due: &due, Weight: 1.5, Tags: []string{"attic"}}
+
+	var buf bytes.Buffer
+	if err := (YAML{}).Encode(&buf, v); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "desc: Fix roof\ndue: \"2019-11-20T09:30:00Z\"\nid: 1000000\ntags:\n  - attic\nweight: 1.5\n"
+	if buf.String() != expected {
+		t.Errorf("%s != %s", buf.String(), expected)
+	}
+
+	for _, c := range []Codec{JSON{}, YAML{}, MessagePack{}} {
+		buf.Reset()
+		if err := c.Encode(&buf, v); err != nil {
+			t.Fatal(err)
+		}
+
+		actual := &todo{}
+		if err := c.Decode(&buf, actual); err != nil {
+			t.Fatal(err)
+		} else if !reflect.DeepEqual(actual, v) {
+			t.Errorf("%T: %s != %s", c, spew.Sdump(actual), spew.Sdump(v))
+		}
+	}
+
+	var data map[string]interface{}
+	if err := (YAML{}).Decode(strings.NewReader("desc: Paint\ndue: 2019-11-20\nfields: {points: 3}\n"), &data); err != nil {
+		t.Fatal(err)
+	}
+
+	expectedData := map[string]interface{}{"desc": "Paint", "due": "2019-11-20", "fields": map[string]interface{}{"points": float64(3)}}
+	if !reflect.DeepEqual(data, expectedData) {
+		t.Errorf("%s != %s", spew.Sdump(data), spew.Sdump(expectedData))
+	}
+}
@@ -0,0 +1,136 @@
+package codec
+
+import (
+	"github.com/vmihailenco/msgpack/v4"
+	"gopkg.in/yaml.v3"
+
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// JSON is the application/json codec.
+type JSON struct{}
+
+func (JSON) MediaTypes() []string {
+	return []string{"application/json"}
+}
+
+func (JSON) Encode(w io.Writer, v interface{}) error {
+	return json.NewEncoder(w).Encode(v)
+}
+
+func (JSON) Decode(r io.Reader, v interface{}) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// YAML encodes values as YAML with the field names of their JSON encoding.
+type YAML struct{}
+
+func (YAML) MediaTypes() []string {
+	return []string{"application/yaml", "application/x-yaml", "text/yaml", "text/x-yaml"}
+}
+
+func (YAML) Encode(w io.Writer, v interface{}) error {
+	generic, err := Generic(v)
+	if err != nil {
+		return err
+	}
+
+	encoder := yaml.NewEncoder(w)
+	encoder.SetIndent(2)
+	if err = encoder.Encode(generic); err != nil {
+		return err
+	}
+	return encoder.Close()
+}
+
+// Decode decodes a YAML document into v as json.Unmarshal would. Timestamps
+// are kept as written, so that a date stays a date.
+func (YAML) Decode(r io.Reader, v interface{}) error {
+	var node yaml.Node
+	if err := yaml.NewDecoder(r).Decode(&node); err != nil {
+		return err
+	}
+
+	generic, err := yamlValue(&node)
+	if err != nil {
+		return err
+	}
+
+	return fromGeneric(generic, v)
+}
+
+func yamlValue(node *yaml.Node) (interface{}, error) {
+	switch node.Kind {
+	case yaml.DocumentNode:
+		if len(node.Content) == 0 {
+			return nil, nil
+		}
+		return yamlValue(node.Content[0])
+	case yaml.AliasNode:
+		return yamlValue(node.Alias)
+	case yaml.MappingNode:
+		result := map[string]interface{}{}
+		for i := 0; i+1 < len(node.Content); i += 2 {
+			key, value := node.Content[i], node.Content[i+1]
+			if key.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("line %d: mapping keys must be strings", key.Line)
+			}
+
+			v, err := yamlValue(value)
+			if err != nil {
+				return nil, err
+			}
+			result[key.Value] = v
+		}
+		return result, nil
+	case yaml.SequenceNode:
+		result := []interface{}{}
+		for _, item := range node.Content {
+			v, err := yamlValue(item)
+			if err != nil {
+				return nil, err
+			}
+			result = append(result, v)
+		}
+		return result, nil
+	}
+
+	if node.ShortTag() == "!!timestamp" {
+		return node.Value, nil
+	}
+
+	var result interface{}
+	err := node.Decode(&result)
+	return result, err
+}
+
+// MessagePack encodes values as MessagePack with the field names of their
+// JSON encoding.
+type MessagePack struct{}
+
+func (MessagePack) MediaTypes() []string {
+	return []string{"application/msgpack", "application/x-msgpack", "application/vnd.msgpack"}
+}
+
+func (MessagePack) Encode(w io.Writer, v interface{}) error {
+	generic, err := Generic(v)
+	if err != nil {
+		return err
+	}
+	return msgpack.NewEncoder(w).SortMapKeys(true).Encode(generic)
+}
+
+func (MessagePack) Decode(r io.Reader, v interface{}) error {
+	var generic interface{}
+	if err := msgpack.NewDecoder(r).Decode(&generic); err != nil {
+		return err
+	}
+	return fromGeneric(generic, v)
+}
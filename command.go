@@ -0,0 +1,98 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/blob"
+
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+// commands are the subcommands of the todo binary, which otherwise runs the
+// server.
+var commands = map[string]func(db *sql.DB, store blob.Store, args []string, stdin io.Reader, stdout io.Writer) error{
+	"export-all": exportAllCommand,
+	"import-all": importAllCommand,
+}
+
+// runCommand runs the subcommand named by args[0] against the database and
+// attachment store of config.
+func runCommand(config *Config, args []string) error {
+	command, ok := commands[args[0]]
+	if !ok {
+		return fmt.Errorf("unknown command %s, expected export-all or import-all", args[0])
+	}
+
+	db, err := OpenDB(config.Database)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	return command(db, blob.NewFileStore(config.AttachmentDir), args[1:], os.Stdin, os.Stdout)
+}
+
+// exportAllCommand writes an archive of the database to a file or stdout:
+//
+//	todo export-all [-format json|ndjson] [-o FILE]
+func exportAllCommand(db *sql.DB, store blob.Store, args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("export-all", flag.ContinueOnError)
+	format := flags.String("format", "json", "archive format, json or ndjson")
+	output := flags.String("o", "", "file to write the archive to instead of stdout")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *format != "json" && *format != "ndjson" {
+		return fmt.Errorf("invalid format %s, expected json or ndjson", *format)
+	}
+
+	if *output == "" {
+		return ExportArchive(db, store, stdout, *format == "ndjson")
+	}
+
+	f, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+
+	if err = ExportArchive(db, store, f, *format == "ndjson"); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// importAllCommand adds an archive from a file or stdin to the database and
+// prints what was imported:
+//
+//	todo import-all [FILE]
+func importAllCommand(db *sql.DB, store blob.Store, args []string, stdin io.Reader, stdout io.Writer) error {
+	flags := flag.NewFlagSet("import-all", flag.ContinueOnError)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	r := stdin
+	if flags.NArg() > 0 {
+		f, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	summary, err := ImportArchive(db, store, r)
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(stdout)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(summary)
+}
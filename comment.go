@@ -0,0 +1,138 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/query"
+
+	"database/sql"
+	"fmt"
+	"time"
+)
+
+const commentColumns = "rowid, todo_id, author_id, body, created, edited"
+
+type Comment struct {
+	ID       int64      `db:"id" json:"id"`
+	TodoID   int64      `db:"todo_id" json:"todo_id"`
+	AuthorID *int64     `db:"author_id" json:"author_id"`
+	Body     string     `db:"body" json:"body"`
+	Created  time.Time  `db:"created" json:"created"`
+	Edited   *time.Time `db:"edited" json:"edited"`
+}
+
+type CommentList []*Comment
+
+type CommentManager struct {
+	Database *sql.DB
+}
+
+func NewCommentManager(db *sql.DB) *CommentManager {
+	return &CommentManager{db}
+}
+
+func scanComment(s scanner) (*Comment, error) {
+	var authorID sql.NullInt64
+	var edited sql.NullTime
+
+	c := &Comment{}
+	if err := s.Scan(&c.ID, &c.TodoID, &authorID, &c.Body, &c.Created, &edited); err != nil {
+		return nil, err
+	}
+
+	if authorID.Valid {
+		c.AuthorID = &authorID.Int64
+	}
+
+	if edited.Valid {
+		c.Edited = &edited.Time
+	}
+
+	return c, nil
+}
+
+// Get returns comment id of the todo identified by todoID.
+func (r *CommentManager) Get(todoID int64, id int64) (*Comment, error) {
+	row := r.Database.QueryRow("SELECT "+commentColumns+" FROM comment WHERE rowid = ? AND todo_id = ?", id, todoID)
+	return scanComment(row)
+}
+
+func (r *CommentManager) Query(filter *query.Query) (CommentList, error) {
+	rows, err := r.Database.Query("SELECT "+commentColumns+" FROM comment"+filter.Query(), filter.Values()...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := CommentList{}
+
+	for rows.Next() {
+		var c *Comment
+		if c, err = scanComment(rows); err != nil {
+			return nil, err
+		}
+		results = append(results, c)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (r *CommentManager) Count(filter *query.Query) (int64, error) {
+	var count int64
+	row := r.Database.QueryRow("SELECT COUNT(*) FROM comment"+filter.Query(), filter.Values()...)
+	err := row.Scan(&count)
+	return count, err
+}
+
+// Create adds a comment to the todo identified by todoID. A nil author
+// records an anonymous comment.
+func (r *CommentManager) Create(todoID int64, author *int64, data map[string]interface{}) (*Comment, error) {
+	var result sql.Result
+	var id int64
+	var err error
+
+	d := TodoMap{}
+	for k, v := range data {
+		d[k] = v
+	}
+	d["todo_id"] = todoID
+	d["author_id"] = author
+	d["created"] = time.Now().UTC()
+
+	insert := d.InsertVars()
+	q := fmt.Sprintf("INSERT INTO comment(%s) VALUES(%s)", insert.Names, insert.Bindvars)
+
+	if result, err = r.Database.Exec(q, insert.Values...); err != nil {
+		return nil, err
+	}
+
+	if id, err = result.LastInsertId(); err != nil {
+		return nil, err
+	}
+
+	return r.Get(todoID, id)
+}
+
+func (r *CommentManager) Update(todoID int64, id int64, data map[string]interface{}) (*Comment, error) {
+	d := TodoMap{}
+	for k, v := range data {
+		d[k] = v
+	}
+	d["edited"] = time.Now().UTC()
+
+	update := d.UpdateVars()
+	q := fmt.Sprintf("UPDATE comment SET %s WHERE rowid = ? AND todo_id = ?;", update.Bindvars)
+
+	if _, err := r.Database.Exec(q, append(update.Values, id, todoID)...); err != nil {
+		return nil, err
+	}
+
+	return r.Get(todoID, id)
+}
+
+func (r *CommentManager) Delete(todoID int64, id int64) error {
+	_, err := r.Database.Exec("DELETE FROM comment WHERE rowid = ? AND todo_id = ?;", id, todoID)
+	return err
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/apierror"
+	"github.com/marcgwilson/todo/query"
+
+	"log"
+	"net/http"
+)
+
+type CommentPage struct {
+	Next     string      `json:"next"`
+	Previous string      `json:"previous"`
+	Results  CommentList `json:"results"`
+}
+
+func (r *Handler) CommentListFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		id := pathID(req, "id")
+
+		if _, err := r.TM.Get(id); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+			return
+		}
+
+		result, ae := query.ParsePage(req.URL.Query())
+		if ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		result.Set("todo", query.NewIDQueryParam("todo_id", id))
+		result.Set("sort", query.NewSortQueryParam())
+		result.Paginate(r.Config.Limit)
+
+		list, err := r.CM.Query(result.Query())
+		if err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusBadRequest, Message: err.Error()})
+			return
+		}
+
+		page := &CommentPage{Results: list}
+		if count, err := r.CM.Count(result.ShallowCopy().Depaginate().Query()); err != nil {
+			log.Printf("ERROR: r.CM.Count: %s", err)
+		} else {
+			page.Next = query.NextPage(result, req.URL, count)
+		}
+
+		page.Previous = query.PrevPage(result, req.URL)
+		writeJSON(w, http.StatusOK, page)
+	}
+}
+
+// CommentCreateFunc adds a comment authored by the caller, or an anonymous
+// comment when the request has no X-User header.
+func (r *Handler) CommentCreateFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		id := pathID(req, "id")
+
+		var data TodoMap
+		var ae *apierror.Error
+		var author *User
+
+		if _, err := r.TM.Get(id); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+			return
+		}
+
+		if author, ae = r.caller(req); ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		if data, ae = UnmarshalJSONRequest(req); ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		var authorID *int64
+		if author != nil {
+			authorID = &author.ID
+		}
+
+		if ae = validate(r.CommentValidator, data); ae != nil {
+			writeError(w, ae)
+		} else if c, err := r.CM.Create(id, authorID, data); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusBadRequest, Message: err.Error()})
+		} else {
+			writeJSON(w, http.StatusCreated, c)
+		}
+	}
+}
+
+// authorize looks up the comment addressed by req and checks that the caller
+// may change it: comments with an author can only be changed by that author.
+func (r *Handler) authorize(req *http.Request) (*Comment, *apierror.Error) {
+	c, err := r.CM.Get(pathID(req, "id"), pathID(req, "cid"))
+	if err != nil {
+		return nil, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"}
+	}
+
+	u, ae := r.caller(req)
+	if ae != nil {
+		return nil, ae
+	}
+
+	if c.AuthorID != nil && (u == nil || u.ID != *c.AuthorID) {
+		return nil, &apierror.Error{Code: http.StatusForbidden, Message: "Only the author can change a comment"}
+	}
+
+	return c, nil
+}
+
+func (r *Handler) CommentUpdateFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		var data TodoMap
+		var c *Comment
+		var ae *apierror.Error
+
+		if c, ae = r.authorize(req); ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		if data, ae = UnmarshalJSONRequest(req); ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		if ae = validate(r.CommentValidator, data); ae != nil {
+			writeError(w, ae)
+		} else if c, err := r.CM.Update(c.TodoID, c.ID, data); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusBadRequest, Message: err.Error()})
+		} else {
+			writeJSON(w, http.StatusOK, c)
+		}
+	}
+}
+
+func (r *Handler) CommentDeleteFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		c, ae := r.authorize(req)
+		if ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		if err := r.CM.Delete(c.TodoID, c.ID); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
@@ -1,43 +1,218 @@
 package main
 
 import (
+	"github.com/marcgwilson/todo/notify"
+
 	"fmt"
 	"os"
 	"strconv"
+	"strings"
+	"time"
 )
 
 type Config struct {
 	Database string
 	Port     int
 	Limit    int64
+	// StrictSubtasks prevents a todo from moving to done while any of its
+	// children are still open.
+	StrictSubtasks bool
+	// Priority is assigned to new todos that do not specify one.
+	Priority int64
+	// AutoAssign assigns an unassigned todo to the caller when it moves to
+	// in_progress.
+	AutoAssign bool
+	// TimerStartsTodo moves a todo to in_progress when a timer is started
+	// on it.
+	TimerStartsTodo bool
+	// AttachmentDir is where the local blob store keeps attachment content.
+	AttachmentDir     string
+	AttachmentMaxSize int64
+	// AttachmentTypes lists the accepted media types; "image/*" accepts
+	// every image type.
+	AttachmentTypes []string
+	// Notifier selects how reminders are delivered: log, webhook, smtp or
+	// none.
+	Notifier   string
+	WebhookURL string
+	SMTP       notify.SMTPConfig
+	// NotifyChanges notifies assignees when others update their todos.
+	NotifyChanges bool
+	// SchedulerInterval is how often reminders are checked. Zero disables
+	// the scheduler.
+	SchedulerInterval time.Duration
+	// DigestHour is the local hour daily digests are sent. Negative disables
+	// digests.
+	DigestHour int
+	// Undated places todos without a due date first or last when lists are
+	// sorted or grouped by due, unless the undated query parameter is given.
+	Undated string
+	// AdminToken authorizes the admin endpoints as a bearer token. They are
+	// disabled when it is empty.
+	AdminToken string
 }
 
 func (r *Config) Addr() string {
 	return fmt.Sprintf(":%d", r.Port)
 }
 
+func DefaultConfig() *Config {
+	return &Config{
+		Database: "todo.db",
+		Port:     8000,
+		Limit:    20,
+		Priority: 2,
+
+		AttachmentDir:     "attachments",
+		AttachmentMaxSize: 10 << 20,
+		AttachmentTypes:   []string{"image/*", "application/pdf", "text/plain"},
+
+		Notifier: "log",
+		SMTP: notify.SMTPConfig{
+			Port: 587,
+			TLS:  "starttls",
+		},
+		SchedulerInterval: time.Minute,
+		DigestHour:        8,
+		Undated:           "last",
+	}
+}
+
 func LookupConfig() (*Config, error) {
 	var err error
 
-	database := "todo.db"
-	port := 8000
-	limit := int64(20)
+	config := DefaultConfig()
 
 	if env, ok := os.LookupEnv("TODO_DB"); ok {
-		database = env
+		config.Database = env
 	}
 
 	if env, ok := os.LookupEnv("TODO_PORT"); ok {
-		if port, err = strconv.Atoi(env); err != nil {
+		if config.Port, err = strconv.Atoi(env); err != nil {
 			return nil, fmt.Errorf("Error parsing TODO_PORT: %s", env)
 		}
 	}
 
 	if env, ok := os.LookupEnv("TODO_LIMIT"); ok {
-		if limit, err = strconv.ParseInt(env, 10, 64); err != nil {
+		if config.Limit, err = strconv.ParseInt(env, 10, 64); err != nil {
 			return nil, fmt.Errorf("Error parsing TODO_LIMIT: %s", env)
 		}
 	}
 
-	return &Config{database, port, limit}, nil
+	if env, ok := os.LookupEnv("TODO_STRICT_SUBTASKS"); ok {
+		if config.StrictSubtasks, err = strconv.ParseBool(env); err != nil {
+			return nil, fmt.Errorf("Error parsing TODO_STRICT_SUBTASKS: %s", env)
+		}
+	}
+
+	if env, ok := os.LookupEnv("TODO_PRIORITY"); ok {
+		if config.Priority, err = strconv.ParseInt(env, 10, 64); err != nil || config.Priority < 0 || config.Priority > 4 {
+			return nil, fmt.Errorf("Error parsing TODO_PRIORITY: %s", env)
+		}
+	}
+
+	if env, ok := os.LookupEnv("TODO_AUTO_ASSIGN"); ok {
+		if config.AutoAssign, err = strconv.ParseBool(env); err != nil {
+			return nil, fmt.Errorf("Error parsing TODO_AUTO_ASSIGN: %s", env)
+		}
+	}
+
+	if env, ok := os.LookupEnv("TODO_TIMER_STARTS_TODO"); ok {
+		if config.TimerStartsTodo, err = strconv.ParseBool(env); err != nil {
+			return nil, fmt.Errorf("Error parsing TODO_TIMER_STARTS_TODO: %s", env)
+		}
+	}
+
+	if env, ok := os.LookupEnv("TODO_ATTACHMENT_DIR"); ok {
+		config.AttachmentDir = env
+	}
+
+	if env, ok := os.LookupEnv("TODO_ATTACHMENT_MAX_SIZE"); ok {
+		if config.AttachmentMaxSize, err = strconv.ParseInt(env, 10, 64); err != nil || config.AttachmentMaxSize < 1 {
+			return nil, fmt.Errorf("Error parsing TODO_ATTACHMENT_MAX_SIZE: %s", env)
+		}
+	}
+
+	if env, ok := os.LookupEnv("TODO_ATTACHMENT_TYPES"); ok {
+		config.AttachmentTypes = []string{}
+		for _, t := range strings.Split(env, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				config.AttachmentTypes = append(config.AttachmentTypes, t)
+			}
+		}
+	}
+
+	if env, ok := os.LookupEnv("TODO_NOTIFIER"); ok {
+		config.Notifier = env
+	}
+
+	if env, ok := os.LookupEnv("TODO_WEBHOOK_URL"); ok {
+		config.WebhookURL = env
+	}
+
+	if env, ok := os.LookupEnv("TODO_SMTP_HOST"); ok {
+		config.SMTP.Host = env
+	}
+
+	if env, ok := os.LookupEnv("TODO_SMTP_PORT"); ok {
+		if config.SMTP.Port, err = strconv.Atoi(env); err != nil {
+			return nil, fmt.Errorf("Error parsing TODO_SMTP_PORT: %s", env)
+		}
+	}
+
+	if env, ok := os.LookupEnv("TODO_SMTP_USERNAME"); ok {
+		config.SMTP.Username = env
+	}
+
+	if env, ok := os.LookupEnv("TODO_SMTP_PASSWORD"); ok {
+		config.SMTP.Password = env
+	}
+
+	if env, ok := os.LookupEnv("TODO_SMTP_FROM"); ok {
+		config.SMTP.From = env
+	}
+
+	if env, ok := os.LookupEnv("TODO_SMTP_TO"); ok {
+		config.SMTP.To = []string{}
+		for _, addr := range strings.Split(env, ",") {
+			if addr = strings.TrimSpace(addr); addr != "" {
+				config.SMTP.To = append(config.SMTP.To, addr)
+			}
+		}
+	}
+
+	if env, ok := os.LookupEnv("TODO_SMTP_TLS"); ok {
+		config.SMTP.TLS = env
+	}
+
+	if env, ok := os.LookupEnv("TODO_NOTIFY_CHANGES"); ok {
+		if config.NotifyChanges, err = strconv.ParseBool(env); err != nil {
+			return nil, fmt.Errorf("Error parsing TODO_NOTIFY_CHANGES: %s", env)
+		}
+	}
+
+	if env, ok := os.LookupEnv("TODO_DIGEST_HOUR"); ok {
+		if config.DigestHour, err = strconv.Atoi(env); err != nil || config.DigestHour > 23 {
+			return nil, fmt.Errorf("Error parsing TODO_DIGEST_HOUR: %s", env)
+		}
+	}
+
+	if env, ok := os.LookupEnv("TODO_SCHEDULER_INTERVAL"); ok {
+		if config.SchedulerInterval, err = time.ParseDuration(env); err != nil {
+			return nil, fmt.Errorf("Error parsing TODO_SCHEDULER_INTERVAL: %s", env)
+		}
+	}
+
+	if env, ok := os.LookupEnv("TODO_UNDATED"); ok {
+		if env != "first" && env != "last" {
+			return nil, fmt.Errorf("Error parsing TODO_UNDATED: %s", env)
+		}
+		config.Undated = env
+	}
+
+	if env, ok := os.LookupEnv("TODO_ADMIN_TOKEN"); ok {
+		config.AdminToken = env
+	}
+
+	return config, nil
 }
@@ -0,0 +1,289 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/codec"
+	"github.com/marcgwilson/todo/query"
+	"github.com/marcgwilson/todo/state"
+
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// csvColumns are the columns of a CSV export, followed by a cf.<name> column
+// for each custom field.
+var csvColumns = []string{"id", "desc", "due", "state", "priority", "project", "assignee", "recurrence", "estimate", "timezone", "all_day", "created", "completed"}
+
+// csvImportFields are the fields a CSV import column can be mapped to, along
+// with cf.<name> for a custom field. project and assignee are names.
+var csvImportFields = []string{"desc", "due", "state", "priority", "project", "assignee", "recurrence", "estimate", "timezone", "all_day"}
+
+func csvTime(t *time.Time, loc *time.Location) string {
+	if t == nil {
+		return ""
+	}
+	return t.In(loc).Format(time.RFC3339)
+}
+
+// csvFormulaPrefixes are the characters that make a spreadsheet evaluate a
+// cell as a formula.
+const csvFormulaPrefixes = "=+-@\t\r"
+
+// csvCell escapes user-entered text for a CSV export by prefixing a cell that
+// a spreadsheet would evaluate as a formula with a quote.
+func csvCell(s string) string {
+	if s != "" && strings.IndexByte(csvFormulaPrefixes, s[0]) >= 0 {
+		return "'" + s
+	}
+	return s
+}
+
+// csvUncell reverses csvCell so that an export imports unchanged.
+func csvUncell(s string) string {
+	if len(s) > 1 && s[0] == '\'' && strings.IndexByte(csvFormulaPrefixes, s[1]) >= 0 {
+		return s[1:]
+	}
+	return s
+}
+
+// csvCustomField formats the value of a custom field as a CSV cell.
+func csvCustomField(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return csvCell(fmt.Sprint(v))
+	}
+}
+
+// csvHeader returns the header row of a CSV export.
+func csvHeader(fields FieldList) []string {
+	header := append([]string{}, csvColumns...)
+	for _, f := range fields {
+		header = append(header, query.CustomFieldPrefix+f.Name)
+	}
+	return header
+}
+
+// csvRecord returns t as a row of a CSV export with the names of its project
+// and assignee. Times are given in the time zone of t and the due date of an
+// all-day todo is a date. Text entered by users is escaped with csvCell.
+func csvRecord(t *Todo, fields FieldList, project string, assignee string) []string {
+	loc := t.Location()
+
+	due := csvTime(t.Due, loc)
+	if t.AllDay && t.Due != nil {
+		due = t.Due.In(loc).Format(dayFormat)
+	}
+
+	var estimate string
+	if t.Estimate != nil {
+		estimate = strconv.FormatInt(*t.Estimate, 10)
+	}
+
+	record := []string{
+		strconv.FormatInt(t.ID, 10),
+		csvCell(t.Description),
+		due,
+		string(t.State),
+		strconv.FormatInt(t.Priority, 10),
+		csvCell(project),
+		csvCell(assignee),
+		t.Recurrence,
+		estimate,
+		t.Timezone,
+		strconv.FormatBool(t.AllDay),
+		csvTime(t.Created, loc),
+		csvTime(t.Completed, loc),
+	}
+
+	for _, f := range fields {
+		record = append(record, csvCustomField(t.CustomFields[f.Name]))
+	}
+
+	return record
+}
+
+// csvMapping maps the columns of a CSV header to import fields. By default a
+// column is imported into the field of the same name, ignoring case; mapping
+// gives the field of a column by its header. Columns without a field, such as
+// the id and timestamps of an export, are returned as ignored.
+func csvMapping(header []string, mapping map[string]string, fields FieldList) ([]string, []string, error) {
+	known := map[string]bool{}
+	for _, name := range csvImportFields {
+		known[name] = true
+	}
+	for _, f := range fields {
+		known[query.CustomFieldPrefix+f.Name] = true
+	}
+
+	for column, field := range mapping {
+		if !known[field] {
+			return nil, nil, fmt.Errorf("unknown field %s for column %s", field, column)
+		}
+	}
+
+	result := make([]string, len(header))
+	ignored := []string{}
+	columns := map[string]string{}
+	found := map[string]bool{}
+
+	for i, column := range header {
+		field, ok := mapping[column]
+		if !ok {
+			field = strings.ToLower(strings.TrimSpace(column))
+		}
+
+		if !known[field] {
+			ignored = append(ignored, column)
+			continue
+		}
+
+		if other, ok := columns[field]; ok {
+			return nil, nil, fmt.Errorf("columns %s and %s are both mapped to %s", other, column, field)
+		}
+
+		columns[field] = column
+		found[column] = true
+		result[i] = field
+	}
+
+	for column := range mapping {
+		if !found[column] {
+			return nil, nil, fmt.Errorf("no column %s", column)
+		}
+	}
+
+	return result, ignored, nil
+}
+
+// csvTodo converts a row of a CSV import into todo data, returning the
+// reasons it cannot be imported, if any. Empty cells are left out, cells
+// escaped by csvCell are restored and the state defaults to todo. Values
+// that do not parse are kept as strings for CreateValidator to reject.
+func csvTodo(record []string, columns []string, fields map[string]*Field, projects map[string]*Project, users map[string]*User) (TodoMap, []string) {
+	d := TodoMap{"state": string(state.Todo)}
+	custom := map[string]interface{}{}
+	messages := []string{}
+
+	for i, value := range record {
+		if i >= len(columns) || columns[i] == "" || value == "" {
+			continue
+		}
+
+		value = csvUncell(value)
+
+		switch field := columns[i]; field {
+		case "priority", "estimate":
+			if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+				d[field] = n
+			} else {
+				d[field] = value
+			}
+		case "all_day":
+			if b, err := strconv.ParseBool(value); err == nil {
+				d[field] = b
+			} else {
+				d[field] = value
+			}
+		case "project":
+			if p, ok := projects[value]; !ok {
+				messages = append(messages, "unknown project "+value)
+			} else if p.Archived {
+				messages = append(messages, "project "+value+" is archived")
+			} else {
+				d["project_id"] = p.ID
+			}
+		case "assignee":
+			if u, ok := users[value]; !ok {
+				messages = append(messages, "unknown user "+value)
+			} else {
+				d["assignee_id"] = u.ID
+			}
+		default:
+			if !strings.HasPrefix(field, query.CustomFieldPrefix) {
+				d[field] = value
+				break
+			}
+
+			name := field[len(query.CustomFieldPrefix):]
+			custom[name] = value
+			switch fields[name].Type {
+			case "number":
+				if n, err := strconv.ParseFloat(value, 64); err == nil {
+					custom[name] = n
+				}
+			case "bool":
+				if b, err := strconv.ParseBool(value); err == nil {
+					custom[name] = b
+				}
+			}
+		}
+	}
+
+	if len(custom) > 0 {
+		d["custom_fields"] = custom
+	}
+
+	return d, messages
+}
+
+// csvCodec encodes a todo or a page of todos in the columns of a CSV export.
+// Request bodies are imported with POST /import?format=csv instead.
+type csvCodec struct {
+	handler *Handler
+}
+
+func (r *csvCodec) MediaTypes() []string {
+	return []string{"text/csv"}
+}
+
+func (r *csvCodec) Encode(w io.Writer, v interface{}) error {
+	var list TodoList
+	switch v := v.(type) {
+	case *Todo:
+		list = TodoList{v}
+	case *PaginatedResponse:
+		list = v.Results
+	default:
+		return codec.ErrUnsupported
+	}
+
+	projects, users, err := r.handler.names()
+	if err != nil {
+		return err
+	}
+
+	fields, err := r.handler.FM.List()
+	if err != nil {
+		return err
+	}
+
+	cw := csv.NewWriter(w)
+	cw.Write(csvHeader(fields))
+
+	for _, t := range list {
+		var project, assignee string
+		if t.ProjectID != nil {
+			project = projects[*t.ProjectID]
+		}
+		if t.AssigneeID != nil {
+			assignee = users[*t.AssigneeID]
+		}
+		cw.Write(csvRecord(t, fields, project, assignee))
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+func (r *csvCodec) Decode(body io.Reader, v interface{}) error {
+	return codec.ErrUnsupported
+}
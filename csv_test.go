@@ -0,0 +1,51 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestCSVFormulaCells(t *testing.T) {
+	fields := FieldList{&Field{Name: "note", Type: "text"}, &Field{Name: "hours", Type: "number"}}
+	todo := &Todo{
+		ID:           1,
+		Description:  "=HYPERLINK(\"http://example.com\")",
+		State:        "todo",
+		CustomFields: map[string]interface{}{"note": "@SUM(A1:A2)", "hours": -1.5},
+	}
+
+	record := csvRecord(todo, fields, "+attic", "-ivan")
+
+	column := map[string]int{}
+	for i, name := range csvHeader(fields) {
+		column[name] = i
+	}
+
+	expected := map[string]string{
+		"desc":     "'=HYPERLINK(\"http://example.com\")",
+		"project":  "'+attic",
+		"assignee": "'-ivan",
+		"cf.note":  "'@SUM(A1:A2)",
+		"cf.hours": "-1.5",
+		"state":    "todo",
+	}
+	for name, value := range expected {
+		if record[column[name]] != value {
+			t.Errorf("%s = %q != %q", name, record[column[name]], value)
+		}
+	}
+
+	for _, cell := range []string{"\tTab", "\rReturn", "plain", "'quoted", ""} {
+		if actual := csvUncell(csvCell(cell)); actual != cell {
+			t.Errorf("csvUncell(csvCell(%q)) = %q", cell, actual)
+		}
+	}
+
+	columns := []string{"desc", "cf.note"}
+	d, messages := csvTodo([]string{record[column["desc"]], record[column["cf.note"]]}, columns,
+		map[string]*Field{"note": fields[0]}, nil, nil)
+
+	if len(messages) != 0 || d["desc"] != todo.Description || !reflect.DeepEqual(d["custom_fields"], map[string]interface{}{"note": "@SUM(A1:A2)"}) {
+		t.Errorf("d = %v, messages = %v", d, messages)
+	}
+}
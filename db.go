@@ -2,10 +2,58 @@ package main
 
 import (
 	"database/sql"
+	"fmt"
 	_ "github.com/mattn/go-sqlite3"
 	"reflect"
+	"strings"
 )
 
+// AlterStmts upgrade tables created by earlier versions of CreateStmt.
+var AlterStmts = []string{
+	"ALTER TABLE todo ADD COLUMN project_id INTEGER",
+	"ALTER TABLE todo ADD COLUMN parent_id INTEGER",
+	"ALTER TABLE todo ADD COLUMN recurrence TEXT",
+	"ALTER TABLE todo ADD COLUMN priority INTEGER DEFAULT 2",
+	"ALTER TABLE todo ADD COLUMN assignee_id INTEGER",
+	"ALTER TABLE todo ADD COLUMN estimate INTEGER",
+	"ALTER TABLE todo ADD COLUMN created TIMESTAMP",
+	"ALTER TABLE todo ADD COLUMN completed TIMESTAMP",
+	"ALTER TABLE todo ADD COLUMN timezone TEXT DEFAULT ''",
+	"ALTER TABLE todo ADD COLUMN all_day BOOLEAN DEFAULT 0",
+	"ALTER TABLE todo ADD COLUMN modified TIMESTAMP",
+	"ALTER TABLE todo ADD COLUMN archived BOOLEAN DEFAULT 0",
+	"ALTER TABLE user ADD COLUMN feed_token TEXT DEFAULT ''",
+	// digest_sent replaces digest, which was keyed by user name.
+	"DROP TABLE IF EXISTS digest",
+}
+
+// timestampColumns are compared in SQL and so are stored in
+// query.TimestampFormat.
+var timestampColumns = [][2]string{
+	{"todo", "due"},
+	{"todo", "created"},
+	{"todo", "completed"},
+	{"todo", "modified"},
+	{"notification", "due"},
+}
+
+// NormalizeStmts rewrite the timestamps stored by earlier versions, which kept
+// the zone they were given in or were integers of nanoseconds, in
+// query.TimestampFormat. Fractions beyond milliseconds are lost.
+var NormalizeStmts = normalizeStmts()
+
+func normalizeStmts() []string {
+	stmts := []string{}
+	for _, c := range timestampColumns {
+		table, column := c[0], c[1]
+		stmts = append(stmts,
+			fmt.Sprintf("UPDATE %[1]s SET %[2]s = strftime('%%Y-%%m-%%d %%H:%%M:%%f000000+00:00', %[2]s / 1e9, 'unixepoch') WHERE typeof(%[2]s) = 'integer'", table, column),
+			fmt.Sprintf("UPDATE %[1]s SET %[2]s = strftime('%%Y-%%m-%%d %%H:%%M:%%f000000+00:00', %[2]s) WHERE typeof(%[2]s) = 'text' AND (length(%[2]s) != 35 OR %[2]s NOT LIKE '%%+00:00')", table, column),
+		)
+	}
+	return stmts
+}
+
 func OpenDB(name string) (*sql.DB, error) {
 	var db *sql.DB
 	var err error
@@ -18,10 +66,39 @@ func OpenDB(name string) (*sql.DB, error) {
 		db.Close()
 		return nil, err
 	}
+	if err = migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
 
 	return db, nil
 }
 
+func migrate(db *sql.DB) error {
+	for _, stmt := range AlterStmts {
+		if _, err := db.Exec(stmt); err != nil && !strings.HasPrefix(err.Error(), "duplicate column name") {
+			return err
+		}
+	}
+	for _, stmt := range NormalizeStmts {
+		if _, err := db.Exec(stmt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// inClause returns the bindvars and values for an SQL IN clause over ids.
+func inClause(ids []int64) (string, []interface{}) {
+	bindvars := make([]string, len(ids), len(ids))
+	values := make([]interface{}, len(ids), len(ids))
+	for i, id := range ids {
+		bindvars[i] = "?"
+		values[i] = id
+	}
+	return "(" + strings.Join(bindvars, ", ") + ")", values
+}
+
 func getFields(i interface{}) map[string]interface{} {
 	v := reflect.Indirect(reflect.ValueOf(i))
 	q := make(map[string]interface{})
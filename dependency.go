@@ -0,0 +1,143 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/state"
+
+	"database/sql"
+)
+
+// AddDependency records that the todo identified by id cannot start until the
+// todo identified by blocker is done.
+func (r *TodoManager) AddDependency(id int64, blocker int64) (*Todo, error) {
+	var err error
+
+	if _, err = r.Get(blocker); err == sql.ErrNoRows {
+		return nil, ErrBlockerNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	if err = r.checkDependency(id, blocker); err != nil {
+		return nil, err
+	}
+
+	if _, err = r.Database.Exec("INSERT OR IGNORE INTO dependency(todo_id, blocker_id) VALUES(?, ?);", id, blocker); err != nil {
+		return nil, err
+	}
+
+	return r.Get(id)
+}
+
+func (r *TodoManager) RemoveDependency(id int64, blocker int64) error {
+	result, err := r.Database.Exec("DELETE FROM dependency WHERE todo_id = ? AND blocker_id = ?;", id, blocker)
+	if err != nil {
+		return err
+	}
+
+	if count, err := result.RowsAffected(); err != nil {
+		return err
+	} else if count == 0 {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
+
+// checkDependency walks the dependencies of blocker and fails if the todo
+// identified by id is among them, since the new edge would close a cycle.
+func (r *TodoManager) checkDependency(id int64, blocker int64) error {
+	visited := map[int64]bool{}
+	queue := []int64{blocker}
+
+	for len(queue) > 0 {
+		current := queue[0]
+		queue = queue[1:]
+
+		if current == id {
+			return ErrDependencyCycle
+		}
+
+		if visited[current] {
+			continue
+		}
+		visited[current] = true
+
+		blockers, err := r.blockersOf(current)
+		if err != nil {
+			return err
+		}
+		queue = append(queue, blockers...)
+	}
+
+	return nil
+}
+
+func (r *TodoManager) blockersOf(id int64) ([]int64, error) {
+	rows, err := r.Database.Query("SELECT blocker_id FROM dependency WHERE todo_id = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []int64{}
+	for rows.Next() {
+		var blocker int64
+		if err = rows.Scan(&blocker); err != nil {
+			return nil, err
+		}
+		results = append(results, blocker)
+	}
+
+	return results, rows.Err()
+}
+
+func (r *TodoManager) openBlockers(id int64) (int64, error) {
+	var count int64
+
+	row := r.Database.QueryRow(`SELECT COUNT(*) FROM dependency d JOIN todo b ON b.rowid = d.blocker_id
+WHERE d.todo_id = ? AND b.state != ?`, id, state.Done)
+	err := row.Scan(&count)
+	return count, err
+}
+
+// loadDependencies sets BlockedBy and Blocks on every todo in list.
+func (r *TodoManager) loadDependencies(list TodoList) error {
+	if len(list) == 0 {
+		return nil
+	}
+
+	index := map[int64]*Todo{}
+	ids := make([]int64, len(list), len(list))
+	for i, t := range list {
+		t.BlockedBy = []int64{}
+		t.Blocks = []int64{}
+		index[t.ID] = t
+		ids[i] = t.ID
+	}
+
+	bindvars, values := inClause(ids)
+	values = append(values, values...)
+
+	rows, err := r.Database.Query("SELECT todo_id, blocker_id FROM dependency WHERE todo_id IN "+bindvars+" OR blocker_id IN "+bindvars+" ORDER BY rowid", values...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var todoID, blockerID int64
+		if err = rows.Scan(&todoID, &blockerID); err != nil {
+			return err
+		}
+
+		if t, ok := index[todoID]; ok {
+			t.BlockedBy = append(t.BlockedBy, blockerID)
+		}
+
+		if t, ok := index[blockerID]; ok {
+			t.Blocks = append(t.Blocks, todoID)
+		}
+	}
+
+	return rows.Err()
+}
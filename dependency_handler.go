@@ -0,0 +1,72 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/apierror"
+	"github.com/marcgwilson/todo/query"
+
+	"database/sql"
+	"net/http"
+)
+
+func (r *Handler) DependencyListFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		var t *Todo
+		var err error
+
+		if t, err = r.TM.Get(pathID(req, "id")); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+			return
+		}
+
+		if result, ae := r.parseQuery(req); ae != nil {
+			writeError(w, ae)
+		} else {
+			r.writeList(w, req, result.Set("blocker", query.NewIDQueryParam("rowid", t.BlockedBy...)))
+		}
+	}
+}
+
+func (r *Handler) DependencyCreateFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		id := pathID(req, "id")
+
+		var data TodoMap
+		var ae *apierror.Error
+
+		if _, err := r.TM.Get(id); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+			return
+		}
+
+		if data, ae = UnmarshalJSONRequest(req); ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		if ae = validate(r.DependencyValidator, data); ae != nil {
+			writeError(w, ae)
+		} else if t, err := r.TM.AddDependency(id, int64(data["blocker_id"].(float64))); err != nil {
+			writeError(w, managerError(err))
+		} else {
+			writeJSON(w, http.StatusCreated, t)
+		}
+	}
+}
+
+func (r *Handler) DependencyDeleteFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		if err := r.TM.RemoveDependency(pathID(req, "id"), pathID(req, "blocker")); err == sql.ErrNoRows {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+		} else if err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
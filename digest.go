@@ -0,0 +1,77 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/notify"
+	"github.com/marcgwilson/todo/query"
+	"github.com/marcgwilson/todo/state"
+
+	"time"
+)
+
+const dayFormat = "2006-01-02"
+
+// Digest collects the open todos that are overdue at now or due later on the
+// same day in now's location. A non-nil assignee restricts it to the todos
+// assigned to that user.
+func (r *TodoManager) Digest(now time.Time, assignee *int64) (*notify.Digest, error) {
+	loc := now.Location()
+	start := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, loc)
+	end := start.AddDate(0, 0, 1)
+
+	digest := &notify.Digest{
+		Date:     start,
+		Overdue:  []*notify.Notification{},
+		DueToday: []*notify.Notification{},
+	}
+
+	q := "SELECT rowid, desc, due, state FROM todo WHERE state != ? AND NOT archived AND due < ?"
+	values := []interface{}{state.Done, query.Timestamp(end)}
+
+	if assignee != nil {
+		q = q + " AND assignee_id = ?"
+		values = append(values, *assignee)
+	}
+
+	rows, err := r.Database.Query(q+" ORDER BY due, rowid", values...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		n := &notify.Notification{}
+		if err = rows.Scan(&n.TodoID, &n.Description, &n.Due, &n.State); err != nil {
+			return nil, err
+		}
+		local := n.Due.In(loc)
+		n.Due = &local
+
+		if n.Due.Before(now) {
+			n.Kind = notify.Overdue
+			digest.Overdue = append(digest.Overdue, n)
+		} else {
+			n.Kind = notify.Reminder
+			digest.DueToday = append(digest.DueToday, n)
+		}
+	}
+
+	return digest, rows.Err()
+}
+
+// DigestSent reports whether the digest for day was already sent to the user
+// identified by user, or the team digest when user is nil.
+func (r *TodoManager) DigestSent(user *int64, day time.Time) (bool, error) {
+	var count int64
+	row := r.Database.QueryRow("SELECT COUNT(*) FROM digest_sent WHERE user_id IS ? AND day = ?", user, day.Format(dayFormat))
+	if err := row.Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
+}
+
+func (r *TodoManager) MarkDigestSent(user *int64, day time.Time, now time.Time) error {
+	_, err := r.Database.Exec(`INSERT INTO digest_sent(user_id, day, sent) SELECT ?, ?, ?
+WHERE NOT EXISTS (SELECT 1 FROM digest_sent WHERE user_id IS ? AND day = ?);`,
+		user, day.Format(dayFormat), now.UTC(), user, day.Format(dayFormat))
+	return err
+}
@@ -0,0 +1,490 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/apierror"
+	"github.com/marcgwilson/todo/query"
+	"github.com/marcgwilson/todo/state"
+
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// FormatErrorMessage is the message of an unsupported format query parameter.
+const FormatErrorMessage = "value must be todotxt or csv"
+
+// exportBatch is the number of todos an export reads at a time.
+const exportBatch = 500
+
+// ImportResponse summarizes an import. Rows that cannot be imported are
+// reported in Errors. With DryRun set nothing is created, Created is the
+// number of todos that would have been and Results is empty.
+type ImportResponse struct {
+	Created int64                   `json:"created"`
+	Failed  int64                   `json:"failed"`
+	DryRun  bool                    `json:"dry_run"`
+	Ignored []string                `json:"ignored,omitempty"`
+	Errors  []*apierror.ErrorDetail `json:"errors"`
+	Results TodoList                `json:"results"`
+}
+
+// importRow is a todo to be imported along with the key and value of the
+// line or row it was read from, which identify it in ImportResponse.Errors.
+type importRow struct {
+	key   string
+	value interface{}
+	data  TodoMap
+}
+
+func formatError(format string) *apierror.Error {
+	return &apierror.Error{
+		Code:    http.StatusBadRequest,
+		Message: "Invalid query parameters",
+		Errors: []*apierror.ErrorDetail{
+			&apierror.ErrorDetail{Key: "format", Value: format, Message: FormatErrorMessage},
+		},
+	}
+}
+
+// ExportFunc writes the todos matching the list filters, unpaginated, in the
+// format given by the format query parameter. Todos are read and written a
+// batch at a time, in id order unless sorted otherwise.
+func (r *Handler) ExportFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		values := req.URL.Query()
+		format := values.Get("format")
+
+		if format != "todotxt" && format != "csv" {
+			writeError(w, formatError(format))
+			return
+		}
+
+		for _, key := range []string{"format", "group_by", "items", "page", "count"} {
+			values.Del(key)
+		}
+
+		if values.Get("sort") == "" {
+			values.Set("sort", "id")
+		}
+
+		result, ae := r.parseValues(req, values)
+		if ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		projects, users, err := r.names()
+		if err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+			return
+		}
+
+		var fields FieldList
+		if format == "csv" {
+			if fields, err = r.FM.List(); err != nil {
+				writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+				return
+			}
+		}
+
+		bw := bufio.NewWriter(w)
+		cw := csv.NewWriter(bw)
+		started := false
+
+		err = r.eachPage(result, func(list TodoList) error {
+			if !started {
+				started = true
+				if format == "csv" {
+					w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+					w.Header().Set("Content-Disposition", `attachment; filename="todo.csv"`)
+					cw.Write(csvHeader(fields))
+				} else {
+					w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+					w.Header().Set("Content-Disposition", `attachment; filename="todo.txt"`)
+				}
+				w.WriteHeader(http.StatusOK)
+			}
+
+			for _, t := range list {
+				var project, assignee string
+				if t.ProjectID != nil {
+					project = projects[*t.ProjectID]
+				}
+				if t.AssigneeID != nil {
+					assignee = users[*t.AssigneeID]
+				}
+
+				if format == "csv" {
+					cw.Write(csvRecord(t, fields, project, assignee))
+				} else {
+					io.WriteString(bw, NewTodoTxt(t, project, assignee).String()+"\n")
+				}
+			}
+
+			cw.Flush()
+			if err := cw.Error(); err != nil {
+				return err
+			}
+			return bw.Flush()
+		})
+
+		if err != nil && !started {
+			writeError(w, &apierror.Error{Code: http.StatusBadRequest, Message: err.Error()})
+		} else if err != nil {
+			log.Printf("ERROR: writing export: %s", err)
+		}
+	}
+}
+
+// eachPage calls fn with each page of exportBatch todos matching result, so
+// that an export of any size does not hold every todo in memory.
+func (r *Handler) eachPage(result *query.QueryParams, fn func(TodoList) error) error {
+	result.Paginate(exportBatch)
+
+	for page := int64(1); ; page++ {
+		list, err := r.TM.Query(result.SetPage(page).Query())
+		if err != nil {
+			return err
+		}
+
+		if err = fn(list); err != nil {
+			return err
+		}
+
+		if int64(len(list)) < exportBatch {
+			return nil
+		}
+	}
+}
+
+// names returns the names of projects and users by id.
+func (r *Handler) names() (map[int64]string, map[int64]string, error) {
+	projects, users := map[int64]string{}, map[int64]string{}
+
+	pl, err := r.PM.List(nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, p := range pl {
+		projects[p.ID] = p.Name
+	}
+
+	ul, err := r.UM.List()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, u := range ul {
+		users[u.ID] = u.Name
+	}
+
+	return projects, users, nil
+}
+
+// ImportFunc creates todos from a file in the format given by the format
+// query parameter. Every line of a todo.txt file is checked before any todo
+// is created; when a line is invalid nothing is imported and each invalid
+// line is reported. The valid rows of a CSV file are imported and the others
+// are reported. A todo that cannot be created is reported the same way and
+// the import goes on. With dry_run=true nothing is created.
+func (r *Handler) ImportFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		values := req.URL.Query()
+
+		var dryRun bool
+		if value := values.Get("dry_run"); value != "" {
+			var err error
+			if dryRun, err = strconv.ParseBool(value); err != nil {
+				writeError(w, &apierror.Error{
+					Code:    http.StatusBadRequest,
+					Message: "Invalid query parameters",
+					Errors: []*apierror.ErrorDetail{
+						&apierror.ErrorDetail{Key: "dry_run", Value: value, Message: "value must be a boolean"},
+					},
+				})
+				return
+			}
+		}
+
+		response := &ImportResponse{DryRun: dryRun, Errors: []*apierror.ErrorDetail{}, Results: TodoList{}}
+
+		var rows []*importRow
+		var ae *apierror.Error
+
+		switch format := values.Get("format"); format {
+		case "todotxt":
+			rows, ae = r.todoTxtData(req.Body)
+		case "csv":
+			rows, ae = r.csvData(req, response)
+		default:
+			ae = formatError(format)
+		}
+
+		if ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		if dryRun {
+			response.Created = int64(len(rows))
+			writeJSON(w, http.StatusOK, response)
+			return
+		}
+
+		for _, row := range rows {
+			t, err := r.TM.Create(row.data)
+			if err != nil {
+				response.Failed++
+				response.Errors = append(response.Errors, &apierror.ErrorDetail{Key: row.key, Value: row.value, Message: err.Error()})
+				continue
+			}
+			response.Created++
+			response.Results = append(response.Results, t)
+		}
+
+		code := http.StatusCreated
+		if response.Created == 0 {
+			code = http.StatusOK
+		}
+
+		writeJSON(w, code, response)
+	}
+}
+
+// todoTxtData converts the tasks of a todo.txt file into todo data validated
+// by CreateValidator. A +project must name a project and an @context naming
+// a user assigns the todo; other contexts are kept in the description.
+// Creation and completion dates are set by the server and not imported.
+func (r *Handler) todoTxtData(body io.Reader) ([]*importRow, *apierror.Error) {
+	pl, err := r.PM.List(nil)
+	if err != nil {
+		return nil, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()}
+	}
+
+	ul, err := r.UM.List()
+	if err != nil {
+		return nil, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()}
+	}
+
+	projects, users := map[string]*Project{}, map[string]*User{}
+	for _, p := range pl {
+		projects[todoTxtTag(p.Name)] = p
+	}
+	for _, u := range ul {
+		users[todoTxtTag(u.Name)] = u
+	}
+
+	create, _ := r.validators()
+
+	results := []*importRow{}
+	errors := []*apierror.ErrorDetail{}
+
+	scanner := bufio.NewScanner(body)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		d, messages := todoTxtTodo(line, projects, users)
+		if len(messages) == 0 {
+			if ae := validate(create, d); ae != nil {
+				for _, e := range ae.Errors {
+					messages = append(messages, e.Key+": "+e.Message)
+				}
+			}
+		}
+
+		for _, message := range messages {
+			errors = append(errors, &apierror.ErrorDetail{Key: fmt.Sprintf("line %d", n), Value: line, Message: message})
+		}
+
+		if len(messages) == 0 {
+			results = append(results, &importRow{fmt.Sprintf("line %d", n), line, d})
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, &apierror.Error{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	if len(errors) > 0 {
+		return nil, &apierror.Error{Code: http.StatusBadRequest, Message: "Invalid todo.txt", Errors: errors}
+	}
+
+	return results, nil
+}
+
+// todoTxtTodo converts a todo.txt task into todo data, returning the reasons
+// it cannot be imported, if any.
+func todoTxtTodo(line string, projects map[string]*Project, users map[string]*User) (TodoMap, []string) {
+	task, err := ParseTodoTxt(line)
+	if err != nil {
+		return nil, []string{err.Error()}
+	}
+
+	messages := []string{}
+
+	d := TodoMap{"state": string(state.Todo)}
+	if task.Done {
+		d["state"] = string(state.Done)
+	}
+
+	if task.Priority != "" {
+		d["priority"] = todoTxtPriority(task.Priority)
+	}
+
+	if task.Due != nil {
+		d["due"] = task.Due.Format(dayFormat)
+	}
+
+	if len(task.Projects) > 1 {
+		messages = append(messages, fmt.Sprintf("more than one project: +%s and +%s", task.Projects[0], task.Projects[1]))
+	} else if len(task.Projects) == 1 {
+		if p, ok := projects[task.Projects[0]]; !ok {
+			messages = append(messages, "unknown project +"+task.Projects[0])
+		} else if p.Archived {
+			messages = append(messages, "project +"+task.Projects[0]+" is archived")
+		} else {
+			d["project_id"] = p.ID
+		}
+	}
+
+	desc := []string{task.Description}
+	var assignee *User
+	for _, c := range task.Contexts {
+		if u, ok := users[c]; !ok {
+			desc = append(desc, "@"+c)
+		} else if assignee != nil && assignee.ID != u.ID {
+			messages = append(messages, fmt.Sprintf("more than one assignee: @%s and @%s", todoTxtTag(assignee.Name), c))
+		} else {
+			assignee = u
+		}
+	}
+
+	if assignee != nil {
+		d["assignee_id"] = assignee.ID
+	}
+
+	d["desc"] = strings.TrimSpace(strings.Join(desc, " "))
+	if d["desc"] == "" {
+		messages = append(messages, "missing description")
+	}
+
+	return d, messages
+}
+
+// csvData converts the rows of a CSV file with a header row into todo data
+// validated by CreateValidator. Columns are mapped to fields as described by
+// csvMapping, with map=Column:field query parameters overriding the default.
+// Rows that cannot be imported are reported in response rather than failing
+// the import.
+func (r *Handler) csvData(req *http.Request, response *ImportResponse) ([]*importRow, *apierror.Error) {
+	mapping := map[string]string{}
+	for _, value := range req.URL.Query()["map"] {
+		i := strings.LastIndex(value, ":")
+		if i < 0 {
+			return nil, mapError(value, "value must be Column:field")
+		}
+		if _, ok := mapping[value[:i]]; ok {
+			return nil, mapError(value, "column "+value[:i]+" is mapped more than once")
+		}
+		mapping[value[:i]] = value[i+1:]
+	}
+
+	reader := csv.NewReader(req.Body)
+	reader.FieldsPerRecord = -1
+
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, &apierror.Error{Code: http.StatusBadRequest, Message: "Invalid CSV: " + err.Error()}
+	}
+
+	if len(records) == 0 {
+		return nil, &apierror.Error{Code: http.StatusBadRequest, Message: "Invalid CSV: missing header row"}
+	}
+
+	header := records[0]
+	header[0] = strings.TrimPrefix(header[0], "\ufeff")
+
+	fl, err := r.FM.List()
+	if err != nil {
+		return nil, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()}
+	}
+
+	columns, ignored, err := csvMapping(header, mapping, fl)
+	if err != nil {
+		return nil, mapError(strings.Join(req.URL.Query()["map"], ","), err.Error())
+	}
+	response.Ignored = ignored
+
+	pl, err := r.PM.List(nil)
+	if err != nil {
+		return nil, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()}
+	}
+
+	ul, err := r.UM.List()
+	if err != nil {
+		return nil, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()}
+	}
+
+	fields, projects, users := map[string]*Field{}, map[string]*Project{}, map[string]*User{}
+	for _, f := range fl {
+		fields[f.Name] = f
+	}
+	for _, p := range pl {
+		projects[p.Name] = p
+	}
+	for _, u := range ul {
+		users[u.Name] = u
+	}
+
+	create, _ := r.validators()
+
+	results := []*importRow{}
+
+	for n, record := range records[1:] {
+		d, messages := csvTodo(record, columns, fields, projects, users)
+		if len(messages) == 0 {
+			if ae := validate(create, d); ae != nil {
+				for _, e := range ae.Errors {
+					messages = append(messages, e.Key+": "+e.Message)
+				}
+			}
+		}
+
+		if len(messages) > 0 {
+			response.Failed++
+			for _, message := range messages {
+				response.Errors = append(response.Errors, &apierror.ErrorDetail{Key: fmt.Sprintf("row %d", n+2), Value: record, Message: message})
+			}
+			continue
+		}
+
+		if ae := r.defaultTimezone(req, d); ae != nil {
+			return nil, ae
+		}
+
+		results = append(results, &importRow{fmt.Sprintf("row %d", n+2), record, d})
+	}
+
+	return results, nil
+}
+
+func mapError(value string, message string) *apierror.Error {
+	return &apierror.Error{
+		Code:    http.StatusBadRequest,
+		Message: "Invalid query parameters",
+		Errors: []*apierror.ErrorDetail{
+			&apierror.ErrorDetail{Key: "map", Value: value, Message: message},
+		},
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+var (
+	ErrFieldExists  = errors.New("custom field name is already taken")
+	ErrFieldOptions = errors.New("enum fields require options")
+)
+
+// FieldTypes are the supported custom field types.
+var FieldTypes = []string{"string", "number", "bool", "date", "enum"}
+
+// FieldNamePattern restricts custom field names so they can be used in
+// cf.<name> query parameters.
+var FieldNamePattern = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+const fieldColumns = "rowid, name, type, required, options"
+
+type Field struct {
+	ID       int64    `db:"id" json:"id"`
+	Name     string   `db:"name" json:"name"`
+	Type     string   `db:"type" json:"type"`
+	Required bool     `db:"required" json:"required"`
+	Options  []string `db:"options" json:"options"`
+}
+
+type FieldList []*Field
+
+type FieldManager struct {
+	Database *sql.DB
+}
+
+func NewFieldManager(db *sql.DB) *FieldManager {
+	return &FieldManager{db}
+}
+
+func scanField(s scanner) (*Field, error) {
+	var options string
+
+	f := &Field{}
+	if err := s.Scan(&f.ID, &f.Name, &f.Type, &f.Required, &options); err != nil {
+		return nil, err
+	}
+
+	f.Options = []string{}
+	if options != "" {
+		if err := json.Unmarshal([]byte(options), &f.Options); err != nil {
+			return nil, err
+		}
+	}
+
+	return f, nil
+}
+
+func (r *FieldManager) Get(id int64) (*Field, error) {
+	row := r.Database.QueryRow("SELECT "+fieldColumns+" FROM field WHERE rowid = ?", id)
+	return scanField(row)
+}
+
+func (r *FieldManager) List() (FieldList, error) {
+	rows, err := r.Database.Query("SELECT " + fieldColumns + " FROM field ORDER BY rowid;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := FieldList{}
+
+	for rows.Next() {
+		var f *Field
+		if f, err = scanField(rows); err != nil {
+			return nil, err
+		}
+		results = append(results, f)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// encodeOptions converts the options in data to their stored JSON form.
+func encodeOptions(data TodoMap) error {
+	options, ok := data["options"]
+	if !ok {
+		return nil
+	}
+
+	b, err := json.Marshal(options)
+	if err != nil {
+		return err
+	}
+
+	data["options"] = string(b)
+	return nil
+}
+
+func (r *FieldManager) Create(data map[string]interface{}) (*Field, error) {
+	var result sql.Result
+	var id int64
+	var err error
+
+	d := TodoMap{}
+	for k, v := range data {
+		d[k] = v
+	}
+
+	if options, _ := d["options"].([]interface{}); d["type"] == "enum" && len(options) == 0 {
+		return nil, ErrFieldOptions
+	}
+
+	var count int64
+	row := r.Database.QueryRow("SELECT COUNT(*) FROM field WHERE name = ?", d["name"])
+	if err = row.Scan(&count); err != nil {
+		return nil, err
+	} else if count > 0 {
+		return nil, ErrFieldExists
+	}
+
+	if err = encodeOptions(d); err != nil {
+		return nil, err
+	}
+
+	insert := d.InsertVars()
+	q := fmt.Sprintf("INSERT INTO field(%s) VALUES(%s)", insert.Names, insert.Bindvars)
+
+	if result, err = r.Database.Exec(q, insert.Values...); err != nil {
+		return nil, err
+	}
+
+	if id, err = result.LastInsertId(); err != nil {
+		return nil, err
+	}
+
+	return r.Get(id)
+}
+
+// Update changes whether a field is required and, for enums, its options.
+func (r *FieldManager) Update(f *Field, data map[string]interface{}) (*Field, error) {
+	d := TodoMap{}
+	for k, v := range data {
+		d[k] = v
+	}
+
+	if options, ok := d["options"].([]interface{}); ok && f.Type == "enum" && len(options) == 0 {
+		return nil, ErrFieldOptions
+	}
+
+	if len(d) == 0 {
+		return f, nil
+	}
+
+	if err := encodeOptions(d); err != nil {
+		return nil, err
+	}
+
+	update := d.UpdateVars()
+	q := fmt.Sprintf("UPDATE field SET %s WHERE rowid = ?;", update.Bindvars)
+
+	if _, err := r.Database.Exec(q, append(update.Values, f.ID)...); err != nil {
+		return nil, err
+	}
+
+	return r.Get(f.ID)
+}
+
+// Delete removes a field definition and every value stored for it.
+func (r *FieldManager) Delete(id int64) error {
+	var tx *sql.Tx
+	var err error
+
+	if tx, err = r.Database.Begin(); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec("DELETE FROM field_value WHERE field_id = ?;", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec("DELETE FROM field WHERE rowid = ?;", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// schema returns the JSON schema of the field's values. Optional fields also
+// accept null, which clears the value.
+func (r *Field) schema() map[string]interface{} {
+	types := map[string]string{
+		"string": "string",
+		"number": "number",
+		"bool":   "boolean",
+		"date":   "string",
+		"enum":   "string",
+	}
+
+	var kind interface{} = types[r.Type]
+	if !r.Required {
+		kind = []interface{}{types[r.Type], "null"}
+	}
+
+	result := map[string]interface{}{"type": kind}
+
+	switch r.Type {
+	case "date":
+		result["format"] = "date"
+	case "enum":
+		options := []interface{}{}
+		for _, o := range r.Options {
+			options = append(options, o)
+		}
+		if !r.Required {
+			options = append(options, nil)
+		}
+		result["enum"] = options
+	}
+
+	return result
+}
+
+// setCustomFields stores values, keyed by field name, for the todo identified
+// by id. A nil value removes the stored value.
+func setCustomFields(tx *sql.Tx, id int64, values map[string]interface{}) error {
+	for name, value := range values {
+		var fieldID int64
+		var kind string
+
+		row := tx.QueryRow("SELECT rowid, type FROM field WHERE name = ?", name)
+		if err := row.Scan(&fieldID, &kind); err == sql.ErrNoRows {
+			return fmt.Errorf("Unknown custom field: %s", name)
+		} else if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec("DELETE FROM field_value WHERE todo_id = ? AND field_id = ?;", id, fieldID); err != nil {
+			return err
+		}
+
+		if value == nil {
+			continue
+		}
+
+		if kind == "number" {
+			if n, ok := value.(int64); ok {
+				value = float64(n)
+			} else if n, ok := value.(int); ok {
+				value = float64(n)
+			}
+		}
+
+		if _, err := tx.Exec("INSERT INTO field_value(todo_id, field_id, value) VALUES(?, ?, ?);", id, fieldID, value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadCustomFields sets CustomFields on every todo in list.
+func (r *TodoManager) loadCustomFields(list TodoList) error {
+	if len(list) == 0 {
+		return nil
+	}
+
+	index := map[int64]*Todo{}
+	ids := make([]int64, len(list), len(list))
+	for i, t := range list {
+		t.CustomFields = map[string]interface{}{}
+		index[t.ID] = t
+		ids[i] = t.ID
+	}
+
+	bindvars, values := inClause(ids)
+
+	rows, err := r.Database.Query("SELECT v.todo_id, f.name, f.type, v.value FROM field_value v JOIN field f ON f.rowid = v.field_id WHERE v.todo_id IN "+bindvars, values...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id int64
+		var name, kind string
+		var value interface{}
+
+		if err = rows.Scan(&id, &name, &kind, &value); err != nil {
+			return err
+		}
+
+		switch v := value.(type) {
+		case []byte:
+			value = string(v)
+		case int64:
+			if kind == "bool" {
+				value = v != 0
+			} else {
+				value = float64(v)
+			}
+		}
+
+		index[id].CustomFields[name] = value
+	}
+
+	return rows.Err()
+}
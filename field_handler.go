@@ -0,0 +1,110 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/apierror"
+
+	"log"
+	"net/http"
+)
+
+// reloadFields rebuilds the todo validators after a field definition changed.
+func (r *Handler) reloadFields() {
+	if err := r.LoadFields(); err != nil {
+		log.Printf("ERROR: loading custom fields: %s", err)
+	}
+}
+
+func (r *Handler) FieldListFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if list, err := r.FM.List(); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+		} else {
+			writeJSON(w, http.StatusOK, list)
+		}
+	}
+}
+
+func (r *Handler) FieldCreateFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		var data TodoMap
+		var ae *apierror.Error
+
+		if data, ae = UnmarshalJSONRequest(req); ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		if ae = validate(r.FieldCreateValidator, data); ae != nil {
+			writeError(w, ae)
+		} else if f, err := r.FM.Create(data); err != nil {
+			writeError(w, managerError(err))
+		} else {
+			r.reloadFields()
+			writeJSON(w, http.StatusCreated, f)
+		}
+	}
+}
+
+func (r *Handler) FieldRetrieveFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if f, err := r.FM.Get(pathID(req, "id")); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+		} else {
+			writeJSON(w, http.StatusOK, f)
+		}
+	}
+}
+
+// FieldUpdateFunc changes whether a field is required and the options of enum
+// fields. Names and types are fixed once a field is created.
+func (r *Handler) FieldUpdateFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		var data TodoMap
+		var ae *apierror.Error
+
+		f, err := r.FM.Get(pathID(req, "id"))
+		if err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+			return
+		}
+
+		if data, ae = UnmarshalJSONRequest(req); ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		if ae = validate(r.FieldUpdateValidator, data); ae != nil {
+			writeError(w, ae)
+		} else if f, err = r.FM.Update(f, data); err != nil {
+			writeError(w, managerError(err))
+		} else {
+			r.reloadFields()
+			writeJSON(w, http.StatusOK, f)
+		}
+	}
+}
+
+// FieldDeleteFunc deletes a field definition along with its values.
+func (r *Handler) FieldDeleteFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		id := pathID(req, "id")
+
+		if _, err := r.FM.Get(id); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+			return
+		}
+
+		if err := r.FM.Delete(id); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+		} else {
+			r.reloadFields()
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
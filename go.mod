@@ -7,6 +7,8 @@ require (
 	github.com/gorilla/mux v1.7.3
 	github.com/mattn/go-sqlite3 v1.11.0
 	github.com/stretchr/testify v1.4.0 // indirect
+	github.com/vmihailenco/msgpack/v4 v4.3.12
 	github.com/xeipuuv/gojsonschema v1.2.0
 	golang.org/x/tools v0.0.0-20191104203557-979d74e0bb73 // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )
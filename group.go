@@ -0,0 +1,49 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/query"
+)
+
+// GroupCount is the number of todos sharing a value of a grouped attribute.
+// Results holds the first todos of the group when they were requested.
+type GroupCount struct {
+	Key     interface{} `json:"key"`
+	Count   int64       `json:"count"`
+	Results TodoList    `json:"results,omitempty"`
+}
+
+// Groups returns the groups of todos matching filter, which must have been
+// built from query parameters that include group.
+func (r *TodoManager) Groups(filter *query.Query, group *query.GroupQueryParam) ([]*GroupCount, error) {
+	q := "SELECT " + group.Expr() + ", COUNT(*) FROM todo" + filter.Query()
+
+	rows, err := r.Database.Query(q, filter.Values()...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*GroupCount{}
+
+	for rows.Next() {
+		gc := &GroupCount{}
+		if err = rows.Scan(&gc.Key, &gc.Count); err != nil {
+			return nil, err
+		}
+		if b, ok := gc.Key.([]byte); ok {
+			gc.Key = string(b)
+		}
+		results = append(results, gc)
+	}
+
+	return results, rows.Err()
+}
+
+// CountGroups returns the number of groups matching the unpaginated filter.
+func (r *TodoManager) CountGroups(filter *query.Query) (int64, error) {
+	var count int64
+
+	row := r.Database.QueryRow("SELECT COUNT(*) FROM (SELECT 1 FROM todo"+filter.Clause()+");", filter.Values()...)
+	err := row.Scan(&count)
+	return count, err
+}
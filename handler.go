@@ -2,16 +2,22 @@ package main
 
 import (
 	"github.com/marcgwilson/todo/apierror"
+	"github.com/marcgwilson/todo/blob"
+	"github.com/marcgwilson/todo/codec"
 	"github.com/marcgwilson/todo/query"
 
 	"github.com/gorilla/mux"
 	"github.com/xeipuuv/gojsonschema"
 
+	"bytes"
 	"encoding/json"
+	"fmt"
 	"io/ioutil"
 	"log"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 )
 
 type PaginatedResponse struct {
@@ -20,6 +26,14 @@ type PaginatedResponse struct {
 	Results  TodoList `json:"results"`
 }
 
+// GroupedResponse is the list response when results are grouped with
+// group_by. Pages are over groups rather than todos.
+type GroupedResponse struct {
+	Next     string        `json:"next"`
+	Previous string        `json:"previous"`
+	Groups   []*GroupCount `json:"groups"`
+}
+
 func (r *PaginatedResponse) Equal(t *PaginatedResponse) bool {
 	if r.Next != t.Next {
 		return false
@@ -37,45 +51,199 @@ func (r *PaginatedResponse) Equal(t *PaginatedResponse) bool {
 }
 
 type Handler struct {
-	TM              *TodoManager
-	Config 			*Config
-	CreateValidator *gojsonschema.Schema
-	UpdateValidator *gojsonschema.Schema
+	TM                     *TodoManager
+	PM                     *ProjectManager
+	UM                     *UserManager
+	CM                     *CommentManager
+	AM                     *AttachmentManager
+	FM                     *FieldManager
+	Config                 *Config
+	Codecs                 *codec.Registry
+	CreateValidator        *gojsonschema.Schema
+	UpdateValidator        *gojsonschema.Schema
+	ProjectCreateValidator *gojsonschema.Schema
+	ProjectUpdateValidator *gojsonschema.Schema
+	UserCreateValidator    *gojsonschema.Schema
+	UserUpdateValidator    *gojsonschema.Schema
+	DependencyValidator    *gojsonschema.Schema
+	CommentValidator       *gojsonschema.Schema
+	WorklogCreateValidator *gojsonschema.Schema
+	WorklogUpdateValidator *gojsonschema.Schema
+	FieldCreateValidator   *gojsonschema.Schema
+	FieldUpdateValidator   *gojsonschema.Schema
+	QuickAddValidator      *gojsonschema.Schema
+
+	// mu guards CreateValidator and UpdateValidator, which are rebuilt when
+	// custom fields change.
+	mu sync.RWMutex
 }
 
 func NewHandler(tm *TodoManager, config *Config) *Handler {
-	var createSchema *gojsonschema.Schema
-	var updateSchema *gojsonschema.Schema
-	var err error
-	var loader = gojsonschema.NewStringLoader(CreateSchema)
-	if createSchema, err = gojsonschema.NewSchema(loader); err != nil {
-		panic(err)
+	h := &Handler{
+		TM:                     tm,
+		PM:                     NewProjectManager(tm.Database),
+		UM:                     NewUserManager(tm.Database),
+		CM:                     NewCommentManager(tm.Database),
+		AM:                     NewAttachmentManager(tm.Database, blob.NewFileStore(config.AttachmentDir), config),
+		FM:                     NewFieldManager(tm.Database),
+		Config:                 config,
+		CreateValidator:        mustSchema(CreateSchema),
+		UpdateValidator:        mustSchema(UpdateSchema),
+		ProjectCreateValidator: mustSchema(ProjectCreateSchema),
+		ProjectUpdateValidator: mustSchema(ProjectUpdateSchema),
+		UserCreateValidator:    mustSchema(UserCreateSchema),
+		UserUpdateValidator:    mustSchema(UserUpdateSchema),
+		DependencyValidator:    mustSchema(DependencySchema),
+		CommentValidator:       mustSchema(CommentSchema),
+		WorklogCreateValidator: mustSchema(WorklogCreateSchema),
+		WorklogUpdateValidator: mustSchema(WorklogUpdateSchema),
+		FieldCreateValidator:   mustSchema(FieldCreateSchema),
+		FieldUpdateValidator:   mustSchema(FieldUpdateSchema),
+		QuickAddValidator:      mustSchema(QuickAddSchema),
+	}
+
+	h.Codecs = codec.NewRegistry(codec.JSON{}, codec.YAML{}, codec.MessagePack{}, &csvCodec{h})
+
+	if err := h.LoadFields(); err != nil {
+		log.Printf("ERROR: loading custom fields: %s", err)
 	}
 
-	loader = gojsonschema.NewStringLoader(UpdateSchema)
-	if updateSchema, err = gojsonschema.NewSchema(loader); err != nil {
+	return h
+}
+
+// LoadFields rebuilds the todo validators from the current custom field
+// definitions.
+func (r *Handler) LoadFields() error {
+	fields, err := r.FM.List()
+	if err != nil {
+		return err
+	}
+
+	create, err := TodoSchema(CreateSchema, fields, true)
+	if err != nil {
+		return err
+	}
+
+	update, err := TodoSchema(UpdateSchema, fields, false)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.CreateValidator, r.UpdateValidator = create, update
+	r.mu.Unlock()
+
+	return nil
+}
+
+func (r *Handler) validators() (*gojsonschema.Schema, *gojsonschema.Schema) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.CreateValidator, r.UpdateValidator
+}
+
+func mustSchema(schema string) *gojsonschema.Schema {
+	loader := gojsonschema.NewStringLoader(schema)
+	s, err := gojsonschema.NewSchema(loader)
+	if err != nil {
 		panic(err)
 	}
+	return s
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, e *apierror.Error) {
+	writeJSON(w, e.Code, e)
+}
+
+// writeNegotiated writes v in the most preferred type of the Accept header
+// whose codec can encode it, so that a client accepting CSV and JSON gets
+// grouped results as JSON. It responds 406 when there is none.
+func (r *Handler) writeNegotiated(w http.ResponseWriter, req *http.Request, code int, v interface{}) {
+	w.Header().Add("Vary", "Accept")
+
+	accept := req.Header.Get("Accept")
+
+	var c codec.Codec
+	var buf bytes.Buffer
+	for _, acceptable := range r.Codecs.Negotiate(accept) {
+		buf.Reset()
+		if err := acceptable.Encode(&buf, v); err == codec.ErrUnsupported {
+			continue
+		} else if err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+			return
+		}
+		c = acceptable
+		break
+	}
+
+	if c == nil {
+		writeError(w, &apierror.Error{
+			Code:    http.StatusNotAcceptable,
+			Message: "Not acceptable",
+			Errors: []*apierror.ErrorDetail{
+				&apierror.ErrorDetail{Key: "Accept", Value: accept, Message: "value must accept one of " + strings.Join(r.Codecs.MediaTypes(), ", ")},
+			},
+		})
+		return
+	}
+
+	contentType := c.MediaTypes()[0]
+	if strings.HasPrefix(contentType, "text/") {
+		contentType += "; charset=utf-8"
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	w.WriteHeader(code)
+	w.Write(buf.Bytes())
+}
+
+// validate checks data against schema and returns the resulting API error, if any.
+func validate(schema *gojsonschema.Schema, data TodoMap) *apierror.Error {
+	result, err := schema.Validate(gojsonschema.NewGoLoader(data))
+	if err != nil {
+		return &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()}
+	}
 
-	return &Handler{
-		tm,
-		config,
-		createSchema,
-		updateSchema,
+	if len(result.Errors()) != 0 {
+		return apierror.NewJSONError(result.Errors())
 	}
+
+	return nil
+}
+
+// managerError converts an error returned by a manager into an API error.
+func managerError(err error) *apierror.Error {
+	code := http.StatusBadRequest
+
+	switch err {
+	case ErrOpenSubtasks, ErrBlocked, ErrUserExists, ErrFieldExists, ErrTimerRunning, ErrNoTimer, ErrWorklogActive:
+		code = http.StatusConflict
+	case ErrAttachmentTooLarge:
+		code = http.StatusRequestEntityTooLarge
+	case ErrAttachmentType:
+		code = http.StatusUnsupportedMediaType
+	}
+
+	return &apierror.Error{Code: code, Message: err.Error()}
+}
+
+func pathID(req *http.Request, key string) int64 {
+	id, _ := strconv.ParseInt(mux.Vars(req)[key], 10, 64)
+	return id
 }
 
 func (r *Handler) RetrieveFunc() http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
-		id, _ := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
-
-		if t, err := r.TM.Get(id); err != nil {
-			e := &apierror.Error{Code: http.StatusNotFound, Message: "Not found"}
-			w.WriteHeader(e.Code)
-			json.NewEncoder(w).Encode(e)
+		if t, err := r.TM.Get(pathID(req, "id")); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
 		} else {
-			w.WriteHeader(http.StatusOK)
-			json.NewEncoder(w).Encode(t)
+			r.writeNegotiated(w, req, http.StatusOK, t)
 		}
 	}
 }
@@ -83,32 +251,142 @@ func (r *Handler) RetrieveFunc() http.HandlerFunc {
 func (r *Handler) ListFunc() http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 		defer req.Body.Close()
-		if result, err := query.ParseValues(req.URL.Query()); err != nil {
-			w.WriteHeader(err.Code)
-			json.NewEncoder(w).Encode(err)
+		if result, err := r.parseQuery(req); err != nil {
+			writeError(w, err)
+		} else {
+			r.writeList(w, req, result)
+		}
+	}
+}
+
+func (r *Handler) writeList(w http.ResponseWriter, req *http.Request, result *query.QueryParams) {
+	if result.Group() != nil {
+		r.writeGroups(w, req, result)
+		return
+	}
+
+	result.Paginate(r.Config.Limit)
+
+	q := result.Query()
+	if list, err := r.TM.Query(q); err != nil {
+		writeError(w, &apierror.Error{Code: http.StatusBadRequest, Message: err.Error()})
+	} else {
+		pr := &PaginatedResponse{Results: list}
+		rc := result.ShallowCopy().Depaginate()
+		qc := rc.Query()
+		if count, err := r.TM.Count(qc); err != nil {
+			log.Printf("ERROR: r.TM.Count: %s", err)
+		} else {
+			pr.Next = query.NextPage(result, req.URL, count)
+		}
+
+		pr.Previous = query.PrevPage(result, req.URL)
+		r.writeNegotiated(w, req, http.StatusOK, pr)
+	}
+}
+
+// writeGroups writes the groups of todos matching result along with the first
+// items todos of each group, as selected by the items query parameter.
+func (r *Handler) writeGroups(w http.ResponseWriter, req *http.Request, result *query.QueryParams) {
+	var items int64
+
+	if value := req.URL.Query().Get("items"); value != "" {
+		if i, err := strconv.ParseInt(value, 10, 64); err != nil || i < 0 || i > r.Config.Limit {
+			writeError(w, &apierror.Error{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid query parameters",
+				Errors: []*apierror.ErrorDetail{
+					&apierror.ErrorDetail{Key: "items", Value: value, Message: fmt.Sprintf("value must be an integer between 0 and %d", r.Config.Limit)},
+				},
+			})
+			return
+		} else {
+			items = i
+		}
+	}
+
+	group := result.Group()
+	result.Paginate(r.Config.Limit)
+
+	groups, err := r.TM.Groups(result.Query(), group)
+	if err != nil {
+		writeError(w, &apierror.Error{Code: http.StatusBadRequest, Message: err.Error()})
+		return
+	}
+
+	if items > 0 {
+		for _, gc := range groups {
+			rc := result.ShallowCopy().Depaginate()
+			delete(rc.Params(), "group_by")
+			rc.Set("group_key", query.NewGroupKeyQueryParam(group, gc.Key))
+
+			if gc.Results, err = r.TM.Query(rc.Paginate(items).Query()); err != nil {
+				writeError(w, &apierror.Error{Code: http.StatusBadRequest, Message: err.Error()})
+				return
+			}
+		}
+	}
+
+	gr := &GroupedResponse{Groups: groups}
+	if count, err := r.TM.CountGroups(result.ShallowCopy().Depaginate().Query()); err != nil {
+		log.Printf("ERROR: r.TM.CountGroups: %s", err)
+	} else {
+		gr.Next = query.NextPage(result, req.URL, count)
+	}
+
+	gr.Previous = query.PrevPage(result, req.URL)
+	r.writeNegotiated(w, req, http.StatusOK, gr)
+}
+
+func (r *Handler) ChildrenFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		id := pathID(req, "id")
+
+		if _, err := r.TM.Get(id); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+			return
+		}
+
+		if result, err := r.parseQuery(req); err != nil {
+			writeError(w, err)
 		} else {
-			result.Paginate(r.Config.Limit)
+			r.writeList(w, req, result.Set("parent", query.NewIDQueryParam("parent_id", id)))
+		}
+	}
+}
+
+// OccurrencesFunc previews the next occurrences of a recurring todo. The count
+// query parameter selects how many are returned, at most 100.
+func (r *Handler) OccurrencesFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
 
-			q := result.Query()
-			if list, err := r.TM.Query(q); err != nil {
-				e := &apierror.Error{Code: http.StatusBadRequest, Message: err.Error()}
-				w.WriteHeader(e.Code)
-				json.NewEncoder(w).Encode(e)
+		count := 5
+
+		if value := req.URL.Query().Get("count"); value != "" {
+			if c, err := strconv.Atoi(value); err != nil || c < 1 || c > 100 {
+				writeError(w, &apierror.Error{
+					Code:    http.StatusBadRequest,
+					Message: "Invalid query parameters",
+					Errors: []*apierror.ErrorDetail{
+						&apierror.ErrorDetail{Key: "count", Value: value, Message: "value must be an integer between 1 and 100"},
+					},
+				})
+				return
 			} else {
-				pr := &PaginatedResponse{Results: list}
-				rc := result.ShallowCopy().Depaginate()
-				qc := rc.Query()
-				if count, err := r.TM.Count(qc); err != nil {
-					log.Printf("ERROR: r.TM.Count: %s", err)
-				} else {
-					pr.Next = query.NextPage(result, req.URL, count)
-				}
-
-				pr.Previous = query.PrevPage(result, req.URL)
-				w.WriteHeader(http.StatusOK)
-				json.NewEncoder(w).Encode(pr)
+				count = c
 			}
 		}
+
+		if t, err := r.TM.Get(pathID(req, "id")); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+		} else if occurrences, err := r.TM.Occurrences(t, count); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+		} else {
+			writeJSON(w, http.StatusOK, occurrences)
+		}
 	}
 }
 
@@ -117,75 +395,52 @@ func (r *Handler) CreateFunc() http.HandlerFunc {
 		defer req.Body.Close()
 
 		var data TodoMap
-		var err *apierror.Error
+		var ae *apierror.Error
 
-		if data, err = UnmarshalJSONRequest(req); err != nil {
-			w.WriteHeader(err.Code)
-			json.NewEncoder(w).Encode([]*apierror.Error{err})
+		if data, ae = r.unmarshalRequest(req); ae != nil {
+			writeJSON(w, ae.Code, []*apierror.Error{ae})
 			return
 		}
 
-		doc := gojsonschema.NewGoLoader(data)
+		create, _ := r.validators()
 
-		if result, err := r.CreateValidator.Validate(doc); err != nil {
-			e := &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()}
-			w.WriteHeader(e.Code)
-			json.NewEncoder(w).Encode(e)
+		if ae = validate(create, data); ae != nil {
+			writeError(w, ae)
+		} else if ae = r.defaultTimezone(req, data); ae != nil {
+			writeError(w, ae)
+		} else if t, err := r.TM.Create(data); err != nil {
+			writeError(w, managerError(err))
 		} else {
-			if len(result.Errors()) != 0 {
-				ae := apierror.NewJSONError(result.Errors())
-				w.WriteHeader(ae.Code)
-				json.NewEncoder(w).Encode(ae)
-			} else {
-				if t, err := r.TM.Create(data); err != nil {
-					ae := &apierror.Error{Code: http.StatusBadRequest, Message: err.Error()}
-					w.WriteHeader(ae.Code)
-					json.NewEncoder(w).Encode(ae)
-				} else {
-					w.WriteHeader(http.StatusCreated)
-					json.NewEncoder(w).Encode(t)
-				}
-			}
+			writeJSON(w, http.StatusCreated, t)
 		}
 	}
 }
 
 func (r *Handler) UpdateFunc() http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
-		id, _ := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+		id := pathID(req, "id")
 
 		defer req.Body.Close()
 
 		var data TodoMap
 		var ae *apierror.Error
 
-		if data, ae = UnmarshalJSONRequest(req); ae != nil {
-			w.WriteHeader(ae.Code)
-			json.NewEncoder(w).Encode(ae)
+		if data, ae = r.unmarshalRequest(req); ae != nil {
+			writeError(w, ae)
 			return
 		}
 
-		doc := gojsonschema.NewGoLoader(data)
+		_, update := r.validators()
 
-		if result, err := r.UpdateValidator.Validate(doc); err != nil {
-			e := &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()}
-			w.WriteHeader(e.Code)
-			json.NewEncoder(w).Encode(e)
+		if ae = validate(update, data); ae != nil {
+			writeError(w, ae)
+		} else if ae = r.autoAssign(req, id, data); ae != nil {
+			writeError(w, ae)
+		} else if todo, err := r.TM.Update(id, data); err != nil {
+			writeError(w, managerError(err))
 		} else {
-			if len(result.Errors()) != 0 {
-				ae = apierror.NewJSONError(result.Errors())
-				w.WriteHeader(ae.Code)
-				json.NewEncoder(w).Encode(ae)
-			} else {
-				if todo, err := r.TM.Update(id, data); err != nil {
-					ae = &apierror.Error{Code: http.StatusBadRequest, Message: err.Error()}
-					w.WriteHeader(ae.Code)
-					json.NewEncoder(w).Encode(ae)
-				} else {
-					w.WriteHeader(http.StatusOK)
-					json.NewEncoder(w).Encode(todo)
-				}
-			}
+			r.TM.NotifyChanged(todo, r.callerID(req))
+			writeJSON(w, http.StatusOK, todo)
 		}
 	}
 }
@@ -194,19 +449,17 @@ func (r *Handler) DeleteFunc() http.HandlerFunc {
 	return func(w http.ResponseWriter, req *http.Request) {
 		defer req.Body.Close()
 
-		id, _ := strconv.ParseInt(mux.Vars(req)["id"], 10, 64)
+		id := pathID(req, "id")
 
 		if _, err := r.TM.Get(id); err != nil {
-			e := &apierror.Error{Code: http.StatusNotFound, Message: "Not found"}
-			w.WriteHeader(e.Code)
-			json.NewEncoder(w).Encode(e)
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
 			return
 		}
 
-		if err := r.TM.Delete(id); err != nil {
-			e := &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()}
-			w.WriteHeader(e.Code)
-			json.NewEncoder(w).Encode(e)
+		if err := r.AM.DeleteAll(id); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+		} else if err := r.TM.Delete(id); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
 		} else {
 			w.WriteHeader(http.StatusNoContent)
 		}
@@ -228,3 +481,26 @@ func UnmarshalJSONRequest(req *http.Request) (TodoMap, *apierror.Error) {
 
 	return data, nil
 }
+
+// unmarshalRequest decodes a request body in the type of its Content-Type
+// header, or as JSON when there is none.
+func (r *Handler) unmarshalRequest(req *http.Request) (TodoMap, *apierror.Error) {
+	contentType := req.Header.Get("Content-Type")
+	if contentType == "" {
+		return UnmarshalJSONRequest(req)
+	}
+
+	c := r.Codecs.Lookup(contentType)
+	if c == nil {
+		return nil, &apierror.Error{Code: http.StatusUnsupportedMediaType, Message: "Unsupported Content-Type " + contentType}
+	}
+
+	var data TodoMap
+	if err := c.Decode(req.Body, &data); err == codec.ErrUnsupported {
+		return nil, &apierror.Error{Code: http.StatusUnsupportedMediaType, Message: "Unsupported Content-Type " + contentType}
+	} else if err != nil {
+		return nil, &apierror.Error{Code: http.StatusBadRequest, Message: err.Error()}
+	}
+
+	return data, nil
+}
@@ -2,19 +2,27 @@ package main
 
 import (
 	"github.com/marcgwilson/todo/apierror"
+	"github.com/marcgwilson/todo/codec"
+	"github.com/marcgwilson/todo/ical"
 	"github.com/marcgwilson/todo/query"
 	"github.com/marcgwilson/todo/state"
 
 	"github.com/davecgh/go-spew/spew"
 
 	"bytes"
+	"encoding/csv"
 	"encoding/json"
+	"encoding/xml"
 	"fmt"
 	"io/ioutil"
+	"mime/multipart"
 	"net/http"
 	"net/url"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"reflect"
+	"strings"
 	"testing"
 	"time"
 )
@@ -45,7 +53,16 @@ func filterTodoWithURLString(t *testing.T, tm *TodoManager, urlString string) To
 }
 
 func TestHandler(t *testing.T) {
+	dir, err := ioutil.TempDir("", "attachments")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
 	config := &Config{Database: ":memory:", Port:0, Limit: 20}
+	config.AttachmentDir = dir
+	config.AttachmentMaxSize = 1024
+	config.AttachmentTypes = []string{"image/*", "text/plain"}
 
 	db, err := OpenDB(config.Database)
 
@@ -53,7 +70,7 @@ func TestHandler(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	tm := &TodoManager{db}
+	tm := NewManager(db, config)
 	h := NewHandler(tm, config)
 
 	todos := GenerateTodos(100, tm)
@@ -73,6 +90,73 @@ func TestHandler(t *testing.T) {
 	t.Run("LIST=due", listFilterDue(ts, tm, todos))
 	t.Run("DELETE", testDelete(ts, tm, todos))
 	t.Run("DELETE-ERRORS", testDeleteErrors(ts, tm, todos))
+	t.Run("PROJECTS", testProjects(ts, tm, todos))
+	t.Run("SUBTASKS", testSubtasks(ts, tm, todos))
+	t.Run("DEPENDENCIES", testDependencies(ts, tm, todos))
+	t.Run("RECURRENCE", testRecurrence(ts, tm, todos))
+	t.Run("PRIORITY", testPriority(ts, tm, todos))
+	t.Run("ASSIGNEES", testAssignees(ts, tm, todos))
+	t.Run("COMMENTS", testComments(ts, tm, todos))
+	t.Run("ATTACHMENTS", testAttachments(ts, tm, todos, dir))
+	t.Run("TIME-TRACKING", testTimeTracking(ts, tm, todos))
+	t.Run("CUSTOM-FIELDS", testCustomFields(ts, tm, todos))
+	t.Run("STATS", testStats(ts, tm, todos))
+	t.Run("GROUPING", testGrouping(ts, tm, todos))
+	t.Run("FILTER", testFilter(ts, tm, todos))
+	t.Run("QUICK-ADD", testQuickAdd(ts, tm, todos))
+	t.Run("TIMEZONES", testTimezones(ts, tm, todos))
+	t.Run("UNDATED", testUndated(ts, tm, todos))
+	t.Run("CALENDAR", testCalendar(ts, tm, todos))
+	t.Run("CALDAV", testCalDAV(ts, tm, todos))
+	t.Run("TODOTXT", testTodoTxt(ts, tm, todos))
+	t.Run("CSV", testCSV(ts, tm, todos))
+	t.Run("NEGOTIATION", testNegotiation(ts, tm, todos))
+	t.Run("ADMIN", testAdmin(ts, tm, todos))
+}
+
+func doJSON(t *testing.T, ts *httptest.Server, method string, url string, payload interface{}, v interface{}) int {
+	return doJSONAs(t, ts, "", method, url, payload, v)
+}
+
+// doJSONAs is doJSON with the X-User header set to user.
+func doJSONAs(t *testing.T, ts *httptest.Server, user string, method string, url string, payload interface{}, v interface{}) int {
+	var req *http.Request
+	var res *http.Response
+	var body []byte
+	var err error
+
+	var reader *bytes.Buffer = &bytes.Buffer{}
+	if payload != nil {
+		if body, err = json.Marshal(payload); err != nil {
+			t.Fatal(err)
+		}
+		reader = bytes.NewBuffer(body)
+	}
+
+	if req, err = http.NewRequest(method, ts.URL+url, reader); err != nil {
+		t.Fatal(err)
+	}
+
+	if user != "" {
+		req.Header.Set(UserHeader, user)
+	}
+
+	if res, err = ts.Client().Do(req); err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if body, err = ioutil.ReadAll(res.Body); err != nil {
+		t.Fatal(err)
+	}
+
+	if v != nil && len(body) > 0 {
+		if err = json.Unmarshal(body, v); err != nil {
+			t.Fatalf("%s %s: %s: %s", method, url, err, string(body))
+		}
+	}
+
+	return res.StatusCode
 }
 
 func testCreate(ts *httptest.Server, tm *TodoManager, td TodoList) func(*testing.T) {
@@ -168,7 +252,6 @@ func testCreateErrors(ts *httptest.Server, tm *TodoManager, td TodoList) func(*t
 				Message: "Invalid JSON",
 				Errors: []*apierror.ErrorDetail{
 					&apierror.ErrorDetail{Key: "desc", Value: "", Message: "required attribute"},
-					&apierror.ErrorDetail{Key: "due", Value: "", Message: "required attribute"},
 					&apierror.ErrorDetail{Key: "state", Value: "", Message: "required attribute"},
 				},
 			},
@@ -176,7 +259,6 @@ func testCreateErrors(ts *httptest.Server, tm *TodoManager, td TodoList) func(*t
 				Code:    http.StatusBadRequest,
 				Message: "Invalid JSON",
 				Errors: []*apierror.ErrorDetail{
-					&apierror.ErrorDetail{Key: "due", Value: "", Message: "required attribute"},
 					&apierror.ErrorDetail{Key: "state", Value: "", Message: "required attribute"},
 				},
 			},
@@ -185,7 +267,6 @@ func testCreateErrors(ts *httptest.Server, tm *TodoManager, td TodoList) func(*t
 				Code:    http.StatusBadRequest,
 				Message: "Invalid JSON",
 				Errors: []*apierror.ErrorDetail{
-					&apierror.ErrorDetail{Key: "due", Value: "", Message: "required attribute"},
 					&apierror.ErrorDetail{Key: "state", Value: "invalid state", Message: "state must be one of the following: \"todo\", \"in_progress\", \"done\""},
 				},
 			},
@@ -195,7 +276,7 @@ func testCreateErrors(ts *httptest.Server, tm *TodoManager, td TodoList) func(*t
 				Message: "Invalid JSON",
 				Errors: []*apierror.ErrorDetail{
 					&apierror.ErrorDetail{Key: "desc", Value: "", Message: "required attribute"},
-					&apierror.ErrorDetail{Key: "due", Value: "invalid date", Message: "Does not match format 'rfc3339'"},
+					&apierror.ErrorDetail{Key: "due", Value: "invalid date", Message: "Does not match format 'rfc3339-or-date'"},
 					&apierror.ErrorDetail{Key: "state", Value: "invalid state", Message: "state must be one of the following: \"todo\", \"in_progress\", \"done\""},
 				},
 			},
@@ -251,7 +332,7 @@ func testUpdate(ts *httptest.Server, tm *TodoManager, td TodoList) func(*testing
 		expected := &Todo{
 			ID:          td[0].ID,
 			Description: "Updated Todo!",
-			Due:         time.Now(),
+			Due:         timePtr(time.Now()),
 			State:       state.Done,
 		}
 
@@ -334,7 +415,7 @@ func testUpdateErrors(ts *httptest.Server, tm *TodoManager, td TodoList) func(*t
 				Code:    http.StatusBadRequest,
 				Message: "Invalid JSON",
 				Errors: []*apierror.ErrorDetail{
-					&apierror.ErrorDetail{Key: "due", Value: "invalid date", Message: "Does not match format 'rfc3339'"},
+					&apierror.ErrorDetail{Key: "due", Value: "invalid date", Message: "Does not match format 'rfc3339-or-date'"},
 					&apierror.ErrorDetail{Key: "state", Value: "invalid state", Message: "state must be one of the following: \"todo\", \"in_progress\", \"done\""},
 				},
 			},
@@ -692,3 +773,2246 @@ func testDeleteErrors(ts *httptest.Server, tm *TodoManager, td TodoList) func(*t
 		}
 	}
 }
+
+func testProjects(ts *httptest.Server, tm *TodoManager, td TodoList) func(*testing.T) {
+	return func(t *testing.T) {
+		home := &Project{}
+		work := &Project{}
+
+		if code := doJSON(t, ts, "POST", "/projects/", map[string]interface{}{"name": "Home"}, home); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		if code := doJSON(t, ts, "POST", "/projects/", map[string]interface{}{"name": "Work", "desc": "Office"}, work); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		todo := &Todo{}
+		payload := map[string]interface{}{
+			"desc":       "Project TODO",
+			"due":        time.Now(),
+			"state":      state.Todo,
+			"project_id": home.ID,
+		}
+
+		if code := doJSON(t, ts, "POST", "/", payload, todo); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		if todo.ProjectID == nil || *todo.ProjectID != home.ID {
+			t.Errorf("todo.ProjectID = %v != %d", todo.ProjectID, home.ID)
+		}
+
+		payload["project_id"] = 9000
+		if code := doJSON(t, ts, "POST", "/", payload, nil); code != http.StatusBadRequest {
+			t.Errorf("statusCode = %d != %d", code, http.StatusBadRequest)
+		}
+
+		pr := &PaginatedResponse{}
+		if code := doJSON(t, ts, "GET", fmt.Sprintf("/projects/%d/todos/", home.ID), nil, pr); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if len(pr.Results) != 1 || !pr.Results[0].Equal(todo) {
+			t.Errorf("actual: %s", spew.Sdump(pr.Results))
+		}
+
+		filtered := filterTodoWithURLString(t, tm, fmt.Sprintf("/?project=%d", home.ID))
+		if !filtered.Equal(pr.Results) {
+			t.Errorf("filtered: %s", spew.Sdump(filtered))
+		}
+
+		if code := doJSON(t, ts, "DELETE", fmt.Sprintf("/projects/%d/?to=%d", home.ID, home.ID), nil, nil); code != http.StatusBadRequest {
+			t.Errorf("statusCode = %d != %d", code, http.StatusBadRequest)
+		}
+
+		if code := doJSON(t, ts, "DELETE", fmt.Sprintf("/projects/%d/?to=%d", home.ID, work.ID), nil, nil); code != http.StatusNoContent {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusNoContent)
+		}
+
+		if moved, err := tm.Get(todo.ID); err != nil {
+			t.Fatal(err)
+		} else if moved.ProjectID == nil || *moved.ProjectID != work.ID {
+			t.Errorf("moved.ProjectID = %v != %d", moved.ProjectID, work.ID)
+		}
+
+		archived := &Project{}
+		if code := doJSON(t, ts, "PATCH", fmt.Sprintf("/projects/%d/", work.ID), map[string]interface{}{"archived": true}, archived); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if !archived.Archived {
+			t.Error("project was not archived")
+		}
+
+		payload["project_id"] = work.ID
+		if code := doJSON(t, ts, "POST", "/", payload, nil); code != http.StatusBadRequest {
+			t.Errorf("statusCode = %d != %d", code, http.StatusBadRequest)
+		}
+
+		garden := &Project{}
+		if code := doJSON(t, ts, "POST", "/projects/", map[string]interface{}{"name": "Garden"}, garden); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		weed := &Todo{}
+		payload["project_id"] = garden.ID
+		if code := doJSON(t, ts, "POST", "/", payload, weed); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		if code := doJSON(t, ts, "DELETE", fmt.Sprintf("/projects/%d/?todos=archive", garden.ID), nil, nil); code != http.StatusNoContent {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusNoContent)
+		}
+
+		if code := doJSON(t, ts, "GET", fmt.Sprintf("/projects/%d/", garden.ID), nil, nil); code != http.StatusNotFound {
+			t.Errorf("statusCode = %d != %d", code, http.StatusNotFound)
+		}
+
+		if got, err := tm.Get(weed.ID); err != nil {
+			t.Fatal(err)
+		} else if !got.Archived || got.ProjectID != nil {
+			t.Errorf("actual: %s", spew.Sdump(got))
+		}
+
+		for _, tc := range []struct {
+			url    string
+			listed bool
+		}{
+			{"/?count=1000", false},
+			{"/?count=1000&archived=false", false},
+			{"/?count=1000&archived=true", true},
+		} {
+			list := &PaginatedResponse{}
+			if code := doJSON(t, ts, "GET", tc.url, nil, list); code != http.StatusOK {
+				t.Fatalf("%s: statusCode = %d != %d", tc.url, code, http.StatusOK)
+			}
+
+			listed := false
+			for _, result := range list.Results {
+				listed = listed || result.ID == weed.ID
+			}
+
+			if listed != tc.listed {
+				t.Errorf("%s: listed = %t != %t", tc.url, listed, tc.listed)
+			}
+		}
+
+		unarchived := &Todo{}
+		if code := doJSON(t, ts, "PATCH", fmt.Sprintf("/%d/", weed.ID), map[string]interface{}{"archived": false}, unarchived); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if unarchived.Archived {
+			t.Error("todo is still archived")
+		}
+
+		if code := doJSON(t, ts, "DELETE", fmt.Sprintf("/%d/", weed.ID), nil, nil); code != http.StatusNoContent {
+			t.Errorf("statusCode = %d != %d", code, http.StatusNoContent)
+		}
+	}
+}
+
+func testSubtasks(ts *httptest.Server, tm *TodoManager, td TodoList) func(*testing.T) {
+	return func(t *testing.T) {
+		parent := &Todo{}
+		payload := map[string]interface{}{
+			"desc":  "Parent TODO",
+			"due":   time.Now(),
+			"state": state.Todo,
+		}
+
+		if code := doJSON(t, ts, "POST", "/", payload, parent); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		children := TodoList{}
+		for _, s := range []state.State{state.Done, state.Todo} {
+			child := &Todo{}
+			payload := map[string]interface{}{
+				"desc":      "Child TODO",
+				"due":       time.Now(),
+				"state":     s,
+				"parent_id": parent.ID,
+			}
+
+			if code := doJSON(t, ts, "POST", "/", payload, child); code != http.StatusCreated {
+				t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+			}
+			children = append(children, child)
+		}
+
+		pr := &PaginatedResponse{}
+		if code := doJSON(t, ts, "GET", fmt.Sprintf("/%d/children/", parent.ID), nil, pr); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if !children.Equal(pr.Results) {
+			t.Errorf("actual: %s", spew.Sdump(pr.Results))
+		}
+
+		if actual, err := tm.Get(parent.ID); err != nil {
+			t.Fatal(err)
+		} else if actual.Progress == nil || *actual.Progress != 0.5 {
+			t.Errorf("actual.Progress = %v != 0.5", actual.Progress)
+		}
+
+		for _, todo := range filterTodoWithURLString(t, tm, "/?root=true") {
+			if todo.ParentID != nil {
+				t.Errorf("todo %d has parent %d", todo.ID, *todo.ParentID)
+			}
+		}
+
+		update := map[string]interface{}{"parent_id": children[0].ID}
+		if code := doJSON(t, ts, "PATCH", fmt.Sprintf("/%d/", parent.ID), update, nil); code != http.StatusBadRequest {
+			t.Errorf("statusCode = %d != %d", code, http.StatusBadRequest)
+		}
+
+		tm.Config.StrictSubtasks = true
+		defer func() { tm.Config.StrictSubtasks = false }()
+
+		update = map[string]interface{}{"state": state.Done}
+		if code := doJSON(t, ts, "PATCH", fmt.Sprintf("/%d/", parent.ID), update, nil); code != http.StatusConflict {
+			t.Errorf("statusCode = %d != %d", code, http.StatusConflict)
+		}
+
+		if code := doJSON(t, ts, "PATCH", fmt.Sprintf("/%d/", children[1].ID), update, nil); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		done := &Todo{}
+		if code := doJSON(t, ts, "PATCH", fmt.Sprintf("/%d/", parent.ID), update, done); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if done.Progress == nil || *done.Progress != 1 {
+			t.Errorf("done.Progress = %v != 1", done.Progress)
+		}
+	}
+}
+
+func testDependencies(ts *httptest.Server, tm *TodoManager, td TodoList) func(*testing.T) {
+	return func(t *testing.T) {
+		created := TodoList{}
+		for i := 0; i < 3; i++ {
+			todo := &Todo{}
+			payload := map[string]interface{}{
+				"desc":  fmt.Sprintf("Dependency TODO %d", i),
+				"due":   time.Now(),
+				"state": state.Todo,
+			}
+
+			if code := doJSON(t, ts, "POST", "/", payload, todo); code != http.StatusCreated {
+				t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+			}
+			created = append(created, todo)
+		}
+
+		a, b, c := created[0], created[1], created[2]
+
+		// a is blocked by b, b is blocked by c.
+		for _, edge := range [][2]*Todo{{a, b}, {b, c}} {
+			payload := map[string]interface{}{"blocker_id": edge[1].ID}
+			if code := doJSON(t, ts, "POST", fmt.Sprintf("/%d/dependencies/", edge[0].ID), payload, nil); code != http.StatusCreated {
+				t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+			}
+		}
+
+		payload := map[string]interface{}{"blocker_id": a.ID}
+		if code := doJSON(t, ts, "POST", fmt.Sprintf("/%d/dependencies/", c.ID), payload, nil); code != http.StatusBadRequest {
+			t.Errorf("statusCode = %d != %d", code, http.StatusBadRequest)
+		}
+
+		actual := &Todo{}
+		if code := doJSON(t, ts, "GET", fmt.Sprintf("/%d/", b.ID), nil, actual); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if !reflect.DeepEqual(actual.BlockedBy, []int64{c.ID}) || !reflect.DeepEqual(actual.Blocks, []int64{a.ID}) {
+			t.Errorf("actual: %s", spew.Sdump(actual))
+		}
+
+		blocked := filterTodoWithURLString(t, tm, "/?blocked=true")
+		if !blocked.Equal(TodoList{a, b}) {
+			t.Errorf("blocked: %s", spew.Sdump(blocked))
+		}
+
+		update := map[string]interface{}{"state": state.InProgress}
+		if code := doJSON(t, ts, "PATCH", fmt.Sprintf("/%d/", b.ID), update, nil); code != http.StatusConflict {
+			t.Errorf("statusCode = %d != %d", code, http.StatusConflict)
+		}
+
+		if code := doJSON(t, ts, "DELETE", fmt.Sprintf("/%d/dependencies/%d/", b.ID, c.ID), nil, nil); code != http.StatusNoContent {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusNoContent)
+		}
+
+		if code := doJSON(t, ts, "PATCH", fmt.Sprintf("/%d/", b.ID), update, nil); code != http.StatusOK {
+			t.Errorf("statusCode = %d != %d", code, http.StatusOK)
+		}
+	}
+}
+
+func testRecurrence(ts *httptest.Server, tm *TodoManager, td TodoList) func(*testing.T) {
+	return func(t *testing.T) {
+		due := time.Date(2019, 11, 4, 9, 0, 0, 0, time.UTC)
+
+		invalid := map[string]interface{}{
+			"desc":       "Invalid recurrence",
+			"due":        due,
+			"state":      state.Todo,
+			"recurrence": "FREQ=SOMETIMES",
+		}
+
+		if code := doJSON(t, ts, "POST", "/", invalid, nil); code != http.StatusBadRequest {
+			t.Errorf("statusCode = %d != %d", code, http.StatusBadRequest)
+		}
+
+		todo := &Todo{}
+		payload := map[string]interface{}{
+			"desc":       "Weekly report",
+			"due":        due,
+			"state":      state.Todo,
+			"recurrence": "FREQ=WEEKLY;BYDAY=MO,TH;COUNT=2",
+		}
+
+		if code := doJSON(t, ts, "POST", "/", payload, todo); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		occurrences := []time.Time{}
+		if code := doJSON(t, ts, "GET", fmt.Sprintf("/%d/occurrences/?count=5", todo.ID), nil, &occurrences); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		next := due.AddDate(0, 0, 3)
+		if len(occurrences) != 2 || !occurrences[0].Equal(due) || !occurrences[1].Equal(next) {
+			t.Errorf("occurrences: %v", occurrences)
+		}
+
+		update := map[string]interface{}{"state": state.Done}
+		if code := doJSON(t, ts, "PATCH", fmt.Sprintf("/%d/", todo.ID), update, nil); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		spawned := filterTodoWithURLString(t, tm, "/?due="+next.Format(time.RFC3339Nano))
+
+		if len(spawned) != 1 {
+			t.Fatalf("spawned: %s", spew.Sdump(spawned))
+		}
+
+		if spawned[0].State != state.Todo || spawned[0].Recurrence != "FREQ=WEEKLY;BYDAY=MO,TH;COUNT=1" {
+			t.Errorf("spawned: %s", spew.Sdump(spawned[0]))
+		}
+
+		if code := doJSON(t, ts, "PATCH", fmt.Sprintf("/%d/", spawned[0].ID), update, nil); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		last := due.AddDate(0, 0, 7)
+		if after := filterTodoWithURLString(t, tm, "/?due="+last.Format(time.RFC3339Nano)); len(after) != 0 {
+			t.Errorf("series did not end: %s", spew.Sdump(after))
+		}
+	}
+}
+
+func testPriority(ts *httptest.Server, tm *TodoManager, td TodoList) func(*testing.T) {
+	return func(t *testing.T) {
+		tm.Config.Priority = 1
+		defer func() { tm.Config.Priority = 0 }()
+
+		defaulted := &Todo{}
+		payload := map[string]interface{}{
+			"desc":  "Default priority",
+			"due":   time.Now(),
+			"state": state.Todo,
+		}
+
+		if code := doJSON(t, ts, "POST", "/", payload, defaulted); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		if defaulted.Priority != 1 {
+			t.Errorf("defaulted.Priority = %d != 1", defaulted.Priority)
+		}
+
+		payload["priority"] = 5
+		if code := doJSON(t, ts, "POST", "/", payload, nil); code != http.StatusBadRequest {
+			t.Errorf("statusCode = %d != %d", code, http.StatusBadRequest)
+		}
+
+		for _, p := range []int{3, 4} {
+			payload["priority"] = p
+			if code := doJSON(t, ts, "POST", "/", payload, nil); code != http.StatusCreated {
+				t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+			}
+		}
+
+		pr := &PaginatedResponse{}
+		if code := doJSON(t, ts, "GET", "/?priority:gte=3&sort=-priority,desc", nil, pr); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if len(pr.Results) != 2 || pr.Results[0].Priority != 4 || pr.Results[1].Priority != 3 {
+			t.Errorf("actual: %s", spew.Sdump(pr.Results))
+		}
+	}
+}
+
+func testAssignees(ts *httptest.Server, tm *TodoManager, td TodoList) func(*testing.T) {
+	return func(t *testing.T) {
+		tm.Config.AutoAssign = true
+		defer func() { tm.Config.AutoAssign = false }()
+
+		alice := &User{}
+		if code := doJSON(t, ts, "POST", "/users/", map[string]interface{}{"name": "alice", "email": "alice@example.com"}, alice); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		bob := &User{}
+		if code := doJSON(t, ts, "POST", "/users/", map[string]interface{}{"name": "bob"}, bob); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		if code := doJSON(t, ts, "POST", "/users/", map[string]interface{}{"name": "bob"}, nil); code != http.StatusConflict {
+			t.Errorf("statusCode = %d != %d", code, http.StatusConflict)
+		}
+
+		if code := doJSON(t, ts, "POST", "/users/", map[string]interface{}{"name": "carol", "email": "carol"}, nil); code != http.StatusBadRequest {
+			t.Errorf("statusCode = %d != %d", code, http.StatusBadRequest)
+		}
+
+		payload := map[string]interface{}{
+			"desc":        "Assigned TODO",
+			"due":         time.Now(),
+			"state":       state.Todo,
+			"assignee_id": alice.ID,
+		}
+
+		assigned := &Todo{}
+		if code := doJSON(t, ts, "POST", "/", payload, assigned); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		if assigned.AssigneeID == nil || *assigned.AssigneeID != alice.ID {
+			t.Errorf("assigned.AssigneeID = %v", assigned.AssigneeID)
+		}
+
+		payload["assignee_id"] = 9999
+		if code := doJSON(t, ts, "POST", "/", payload, nil); code != http.StatusBadRequest {
+			t.Errorf("statusCode = %d != %d", code, http.StatusBadRequest)
+		}
+
+		delete(payload, "assignee_id")
+		started := &Todo{}
+		if code := doJSON(t, ts, "POST", "/", payload, started); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		url := fmt.Sprintf("/%d/", started.ID)
+		if code := doJSONAs(t, ts, "bob", "PATCH", url, map[string]interface{}{"state": state.InProgress}, started); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if started.AssigneeID == nil || *started.AssigneeID != bob.ID {
+			t.Errorf("started.AssigneeID = %v", started.AssigneeID)
+		}
+
+		if code := doJSONAs(t, ts, "mallory", "PATCH", url, map[string]interface{}{"state": state.InProgress}, nil); code != http.StatusUnauthorized {
+			t.Errorf("statusCode = %d != %d", code, http.StatusUnauthorized)
+		}
+
+		pr := &PaginatedResponse{}
+		if code := doJSONAs(t, ts, "alice", "GET", "/?assignee=me", nil, pr); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if len(pr.Results) != 1 || pr.Results[0].ID != assigned.ID {
+			t.Errorf("actual: %s", spew.Sdump(pr.Results))
+		}
+
+		pr = &PaginatedResponse{}
+		if code := doJSON(t, ts, "GET", fmt.Sprintf("/?assignee=%d&assignee=%d", alice.ID, bob.ID), nil, pr); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if len(pr.Results) != 2 {
+			t.Errorf("actual: %s", spew.Sdump(pr.Results))
+		}
+
+		if code := doJSON(t, ts, "GET", "/?assignee=me", nil, nil); code != http.StatusBadRequest {
+			t.Errorf("statusCode = %d != %d", code, http.StatusBadRequest)
+		}
+
+		unassigned := filterTodoWithURLString(t, tm, "/?unassigned=true")
+		for _, todo := range unassigned {
+			if todo.AssigneeID != nil {
+				t.Errorf("todo %d is assigned", todo.ID)
+			}
+		}
+
+		if code := doJSON(t, ts, "DELETE", fmt.Sprintf("/users/%d/", alice.ID), nil, nil); code != http.StatusNoContent {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusNoContent)
+		}
+
+		if todo, err := tm.Get(assigned.ID); err != nil || todo.AssigneeID != nil {
+			t.Errorf("todo = %v, err = %v", todo, err)
+		}
+	}
+}
+
+func testComments(ts *httptest.Server, tm *TodoManager, td TodoList) func(*testing.T) {
+	return func(t *testing.T) {
+		if code := doJSON(t, ts, "POST", "/users/", map[string]interface{}{"name": "dave"}, nil); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		todo := &Todo{}
+		payload := map[string]interface{}{"desc": "Discussed TODO", "due": time.Now(), "state": state.Todo}
+		if code := doJSON(t, ts, "POST", "/", payload, todo); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		url := fmt.Sprintf("/%d/comments/", todo.ID)
+
+		comments := []*Comment{}
+		for i, user := range []string{"dave", "", "dave"} {
+			c := &Comment{}
+			body := map[string]interface{}{"body": fmt.Sprintf("Comment %d", i)}
+			if code := doJSONAs(t, ts, user, "POST", url, body, c); code != http.StatusCreated {
+				t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+			}
+			comments = append(comments, c)
+		}
+
+		if comments[0].AuthorID == nil || comments[1].AuthorID != nil || comments[0].Edited != nil {
+			t.Errorf("comments = %s", spew.Sdump(comments))
+		}
+
+		if code := doJSON(t, ts, "POST", url, map[string]interface{}{"body": ""}, nil); code != http.StatusBadRequest {
+			t.Errorf("statusCode = %d != %d", code, http.StatusBadRequest)
+		}
+
+		if code := doJSON(t, ts, "POST", "/9999/comments/", map[string]interface{}{"body": "Lost"}, nil); code != http.StatusNotFound {
+			t.Errorf("statusCode = %d != %d", code, http.StatusNotFound)
+		}
+
+		page := &CommentPage{}
+		if code := doJSON(t, ts, "GET", url+"?count=2", nil, page); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if len(page.Results) != 2 || page.Results[0].ID != comments[0].ID || page.Next == "" || page.Previous != "" {
+			t.Errorf("page = %s", spew.Sdump(page))
+		}
+
+		page = &CommentPage{}
+		if code := doJSON(t, ts, "GET", url+"?count=2&page=2", nil, page); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if len(page.Results) != 1 || page.Results[0].ID != comments[2].ID || page.Next != "" {
+			t.Errorf("page = %s", spew.Sdump(page))
+		}
+
+		mine := fmt.Sprintf("%s%d/", url, comments[0].ID)
+
+		if code := doJSON(t, ts, "PATCH", mine, map[string]interface{}{"body": "Hijacked"}, nil); code != http.StatusForbidden {
+			t.Errorf("statusCode = %d != %d", code, http.StatusForbidden)
+		}
+
+		edited := &Comment{}
+		if code := doJSONAs(t, ts, "dave", "PATCH", mine, map[string]interface{}{"body": "Edited"}, edited); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if edited.Body != "Edited" || edited.Edited == nil || !edited.Created.Equal(comments[0].Created) {
+			t.Errorf("edited = %s", spew.Sdump(edited))
+		}
+
+		anonymous := fmt.Sprintf("%s%d/", url, comments[1].ID)
+		if code := doJSON(t, ts, "DELETE", anonymous, nil, nil); code != http.StatusNoContent {
+			t.Errorf("statusCode = %d != %d", code, http.StatusNoContent)
+		}
+
+		if code := doJSON(t, ts, "DELETE", anonymous, nil, nil); code != http.StatusNotFound {
+			t.Errorf("statusCode = %d != %d", code, http.StatusNotFound)
+		}
+
+		if code := doJSON(t, ts, "DELETE", fmt.Sprintf("/%d/", todo.ID), nil, nil); code != http.StatusNoContent {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusNoContent)
+		}
+
+		if count, err := NewCommentManager(tm.Database).Count(query.All()); err != nil || count != 0 {
+			t.Errorf("count = %d, err = %v", count, err)
+		}
+	}
+}
+
+func upload(t *testing.T, ts *httptest.Server, url string, name string, content []byte, v interface{}) int {
+	body := &bytes.Buffer{}
+	mw := multipart.NewWriter(body)
+
+	fw, err := mw.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(content)
+	mw.Close()
+
+	res, err := ts.Client().Post(ts.URL+url, mw.FormDataContentType(), body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if v != nil {
+		if err = json.NewDecoder(res.Body).Decode(v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return res.StatusCode
+}
+
+func countBlobs(t *testing.T, dir string) int {
+	matches, err := filepath.Glob(filepath.Join(dir, "*", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return len(matches)
+}
+
+func testAttachments(ts *httptest.Server, tm *TodoManager, td TodoList, dir string) func(*testing.T) {
+	return func(t *testing.T) {
+		todo := &Todo{}
+		payload := map[string]interface{}{"desc": "Attached TODO", "due": time.Now(), "state": state.Todo}
+		if code := doJSON(t, ts, "POST", "/", payload, todo); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		url := fmt.Sprintf("/%d/attachments/", todo.ID)
+		content := []byte("Meeting notes")
+
+		first := &Attachment{}
+		if code := upload(t, ts, url, "notes.txt", content, first); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		if first.Name != "notes.txt" || first.Size != int64(len(content)) || first.ContentType != "text/plain; charset=utf-8" {
+			t.Errorf("first = %s", spew.Sdump(first))
+		}
+
+		second := &Attachment{}
+		if code := upload(t, ts, url, "../copy.txt", content, second); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		if second.Name != "copy.txt" || second.Hash != first.Hash || countBlobs(t, dir) != 1 {
+			t.Errorf("second = %s, blobs = %d", spew.Sdump(second), countBlobs(t, dir))
+		}
+
+		if code := upload(t, ts, url, "big.txt", bytes.Repeat([]byte("a"), 1025), nil); code != http.StatusRequestEntityTooLarge {
+			t.Errorf("statusCode = %d != %d", code, http.StatusRequestEntityTooLarge)
+		}
+
+		if code := upload(t, ts, url, "doc.pdf", []byte("%PDF-1.4\n"), nil); code != http.StatusUnsupportedMediaType {
+			t.Errorf("statusCode = %d != %d", code, http.StatusUnsupportedMediaType)
+		}
+
+		list := AttachmentList{}
+		if code := doJSON(t, ts, "GET", url, nil, &list); code != http.StatusOK || len(list) != 2 {
+			t.Errorf("statusCode = %d, list = %s", code, spew.Sdump(list))
+		}
+
+		res, err := ts.Client().Get(fmt.Sprintf("%s%s%d/", ts.URL, url, first.ID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		downloaded, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		if res.StatusCode != http.StatusOK || !bytes.Equal(downloaded, content) {
+			t.Errorf("statusCode = %d, content = %q", res.StatusCode, downloaded)
+		}
+
+		if cd := res.Header.Get("Content-Disposition"); cd != "attachment; filename=notes.txt" {
+			t.Errorf("Content-Disposition = %s", cd)
+		}
+
+		if code := doJSON(t, ts, "DELETE", fmt.Sprintf("%s%d/", url, first.ID), nil, nil); code != http.StatusNoContent {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusNoContent)
+		}
+
+		if n := countBlobs(t, dir); n != 1 {
+			t.Errorf("shared blob deleted: %d blobs", n)
+		}
+
+		if code := doJSON(t, ts, "DELETE", fmt.Sprintf("/%d/", todo.ID), nil, nil); code != http.StatusNoContent {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusNoContent)
+		}
+
+		if n := countBlobs(t, dir); n != 0 {
+			t.Errorf("%d blobs left after deleting the todo", n)
+		}
+	}
+}
+
+func testTimeTracking(ts *httptest.Server, tm *TodoManager, td TodoList) func(*testing.T) {
+	return func(t *testing.T) {
+		tm.Config.TimerStartsTodo = true
+		defer func() { tm.Config.TimerStartsTodo = false }()
+
+		if code := doJSON(t, ts, "POST", "/users/", map[string]interface{}{"name": "erin"}, nil); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		parent := &Todo{}
+		payload := map[string]interface{}{"desc": "Billable TODO", "due": time.Now(), "state": state.Todo, "estimate": 7200}
+		if code := doJSON(t, ts, "POST", "/", payload, parent); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		if parent.Estimate == nil || *parent.Estimate != 7200 || parent.TimeSpent != 0 {
+			t.Errorf("parent = %s", spew.Sdump(parent))
+		}
+
+		child := &Todo{}
+		payload = map[string]interface{}{"desc": "Billable subtask", "due": time.Now(), "state": state.Todo, "parent_id": parent.ID}
+		if code := doJSON(t, ts, "POST", "/", payload, child); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		started := time.Date(2019, 11, 4, 9, 0, 0, 0, time.UTC)
+		entry := map[string]interface{}{"started": started, "ended": started.Add(30 * time.Minute), "note": "Research"}
+
+		logged := &Worklog{}
+		if code := doJSONAs(t, ts, "erin", "POST", fmt.Sprintf("/%d/worklogs/", child.ID), entry, logged); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		if logged.Duration != 1800 || logged.UserID == nil || logged.Note != "Research" {
+			t.Errorf("logged = %s", spew.Sdump(logged))
+		}
+
+		entry["ended"] = started
+		if code := doJSON(t, ts, "POST", fmt.Sprintf("/%d/worklogs/", child.ID), entry, nil); code != http.StatusBadRequest {
+			t.Errorf("statusCode = %d != %d", code, http.StatusBadRequest)
+		}
+
+		if todo, err := tm.Get(parent.ID); err != nil || todo.TimeSpent != 1800 {
+			t.Errorf("todo = %v, err = %v", todo, err)
+		}
+
+		timer := fmt.Sprintf("/%d/timer/", parent.ID)
+
+		if code := doJSON(t, ts, "POST", timer+"start/", nil, nil); code != http.StatusUnauthorized {
+			t.Errorf("statusCode = %d != %d", code, http.StatusUnauthorized)
+		}
+
+		running := &Worklog{}
+		if code := doJSONAs(t, ts, "erin", "POST", timer+"start/", nil, running); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		if running.Ended != nil {
+			t.Errorf("running = %s", spew.Sdump(running))
+		}
+
+		if todo, err := tm.Get(parent.ID); err != nil || todo.State != state.InProgress {
+			t.Errorf("todo = %v, err = %v", todo, err)
+		}
+
+		if code := doJSONAs(t, ts, "erin", "POST", fmt.Sprintf("/%d/timer/start/", child.ID), nil, nil); code != http.StatusConflict {
+			t.Errorf("statusCode = %d != %d", code, http.StatusConflict)
+		}
+
+		if _, err := tm.insertWorklog(child.ID, running.UserID, time.Now().UTC(), nil, ""); err == nil {
+			t.Error("second running timer was inserted")
+		}
+
+		if code := doJSONAs(t, ts, "erin", "PATCH", fmt.Sprintf("/%d/worklogs/%d/", parent.ID, running.ID), map[string]interface{}{"note": "x"}, nil); code != http.StatusConflict {
+			t.Errorf("statusCode = %d != %d", code, http.StatusConflict)
+		}
+
+		stopped := &Worklog{}
+		if code := doJSONAs(t, ts, "erin", "POST", timer+"stop/", nil, stopped); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if stopped.ID != running.ID || stopped.Ended == nil {
+			t.Errorf("stopped = %s", spew.Sdump(stopped))
+		}
+
+		if code := doJSONAs(t, ts, "erin", "POST", timer+"stop/", nil, nil); code != http.StatusConflict {
+			t.Errorf("statusCode = %d != %d", code, http.StatusConflict)
+		}
+
+		url := fmt.Sprintf("/%d/worklogs/%d/", child.ID, logged.ID)
+
+		if code := doJSONAs(t, ts, "dave", "PATCH", url, map[string]interface{}{"note": "Stolen"}, nil); code != http.StatusForbidden {
+			t.Errorf("statusCode = %d != %d", code, http.StatusForbidden)
+		}
+
+		updated := &Worklog{}
+		if code := doJSONAs(t, ts, "erin", "PATCH", url, map[string]interface{}{"ended": started.Add(time.Hour)}, updated); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if updated.Duration != 3600 || updated.Note != "Research" {
+			t.Errorf("updated = %s", spew.Sdump(updated))
+		}
+
+		list := WorklogList{}
+		if code := doJSON(t, ts, "GET", fmt.Sprintf("/%d/worklogs/", child.ID), nil, &list); code != http.StatusOK || len(list) != 1 {
+			t.Errorf("statusCode = %d, list = %s", code, spew.Sdump(list))
+		}
+
+		if code := doJSONAs(t, ts, "erin", "DELETE", url, nil, nil); code != http.StatusNoContent {
+			t.Errorf("statusCode = %d != %d", code, http.StatusNoContent)
+		}
+
+		if todo, err := tm.Get(parent.ID); err != nil || todo.TimeSpent > 5 {
+			t.Errorf("todo = %v, err = %v", todo, err)
+		}
+	}
+}
+
+func testCustomFields(ts *httptest.Server, tm *TodoManager, td TodoList) func(*testing.T) {
+	return func(t *testing.T) {
+		points := &Field{}
+		if code := doJSON(t, ts, "POST", "/fields/", map[string]interface{}{"name": "story_points", "type": "number"}, points); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		if code := doJSON(t, ts, "POST", "/fields/", map[string]interface{}{"name": "story_points", "type": "string"}, nil); code != http.StatusConflict {
+			t.Errorf("statusCode = %d != %d", code, http.StatusConflict)
+		}
+
+		if code := doJSON(t, ts, "POST", "/fields/", map[string]interface{}{"name": "size", "type": "enum"}, nil); code != http.StatusBadRequest {
+			t.Errorf("statusCode = %d != %d", code, http.StatusBadRequest)
+		}
+
+		size := &Field{}
+		payload := map[string]interface{}{"name": "size", "type": "enum", "options": []string{"S", "M", "L"}, "required": true}
+		if code := doJSON(t, ts, "POST", "/fields/", payload, size); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		payload = map[string]interface{}{"desc": "Estimated TODO", "due": time.Now(), "state": state.Todo}
+		if code := doJSON(t, ts, "POST", "/", payload, nil); code != http.StatusBadRequest {
+			t.Errorf("statusCode = %d != %d", code, http.StatusBadRequest)
+		}
+
+		payload["custom_fields"] = map[string]interface{}{"size": "XL"}
+		if code := doJSON(t, ts, "POST", "/", payload, nil); code != http.StatusBadRequest {
+			t.Errorf("statusCode = %d != %d", code, http.StatusBadRequest)
+		}
+
+		payload["custom_fields"] = map[string]interface{}{"size": "M", "story_points": "five"}
+		if code := doJSON(t, ts, "POST", "/", payload, nil); code != http.StatusBadRequest {
+			t.Errorf("statusCode = %d != %d", code, http.StatusBadRequest)
+		}
+
+		small := &Todo{}
+		payload["custom_fields"] = map[string]interface{}{"size": "S", "story_points": 2}
+		if code := doJSON(t, ts, "POST", "/", payload, small); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		large := &Todo{}
+		payload["custom_fields"] = map[string]interface{}{"size": "L", "story_points": 8}
+		if code := doJSON(t, ts, "POST", "/", payload, large); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		if large.CustomFields["size"] != "L" || large.CustomFields["story_points"] != 8.0 {
+			t.Errorf("large = %s", spew.Sdump(large))
+		}
+
+		pr := &PaginatedResponse{}
+		if code := doJSON(t, ts, "GET", "/?cf.story_points:gte=3", nil, pr); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if len(pr.Results) != 1 || pr.Results[0].ID != large.ID {
+			t.Errorf("actual: %s", spew.Sdump(pr.Results))
+		}
+
+		pr = &PaginatedResponse{}
+		if code := doJSON(t, ts, "GET", "/?cf.size=S&cf.size=M", nil, pr); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if len(pr.Results) != 1 || pr.Results[0].ID != small.ID {
+			t.Errorf("actual: %s", spew.Sdump(pr.Results))
+		}
+
+		if code := doJSON(t, ts, "GET", "/?cf.color=red", nil, nil); code != http.StatusBadRequest {
+			t.Errorf("statusCode = %d != %d", code, http.StatusBadRequest)
+		}
+
+		if code := doJSON(t, ts, "GET", "/?cf.story_points:near=3", nil, nil); code != http.StatusBadRequest {
+			t.Errorf("statusCode = %d != %d", code, http.StatusBadRequest)
+		}
+
+		updated := &Todo{}
+		payload = map[string]interface{}{"custom_fields": map[string]interface{}{"story_points": nil}}
+		if code := doJSON(t, ts, "PATCH", fmt.Sprintf("/%d/", large.ID), payload, updated); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if _, ok := updated.CustomFields["story_points"]; ok || updated.CustomFields["size"] != "L" {
+			t.Errorf("updated = %s", spew.Sdump(updated))
+		}
+
+		if code := doJSON(t, ts, "DELETE", fmt.Sprintf("/fields/%d/", size.ID), nil, nil); code != http.StatusNoContent {
+			t.Errorf("statusCode = %d != %d", code, http.StatusNoContent)
+		}
+
+		if todo, err := tm.Get(large.ID); err != nil || len(todo.CustomFields) != 0 {
+			t.Errorf("todo = %v, err = %v", todo, err)
+		}
+
+		payload = map[string]interface{}{"desc": "Unsized TODO", "due": time.Now(), "state": state.Todo}
+		if code := doJSON(t, ts, "POST", "/", payload, nil); code != http.StatusCreated {
+			t.Errorf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+	}
+}
+
+func testStats(ts *httptest.Server, tm *TodoManager, td TodoList) func(*testing.T) {
+	return func(t *testing.T) {
+		metrics := &Project{}
+		if code := doJSON(t, ts, "POST", "/projects/", map[string]interface{}{"name": "Metrics"}, metrics); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		payloads := []map[string]interface{}{
+			{"desc": "Shipped", "due": time.Now().Add(time.Hour), "state": state.Done, "project_id": metrics.ID},
+			{"desc": "Late", "due": time.Now().Add(-time.Hour), "state": state.InProgress, "project_id": metrics.ID, "priority": 4},
+			{"desc": "Upcoming", "due": time.Now().Add(time.Hour), "state": state.Todo, "project_id": metrics.ID, "priority": 4},
+		}
+
+		created := TodoList{}
+		for _, payload := range payloads {
+			todo := &Todo{}
+			if code := doJSON(t, ts, "POST", "/", payload, todo); code != http.StatusCreated {
+				t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+			}
+			created = append(created, todo)
+		}
+
+		shipped := created[0]
+		if shipped.Created == nil || shipped.Completed == nil {
+			t.Fatalf("shipped = %s", spew.Sdump(shipped))
+		}
+
+		if _, err := tm.Database.Exec("UPDATE todo SET created = ? WHERE rowid = ?", shipped.Completed.Add(-2*time.Hour), shipped.ID); err != nil {
+			t.Fatal(err)
+		}
+
+		stats := &Stats{}
+		if code := doJSON(t, ts, "GET", fmt.Sprintf("/stats/?project=%d&from=%s", metrics.ID, time.Now().UTC().AddDate(0, 0, -6).Format("2006-01-02")), nil, stats); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if stats.Total != 3 || stats.Overdue != 1 || stats.States[state.Done] != 1 || stats.States[state.Todo] != 1 {
+			t.Errorf("stats = %s", spew.Sdump(stats))
+		}
+
+		if len(stats.Completed) != 7 || stats.Completed[6].Count != 1 || stats.Completed[6].Date != time.Now().UTC().Format("2006-01-02") {
+			t.Errorf("completed = %s", spew.Sdump(stats.Completed))
+		}
+
+		if stats.AverageCompletion == nil || *stats.AverageCompletion < 7199 || *stats.AverageCompletion > 7201 {
+			t.Errorf("average = %v", stats.AverageCompletion)
+		}
+
+		if priorities := stats.Groups["priority"]; len(priorities) != 2 || priorities[1].Key != 4.0 || priorities[1].Count != 2 {
+			t.Errorf("priorities = %s", spew.Sdump(priorities))
+		}
+
+		stats = &Stats{}
+		if code := doJSON(t, ts, "GET", fmt.Sprintf("/stats/?project=%d&state=todo&group_by=state", metrics.ID), nil, stats); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if stats.Total != 1 || len(stats.Groups) != 1 || len(stats.Completed) != 30 || stats.AverageCompletion != nil {
+			t.Errorf("stats = %s", spew.Sdump(stats))
+		}
+
+		for _, url := range []string{"/stats/?group_by=desc", "/stats/?from=yesterday", "/stats/?from=2019-01-01&to=2020-06-01", "/stats/?state=bogus"} {
+			if code := doJSON(t, ts, "GET", url, nil, nil); code != http.StatusBadRequest {
+				t.Errorf("%s: statusCode = %d != %d", url, code, http.StatusBadRequest)
+			}
+		}
+
+		reopened := &Todo{}
+		if code := doJSON(t, ts, "PATCH", fmt.Sprintf("/%d/", shipped.ID), map[string]interface{}{"state": state.Todo}, reopened); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if reopened.Completed != nil || reopened.Created == nil {
+			t.Errorf("reopened = %s", spew.Sdump(reopened))
+		}
+	}
+}
+
+func testGrouping(ts *httptest.Server, tm *TodoManager, td TodoList) func(*testing.T) {
+	return func(t *testing.T) {
+		grouped := &Project{}
+		if code := doJSON(t, ts, "POST", "/projects/", map[string]interface{}{"name": "Grouped"}, grouped); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		payloads := []map[string]interface{}{
+			{"desc": "Monday", "due": time.Date(2019, 11, 4, 9, 0, 0, 0, time.UTC), "state": state.Todo, "project_id": grouped.ID},
+			{"desc": "Tuesday", "due": time.Date(2019, 11, 5, 9, 0, 0, 0, time.UTC), "state": state.Todo, "project_id": grouped.ID},
+			{"desc": "December", "due": time.Date(2019, 12, 2, 9, 0, 0, 0, time.UTC), "state": state.Done, "project_id": grouped.ID},
+		}
+
+		for _, payload := range payloads {
+			if code := doJSON(t, ts, "POST", "/", payload, nil); code != http.StatusCreated {
+				t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+			}
+		}
+
+		gr := &GroupedResponse{}
+		if code := doJSON(t, ts, "GET", fmt.Sprintf("/projects/%d/todos/?group_by=state&items=1&sort=-due", grouped.ID), nil, gr); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if len(gr.Groups) != 2 || gr.Groups[0].Key != "done" || gr.Groups[1].Key != "todo" || gr.Groups[1].Count != 2 {
+			t.Fatalf("groups = %s", spew.Sdump(gr.Groups))
+		}
+
+		if results := gr.Groups[1].Results; len(results) != 1 || results[0].Description != "Tuesday" {
+			t.Errorf("results = %s", spew.Sdump(results))
+		}
+
+		for bucket, expected := range map[string][]string{"due:day": {"2019-11-04", "2019-11-05", "2019-12-02"}, "due:week": {"2019-11-04", "2019-12-02"}, "due:month": {"2019-11", "2019-12"}} {
+			gr = &GroupedResponse{}
+			if code := doJSON(t, ts, "GET", fmt.Sprintf("/?project=%d&group_by=%s", grouped.ID, bucket), nil, gr); code != http.StatusOK {
+				t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+			}
+
+			keys := []string{}
+			for _, gc := range gr.Groups {
+				keys = append(keys, gc.Key.(string))
+				if gc.Results != nil {
+					t.Errorf("%s: results = %s", bucket, spew.Sdump(gc.Results))
+				}
+			}
+
+			if !reflect.DeepEqual(keys, expected) {
+				t.Errorf("%s: %v != %v", bucket, keys, expected)
+			}
+		}
+
+		gr = &GroupedResponse{}
+		if code := doJSON(t, ts, "GET", fmt.Sprintf("/?project=%d&group_by=due:day&count=2", grouped.ID), nil, gr); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if len(gr.Groups) != 2 || gr.Next == "" {
+			t.Errorf("response = %s", spew.Sdump(gr))
+		}
+
+		for _, url := range []string{"/?group_by=desc", "/?group_by=state&items=-1", "/?group_by=state&items=x"} {
+			if code := doJSON(t, ts, "GET", url, nil, nil); code != http.StatusBadRequest {
+				t.Errorf("%s: statusCode = %d != %d", url, code, http.StatusBadRequest)
+			}
+		}
+	}
+}
+
+func testFilter(ts *httptest.Server, tm *TodoManager, td TodoList) func(*testing.T) {
+	return func(t *testing.T) {
+		filtered := &Project{}
+		if code := doJSON(t, ts, "POST", "/projects/", map[string]interface{}{"name": "Filtered"}, filtered); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		payloads := []map[string]interface{}{
+			{"desc": "Overdue chore", "due": time.Now().Add(-time.Hour), "state": state.Todo, "project_id": filtered.ID, "priority": 1},
+			{"desc": "Urgent chore", "due": time.Now().Add(time.Hour), "state": state.Todo, "project_id": filtered.ID, "priority": 4},
+			{"desc": "Relaxed chore", "due": time.Now().Add(time.Hour), "state": state.Todo, "project_id": filtered.ID, "priority": 1},
+			{"desc": "Finished chore", "due": time.Now().Add(-time.Hour), "state": state.Done, "project_id": filtered.ID, "priority": 4},
+		}
+
+		for _, payload := range payloads {
+			if code := doJSON(t, ts, "POST", "/", payload, nil); code != http.StatusCreated {
+				t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+			}
+		}
+
+		filter := url.QueryEscape(fmt.Sprintf(`project = %d and state = "todo" and (due < now() or priority >= 3)`, filtered.ID))
+
+		pr := &PaginatedResponse{}
+		if code := doJSON(t, ts, "GET", "/?sort=desc&filter="+filter, nil, pr); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if len(pr.Results) != 2 || pr.Results[0].Description != "Overdue chore" || pr.Results[1].Description != "Urgent chore" {
+			t.Errorf("actual: %s", spew.Sdump(pr.Results))
+		}
+
+		filter = url.QueryEscape(`desc contains "chore" and not state = "todo"`)
+		if code := doJSON(t, ts, "GET", fmt.Sprintf("/?project=%d&filter=%s", filtered.ID, filter), nil, pr); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if len(pr.Results) != 1 || pr.Results[0].Description != "Finished chore" {
+			t.Errorf("actual: %s", spew.Sdump(pr.Results))
+		}
+
+		if code := doJSON(t, ts, "GET", fmt.Sprintf("/?project=%d&due:lt=now&due:gte=today-1d&tz=Europe/Paris&sort=desc", filtered.ID), nil, pr); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if len(pr.Results) != 2 || pr.Results[0].Description != "Finished chore" || pr.Results[1].Description != "Overdue chore" {
+			t.Errorf("actual: %s", spew.Sdump(pr.Results))
+		}
+
+		for _, url := range []string{"/?due:lt=yesterday", "/?due:lt=now&tz=Mars/Olympus"} {
+			if code := doJSON(t, ts, "GET", url, nil, nil); code != http.StatusBadRequest {
+				t.Errorf("%s: statusCode = %d != %d", url, code, http.StatusBadRequest)
+			}
+		}
+
+		e := &apierror.Error{}
+		if code := doJSON(t, ts, "GET", "/?filter="+url.QueryEscape(`state = "todo" or`), nil, e); code != http.StatusBadRequest {
+			t.Errorf("statusCode = %d != %d", code, http.StatusBadRequest)
+		}
+
+		if len(e.Errors) != 1 || e.Errors[0].Key != "filter" || e.Errors[0].Message != "position 17: expected a field, found end of input" {
+			t.Errorf("error = %s", spew.Sdump(e))
+		}
+	}
+}
+
+func testQuickAdd(ts *httptest.Server, tm *TodoManager, td TodoList) func(*testing.T) {
+	return func(t *testing.T) {
+		finance := &Project{}
+		if code := doJSON(t, ts, "POST", "/projects/", map[string]interface{}{"name": "Personal Finance"}, finance); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		qr := &QuickAddResponse{}
+		payload := map[string]interface{}{"text": "Pay rent tomorrow 9am #personal-finance @bob !high"}
+		if code := doJSON(t, ts, "POST", "/quick/?tz=Europe/Paris", payload, qr); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		if qr.Parsed.Description != "Pay rent" || qr.Parsed.Due == nil || qr.Parsed.Project != "personal-finance" || qr.Parsed.Assignee != "bob" {
+			t.Fatalf("parsed = %s", spew.Sdump(qr.Parsed))
+		}
+
+		loc, _ := time.LoadLocation("Europe/Paris")
+		if due := qr.Parsed.Due.In(loc); due.Hour() != 9 || due.Minute() != 0 || !due.After(time.Now()) {
+			t.Errorf("due = %s", due)
+		}
+
+		todo := qr.Todo
+		if todo == nil || todo.Description != "Pay rent" || !todo.Due.Equal(*qr.Parsed.Due) || todo.Priority != 3 || todo.State != state.Todo {
+			t.Fatalf("todo = %s", spew.Sdump(todo))
+		}
+
+		if todo.ProjectID == nil || *todo.ProjectID != finance.ID || todo.AssigneeID == nil {
+			t.Errorf("todo = %s", spew.Sdump(todo))
+		}
+
+		if created, err := tm.Get(todo.ID); err != nil || !created.Equal(todo) {
+			t.Errorf("created = %v, err = %v", created, err)
+		}
+
+		undated := &QuickAddResponse{}
+		if code := doJSON(t, ts, "POST", "/quick/", map[string]interface{}{"text": "Pay rent"}, undated); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		if undated.Parsed.Due != nil || undated.Todo == nil || undated.Todo.Due != nil {
+			t.Errorf("actual: %s", spew.Sdump(undated))
+		}
+
+		tagged := &QuickAddResponse{}
+		if code := doJSON(t, ts, "POST", "/quick/", map[string]interface{}{"text": "Pay rent tomorrow #finance #personal-finance !high"}, tagged); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		if tagged.Parsed.Description != "Pay rent #finance" || len(tagged.Parsed.Tags) != 1 || tagged.Parsed.Tags[0] != "finance" || tagged.Parsed.Project != "personal-finance" {
+			t.Errorf("parsed = %s", spew.Sdump(tagged.Parsed))
+		}
+
+		if tagged.Todo == nil || tagged.Todo.Description != "Pay rent #finance" || tagged.Todo.ProjectID == nil || *tagged.Todo.ProjectID != finance.ID {
+			t.Errorf("todo = %s", spew.Sdump(tagged.Todo))
+		}
+
+		for _, text := range []string{"", "Pay rent tomorrow @nobody", "Pay rent !asap"} {
+			if code := doJSON(t, ts, "POST", "/quick/", map[string]interface{}{"text": text}, nil); code != http.StatusBadRequest {
+				t.Errorf("%q: statusCode = %d != %d", text, code, http.StatusBadRequest)
+			}
+		}
+
+		if code := doJSON(t, ts, "POST", "/quick/?tz=Mars/Olympus", payload, nil); code != http.StatusBadRequest {
+			t.Errorf("statusCode = %d != %d", code, http.StatusBadRequest)
+		}
+	}
+}
+
+func testTimezones(ts *httptest.Server, tm *TodoManager, td TodoList) func(*testing.T) {
+	return func(t *testing.T) {
+		zoned := &Project{}
+		if code := doJSON(t, ts, "POST", "/projects/", map[string]interface{}{"name": "Zoned"}, zoned); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		newYork, _ := time.LoadLocation("America/New_York")
+		tokyo, _ := time.LoadLocation("Asia/Tokyo")
+
+		friday := &Todo{}
+		payload := map[string]interface{}{"desc": "Friday", "due": "2019-11-15", "state": state.Todo, "project_id": zoned.ID}
+		if code := doJSON(t, ts, "POST", "/?tz=America/New_York", payload, friday); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		if !friday.AllDay || friday.Timezone != "America/New_York" || !friday.Due.Equal(time.Date(2019, 11, 15, 23, 59, 59, 0, newYork)) {
+			t.Errorf("actual: %s", spew.Sdump(friday))
+		}
+
+		if _, offset := friday.Due.Zone(); offset != -5*60*60 {
+			t.Errorf("due = %s", friday.Due)
+		}
+
+		// Moving an all-day todo to another zone keeps its day.
+		updated := &Todo{}
+		if code := doJSON(t, ts, "PATCH", fmt.Sprintf("/%d/", friday.ID), map[string]interface{}{"timezone": "Asia/Tokyo"}, updated); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if !updated.AllDay || !updated.Due.Equal(time.Date(2019, 11, 15, 23, 59, 59, 0, tokyo)) {
+			t.Errorf("actual: %s", spew.Sdump(updated))
+		}
+
+		// Timed todos on either side of the end of daylight saving time,
+		// given with different offsets.
+		payloads := []map[string]interface{}{
+			{"desc": "Before DST", "due": "2019-11-02T13:00:00-04:00", "state": state.Todo, "project_id": zoned.ID, "timezone": "America/New_York"},
+			{"desc": "After DST", "due": "2019-11-03T22:00:00+05:00", "state": state.Todo, "project_id": zoned.ID},
+		}
+
+		for _, payload := range payloads {
+			if code := doJSON(t, ts, "POST", "/", payload, nil); code != http.StatusCreated {
+				t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+			}
+		}
+
+		pr := &PaginatedResponse{}
+		if code := doJSON(t, ts, "GET", fmt.Sprintf("/?project=%d&due=2019-11-02T17:00:00Z", zoned.ID), nil, pr); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if len(pr.Results) != 1 || pr.Results[0].Description != "Before DST" {
+			t.Fatalf("actual: %s", spew.Sdump(pr.Results))
+		}
+
+		if _, offset := pr.Results[0].Due.Zone(); offset != -4*60*60 {
+			t.Errorf("due = %s", pr.Results[0].Due)
+		}
+
+		if code := doJSON(t, ts, "GET", fmt.Sprintf("/?project=%d&due:gte=2019-11-02T00:00:00-04:00&due:lt=2019-11-04T00:00:00-05:00&sort=due", zoned.ID), nil, pr); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if len(pr.Results) != 2 || pr.Results[0].Description != "Before DST" || pr.Results[1].Description != "After DST" {
+			t.Errorf("actual: %s", spew.Sdump(pr.Results))
+		}
+
+		for _, payload := range []map[string]interface{}{
+			{"desc": "Nowhere", "due": "2019-11-15", "state": state.Todo, "timezone": "Mars/Olympus"},
+			{"desc": "Nowhere", "due": "2019-11-15", "state": state.Todo, "timezone": "Local"},
+			{"desc": "Nowhere", "due": "2019-11-15", "state": state.Todo, "all_day": "yes"},
+		} {
+			if code := doJSON(t, ts, "POST", "/", payload, nil); code != http.StatusBadRequest {
+				t.Errorf("%v: statusCode = %d != %d", payload, code, http.StatusBadRequest)
+			}
+		}
+	}
+}
+
+func testUndated(ts *httptest.Server, tm *TodoManager, td TodoList) func(*testing.T) {
+	return func(t *testing.T) {
+		someday := &Project{}
+		if code := doJSON(t, ts, "POST", "/projects/", map[string]interface{}{"name": "Someday"}, someday); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		undated := &Todo{}
+		if code := doJSON(t, ts, "POST", "/", map[string]interface{}{"desc": "Learn Go", "state": state.Todo, "project_id": someday.ID}, undated); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		if undated.Due != nil {
+			t.Errorf("actual: %s", spew.Sdump(undated))
+		}
+
+		dated := &Todo{}
+		if code := doJSON(t, ts, "POST", "/", map[string]interface{}{"desc": "Read a book", "due": time.Now(), "state": state.Todo, "project_id": someday.ID}, dated); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		cleared := &Todo{}
+		if code := doJSON(t, ts, "PATCH", fmt.Sprintf("/%d/", dated.ID), map[string]interface{}{"due": nil}, cleared); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if cleared.Due != nil {
+			t.Errorf("actual: %s", spew.Sdump(cleared))
+		}
+
+		if code := doJSON(t, ts, "PATCH", fmt.Sprintf("/%d/", dated.ID), map[string]interface{}{"due": "2019-11-15"}, dated); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		pr := &PaginatedResponse{}
+		for params, expected := range map[string][]string{
+			"due:isnull=true":               {"Learn Go"},
+			"due:isnull=false":              {"Read a book"},
+			"sort=due":                      {"Read a book", "Learn Go"},
+			"sort=-due":                     {"Read a book", "Learn Go"},
+			"sort=due&undated=first":        {"Learn Go", "Read a book"},
+			"filter=" + url.QueryEscape("due is null"): {"Learn Go"},
+		} {
+			if code := doJSON(t, ts, "GET", fmt.Sprintf("/?project=%d&%s", someday.ID, params), nil, pr); code != http.StatusOK {
+				t.Fatalf("%s: statusCode = %d != %d", params, code, http.StatusOK)
+			}
+
+			actual := []string{}
+			for _, todo := range pr.Results {
+				actual = append(actual, todo.Description)
+			}
+
+			if !reflect.DeepEqual(actual, expected) {
+				t.Errorf("%s: %v != %v", params, actual, expected)
+			}
+		}
+
+		if code := doJSON(t, ts, "GET", "/?sort=due&undated=middle", nil, nil); code != http.StatusBadRequest {
+			t.Errorf("statusCode = %d != %d", code, http.StatusBadRequest)
+		}
+	}
+}
+
+func getCalendar(t *testing.T, ts *httptest.Server, url string) (int, *ical.Component) {
+	res, err := ts.Client().Get(ts.URL + url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return res.StatusCode, nil
+	}
+
+	if ct := res.Header.Get("Content-Type"); ct != "text/calendar; charset=utf-8" {
+		t.Errorf("Content-Type = %s", ct)
+	}
+
+	cal, err := ical.Decode(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res.StatusCode, cal
+}
+
+func testCalendar(ts *httptest.Server, tm *TodoManager, td TodoList) func(*testing.T) {
+	return func(t *testing.T) {
+		frank := &User{}
+		if code := doJSON(t, ts, "POST", "/users/", map[string]interface{}{"name": "frank"}, frank); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		feedURL := fmt.Sprintf("/users/%d/feed/", frank.ID)
+
+		if code := doJSON(t, ts, "POST", feedURL, nil, nil); code != http.StatusUnauthorized {
+			t.Errorf("statusCode = %d != %d", code, http.StatusUnauthorized)
+		}
+
+		if code := doJSONAs(t, ts, "bob", "POST", feedURL, nil, nil); code != http.StatusForbidden {
+			t.Errorf("statusCode = %d != %d", code, http.StatusForbidden)
+		}
+
+		if code := doJSONAs(t, ts, "frank", "POST", "/users/999999/feed/", nil, nil); code != http.StatusNotFound {
+			t.Errorf("statusCode = %d != %d", code, http.StatusNotFound)
+		}
+
+		feed := &FeedResponse{}
+		if code := doJSONAs(t, ts, "frank", "POST", feedURL, nil, feed); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if feed.Token == "" || feed.URL != "/calendar.ics?token="+feed.Token {
+			t.Fatalf("actual: %s", spew.Sdump(feed))
+		}
+
+		for _, u := range []string{"/calendar.ics", "/calendar.ics?token=unknown"} {
+			if code, _ := getCalendar(t, ts, u); code != http.StatusNotFound {
+				t.Errorf("%s: statusCode = %d != %d", u, code, http.StatusNotFound)
+			}
+		}
+
+		groceries := &Todo{}
+		if code := doJSON(t, ts, "POST", "/", map[string]interface{}{"desc": "Buy milk, eggs; bread", "due": "2019-11-15", "timezone": "America/New_York", "state": state.InProgress, "priority": 4, "assignee_id": frank.ID, "recurrence": "FREQ=WEEKLY"}, groceries); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		taxes := &Todo{}
+		if code := doJSON(t, ts, "POST", "/", map[string]interface{}{"desc": "File taxes", "due": "2019-11-16T17:00:00Z", "state": state.Done, "priority": 2, "assignee_id": frank.ID}, taxes); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		code, cal := getCalendar(t, ts, feed.URL+"&assignee=me&sort=due")
+		if code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if cal.Name != "VCALENDAR" || cal.Get("VERSION") == nil || cal.Get("VERSION").Value != "2.0" {
+			t.Errorf("actual: %s", cal)
+		}
+
+		vtodos := cal.Children("VTODO")
+		if len(vtodos) != 2 {
+			t.Fatalf("actual: %s", cal)
+		}
+
+		for i, expected := range []map[string]string{
+			{"UID": todoUID(groceries.ID), "SUMMARY": "Buy milk\\, eggs\\; bread", "DUE": "20191115", "STATUS": "IN-PROCESS", "PRIORITY": "1", "RRULE": "FREQ=WEEKLY"},
+			{"UID": todoUID(taxes.ID), "SUMMARY": "File taxes", "DUE": "20191116T170000Z", "STATUS": "COMPLETED", "PRIORITY": "5"},
+		} {
+			for name, value := range expected {
+				if p := vtodos[i].Get(name); p == nil || p.Value != value {
+					t.Errorf("%s = %#v != %s", name, p, value)
+				}
+			}
+
+			if vtodos[i].Get("LAST-MODIFIED") == nil || vtodos[i].Get("DTSTAMP") == nil {
+				t.Errorf("actual: %s", vtodos[i])
+			}
+		}
+
+		if p := vtodos[0].Get("DUE"); p == nil || p.Params["VALUE"] != "DATE" {
+			t.Errorf("DUE = %#v", p)
+		}
+
+		if p := vtodos[1].Get("COMPLETED"); p == nil {
+			t.Errorf("actual: %s", vtodos[1])
+		}
+
+		if code := doJSON(t, ts, "PATCH", fmt.Sprintf("/%d/", groceries.ID), map[string]interface{}{"desc": "Buy milk"}, nil); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		code, cal = getCalendar(t, ts, feed.URL+"&assignee=me&state=in_progress")
+		if code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if vtodos = cal.Children("VTODO"); len(vtodos) != 1 || vtodos[0].Get("UID").Value != todoUID(groceries.ID) || vtodos[0].Get("SUMMARY").Text() != "Buy milk" {
+			t.Errorf("actual: %s", cal)
+		}
+
+		if code, _ := getCalendar(t, ts, feed.URL+"&priority=9"); code != http.StatusBadRequest {
+			t.Errorf("statusCode = %d != %d", code, http.StatusBadRequest)
+		}
+
+		if code := doJSONAs(t, ts, "frank", "POST", feedURL, nil, &FeedResponse{}); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		if code, _ := getCalendar(t, ts, feed.URL); code != http.StatusNotFound {
+			t.Errorf("revoked feed: statusCode = %d != %d", code, http.StatusNotFound)
+		}
+	}
+}
+
+// Requests recorded from CalDAV clients.
+const (
+	thunderbirdPropfind = `<?xml version="1.0" encoding="UTF-8"?>
+<D:propfind xmlns:D="DAV:" xmlns:CS="http://calendarserver.org/ns/" xmlns:C="urn:ietf:params:xml:ns:caldav">
+  <D:prop>
+    <D:resourcetype/>
+    <D:owner/>
+    <D:current-user-principal/>
+    <D:supported-report-set/>
+    <C:supported-calendar-component-set/>
+    <CS:getctag/>
+  </D:prop>
+</D:propfind>`
+
+	thunderbirdQuery = `<?xml version="1.0" encoding="UTF-8"?>
+<calendar-query xmlns:D="DAV:" xmlns="urn:ietf:params:xml:ns:caldav">
+  <D:prop>
+    <D:getetag/>
+  </D:prop>
+  <filter>
+    <comp-filter name="VCALENDAR">
+      <comp-filter name="VTODO"/>
+    </comp-filter>
+  </filter>
+</calendar-query>`
+
+	thunderbirdPut = "BEGIN:VCALENDAR\r\n" +
+		"PRODID:-//Mozilla.org/NONSGML Mozilla Calendar V1.1//EN\r\n" +
+		"VERSION:2.0\r\n" +
+		"BEGIN:VTIMEZONE\r\n" +
+		"TZID:Europe/Paris\r\n" +
+		"BEGIN:STANDARD\r\n" +
+		"TZOFFSETFROM:+0200\r\n" +
+		"TZOFFSETTO:+0100\r\n" +
+		"TZNAME:CET\r\n" +
+		"DTSTART:19701025T030000\r\n" +
+		"RRULE:FREQ=YEARLY;BYDAY=-1SU;BYMONTH=10\r\n" +
+		"END:STANDARD\r\n" +
+		"END:VTIMEZONE\r\n" +
+		"BEGIN:VTODO\r\n" +
+		"CREATED:20191112T100000Z\r\n" +
+		"LAST-MODIFIED:20191112T100000Z\r\n" +
+		"DTSTAMP:20191112T100000Z\r\n" +
+		"UID:5d2c7b9e-1f0a-4c3e-8b6d-2a9f0e1c3b4d\r\n" +
+		"SUMMARY:Submit expense report\r\n" +
+		"STATUS:IN-PROCESS\r\n" +
+		"DUE;TZID=Europe/Paris:20191115T170000\r\n" +
+		"X-MOZ-GENERATION:1\r\n" +
+		"END:VTODO\r\n" +
+		"END:VCALENDAR\r\n"
+
+	remindersQuery = `<?xml version="1.0" encoding="UTF-8"?>
+<B:calendar-query xmlns:B="urn:ietf:params:xml:ns:caldav">
+  <A:prop xmlns:A="DAV:">
+    <A:getetag/>
+    <A:getcontenttype/>
+  </A:prop>
+  <B:filter>
+    <B:comp-filter name="VCALENDAR">
+      <B:comp-filter name="VTODO">
+        <B:prop-filter name="COMPLETED">
+          <B:is-not-defined/>
+        </B:prop-filter>
+      </B:comp-filter>
+    </B:comp-filter>
+  </B:filter>
+</B:calendar-query>`
+
+	remindersMultiget = `<?xml version="1.0" encoding="UTF-8"?>
+<B:calendar-multiget xmlns:B="urn:ietf:params:xml:ns:caldav">
+  <A:prop xmlns:A="DAV:">
+    <A:getetag/>
+    <B:calendar-data/>
+  </A:prop>
+  <A:href xmlns:A="DAV:">/caldav/todos/8B1E2F4A-3C5D-4E6F-9A0B-1C2D3E4F5A6B.ics</A:href>
+  <A:href xmlns:A="DAV:">/caldav/todos/missing.ics</A:href>
+</B:calendar-multiget>`
+
+	remindersPut = "BEGIN:VCALENDAR\r\n" +
+		"VERSION:2.0\r\n" +
+		"PRODID:-//Apple Inc.//iOS 13.2//EN\r\n" +
+		"CALSCALE:GREGORIAN\r\n" +
+		"BEGIN:VTODO\r\n" +
+		"CREATED:20191112T091500Z\r\n" +
+		"DTSTAMP:20191112T091532Z\r\n" +
+		"DUE;VALUE=DATE:20191120\r\n" +
+		"LAST-MODIFIED:20191112T091532Z\r\n" +
+		"PRIORITY:1\r\n" +
+		"SEQUENCE:0\r\n" +
+		"STATUS:NEEDS-ACTION\r\n" +
+		"SUMMARY:Renew passport\r\n" +
+		"UID:8B1E2F4A-3C5D-4E6F-9A0B-1C2D3E4F5A6B\r\n" +
+		"END:VTODO\r\n" +
+		"END:VCALENDAR\r\n"
+)
+
+type testMultistatus struct {
+	Responses []struct {
+		Href     string `xml:"DAV: href"`
+		Status   string `xml:"DAV: status"`
+		Propstat []struct {
+			Prop struct {
+				ETag         string `xml:"DAV: getetag"`
+				CTag         string `xml:"http://calendarserver.org/ns/ getctag"`
+				CalendarData string `xml:"urn:ietf:params:xml:ns:caldav calendar-data"`
+				ResourceType struct {
+					Inner string `xml:",innerxml"`
+				} `xml:"DAV: resourcetype"`
+				Any []struct {
+					XMLName xml.Name
+				} `xml:",any"`
+			} `xml:"DAV: prop"`
+			Status string `xml:"DAV: status"`
+		} `xml:"DAV: propstat"`
+	} `xml:"DAV: response"`
+}
+
+// doDAV sends a CalDAV request authenticated as user with token.
+func doDAV(t *testing.T, ts *httptest.Server, user string, token string, method string, url string, headers map[string]string, body string) (*http.Response, []byte) {
+	req, err := http.NewRequest(method, ts.URL+url, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if user != "" {
+		req.SetBasicAuth(user, token)
+	}
+
+	for k, v := range headers {
+		req.Header.Set(k, v)
+	}
+
+	res, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	data, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return res, data
+}
+
+func decodeMultistatus(t *testing.T, res *http.Response, body []byte) *testMultistatus {
+	if res.StatusCode != http.StatusMultiStatus {
+		t.Fatalf("statusCode = %d != %d: %s", res.StatusCode, http.StatusMultiStatus, body)
+	}
+
+	ms := &testMultistatus{}
+	if err := xml.Unmarshal(body, ms); err != nil {
+		t.Fatalf("%s: %s", err, body)
+	}
+	return ms
+}
+
+func testCalDAV(ts *httptest.Server, tm *TodoManager, td TodoList) func(*testing.T) {
+	return func(t *testing.T) {
+		if code := doJSON(t, ts, "POST", "/users/", map[string]interface{}{"name": "grace"}, nil); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		grace, err := (&UserManager{tm.Database}).GetByName("grace")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		feed := &FeedResponse{}
+		if code := doJSONAs(t, ts, "grace", "POST", fmt.Sprintf("/users/%d/feed/", grace.ID), nil, feed); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusOK)
+		}
+
+		dav := func(method string, url string, headers map[string]string, body string) (*http.Response, []byte) {
+			return doDAV(t, ts, "grace", feed.Token, method, url, headers, body)
+		}
+
+		multistatus := func(res *http.Response, body []byte) *testMultistatus {
+			return decodeMultistatus(t, res, body)
+		}
+
+		res, _ := dav("OPTIONS", CalDAVCalendar, nil, "")
+		if res.StatusCode != http.StatusOK || !strings.Contains(res.Header.Get("DAV"), "calendar-access") {
+			t.Errorf("OPTIONS: %d %v", res.StatusCode, res.Header)
+		}
+
+		for _, user := range []string{"", "bob"} {
+			res, _ = doDAV(t, ts, user, feed.Token, "PROPFIND", CalDAVCalendar, map[string]string{"Depth": "0"}, thunderbirdPropfind)
+			if res.StatusCode != http.StatusUnauthorized || res.Header.Get("WWW-Authenticate") == "" {
+				t.Errorf("%q: statusCode = %d != %d", user, res.StatusCode, http.StatusUnauthorized)
+			}
+		}
+
+		ctag := func() string {
+			ms := multistatus(dav("PROPFIND", CalDAVCalendar, map[string]string{"Depth": "0"}, thunderbirdPropfind))
+			if len(ms.Responses) != 1 || len(ms.Responses[0].Propstat) != 2 {
+				t.Fatalf("actual: %s", spew.Sdump(ms))
+			}
+
+			found, missing := ms.Responses[0].Propstat[0], ms.Responses[0].Propstat[1]
+			if !strings.Contains(found.Prop.ResourceType.Inner, "calendar") || found.Prop.CTag == "" || !strings.Contains(missing.Status, "404") {
+				t.Errorf("actual: %s", spew.Sdump(ms))
+			}
+
+			if len(missing.Prop.Any) != 1 || missing.Prop.Any[0].XMLName.Local != "owner" {
+				t.Errorf("missing: %s", spew.Sdump(missing))
+			}
+
+			return found.Prop.CTag
+		}
+
+		ms := multistatus(dav("PROPFIND", CalDAVRoot, map[string]string{"Depth": "1"}, ""))
+		if len(ms.Responses) != 2 || ms.Responses[0].Href != CalDAVRoot || ms.Responses[1].Href != CalDAVCalendar {
+			t.Errorf("actual: %s", spew.Sdump(ms))
+		}
+
+		before := ctag()
+
+		remindersURL := CalDAVCalendar + "8B1E2F4A-3C5D-4E6F-9A0B-1C2D3E4F5A6B.ics"
+		calendarType := map[string]string{"Content-Type": "text/calendar; charset=utf-8", "If-None-Match": "*"}
+
+		if res, body := dav("PUT", remindersURL, calendarType, remindersPut); res.StatusCode != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d: %s", res.StatusCode, http.StatusCreated, body)
+		}
+
+		if res, _ = dav("PUT", remindersURL, calendarType, remindersPut); res.StatusCode != http.StatusPreconditionFailed {
+			t.Errorf("statusCode = %d != %d", res.StatusCode, http.StatusPreconditionFailed)
+		}
+
+		// A UID belongs to one calendar object, including the default UIDs of
+		// todos created through the API.
+		duplicates := map[string]string{
+			"copy.ics":    remindersPut,
+			"default.ics": strings.Replace(remindersPut, "UID:8B1E2F4A-3C5D-4E6F-9A0B-1C2D3E4F5A6B", "UID:"+todoUID(td[0].ID), 1),
+		}
+		for name, data := range duplicates {
+			if res, body := dav("PUT", CalDAVCalendar+name, calendarType, data); res.StatusCode != http.StatusForbidden {
+				t.Errorf("%s: statusCode = %d != %d: %s", name, res.StatusCode, http.StatusForbidden, body)
+			}
+
+			if res, _ = dav("GET", CalDAVCalendar+name, nil, ""); res.StatusCode != http.StatusNotFound {
+				t.Errorf("%s: statusCode = %d != %d", name, res.StatusCode, http.StatusNotFound)
+			}
+		}
+
+		thunderbirdURL := CalDAVCalendar + "5d2c7b9e-1f0a-4c3e-8b6d-2a9f0e1c3b4d.ics"
+		if res, body := dav("PUT", thunderbirdURL, calendarType, thunderbirdPut); res.StatusCode != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d: %s", res.StatusCode, http.StatusCreated, body)
+		}
+
+		event := strings.Replace(remindersPut, "VTODO", "VEVENT", -1)
+		if res, _ = dav("PUT", CalDAVCalendar+"event.ics", calendarType, event); res.StatusCode != http.StatusForbidden {
+			t.Errorf("statusCode = %d != %d", res.StatusCode, http.StatusForbidden)
+		}
+
+		after := ctag()
+		if after == before {
+			t.Errorf("ctag unchanged: %s", after)
+		}
+
+		etags := map[string]string{}
+		ms = multistatus(dav("REPORT", CalDAVCalendar, map[string]string{"Depth": "1"}, thunderbirdQuery))
+		for _, r := range ms.Responses {
+			etags[r.Href] = r.Propstat[0].Prop.ETag
+		}
+
+		if etags[remindersURL] == "" || etags[thunderbirdURL] == "" || len(etags) != len(filterTodoWithURLString(t, tm, "/")) {
+			t.Errorf("actual: %s", spew.Sdump(etags))
+		}
+
+		ms = multistatus(dav("REPORT", CalDAVCalendar, map[string]string{"Depth": "1"}, remindersMultiget))
+		if len(ms.Responses) != 2 || ms.Responses[0].Href != remindersURL || !strings.Contains(ms.Responses[1].Status, "404") {
+			t.Fatalf("actual: %s", spew.Sdump(ms))
+		}
+
+		cal, err := ical.Decode(strings.NewReader(ms.Responses[0].Propstat[0].Prop.CalendarData))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		passport := cal.Children("VTODO")[0]
+		for name, value := range map[string]string{"UID": "8B1E2F4A-3C5D-4E6F-9A0B-1C2D3E4F5A6B", "SUMMARY": "Renew passport", "DUE": "20191120", "PRIORITY": "1", "STATUS": "NEEDS-ACTION"} {
+			if p := passport.Get(name); p == nil || p.Value != value {
+				t.Errorf("%s = %#v != %s", name, p, value)
+			}
+		}
+
+		res, body := dav("GET", thunderbirdURL, nil, "")
+		if res.StatusCode != http.StatusOK || res.Header.Get("ETag") != etags[thunderbirdURL] {
+			t.Fatalf("GET: %d %s != %s", res.StatusCode, res.Header.Get("ETag"), etags[thunderbirdURL])
+		}
+
+		pr := &PaginatedResponse{}
+		if code := doJSON(t, ts, "GET", "/?filter="+url.QueryEscape(`desc = "Submit expense report"`), nil, pr); code != http.StatusOK || len(pr.Results) != 1 {
+			t.Fatalf("statusCode = %d: %s", code, spew.Sdump(pr))
+		}
+
+		report := pr.Results[0]
+		if report.State != state.InProgress || report.Timezone != "Europe/Paris" || !report.Due.Equal(time.Date(2019, 11, 15, 16, 0, 0, 0, time.UTC)) {
+			t.Errorf("actual: %s", spew.Sdump(report))
+		}
+
+		ms = multistatus(dav("PROPFIND", fmt.Sprintf("%s%d.ics", CalDAVCalendar, td[0].ID), map[string]string{"Depth": "0"}, ""))
+		if len(ms.Responses) != 1 || ms.Responses[0].Propstat[0].Prop.ETag == "" {
+			t.Errorf("actual: %s", spew.Sdump(ms))
+		}
+
+		if res, _ = dav("GET", fmt.Sprintf("%s%d.ics", CalDAVCalendar, report.ID), nil, ""); res.StatusCode != http.StatusNotFound {
+			t.Errorf("statusCode = %d != %d", res.StatusCode, http.StatusNotFound)
+		}
+
+		done := strings.Replace(string(body), "STATUS:IN-PROCESS", "STATUS:COMPLETED", 1)
+		if res, _ = dav("PUT", thunderbirdURL, map[string]string{"Content-Type": "text/calendar", "If-Match": `"stale"`}, done); res.StatusCode != http.StatusPreconditionFailed {
+			t.Errorf("statusCode = %d != %d", res.StatusCode, http.StatusPreconditionFailed)
+		}
+
+		if res, body = dav("PUT", thunderbirdURL, map[string]string{"Content-Type": "text/calendar", "If-Match": etags[thunderbirdURL]}, done); res.StatusCode != http.StatusNoContent {
+			t.Fatalf("statusCode = %d != %d: %s", res.StatusCode, http.StatusNoContent, body)
+		}
+
+		if actual, err := tm.Get(report.ID); err != nil || actual.State != state.Done || actual.Completed == nil {
+			t.Errorf("actual: %s %s", spew.Sdump(actual), err)
+		}
+
+		ms = multistatus(dav("REPORT", CalDAVCalendar, map[string]string{"Depth": "1"}, remindersQuery))
+		for _, r := range ms.Responses {
+			if r.Href == thunderbirdURL {
+				t.Errorf("completed todo %s matched", r.Href)
+			}
+		}
+
+		if ctag() == after {
+			t.Errorf("ctag unchanged: %s", after)
+		}
+
+		res, _ = dav("GET", remindersURL, nil, "")
+		if res, body = dav("DELETE", remindersURL, map[string]string{"If-Match": res.Header.Get("ETag")}, ""); res.StatusCode != http.StatusNoContent {
+			t.Fatalf("statusCode = %d != %d: %s", res.StatusCode, http.StatusNoContent, body)
+		}
+
+		if res, _ = dav("GET", remindersURL, nil, ""); res.StatusCode != http.StatusNotFound {
+			t.Errorf("statusCode = %d != %d", res.StatusCode, http.StatusNotFound)
+		}
+	}
+}
+
+func testTodoTxt(ts *httptest.Server, tm *TodoManager, td TodoList) func(*testing.T) {
+	return func(t *testing.T) {
+		shed := &Project{}
+		if code := doJSON(t, ts, "POST", "/projects/", map[string]interface{}{"name": "garden shed"}, shed); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		heidi := &User{}
+		if code := doJSON(t, ts, "POST", "/users/", map[string]interface{}{"name": "heidi"}, heidi); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		post := func(body string, v interface{}) int {
+			res, err := ts.Client().Post(ts.URL+"/import?format=todotxt", "text/plain", bytes.NewBufferString(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			if err = json.NewDecoder(res.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+			return res.StatusCode
+		}
+
+		invalid := "Sand floor +garden-shed\n" +
+			"Fix fence +fence\n" +
+			"Paint +garden-shed +fence due:soon\n" +
+			"x\n" +
+			"Oil hinges @heidi @frank\n"
+
+		ae := &apierror.Error{}
+		if code := post(invalid, ae); code != http.StatusBadRequest {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusBadRequest)
+		}
+
+		expectedErrors := []*apierror.ErrorDetail{
+			&apierror.ErrorDetail{Key: "line 2", Value: "Fix fence +fence", Message: "unknown project +fence"},
+			&apierror.ErrorDetail{Key: "line 3", Value: "Paint +garden-shed +fence due:soon", Message: "invalid due date due:soon, expected due:YYYY-MM-DD"},
+			&apierror.ErrorDetail{Key: "line 4", Value: "x", Message: "missing description"},
+			&apierror.ErrorDetail{Key: "line 5", Value: "Oil hinges @heidi @frank", Message: "more than one assignee: @heidi and @frank"},
+		}
+
+		if !reflect.DeepEqual(ae.Errors, expectedErrors) {
+			t.Errorf("actual: %s", spew.Sdump(ae))
+		}
+
+		if list := filterTodoWithURLString(t, tm, fmt.Sprintf("/?project=%d", shed.ID)); len(list) != 0 {
+			t.Errorf("imported after errors: %s", spew.Sdump(list))
+		}
+
+		file := "(A) Fix roof +garden-shed @heidi due:2019-11-20\n" +
+			"x 2019-11-10 2019-11-01 Buy paint +garden-shed pri:C\n" +
+			"\n" +
+			"Call plumber @phone +garden-shed\n"
+
+		ir := &ImportResponse{}
+		if code := post(file, ir); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d: %s", code, http.StatusCreated, spew.Sdump(ir))
+		}
+
+		if ir.Created != 3 || len(ir.Results) != 3 {
+			t.Fatalf("actual: %s", spew.Sdump(ir))
+		}
+
+		roof, paint, plumber := ir.Results[0], ir.Results[1], ir.Results[2]
+
+		if roof.Description != "Fix roof" || roof.Priority != 4 || !roof.AllDay || roof.Due == nil || roof.Due.Format(dayFormat) != "2019-11-20" ||
+			roof.AssigneeID == nil || *roof.AssigneeID != heidi.ID || roof.ProjectID == nil || *roof.ProjectID != shed.ID {
+			t.Errorf("actual: %s", spew.Sdump(roof))
+		}
+
+		if paint.Description != "Buy paint" || paint.State != state.Done || paint.Priority != 2 || paint.Completed == nil {
+			t.Errorf("actual: %s", spew.Sdump(paint))
+		}
+
+		if plumber.Description != "Call plumber @phone" || plumber.AssigneeID != nil || plumber.Priority != tm.Config.Priority {
+			t.Errorf("actual: %s", spew.Sdump(plumber))
+		}
+
+		res, err := ts.Client().Get(ts.URL + fmt.Sprintf("/export?format=todotxt&project=%d&sort=id", shed.ID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		today := time.Now().UTC().Format(dayFormat)
+		expected := fmt.Sprintf("(A) %[1]s Fix roof +garden-shed @heidi due:2019-11-20\n"+
+			"x %[1]s %[1]s Buy paint +garden-shed pri:C\n"+
+			"(%[2]s) %[1]s Call plumber @phone +garden-shed\n", today, todoTxtPriorities[tm.Config.Priority:tm.Config.Priority+1])
+
+		if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/plain; charset=utf-8" || string(body) != expected {
+			t.Errorf("%d %s:\n%s\n!=\n%s", res.StatusCode, res.Header.Get("Content-Type"), body, expected)
+		}
+
+		for _, u := range []string{"/export", "/export?format=pdf"} {
+			if code := doJSON(t, ts, "GET", u, nil, nil); code != http.StatusBadRequest {
+				t.Errorf("%s: statusCode = %d != %d", u, code, http.StatusBadRequest)
+			}
+		}
+	}
+}
+
+func testCSV(ts *httptest.Server, tm *TodoManager, td TodoList) func(*testing.T) {
+	return func(t *testing.T) {
+		attic := &Project{}
+		if code := doJSON(t, ts, "POST", "/projects/", map[string]interface{}{"name": "attic"}, attic); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		ivan := &User{}
+		if code := doJSON(t, ts, "POST", "/users/", map[string]interface{}{"name": "ivan"}, ivan); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+
+		hours := &Field{}
+		if code := doJSON(t, ts, "POST", "/fields/", map[string]interface{}{"name": "hours", "type": "number"}, hours); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d", code, http.StatusCreated)
+		}
+		defer doJSON(t, ts, "DELETE", fmt.Sprintf("/fields/%d/", hours.ID), nil, nil)
+
+		post := func(query string, body string, v interface{}) int {
+			res, err := ts.Client().Post(ts.URL+"/import?format=csv"+query, "text/csv", bytes.NewBufferString(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			if err = json.NewDecoder(res.Body).Decode(v); err != nil {
+				t.Fatal(err)
+			}
+			return res.StatusCode
+		}
+
+		file := "\ufeffTitle,Due,Owner,Project,Priority,cf.hours,Notes\n" +
+			"Insulate roof,2019-11-20,ivan,attic,4,2.5,buy wool first\n" +
+			"\"Sweep, then mop\",2019-11-21T09:00:00Z,,attic,,,\n" +
+			"Fix window,,,attic,9,,\n" +
+			"Clear boxes,,nobody,attic,,,\n"
+
+		mapping := "&map=Title:desc&map=Owner:assignee"
+
+		ir := &ImportResponse{}
+		if code := post("&dry_run=true"+mapping, file, ir); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d: %s", code, http.StatusOK, spew.Sdump(ir))
+		}
+
+		if !ir.DryRun || ir.Created != 2 || ir.Failed != 2 || len(ir.Results) != 0 || !reflect.DeepEqual(ir.Ignored, []string{"Notes"}) ||
+			len(ir.Errors) != 2 || ir.Errors[0].Key != "row 4" || !strings.HasPrefix(ir.Errors[0].Message, "priority: ") ||
+			!reflect.DeepEqual(ir.Errors[1], &apierror.ErrorDetail{Key: "row 5", Value: []interface{}{"Clear boxes", "", "nobody", "attic", "", "", ""}, Message: "unknown user nobody"}) {
+			t.Errorf("actual: %s", spew.Sdump(ir))
+		}
+
+		if list := filterTodoWithURLString(t, tm, fmt.Sprintf("/?project=%d", attic.ID)); len(list) != 0 {
+			t.Errorf("imported by a dry run: %s", spew.Sdump(list))
+		}
+
+		ir = &ImportResponse{}
+		if code := post(mapping, file, ir); code != http.StatusCreated {
+			t.Fatalf("statusCode = %d != %d: %s", code, http.StatusCreated, spew.Sdump(ir))
+		}
+
+		if ir.DryRun || ir.Created != 2 || ir.Failed != 2 || len(ir.Results) != 2 {
+			t.Fatalf("actual: %s", spew.Sdump(ir))
+		}
+
+		roof, sweep := ir.Results[0], ir.Results[1]
+
+		if roof.Description != "Insulate roof" || roof.Priority != 4 || !roof.AllDay || roof.Due == nil || roof.Due.Format(dayFormat) != "2019-11-20" ||
+			roof.AssigneeID == nil || *roof.AssigneeID != ivan.ID || roof.ProjectID == nil || *roof.ProjectID != attic.ID || roof.CustomFields["hours"] != 2.5 {
+			t.Errorf("actual: %s", spew.Sdump(roof))
+		}
+
+		if sweep.Description != "Sweep, then mop" || sweep.AllDay || sweep.Due == nil || !sweep.Due.Equal(time.Date(2019, 11, 21, 9, 0, 0, 0, time.UTC)) || sweep.AssigneeID != nil {
+			t.Errorf("actual: %s", spew.Sdump(sweep))
+		}
+
+		res, err := ts.Client().Get(ts.URL + fmt.Sprintf("/export?format=csv&project=%d", attic.ID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		body, err := ioutil.ReadAll(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/csv; charset=utf-8" {
+			t.Fatalf("%d %s: %s", res.StatusCode, res.Header.Get("Content-Type"), body)
+		}
+
+		records, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if len(records) != 3 || !reflect.DeepEqual(records[0][:len(csvColumns)], csvColumns) {
+			t.Fatalf("actual:\n%s", body)
+		}
+
+		column := map[string]int{}
+		for i, name := range records[0] {
+			column[name] = i
+		}
+
+		expected := map[string]string{"id": fmt.Sprint(roof.ID), "desc": "Insulate roof", "due": "2019-11-20", "state": "todo", "priority": "4",
+			"project": "attic", "assignee": "ivan", "all_day": "true", "completed": "", "cf.hours": "2.5"}
+		for name, value := range expected {
+			if i, ok := column[name]; !ok || records[1][i] != value {
+				t.Errorf("%s != %s:\n%s", name, value, body)
+			}
+		}
+
+		if records[2][column["desc"]] != "Sweep, then mop" || records[2][column["due"]] != "2019-11-21T09:00:00Z" || records[2][column["cf.hours"]] != "" {
+			t.Errorf("actual:\n%s", body)
+		}
+
+		ir = &ImportResponse{}
+		if code := post("&dry_run=true", string(body), ir); code != http.StatusOK {
+			t.Fatalf("statusCode = %d != %d: %s", code, http.StatusOK, spew.Sdump(ir))
+		}
+
+		if ir.Created != 2 || ir.Failed != 0 || !reflect.DeepEqual(ir.Ignored, []string{"id", "created", "completed"}) {
+			t.Errorf("actual: %s", spew.Sdump(ir))
+		}
+
+		for _, m := range []string{"&map=Title:title", "&map=Title", "&map=Title:desc&map=Notes:desc", "&map=Missing:desc"} {
+			ae := &apierror.Error{}
+			if code := post(m, file, ae); code != http.StatusBadRequest || len(ae.Errors) != 1 || ae.Errors[0].Key != "map" {
+				t.Errorf("%s: %d %s", m, code, spew.Sdump(ae))
+			}
+		}
+
+		ae := &apierror.Error{}
+		if code := post("", "desc,state\n\"unterminated,todo\n", ae); code != http.StatusBadRequest {
+			t.Errorf("statusCode = %d != %d: %s", code, http.StatusBadRequest, spew.Sdump(ae))
+		}
+	}
+}
+
+func testNegotiation(ts *httptest.Server, tm *TodoManager, td TodoList) func(*testing.T) {
+	return func(t *testing.T) {
+		do := func(method string, url string, header map[string]string, body []byte) (*http.Response, []byte) {
+			req, err := http.NewRequest(method, ts.URL+url, bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for key, value := range header {
+				req.Header.Set(key, value)
+			}
+
+			res, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			data, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return res, data
+		}
+
+		expected, err := tm.Get(filterTodoWithURLString(t, tm, "/?sort=id")[0].ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		url := fmt.Sprintf("/%d/", expected.ID)
+
+		for _, c := range []codec.Codec{codec.JSON{}, codec.YAML{}, codec.MessagePack{}} {
+			res, body := do("GET", url, map[string]string{"Accept": c.MediaTypes()[0]}, nil)
+			if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != c.MediaTypes()[0] || res.Header.Get("Vary") != "Accept" {
+				t.Fatalf("%d %s: %s", res.StatusCode, res.Header.Get("Content-Type"), body)
+			}
+
+			actual := &Todo{}
+			if err := c.Decode(bytes.NewReader(body), actual); err != nil {
+				t.Fatal(err)
+			} else if !actual.Equal(expected) {
+				t.Errorf("%T: %s != %s", c, spew.Sdump(actual), spew.Sdump(expected))
+			}
+
+			res, body = do("GET", "/?sort=id", map[string]string{"Accept": c.MediaTypes()[0] + ";q=0.9, text/html"}, nil)
+			pr := &PaginatedResponse{}
+			if err := c.Decode(bytes.NewReader(body), pr); err != nil {
+				t.Fatal(err)
+			} else if res.StatusCode != http.StatusOK || len(pr.Results) != int(tm.Config.Limit) || pr.Results[0].ID != expected.ID || pr.Next == "" {
+				t.Errorf("%T: %d %s", c, res.StatusCode, spew.Sdump(pr))
+			}
+		}
+
+		res, body := do("GET", "/?sort=id&count=2", map[string]string{"Accept": "text/csv"}, nil)
+		if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/csv; charset=utf-8" {
+			t.Fatalf("%d %s: %s", res.StatusCode, res.Header.Get("Content-Type"), body)
+		}
+
+		records, err := csv.NewReader(bytes.NewReader(body)).ReadAll()
+		if err != nil {
+			t.Fatal(err)
+		} else if len(records) != 3 || records[0][0] != "id" || records[1][0] != fmt.Sprint(expected.ID) || records[1][1] != expected.Description {
+			t.Errorf("actual:\n%s", body)
+		}
+
+		for _, u := range []string{url, "/?group_by=state"} {
+			for _, accept := range []string{"text/html", "application/json;q=0, */*;q=0"} {
+				if res, body = do("GET", u, map[string]string{"Accept": accept}, nil); res.StatusCode != http.StatusNotAcceptable {
+					t.Errorf("%s %s: %d %s", u, accept, res.StatusCode, body)
+				}
+			}
+		}
+
+		if res, body = do("GET", "/?group_by=state", map[string]string{"Accept": "text/csv"}, nil); res.StatusCode != http.StatusNotAcceptable {
+			t.Errorf("%d %s", res.StatusCode, body)
+		}
+
+		res, body = do("GET", "/?group_by=state", map[string]string{"Accept": "text/csv, application/json;q=0.5"}, nil)
+		if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("%d %s: %s", res.StatusCode, res.Header.Get("Content-Type"), body)
+		}
+
+		yaml := []byte("desc: Sort screws\nstate: todo\ndue: 2019-12-01\n")
+		if res, body = do("POST", "/", map[string]string{"Content-Type": "application/yaml"}, yaml); res.StatusCode != http.StatusCreated {
+			t.Fatalf("%d %s", res.StatusCode, body)
+		}
+
+		created := &Todo{}
+		if err = json.Unmarshal(body, created); err != nil {
+			t.Fatal(err)
+		} else if created.Description != "Sort screws" || !created.AllDay || created.Due == nil || created.Due.Format(dayFormat) != "2019-12-01" {
+			t.Errorf("actual: %s", spew.Sdump(created))
+		}
+
+		var packed bytes.Buffer
+		if err = (codec.MessagePack{}).Encode(&packed, map[string]interface{}{"priority": 3}); err != nil {
+			t.Fatal(err)
+		}
+
+		if res, body = do("PATCH", fmt.Sprintf("/%d/", created.ID), map[string]string{"Content-Type": "application/msgpack"}, packed.Bytes()); res.StatusCode != http.StatusOK {
+			t.Fatalf("%d %s", res.StatusCode, body)
+		} else if err = json.Unmarshal(body, created); err != nil || created.Priority != 3 {
+			t.Errorf("actual: %s", body)
+		}
+
+		for _, contentType := range []string{"text/csv", "text/html"} {
+			if res, body = do("POST", "/", map[string]string{"Content-Type": contentType}, []byte("desc,state\nSort nails,todo\n")); res.StatusCode != http.StatusUnsupportedMediaType {
+				t.Errorf("%s: %d %s", contentType, res.StatusCode, body)
+			}
+		}
+
+		if res, body = do("POST", "/", map[string]string{"Content-Type": "application/yaml"}, []byte("desc: [unclosed\n")); res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%d %s", res.StatusCode, body)
+		}
+
+		if err = tm.Delete(created.ID); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func testAdmin(ts *httptest.Server, tm *TodoManager, td TodoList) func(*testing.T) {
+	return func(t *testing.T) {
+		do := func(method string, url string, token string, body []byte) (*http.Response, []byte) {
+			req, err := http.NewRequest(method, ts.URL+url, bytes.NewReader(body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token != "" {
+				req.Header.Set("Authorization", "Bearer "+token)
+			}
+
+			res, err := ts.Client().Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer res.Body.Close()
+
+			data, err := ioutil.ReadAll(res.Body)
+			if err != nil {
+				t.Fatal(err)
+			}
+			return res, data
+		}
+
+		if res, body := do("GET", "/admin/export", "secret", nil); res.StatusCode != http.StatusForbidden {
+			t.Fatalf("%d %s", res.StatusCode, body)
+		}
+
+		tm.Config.AdminToken = "secret"
+		defer func() { tm.Config.AdminToken = "" }()
+
+		for _, token := range []string{"", "guess"} {
+			if res, body := do("GET", "/admin/export", token, nil); res.StatusCode != http.StatusUnauthorized {
+				t.Errorf("%q: %d %s", token, res.StatusCode, body)
+			}
+		}
+
+		if res, body := do("GET", "/admin/export?format=xml", "secret", nil); res.StatusCode != http.StatusBadRequest {
+			t.Errorf("%d %s", res.StatusCode, body)
+		}
+
+		count := len(filterTodoWithURLString(t, tm, "/"))
+
+		res, archive := do("GET", "/admin/export?format=ndjson", "secret", nil)
+		if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "application/x-ndjson" {
+			t.Fatalf("%d %s: %s", res.StatusCode, res.Header.Get("Content-Type"), archive)
+		}
+
+		if !bytes.HasPrefix(archive, []byte(`{"type":"header","format":"todo-archive","version":1`)) || !bytes.Contains(archive, []byte(`{"type":"checksums"`)) {
+			t.Errorf("archive: %.200s", archive)
+		}
+
+		if res, body := do("POST", "/admin/import", "guess", archive); res.StatusCode != http.StatusUnauthorized {
+			t.Errorf("%d %s", res.StatusCode, body)
+		}
+
+		// Every user in the archive already exists, so importing it into the
+		// same database must fail and leave nothing behind.
+		if res, body := do("POST", "/admin/import", "secret", archive); res.StatusCode != http.StatusBadRequest || !bytes.Contains(body, []byte("Invalid archive")) {
+			t.Errorf("%d %s", res.StatusCode, body)
+		}
+
+		if actual := len(filterTodoWithURLString(t, tm, "/")); actual != count {
+			t.Errorf("%d != %d", actual, count)
+		}
+	}
+}
@@ -0,0 +1,331 @@
+package ical
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	DateTimeFormat = "20060102T150405Z"
+	// LocalFormat is a date-time without a zone, either floating or in the
+	// zone named by a TZID parameter.
+	LocalFormat = "20060102T150405"
+	DateFormat  = "20060102"
+)
+
+// maxLine is the number of octets after which content lines are folded.
+const maxLine = 75
+
+// Property is a content line such as DUE;VALUE=DATE:20191115. Value is kept
+// as written; use Text for TEXT values.
+type Property struct {
+	Name   string
+	Params map[string]string
+	Value  string
+}
+
+// Text returns the value of r with TEXT escapes removed.
+func (r *Property) Text() string {
+	return UnescapeText(r.Value)
+}
+
+// Component is an iCalendar component such as VCALENDAR or VTODO.
+type Component struct {
+	Name       string
+	Properties []*Property
+	Components []*Component
+}
+
+func NewComponent(name string) *Component {
+	return &Component{Name: name}
+}
+
+// Add appends a property whose value is already encoded, with params given as
+// name, value pairs.
+func (r *Component) Add(name string, value string, params ...string) *Property {
+	p := &Property{Name: name, Value: value}
+	if len(params) > 0 {
+		p.Params = map[string]string{}
+		for i := 0; i+1 < len(params); i += 2 {
+			p.Params[params[i]] = params[i+1]
+		}
+	}
+	r.Properties = append(r.Properties, p)
+	return p
+}
+
+// AddText appends a TEXT property, escaping text.
+func (r *Component) AddText(name string, text string) *Property {
+	return r.Add(name, EscapeText(text))
+}
+
+// AddTime appends a DATE-TIME property in UTC.
+func (r *Component) AddTime(name string, t time.Time) *Property {
+	return r.Add(name, t.UTC().Format(DateTimeFormat))
+}
+
+// Get returns the first property named name, or nil.
+func (r *Component) Get(name string) *Property {
+	for _, p := range r.Properties {
+		if strings.EqualFold(p.Name, name) {
+			return p
+		}
+	}
+	return nil
+}
+
+// Children returns the subcomponents named name.
+func (r *Component) Children(name string) []*Component {
+	results := []*Component{}
+	for _, c := range r.Components {
+		if strings.EqualFold(c.Name, name) {
+			results = append(results, c)
+		}
+	}
+	return results
+}
+
+// Encode writes r with CRLF line endings, folding long lines.
+func (r *Component) Encode(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	r.encode(bw)
+	return bw.Flush()
+}
+
+func (r *Component) String() string {
+	var b strings.Builder
+	r.Encode(&b)
+	return b.String()
+}
+
+func (r *Component) encode(w *bufio.Writer) {
+	writeLine(w, "BEGIN:"+r.Name)
+	for _, p := range r.Properties {
+		writeLine(w, p.line())
+	}
+	for _, c := range r.Components {
+		c.encode(w)
+	}
+	writeLine(w, "END:"+r.Name)
+}
+
+func (r *Property) line() string {
+	var b strings.Builder
+	b.WriteString(r.Name)
+
+	names := make([]string, 0, len(r.Params))
+	for name := range r.Params {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		value := r.Params[name]
+		if strings.ContainsAny(value, ";:,") {
+			value = `"` + value + `"`
+		}
+		b.WriteString(";" + name + "=" + value)
+	}
+
+	b.WriteString(":" + r.Value)
+	return b.String()
+}
+
+// writeLine folds line into chunks of at most maxLine octets without
+// splitting UTF-8 sequences. Continuation lines start with a space.
+func writeLine(w *bufio.Writer, line string) {
+	limit := maxLine
+	for len(line) > limit {
+		i := limit
+		for i > 0 && !utf8.RuneStart(line[i]) {
+			i--
+		}
+		w.WriteString(line[:i] + "\r\n ")
+		line = line[i:]
+		limit = maxLine - 1
+	}
+	w.WriteString(line + "\r\n")
+}
+
+// Decode parses the first component in r, such as a VCALENDAR.
+func Decode(r io.Reader) (*Component, error) {
+	lines, err := unfold(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var stack []*Component
+	var root *Component
+
+	for n, line := range lines {
+		if line == "" {
+			continue
+		}
+
+		p, err := parseLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", n+1, err)
+		}
+
+		switch strings.ToUpper(p.Name) {
+		case "BEGIN":
+			c := NewComponent(strings.ToUpper(p.Value))
+			if len(stack) > 0 {
+				parent := stack[len(stack)-1]
+				parent.Components = append(parent.Components, c)
+			} else if root != nil {
+				return nil, fmt.Errorf("line %d: more than one top-level component", n+1)
+			} else {
+				root = c
+			}
+			stack = append(stack, c)
+		case "END":
+			if len(stack) == 0 || !strings.EqualFold(stack[len(stack)-1].Name, p.Value) {
+				return nil, fmt.Errorf("line %d: unexpected END:%s", n+1, p.Value)
+			}
+			stack = stack[:len(stack)-1]
+		default:
+			if len(stack) == 0 {
+				return nil, fmt.Errorf("line %d: property %s outside of a component", n+1, p.Name)
+			}
+			c := stack[len(stack)-1]
+			c.Properties = append(c.Properties, p)
+		}
+	}
+
+	if root == nil {
+		return nil, fmt.Errorf("no component")
+	}
+
+	if len(stack) > 0 {
+		return nil, fmt.Errorf("missing END:%s", stack[len(stack)-1].Name)
+	}
+
+	return root, nil
+}
+
+// unfold joins continuation lines, which start with a space or a tab.
+func unfold(r io.Reader) ([]string, error) {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 4096), 1<<20)
+
+	lines := []string{}
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if len(line) > 0 && (line[0] == ' ' || line[0] == '\t') && len(lines) > 0 {
+			lines[len(lines)-1] += line[1:]
+		} else {
+			lines = append(lines, line)
+		}
+	}
+
+	return lines, scanner.Err()
+}
+
+func parseLine(line string) (*Property, error) {
+	p := &Property{}
+
+	i := strings.IndexAny(line, ";:")
+	if i <= 0 {
+		return nil, fmt.Errorf("expected a property name in %q", line)
+	}
+	p.Name = strings.ToUpper(line[:i])
+	line = line[i:]
+
+	for len(line) > 0 && line[0] == ';' {
+		line = line[1:]
+
+		eq := strings.IndexByte(line, '=')
+		if eq <= 0 {
+			return nil, fmt.Errorf("expected a parameter of %s", p.Name)
+		}
+		name := strings.ToUpper(line[:eq])
+		line = line[eq+1:]
+
+		var value string
+		if strings.HasPrefix(line, `"`) {
+			end := strings.IndexByte(line[1:], '"')
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quote in parameter %s of %s", name, p.Name)
+			}
+			value, line = line[1:end+1], line[end+2:]
+		} else {
+			end := strings.IndexAny(line, ";:")
+			if end < 0 {
+				return nil, fmt.Errorf("missing value of %s", p.Name)
+			}
+			value, line = line[:end], line[end:]
+		}
+
+		if p.Params == nil {
+			p.Params = map[string]string{}
+		}
+		p.Params[name] = value
+	}
+
+	if !strings.HasPrefix(line, ":") {
+		return nil, fmt.Errorf("missing value of %s", p.Name)
+	}
+	p.Value = line[1:]
+
+	return p, nil
+}
+
+var textEscaper = strings.NewReplacer(`\`, `\\`, ";", `\;`, ",", `\,`, "\r\n", `\n`, "\n", `\n`)
+
+// EscapeText escapes backslashes, semicolons, commas and newlines.
+func EscapeText(s string) string {
+	return textEscaper.Replace(s)
+}
+
+// UnescapeText reverses EscapeText.
+func UnescapeText(s string) string {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+			switch s[i] {
+			case 'n', 'N':
+				b.WriteByte('\n')
+			default:
+				b.WriteByte(s[i])
+			}
+			continue
+		}
+		b.WriteByte(s[i])
+	}
+	return b.String()
+}
+
+// ParseTime parses a DATE or DATE-TIME property. A date-time in UTC or in the
+// zone of a TZID parameter is returned in that zone, and a floating one in
+// loc. A date, the second result being true, is midnight in loc.
+func ParseTime(p *Property, loc *time.Location) (time.Time, bool, error) {
+	value := p.Value
+
+	if p.Params["VALUE"] == "DATE" || len(value) == len(DateFormat) {
+		t, err := time.ParseInLocation(DateFormat, value, loc)
+		return t, true, err
+	}
+
+	if strings.HasSuffix(value, "Z") {
+		t, err := time.Parse(DateTimeFormat, value)
+		return t, false, err
+	}
+
+	if tzid, ok := p.Params["TZID"]; ok {
+		zone, err := time.LoadLocation(tzid)
+		if err != nil {
+			return time.Time{}, false, fmt.Errorf("unknown TZID %q", tzid)
+		}
+		loc = zone
+	}
+
+	t, err := time.ParseInLocation(LocalFormat, value, loc)
+	return t, false, err
+}
@@ -0,0 +1,88 @@
+package ical
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	summary := "Buy milk, eggs; bread\\butter\nand a very long list of other groceries that does not fit on one line: café"
+
+	cal := NewComponent("VCALENDAR")
+	cal.Add("VERSION", "2.0")
+	todo := NewComponent("VTODO")
+	todo.AddText("SUMMARY", summary)
+	todo.Add("DUE", "20191115", "VALUE", "DATE")
+	todo.Add("X-NOTE", "a", "X-LABEL", "one;two")
+	cal.Components = append(cal.Components, todo)
+
+	encoded := cal.String()
+
+	for _, line := range strings.Split(strings.TrimSuffix(encoded, "\r\n"), "\r\n") {
+		if len(line) > 75 {
+			t.Errorf("line longer than 75 octets: %q", line)
+		}
+	}
+
+	if !strings.Contains(encoded, "DUE;VALUE=DATE:20191115\r\n") || !strings.Contains(encoded, `X-NOTE;X-LABEL="one;two":a`) {
+		t.Errorf("encoded: %s", encoded)
+	}
+
+	decoded, err := Decode(strings.NewReader(encoded))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	todos := decoded.Children("VTODO")
+	if decoded.Name != "VCALENDAR" || len(todos) != 1 {
+		t.Fatalf("decoded: %#v", decoded)
+	}
+
+	if actual := todos[0].Get("SUMMARY").Text(); actual != summary {
+		t.Errorf("%q != %q", actual, summary)
+	}
+
+	if p := todos[0].Get("x-note"); p == nil || p.Params["X-LABEL"] != "one;two" || p.Value != "a" {
+		t.Errorf("X-NOTE: %#v", p)
+	}
+
+	for _, invalid := range []string{"", "BEGIN:VTODO\r\n", "BEGIN:VTODO\r\nEND:VEVENT\r\n", "SUMMARY:x\r\n", "BEGIN:VTODO\r\nSUMMARY\r\nEND:VTODO\r\n"} {
+		if _, err := Decode(strings.NewReader(invalid)); err == nil {
+			t.Errorf("Decode(%q) succeeded", invalid)
+		}
+	}
+}
+
+func TestParseTime(t *testing.T) {
+	paris, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	tests := []struct {
+		property *Property
+		expected time.Time
+		date     bool
+	}{
+		{&Property{Name: "DUE", Value: "20191115T120000Z"}, time.Date(2019, 11, 15, 12, 0, 0, 0, time.UTC), false},
+		{&Property{Name: "DUE", Value: "20191115T120000", Params: map[string]string{"TZID": "Europe/Paris"}}, time.Date(2019, 11, 15, 11, 0, 0, 0, time.UTC), false},
+		{&Property{Name: "DUE", Value: "20191115T120000"}, time.Date(2019, 11, 15, 12, 0, 0, 0, time.UTC), false},
+		{&Property{Name: "DUE", Value: "20191115", Params: map[string]string{"VALUE": "DATE"}}, time.Date(2019, 11, 15, 0, 0, 0, 0, time.UTC), true},
+	}
+
+	for _, test := range tests {
+		actual, date, err := ParseTime(test.property, time.UTC)
+		if err != nil || !actual.Equal(test.expected) || date != test.date {
+			t.Errorf("%s: %s, %v, %v", test.property.Value, actual, date, err)
+		}
+	}
+
+	if actual, _, _ := ParseTime(&Property{Name: "DUE", Value: "20191115T120000"}, paris); !actual.Equal(time.Date(2019, 11, 15, 11, 0, 0, 0, time.UTC)) {
+		t.Errorf("floating time in Europe/Paris: %s", actual)
+	}
+
+	if _, _, err := ParseTime(&Property{Name: "DUE", Value: "20191115T120000", Params: map[string]string{"TZID": "Mars/Olympus"}}, time.UTC); err == nil {
+		t.Error("unknown TZID accepted")
+	}
+}
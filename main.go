@@ -1,8 +1,13 @@
 package main
 
 import (
+	"context"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
 )
 
 func main() {
@@ -12,6 +17,13 @@ func main() {
 		log.Panic(err)
 	}
 
+	if len(os.Args) > 1 {
+		if err = runCommand(config, os.Args[1:]); err != nil {
+			log.Fatal(err)
+		}
+		return
+	}
+
 	db, err := OpenDB(config.Database)
 	if err != nil {
 		log.Fatal(err)
@@ -21,11 +33,41 @@ func main() {
 
 	log.Printf("Running Todo server at %s using database %s...\n", config.Addr(), config.Database)
 
-
-	m := &TodoManager{db}
+	m := NewManager(db, config)
 	h := NewHandler(m, config)
 
-	if err := http.ListenAndServe(config.Addr(), NewRouter(h)); err != nil {
+	notifier, err := NewNotifier(config)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	if config.NotifyChanges {
+		m.Notifier = notifier
+	}
+
+	if config.SchedulerInterval > 0 {
+		scheduler := NewScheduler(m, notifier, config.SchedulerInterval)
+		scheduler.DigestHour = config.DigestHour
+		scheduler.Start()
+		defer scheduler.Stop()
+	}
+
+	srv := &http.Server{Addr: config.Addr(), Handler: NewRouter(h)}
+
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+		<-sig
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		defer cancel()
+
+		if err := srv.Shutdown(ctx); err != nil {
+			log.Printf("ERROR: shutting down: %s", err)
+		}
+	}()
+
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
 		log.Fatal(err)
 	}
 }
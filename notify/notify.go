@@ -0,0 +1,121 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+)
+
+type Kind string
+
+const (
+	Reminder Kind = "reminder"
+	Overdue  Kind = "overdue"
+	Changed  Kind = "changed"
+)
+
+// Notification describes a todo that needs somebody's attention.
+type Notification struct {
+	Kind        Kind   `json:"kind"`
+	TodoID      int64  `json:"todo_id"`
+	Description string `json:"desc"`
+	// Due is nil for a changed todo without a due date.
+	Due   *time.Time `json:"due"`
+	State string     `json:"state"`
+	// Offset is the number of seconds before Due a reminder was scheduled.
+	Offset int64 `json:"offset"`
+	// To holds the assignee's address, if any. Notifiers that deliver to
+	// people prefer it over their configured recipients.
+	To []string `json:"to,omitempty"`
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, n *Notification) error
+}
+
+type LogNotifier struct {
+	Logger *log.Logger
+}
+
+func (r *LogNotifier) Notify(ctx context.Context, n *Notification) error {
+	logger := r.Logger
+	if logger == nil {
+		logger = log.New(log.Writer(), "", log.LstdFlags)
+	}
+
+	due := "never"
+	if n.Due != nil {
+		due = n.Due.Format(time.RFC3339)
+	}
+
+	logger.Printf("NOTIFY %s: todo %d %q due %s", n.Kind, n.TodoID, n.Description, due)
+	return nil
+}
+
+// WebhookNotifier POSTs each notification as JSON to URL.
+type WebhookNotifier struct {
+	URL    string
+	Client *http.Client
+}
+
+func (r *WebhookNotifier) Notify(ctx context.Context, n *Notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", r.URL, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+
+	client := r.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("Webhook responded with status %d", res.StatusCode)
+	}
+
+	return nil
+}
+
+// Multi dispatches every notification to each of its notifiers, returning the
+// first error encountered.
+type Multi []Notifier
+
+func (r Multi) Notify(ctx context.Context, n *Notification) error {
+	var first error
+	for _, notifier := range r {
+		if err := notifier.Notify(ctx, n); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+// SendDigest forwards d to each notifier that can send digests.
+func (r Multi) SendDigest(ctx context.Context, d *Digest) error {
+	var first error
+	for _, notifier := range r {
+		if sender, ok := notifier.(DigestSender); ok {
+			if err := sender.SendDigest(ctx, d); err != nil && first == nil {
+				first = err
+			}
+		}
+	}
+	return first
+}
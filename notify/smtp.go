@@ -0,0 +1,270 @@
+package notify
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	htmltemplate "html/template"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// Digest summarizes the open todos of a recipient for one day.
+type Digest struct {
+	To       []string
+	Date     time.Time
+	Overdue  []*Notification
+	DueToday []*Notification
+}
+
+type DigestSender interface {
+	SendDigest(ctx context.Context, d *Digest) error
+}
+
+type SMTPConfig struct {
+	Host     string
+	Port     int
+	Username string
+	Password string
+	From     string
+	To       []string
+	// TLS is one of "none", "starttls" or "tls".
+	TLS string
+	// TLSConfig overrides the TLS configuration, mainly for tests.
+	TLSConfig *tls.Config
+}
+
+const notificationSubject = `{{if eq .Kind "overdue"}}Overdue{{else if eq .Kind "changed"}}Updated{{else}}Reminder{{end}}: {{.Description}}`
+
+const notificationText = `{{if eq .Kind "overdue"}}This todo is overdue.{{else if eq .Kind "changed"}}This todo was updated.{{else}}This todo is due soon.{{end}}
+
+#{{.TodoID}} {{.Description}}
+Due:   {{if .Due}}{{.Due.Format "Mon, 02 Jan 2006 15:04 MST"}}{{else}}none{{end}}
+State: {{.State}}
+`
+
+const notificationHTML = `<p>{{if eq .Kind "overdue"}}This todo is overdue.{{else if eq .Kind "changed"}}This todo was updated.{{else}}This todo is due soon.{{end}}</p>
+<p><strong>#{{.TodoID}} {{.Description}}</strong></p>
+<table>
+<tr><th align="left">Due</th><td>{{if .Due}}{{.Due.Format "Mon, 02 Jan 2006 15:04 MST"}}{{else}}none{{end}}</td></tr>
+<tr><th align="left">State</th><td>{{.State}}</td></tr>
+</table>
+`
+
+const digestSubject = `Todo digest for {{.Date.Format "Mon, 02 Jan 2006"}}`
+
+const digestText = `Overdue ({{len .Overdue}})
+{{range .Overdue}}  #{{.TodoID}} {{.Description}} (due {{.Due.Format "02 Jan 15:04"}}, {{.State}})
+{{else}}  Nothing overdue.
+{{end}}
+Due today ({{len .DueToday}})
+{{range .DueToday}}  #{{.TodoID}} {{.Description}} (due {{.Due.Format "15:04"}}, {{.State}})
+{{else}}  Nothing due today.
+{{end}}`
+
+const digestHTML = `<h2>Overdue ({{len .Overdue}})</h2>
+{{if .Overdue}}<ul>
+{{range .Overdue}}<li>#{{.TodoID}} {{.Description}} (due {{.Due.Format "02 Jan 15:04"}}, {{.State}})</li>
+{{end}}</ul>{{else}}<p>Nothing overdue.</p>{{end}}
+<h2>Due today ({{len .DueToday}})</h2>
+{{if .DueToday}}<ul>
+{{range .DueToday}}<li>#{{.TodoID}} {{.Description}} (due {{.Due.Format "15:04"}}, {{.State}})</li>
+{{end}}</ul>{{else}}<p>Nothing due today.</p>{{end}}
+`
+
+type mailTemplate struct {
+	subject *texttemplate.Template
+	text    *texttemplate.Template
+	html    *htmltemplate.Template
+}
+
+func newMailTemplate(name string, subject string, text string, html string) *mailTemplate {
+	return &mailTemplate{
+		texttemplate.Must(texttemplate.New(name + "-subject").Parse(subject)),
+		texttemplate.Must(texttemplate.New(name + "-text").Parse(text)),
+		htmltemplate.Must(htmltemplate.New(name + "-html").Parse(html)),
+	}
+}
+
+var (
+	notificationTemplate = newMailTemplate("notification", notificationSubject, notificationText, notificationHTML)
+	digestTemplate       = newMailTemplate("digest", digestSubject, digestText, digestHTML)
+)
+
+// SMTPNotifier mails notifications and daily digests.
+type SMTPNotifier struct {
+	Config *SMTPConfig
+}
+
+func (r *SMTPNotifier) Notify(ctx context.Context, n *Notification) error {
+	to := n.To
+	if len(to) == 0 {
+		to = r.Config.To
+	}
+
+	msg, err := r.message(to, notificationTemplate, n)
+	if err != nil {
+		return err
+	}
+	return r.send(ctx, to, msg)
+}
+
+func (r *SMTPNotifier) SendDigest(ctx context.Context, d *Digest) error {
+	to := d.To
+	if len(to) == 0 {
+		to = r.Config.To
+	}
+
+	msg, err := r.message(to, digestTemplate, d)
+	if err != nil {
+		return err
+	}
+	return r.send(ctx, to, msg)
+}
+
+// message renders a multipart/alternative mail with text and HTML bodies.
+func (r *SMTPNotifier) message(to []string, t *mailTemplate, data interface{}) ([]byte, error) {
+	var subject, text, html bytes.Buffer
+
+	if err := t.subject.Execute(&subject, data); err != nil {
+		return nil, err
+	}
+	if err := t.text.Execute(&text, data); err != nil {
+		return nil, err
+	}
+	if err := t.html.Execute(&html, data); err != nil {
+		return nil, err
+	}
+
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+
+	for _, part := range []struct {
+		contentType string
+		content     []byte
+	}{
+		{"text/plain; charset=utf-8", text.Bytes()},
+		{"text/html; charset=utf-8", html.Bytes()},
+	} {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", part.contentType)
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+
+		w, err := mw.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+
+		qp := quotedprintable.NewWriter(w)
+		if _, err = qp.Write(part.content); err != nil {
+			return nil, err
+		}
+		if err = qp.Close(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", headerValue(r.Config.From))
+	fmt.Fprintf(&msg, "To: %s\r\n", headerValue(strings.Join(to, ", ")))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", headerValue(subject.String())))
+	fmt.Fprintf(&msg, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&msg, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&msg, "Content-Type: multipart/alternative; boundary=%s\r\n", mw.Boundary())
+	fmt.Fprintf(&msg, "\r\n")
+	msg.Write(body.Bytes())
+
+	return msg.Bytes(), nil
+}
+
+// headerValue replaces the line breaks in s, which come from todo
+// descriptions and addresses, so that they cannot start new headers.
+func headerValue(s string) string {
+	return strings.NewReplacer("\r\n", " ", "\r", " ", "\n", " ").Replace(s)
+}
+
+func (r *SMTPNotifier) send(ctx context.Context, to []string, msg []byte) error {
+	if len(to) == 0 {
+		return fmt.Errorf("No recipients")
+	}
+
+	addr := net.JoinHostPort(r.Config.Host, strconv.Itoa(r.Config.Port))
+
+	tlsConfig := r.Config.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: r.Config.Host}
+	}
+
+	dialer := &net.Dialer{Timeout: 30 * time.Second}
+
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	if r.Config.TLS == "tls" {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	c, err := smtp.NewClient(conn, r.Config.Host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if r.Config.TLS == "starttls" {
+		if err = c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+
+	if r.Config.Username != "" {
+		auth := smtp.PlainAuth("", r.Config.Username, r.Config.Password, r.Config.Host)
+		if err = c.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	if err = c.Mail(r.Config.From); err != nil {
+		return err
+	}
+
+	for _, rcpt := range to {
+		if err = c.Rcpt(rcpt); err != nil {
+			return err
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+
+	if _, err = w.Write(msg); err != nil {
+		return err
+	}
+
+	if err = w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
@@ -0,0 +1,305 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+	"time"
+)
+
+type message struct {
+	auth string
+	from string
+	to   []string
+	data string
+}
+
+// fakeSMTPServer accepts a single plaintext session per connection and
+// records each delivered message.
+type fakeSMTPServer struct {
+	listener net.Listener
+	messages chan *message
+}
+
+func newFakeSMTPServer(t *testing.T) *fakeSMTPServer {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &fakeSMTPServer{l, make(chan *message, 10)}
+	go s.serve()
+	return s
+}
+
+func (r *fakeSMTPServer) port() int {
+	return r.listener.Addr().(*net.TCPAddr).Port
+}
+
+func (r *fakeSMTPServer) serve() {
+	for {
+		conn, err := r.listener.Accept()
+		if err != nil {
+			return
+		}
+		go r.session(conn)
+	}
+}
+
+func (r *fakeSMTPServer) session(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	reply := func(line string) {
+		fmt.Fprintf(conn, "%s\r\n", line)
+	}
+
+	msg := &message{}
+	reply("220 localhost ESMTP")
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		verb := strings.ToUpper(strings.SplitN(line, " ", 2)[0])
+
+		switch verb {
+		case "EHLO":
+			reply("250-localhost")
+			reply("250 AUTH PLAIN")
+		case "AUTH":
+			auth, _ := base64.StdEncoding.DecodeString(strings.TrimPrefix(line, "AUTH PLAIN "))
+			msg.auth = string(auth)
+			reply("235 Authenticated")
+		case "MAIL":
+			msg.from = strings.Trim(strings.TrimPrefix(line, "MAIL FROM:"), "<>")
+			reply("250 OK")
+		case "RCPT":
+			msg.to = append(msg.to, strings.Trim(strings.TrimPrefix(line, "RCPT TO:"), "<>"))
+			reply("250 OK")
+		case "DATA":
+			reply("354 Go ahead")
+			var data strings.Builder
+			for {
+				line, err = reader.ReadString('\n')
+				if err != nil {
+					return
+				}
+				if line == ".\r\n" {
+					break
+				}
+				data.WriteString(strings.TrimPrefix(line, "."))
+			}
+			msg.data = data.String()
+			r.messages <- msg
+			msg = &message{}
+			reply("250 Queued")
+		case "QUIT":
+			reply("221 Bye")
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func (r *fakeSMTPServer) receive(t *testing.T) *message {
+	select {
+	case msg := <-r.messages:
+		return msg
+	case <-time.After(5 * time.Second):
+		t.Fatal("no message received")
+	}
+	return nil
+}
+
+// parts returns the subject and the decoded bodies of a multipart message
+// keyed by media type.
+func parts(t *testing.T, data string) (string, map[string]string) {
+	msg, err := mail.ReadMessage(strings.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %s", msg.Header.Get("Content-Type"))
+	}
+
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := map[string]string{}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := mr.NextPart()
+		if err != nil {
+			break
+		}
+		partType, _, _ := mime.ParseMediaType(p.Header.Get("Content-Type"))
+		body, err := ioutil.ReadAll(quotedprintable.NewReader(p))
+		if err != nil {
+			t.Fatal(err)
+		}
+		results[partType] = string(body)
+	}
+
+	return subject, results
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	defer server.listener.Close()
+
+	notifier := &SMTPNotifier{&SMTPConfig{
+		Host:     "127.0.0.1",
+		Port:     server.port(),
+		Username: "todo",
+		Password: "secret",
+		From:     "todo@example.com",
+		To:       []string{"alice@example.com", "bob@example.com"},
+		TLS:      "none",
+	}}
+
+	due := time.Date(2019, 11, 4, 12, 0, 0, 0, time.UTC)
+	n := &Notification{Kind: Overdue, TodoID: 7, Description: "Pay rent <now>", Due: &due, State: "todo"}
+
+	if err := notifier.Notify(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := server.receive(t)
+
+	if msg.auth != "\x00todo\x00secret" {
+		t.Errorf("auth = %q", msg.auth)
+	}
+
+	if msg.from != "todo@example.com" || strings.Join(msg.to, ",") != "alice@example.com,bob@example.com" {
+		t.Errorf("envelope = %s -> %v", msg.from, msg.to)
+	}
+
+	subject, bodies := parts(t, msg.data)
+
+	if subject != "Overdue: Pay rent <now>" {
+		t.Errorf("subject = %q", subject)
+	}
+
+	if text := bodies["text/plain"]; !strings.Contains(text, "#7 Pay rent <now>") || !strings.Contains(text, "Mon, 04 Nov 2019 12:00 UTC") {
+		t.Errorf("text = %q", text)
+	}
+
+	if html := bodies["text/html"]; !strings.Contains(html, "#7 Pay rent &lt;now&gt;") {
+		t.Errorf("html = %q", html)
+	}
+}
+
+func TestSMTPNotifierHeaderInjection(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	defer server.listener.Close()
+
+	notifier := &SMTPNotifier{&SMTPConfig{
+		Host: "127.0.0.1",
+		Port: server.port(),
+		From: "todo@example.com",
+		To:   []string{"alice@example.com"},
+		TLS:  "none",
+	}}
+
+	n := &Notification{Kind: Changed, TodoID: 8, Description: "x\r\nBcc: mallory@example.com", State: "todo"}
+
+	if err := notifier.Notify(context.Background(), n); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := server.receive(t)
+
+	parsed, err := mail.ReadMessage(strings.NewReader(msg.data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if bcc := parsed.Header.Get("Bcc"); bcc != "" {
+		t.Errorf("Bcc = %q", bcc)
+	}
+
+	if subject, _ := parts(t, msg.data); subject != "Updated: x Bcc: mallory@example.com" {
+		t.Errorf("subject = %q", subject)
+	}
+}
+
+func TestSMTPDigest(t *testing.T) {
+	server := newFakeSMTPServer(t)
+	defer server.listener.Close()
+
+	notifier := &SMTPNotifier{&SMTPConfig{
+		Host: "127.0.0.1",
+		Port: server.port(),
+		From: "todo@example.com",
+		To:   []string{"team@example.com"},
+		TLS:  "none",
+	}}
+
+	day := time.Date(2019, 11, 4, 0, 0, 0, 0, time.UTC)
+	overdue := day.Add(-time.Hour)
+	d := &Digest{
+		To:   []string{"carol@example.com"},
+		Date: day,
+		Overdue: []*Notification{
+			{Kind: Overdue, TodoID: 1, Description: "Écrire le rapport", Due: &overdue, State: "in_progress"},
+		},
+		DueToday: []*Notification{},
+	}
+
+	if err := notifier.SendDigest(context.Background(), d); err != nil {
+		t.Fatal(err)
+	}
+
+	msg := server.receive(t)
+
+	if msg.auth != "" || strings.Join(msg.to, ",") != "carol@example.com" {
+		t.Errorf("auth = %q, to = %v", msg.auth, msg.to)
+	}
+
+	subject, bodies := parts(t, msg.data)
+
+	if subject != "Todo digest for Mon, 04 Nov 2019" {
+		t.Errorf("subject = %q", subject)
+	}
+
+	text := bodies["text/plain"]
+	if !strings.Contains(text, "Overdue (1)") || !strings.Contains(text, "#1 Écrire le rapport") || !strings.Contains(text, "Nothing due today.") {
+		t.Errorf("text = %q", text)
+	}
+
+	if html := bodies["text/html"]; !strings.Contains(html, "<li>#1 Écrire le rapport") {
+		t.Errorf("html = %q", html)
+	}
+}
+
+func TestSMTPNotifierUnavailable(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := l.Addr().(*net.TCPAddr).Port
+	l.Close()
+
+	notifier := &SMTPNotifier{&SMTPConfig{Host: "127.0.0.1", Port: port, From: "todo@example.com", To: []string{"a@example.com"}, TLS: "none"}}
+
+	if err := notifier.Notify(context.Background(), &Notification{Kind: Reminder}); err == nil {
+		t.Error("expected an error")
+	}
+}
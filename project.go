@@ -0,0 +1,151 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+const projectColumns = "rowid, name, desc, archived"
+
+type Project struct {
+	ID          int64  `db:"id" json:"id"`
+	Name        string `db:"name" json:"name"`
+	Description string `db:"desc" json:"desc"`
+	Archived    bool   `db:"archived" json:"archived"`
+}
+
+func (r *Project) Equal(t *Project) bool {
+	return *r == *t
+}
+
+type ProjectList []*Project
+
+type ProjectManager struct {
+	Database *sql.DB
+}
+
+func NewProjectManager(db *sql.DB) *ProjectManager {
+	return &ProjectManager{db}
+}
+
+func scanProject(s scanner) (*Project, error) {
+	p := &Project{}
+	if err := s.Scan(&p.ID, &p.Name, &p.Description, &p.Archived); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (r *ProjectManager) Get(id int64) (*Project, error) {
+	row := r.Database.QueryRow("SELECT "+projectColumns+" FROM project WHERE rowid = ?", id)
+	return scanProject(row)
+}
+
+// GetByName returns the project whose name matches name ignoring case, with
+// spaces in the name matching "-".
+func (r *ProjectManager) GetByName(name string) (*Project, error) {
+	row := r.Database.QueryRow("SELECT "+projectColumns+" FROM project WHERE REPLACE(name, ' ', '-') = ? COLLATE NOCASE ORDER BY rowid LIMIT 1", name)
+	return scanProject(row)
+}
+
+func (r *ProjectManager) List(archived *bool) (ProjectList, error) {
+	var rows *sql.Rows
+	var err error
+
+	q := "SELECT " + projectColumns + " FROM project"
+	values := []interface{}{}
+
+	if archived != nil {
+		q = q + " WHERE archived = ?"
+		values = append(values, *archived)
+	}
+
+	if rows, err = r.Database.Query(q+" ORDER BY rowid;", values...); err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := ProjectList{}
+
+	for rows.Next() {
+		var p *Project
+		if p, err = scanProject(rows); err != nil {
+			return nil, err
+		}
+		results = append(results, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (r *ProjectManager) Create(data map[string]interface{}) (*Project, error) {
+	var result sql.Result
+	var id int64
+	var err error
+
+	insert := TodoMap(data).InsertVars()
+	q := fmt.Sprintf("INSERT INTO project(%s) VALUES(%s)", insert.Names, insert.Bindvars)
+
+	if result, err = r.Database.Exec(q, insert.Values...); err != nil {
+		return nil, err
+	}
+
+	if id, err = result.LastInsertId(); err != nil {
+		return nil, err
+	}
+
+	return r.Get(id)
+}
+
+func (r *ProjectManager) Update(id int64, data map[string]interface{}) (*Project, error) {
+	if len(data) == 0 {
+		return r.Get(id)
+	}
+
+	update := TodoMap(data).UpdateVars()
+	q := fmt.Sprintf("UPDATE project SET %s WHERE rowid = ?;", update.Bindvars)
+
+	if _, err := r.Database.Exec(q, append(update.Values, id)...); err != nil {
+		return nil, err
+	}
+
+	return r.Get(id)
+}
+
+// Delete removes a project and moves its todos to the project identified by
+// moveTo, or detaches them from any project when moveTo is nil.
+func (r *ProjectManager) Delete(id int64, moveTo *int64) error {
+	return r.delete(id, "UPDATE todo SET project_id = ? WHERE project_id = ?;", moveTo, id)
+}
+
+// DeleteArchive removes a project, detaching and archiving its todos.
+func (r *ProjectManager) DeleteArchive(id int64) error {
+	return r.delete(id, "UPDATE todo SET project_id = NULL, archived = 1 WHERE project_id = ?;", id)
+}
+
+// delete runs the update of the project's todos in q and removes the project
+// in one transaction.
+func (r *ProjectManager) delete(id int64, q string, args ...interface{}) error {
+	var tx *sql.Tx
+	var err error
+
+	if tx, err = r.Database.Begin(); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec(q, args...); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec("DELETE FROM project WHERE rowid = ?;", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/apierror"
+	"github.com/marcgwilson/todo/query"
+
+	"net/http"
+	"strconv"
+)
+
+func (r *Handler) ProjectListFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		var archived *bool
+
+		if value := req.URL.Query().Get("archived"); value != "" {
+			if b, err := strconv.ParseBool(value); err != nil {
+				writeError(w, &apierror.Error{
+					Code:    http.StatusBadRequest,
+					Message: "Invalid query parameters",
+					Errors: []*apierror.ErrorDetail{
+						&apierror.ErrorDetail{Key: "archived", Value: value, Message: "value must be a boolean"},
+					},
+				})
+				return
+			} else {
+				archived = &b
+			}
+		}
+
+		if list, err := r.PM.List(archived); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+		} else {
+			writeJSON(w, http.StatusOK, list)
+		}
+	}
+}
+
+func (r *Handler) ProjectCreateFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		var data TodoMap
+		var ae *apierror.Error
+
+		if data, ae = UnmarshalJSONRequest(req); ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		if ae = validate(r.ProjectCreateValidator, data); ae != nil {
+			writeError(w, ae)
+		} else if p, err := r.PM.Create(data); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusBadRequest, Message: err.Error()})
+		} else {
+			writeJSON(w, http.StatusCreated, p)
+		}
+	}
+}
+
+func (r *Handler) ProjectRetrieveFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if p, err := r.PM.Get(pathID(req, "id")); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+		} else {
+			writeJSON(w, http.StatusOK, p)
+		}
+	}
+}
+
+func (r *Handler) ProjectUpdateFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		id := pathID(req, "id")
+
+		var data TodoMap
+		var ae *apierror.Error
+
+		if _, err := r.PM.Get(id); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+			return
+		}
+
+		if data, ae = UnmarshalJSONRequest(req); ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		if ae = validate(r.ProjectUpdateValidator, data); ae != nil {
+			writeError(w, ae)
+		} else if p, err := r.PM.Update(id, data); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusBadRequest, Message: err.Error()})
+		} else {
+			writeJSON(w, http.StatusOK, p)
+		}
+	}
+}
+
+// ProjectDeleteFunc deletes a project. The todos query parameter selects what
+// happens to the project's todos: "move" (the default) reassigns them to the
+// project given by the to parameter, or detaches them when to is omitted, and
+// "archive" detaches and archives them.
+func (r *Handler) ProjectDeleteFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		id := pathID(req, "id")
+
+		if _, err := r.PM.Get(id); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+			return
+		}
+
+		values := req.URL.Query()
+
+		switch values.Get("todos") {
+		case "archive":
+			if err := r.PM.DeleteArchive(id); err != nil {
+				writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+			} else {
+				w.WriteHeader(http.StatusNoContent)
+			}
+		case "", "move":
+			var moveTo *int64
+
+			if to := values.Get("to"); to != "" {
+				target, err := strconv.ParseInt(to, 10, 64)
+				if err != nil || target == id || !r.activeProject(target) {
+					writeError(w, &apierror.Error{
+						Code:    http.StatusBadRequest,
+						Message: "Invalid query parameters",
+						Errors: []*apierror.ErrorDetail{
+							&apierror.ErrorDetail{Key: "to", Value: to, Message: "value must be the id of another active project"},
+						},
+					})
+					return
+				}
+
+				moveTo = &target
+			}
+
+			if err := r.PM.Delete(id, moveTo); err != nil {
+				writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+			} else {
+				w.WriteHeader(http.StatusNoContent)
+			}
+		default:
+			writeError(w, &apierror.Error{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid query parameters",
+				Errors: []*apierror.ErrorDetail{
+					&apierror.ErrorDetail{Key: "todos", Value: values.Get("todos"), Message: "value must be move or archive"},
+				},
+			})
+		}
+	}
+}
+
+func (r *Handler) activeProject(id int64) bool {
+	p, err := r.PM.Get(id)
+	return err == nil && !p.Archived
+}
+
+func (r *Handler) ProjectTodosFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		id := pathID(req, "id")
+
+		if _, err := r.PM.Get(id); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+			return
+		}
+
+		if result, err := r.parseQuery(req); err != nil {
+			writeError(w, err)
+		} else {
+			r.writeList(w, req, result.Set("project", query.NewIDQueryParam("project_id", id)))
+		}
+	}
+}
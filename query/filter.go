@@ -0,0 +1,526 @@
+package query
+
+import (
+	"github.com/marcgwilson/todo/apierror"
+	"github.com/marcgwilson/todo/state"
+
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// The filter query parameter takes a boolean expression over todo attributes,
+// e.g. state = "todo" and (due < now() or priority >= 3). Expressions are
+// parsed into a tree, checked against filterFields and compiled to SQL with
+// every value bound as a parameter.
+//
+//	expr       = and { "or" and }
+//	and        = unary { "and" unary }
+//	unary      = "not" unary | "(" expr ")" | comparison
+//	comparison = field op value | field "in" "(" value { "," value } ")"
+//	           | field "contains" string | field "is" [ "not" ] "null"
+//	op         = "=" | "!=" | "<" | "<=" | ">" | ">="
+//	value      = string | integer | "now()"
+//
+// Datetimes are strings holding RFC-3339 or relative dates, see
+// ParseRelativeTime.
+
+const (
+	maxFilterLength = 1024
+	maxFilterDepth  = 32
+)
+
+type filterKind int
+
+const (
+	filterInt filterKind = iota
+	filterText
+	filterTime
+	filterState
+	filterPriority
+)
+
+type filterField struct {
+	column string
+	kind   filterKind
+}
+
+var filterFields = map[string]*filterField{
+	"id":        {"rowid", filterInt},
+	"desc":      {`"desc"`, filterText},
+	"due":       {"due", filterTime},
+	"state":     {"state", filterState},
+	"priority":  {"priority", filterPriority},
+	"project":   {"project_id", filterInt},
+	"parent":    {"parent_id", filterInt},
+	"assignee":  {"assignee_id", filterInt},
+	"estimate":  {"estimate", filterInt},
+	"created":   {"created", filterTime},
+	"completed": {"completed", filterTime},
+}
+
+// FilterError reports an invalid filter expression and the byte offset at
+// which it was detected.
+type FilterError struct {
+	Pos     int
+	Message string
+}
+
+func (r *FilterError) Error() string {
+	return fmt.Sprintf("position %d: %s", r.Pos, r.Message)
+}
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenString
+	tokenNumber
+	tokenOperator
+	tokenLParen
+	tokenRParen
+	tokenComma
+)
+
+type token struct {
+	kind tokenKind
+	text string
+	pos  int
+}
+
+func (r token) String() string {
+	if r.kind == tokenEOF {
+		return "end of input"
+	}
+	return strconv.Quote(r.text)
+}
+
+func lexFilter(s string) ([]token, error) {
+	tokens := []token{}
+
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case c == '(':
+			tokens = append(tokens, token{tokenLParen, "(", i})
+			i++
+		case c == ')':
+			tokens = append(tokens, token{tokenRParen, ")", i})
+			i++
+		case c == ',':
+			tokens = append(tokens, token{tokenComma, ",", i})
+			i++
+		case strings.ContainsRune("=!<>", c):
+			op := string(c)
+			if i+1 < len(s) && s[i+1] == '=' {
+				op += "="
+			}
+			if op == "!" {
+				return nil, &FilterError{i, "expected !="}
+			}
+			tokens = append(tokens, token{tokenOperator, op, i})
+			i += len(op)
+		case c == '"':
+			var b strings.Builder
+			start := i
+			i++
+			for {
+				if i >= len(s) {
+					return nil, &FilterError{start, "unterminated string"}
+				}
+				if s[i] == '\\' && i+1 < len(s) {
+					b.WriteByte(s[i+1])
+					i += 2
+					continue
+				}
+				if s[i] == '"' {
+					i++
+					break
+				}
+				b.WriteByte(s[i])
+				i++
+			}
+			tokens = append(tokens, token{tokenString, b.String(), start})
+		case c == '-' || isDigit(s[i]):
+			start := i
+			i++
+			for i < len(s) && isDigit(s[i]) {
+				i++
+			}
+			tokens = append(tokens, token{tokenNumber, s[start:i], start})
+		case isLetter(s[i]):
+			start := i
+			for i < len(s) && (isLetter(s[i]) || isDigit(s[i])) {
+				i++
+			}
+			tokens = append(tokens, token{tokenIdent, s[start:i], start})
+		default:
+			return nil, &FilterError{i, fmt.Sprintf("unexpected character %q", c)}
+		}
+	}
+
+	return append(tokens, token{tokenEOF, "", len(s)}), nil
+}
+
+func isDigit(c byte) bool {
+	return '0' <= c && c <= '9'
+}
+
+func isLetter(c byte) bool {
+	return c == '_' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
+
+type filterNode interface {
+	sql() (string, []interface{})
+}
+
+type filterLogical struct {
+	op    string
+	left  filterNode
+	right filterNode
+}
+
+func (r *filterLogical) sql() (string, []interface{}) {
+	left, lv := r.left.sql()
+	right, rv := r.right.sql()
+	return "(" + left + " " + r.op + " " + right + ")", append(lv, rv...)
+}
+
+type filterNot struct {
+	node filterNode
+}
+
+func (r *filterNot) sql() (string, []interface{}) {
+	s, values := r.node.sql()
+	return "NOT " + s, values
+}
+
+type filterComparison struct {
+	field  *filterField
+	op     string
+	values []interface{}
+}
+
+func (r *filterComparison) sql() (string, []interface{}) {
+	switch r.op {
+	case "IS NULL", "IS NOT NULL":
+		return "(" + r.field.column + " " + r.op + ")", []interface{}{}
+	case "IN":
+		bindvars := make([]string, len(r.values), len(r.values))
+		for i := range r.values {
+			bindvars[i] = "?"
+		}
+		return "(" + r.field.column + " IN (" + strings.Join(bindvars, ", ") + "))", r.values
+	case "LIKE":
+		return "(" + r.field.column + ` LIKE ? ESCAPE '\')`, r.values
+	default:
+		return "(" + r.field.column + " " + r.op + " ?)", r.values
+	}
+}
+
+type filterParser struct {
+	tokens []token
+	pos    int
+	depth  int
+	now    time.Time
+}
+
+func (r *filterParser) peek() token {
+	return r.tokens[r.pos]
+}
+
+func (r *filterParser) next() token {
+	t := r.tokens[r.pos]
+	if t.kind != tokenEOF {
+		r.pos++
+	}
+	return t
+}
+
+// keyword consumes the next token if it is the case-insensitive keyword kw.
+func (r *filterParser) keyword(kw string) bool {
+	if t := r.peek(); t.kind == tokenIdent && strings.EqualFold(t.text, kw) {
+		r.pos++
+		return true
+	}
+	return false
+}
+
+func (r *filterParser) expect(kind tokenKind, text string) error {
+	if t := r.next(); t.kind != kind {
+		return &FilterError{t.pos, fmt.Sprintf("expected %s, found %s", text, t)}
+	}
+	return nil
+}
+
+func (r *filterParser) parseOr() (filterNode, error) {
+	left, err := r.parseAnd()
+	if err != nil {
+		return nil, err
+	}
+
+	for r.keyword("or") {
+		right, err := r.parseAnd()
+		if err != nil {
+			return nil, err
+		}
+		left = &filterLogical{"OR", left, right}
+	}
+
+	return left, nil
+}
+
+func (r *filterParser) parseAnd() (filterNode, error) {
+	left, err := r.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+
+	for r.keyword("and") {
+		right, err := r.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		left = &filterLogical{"AND", left, right}
+	}
+
+	return left, nil
+}
+
+func (r *filterParser) parseUnary() (filterNode, error) {
+	if r.depth++; r.depth > maxFilterDepth {
+		return nil, &FilterError{r.peek().pos, "expression is nested too deeply"}
+	}
+	defer func() { r.depth-- }()
+
+	if r.keyword("not") {
+		node, err := r.parseUnary()
+		if err != nil {
+			return nil, err
+		}
+		return &filterNot{node}, nil
+	}
+
+	if r.peek().kind == tokenLParen {
+		r.next()
+		node, err := r.parseOr()
+		if err != nil {
+			return nil, err
+		}
+		if err = r.expect(tokenRParen, `")"`); err != nil {
+			return nil, err
+		}
+		return node, nil
+	}
+
+	return r.parseComparison()
+}
+
+func (r *filterParser) parseComparison() (filterNode, error) {
+	name := r.next()
+	if name.kind != tokenIdent {
+		return nil, &FilterError{name.pos, fmt.Sprintf("expected a field, found %s", name)}
+	}
+
+	field, ok := filterFields[strings.ToLower(name.text)]
+	if !ok {
+		return nil, &FilterError{name.pos, fmt.Sprintf("unknown field %s", name)}
+	}
+
+	if r.keyword("is") {
+		op := "IS NULL"
+		if r.keyword("not") {
+			op = "IS NOT NULL"
+		}
+		if !r.keyword("null") {
+			t := r.peek()
+			return nil, &FilterError{t.pos, fmt.Sprintf("expected null, found %s", t)}
+		}
+		return &filterComparison{field, op, nil}, nil
+	}
+
+	if t := r.peek(); r.keyword("contains") {
+		if field.kind != filterText {
+			return nil, &FilterError{t.pos, fmt.Sprintf("contains cannot be applied to %s", name.text)}
+		}
+		value := r.next()
+		if value.kind != tokenString {
+			return nil, &FilterError{value.pos, fmt.Sprintf("expected a string, found %s", value)}
+		}
+		escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(value.text)
+		return &filterComparison{field, "LIKE", []interface{}{"%" + escaped + "%"}}, nil
+	}
+
+	if r.keyword("in") {
+		if err := r.expect(tokenLParen, `"("`); err != nil {
+			return nil, err
+		}
+
+		values := []interface{}{}
+		for {
+			value, err := r.parseValue(field)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, value)
+
+			if r.peek().kind != tokenComma {
+				break
+			}
+			r.next()
+		}
+
+		if err := r.expect(tokenRParen, `")"`); err != nil {
+			return nil, err
+		}
+		return &filterComparison{field, "IN", values}, nil
+	}
+
+	op := r.next()
+	if op.kind != tokenOperator {
+		return nil, &FilterError{op.pos, fmt.Sprintf("expected an operator, found %s", op)}
+	}
+
+	if op.text != "=" && op.text != "!=" && (field.kind == filterText || field.kind == filterState) {
+		return nil, &FilterError{op.pos, fmt.Sprintf("%s cannot be applied to %s", op.text, name.text)}
+	}
+
+	value, err := r.parseValue(field)
+	if err != nil {
+		return nil, err
+	}
+
+	return &filterComparison{field, op.text, []interface{}{value}}, nil
+}
+
+func (r *filterParser) parseValue(field *filterField) (interface{}, error) {
+	t := r.next()
+
+	switch field.kind {
+	case filterInt, filterPriority:
+		if t.kind != tokenNumber {
+			return nil, &FilterError{t.pos, fmt.Sprintf("expected an integer, found %s", t)}
+		}
+		i, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			return nil, &FilterError{t.pos, fmt.Sprintf("invalid integer %s", t)}
+		}
+		if field.kind == filterPriority && (i < 0 || i > 4) {
+			return nil, &FilterError{t.pos, "priority must be between 0 and 4"}
+		}
+		return i, nil
+	case filterState:
+		if t.kind == tokenString {
+			if s, ok := state.States[t.text]; ok {
+				return s, nil
+			}
+		}
+		return nil, &FilterError{t.pos, fmt.Sprintf("expected a state, found %s", t)}
+	case filterTime:
+		if t.kind == tokenIdent && strings.EqualFold(t.text, "now") {
+			if err := r.expect(tokenLParen, `"("`); err != nil {
+				return nil, err
+			}
+			if err := r.expect(tokenRParen, `")"`); err != nil {
+				return nil, err
+			}
+			return Timestamp(r.now), nil
+		}
+		if t.kind == tokenString {
+			v, err := ParseRelativeTime(t.text, r.now)
+			if err != nil {
+				return nil, &FilterError{t.pos, err.Error()}
+			}
+			return Timestamp(v), nil
+		}
+		return nil, &FilterError{t.pos, fmt.Sprintf("expected a datetime string or now(), found %s", t)}
+	default:
+		if t.kind != tokenString {
+			return nil, &FilterError{t.pos, fmt.Sprintf("expected a string, found %s", t)}
+		}
+		return t.text, nil
+	}
+}
+
+// FilterQueryParam is a compiled filter expression.
+type FilterQueryParam struct {
+	name   string
+	values []interface{}
+}
+
+func (r *FilterQueryParam) Name() string {
+	return r.name
+}
+
+func (r *FilterQueryParam) Values() []interface{} {
+	return r.values
+}
+
+// ParseFilter compiles the filter expression s. now() evaluates to now and
+// relative dates are evaluated in its location.
+func ParseFilter(s string, now time.Time) (*FilterQueryParam, error) {
+	if len(s) > maxFilterLength {
+		return nil, &FilterError{maxFilterLength, fmt.Sprintf("expression is longer than %d characters", maxFilterLength)}
+	}
+
+	tokens, err := lexFilter(s)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &filterParser{tokens: tokens, now: now}
+
+	if p.peek().kind == tokenEOF {
+		return nil, &FilterError{0, "expression is empty"}
+	}
+
+	node, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+
+	if t := p.peek(); t.kind != tokenEOF {
+		return nil, &FilterError{t.pos, fmt.Sprintf("unexpected %s", t)}
+	}
+
+	name, values := node.sql()
+	return &FilterQueryParam{name, values}, nil
+}
+
+// FilterParser parses filter expressions. Several expressions must all match.
+// Relative dates are evaluated in loc.
+func FilterParser(param string, loc *time.Location) ParamListParser {
+	return func(values []string) (IQueryParam, *apierror.Error) {
+		var ae *apierror.Error
+		names := []string{}
+		results := []interface{}{}
+		errors := []*apierror.ErrorDetail{}
+		now := time.Now().In(loc)
+
+		for _, value := range values {
+			if f, err := ParseFilter(value, now); err != nil {
+				errors = append(errors, &apierror.ErrorDetail{Key: param, Value: value, Message: err.Error()})
+			} else {
+				names = append(names, f.name)
+				results = append(results, f.values...)
+			}
+		}
+
+		if len(errors) > 0 {
+			ae = &apierror.Error{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid query parameters",
+				Errors:  errors,
+			}
+		}
+
+		return &FilterQueryParam{strings.Join(names, " AND "), results}, ae
+	}
+}
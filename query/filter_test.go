@@ -0,0 +1,88 @@
+package query
+
+import (
+	"github.com/davecgh/go-spew/spew"
+
+	"github.com/marcgwilson/todo/state"
+
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseFilter(t *testing.T) {
+	now := time.Date(2019, 11, 4, 12, 0, 0, 0, time.UTC)
+	due := time.Date(2019, 11, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		filter string
+		name   string
+		values []interface{}
+	}{
+		{
+			`state = "todo" and (due < now() or priority >= 3)`,
+			`((state = ?) AND ((due < ?) OR (priority >= ?)))`,
+			[]interface{}{state.Todo, Timestamp(now), int64(3)},
+		},
+		{
+			`not state in ("done", "in_progress") OR project is null`,
+			`(NOT (state IN (?, ?)) OR (project_id IS NULL))`,
+			[]interface{}{state.Done, state.InProgress},
+		},
+		{
+			`desc contains "50%_off" and due >= "2019-11-01T00:00:00Z" and assignee is not null`,
+			`(((` + `"desc"` + ` LIKE ? ESCAPE '\') AND (due >= ?)) AND (assignee_id IS NOT NULL))`,
+			[]interface{}{`%50\%\_off%`, Timestamp(due)},
+		},
+		{
+			`desc = "Robert\"); DROP TABLE todo; --"`,
+			`("desc" = ?)`,
+			[]interface{}{`Robert"); DROP TABLE todo; --`},
+		},
+	}
+
+	for _, test := range tests {
+		f, err := ParseFilter(test.filter, now)
+		if err != nil {
+			t.Errorf("%s: %s", test.filter, err)
+			continue
+		}
+
+		if f.Name() != test.name {
+			t.Errorf("%s: %s != %s", test.filter, f.Name(), test.name)
+		}
+
+		if !reflect.DeepEqual(f.Values(), test.values) {
+			t.Errorf("%s: %s != %s", test.filter, spew.Sdump(f.Values()), spew.Sdump(test.values))
+		}
+	}
+}
+
+func TestParseFilterErrors(t *testing.T) {
+	tests := map[string]string{
+		``:                        "position 0: expression is empty",
+		`color = "red"`:           `position 0: unknown field "color"`,
+		`state = "closed"`:        `position 8: expected a state, found "closed"`,
+		`priority > 7`:            "position 11: priority must be between 0 and 4",
+		`state < "todo"`:          `position 6: < cannot be applied to state`,
+		`due < "tomorrow"`:        `position 6: unknown date "tomorrow", expected now, today, startOf(...) or endOf(...)`,
+		`(state = "todo"`:         "position 15: expected \")\", found end of input",
+		`state = "todo" priority`: `position 15: unexpected "priority"`,
+		`desc = "open`:            "position 7: unterminated string",
+		`priority ! 3`:            "position 9: expected !=",
+		`priority contains "3"`:   "position 9: contains cannot be applied to priority",
+		strings.Repeat("not ", 40) + `state = "todo"`: "position 128: expression is nested too deeply",
+	}
+
+	for filter, expected := range tests {
+		if _, err := ParseFilter(filter, time.Now()); err == nil || err.Error() != expected {
+			t.Errorf("%q: %v != %s", filter, err, expected)
+		}
+	}
+
+	if _, ae := ParseValues(url.Values{"filter": {`state = "todo"`, `priority = x`}}); ae == nil || len(ae.Errors) != 1 || ae.Errors[0].Key != "filter" {
+		t.Errorf("ae = %s", spew.Sdump(ae))
+	}
+}
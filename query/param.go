@@ -12,20 +12,45 @@ import (
 )
 
 var (
-	TimeErrorMessage  = "value must be in RFC-3339 format"
-	PageErrorMessage  = "value must be an integer greater than 0"
-	CountErrorMessage = "value must be an integer greater than 0"
+	TimeErrorMessage     = "value must be an RFC-3339 datetime or a relative date such as now-1d, today or startOf(week)"
+	TZErrorMessage       = "value must be an IANA time zone such as Europe/Paris"
+	PageErrorMessage     = "value must be an integer greater than 0"
+	CountErrorMessage    = "value must be an integer greater than 0"
+	IDErrorMessage       = "value must be an integer greater than 0"
+	BoolErrorMessage     = "value must be true or false"
+	PriorityErrorMessage = "value must be an integer between 0 and 4"
+	SortErrorMessage     = "value must be a comma separated list of id, desc, due, state, priority, optionally prefixed with -"
+	OperatorErrorMessage = "operator must be one of gt, gte, lt, lte"
+	GroupErrorMessage    = "value must be one of " + strings.Join(GroupNames(), ", ")
+	UndatedErrorMessage  = "value must be first or last"
 )
 
 var parserMap = map[string]ParamListParser{
-	"due:gt":  DueDateParser("due >"),
-	"due:lt":  DueDateParser("due <"),
-	"due:gte": DueDateParser("due >="),
-	"due:lte": DueDateParser("due <="),
-	"due":     DueDateParser("due ="),
-	"state":   StateParser("state"),
-	"page":    PageParser("page"),
-	"count":   CountParser("count"),
+	"due:gt":       DueDateParser("due:gt", "due >", time.UTC),
+	"due:lt":       DueDateParser("due:lt", "due <", time.UTC),
+	"due:gte":      DueDateParser("due:gte", "due >=", time.UTC),
+	"due:lte":      DueDateParser("due:lte", "due <=", time.UTC),
+	"due":          DueDateParser("due", "due =", time.UTC),
+	"due:isnull":   IsNullParser("due:isnull", "due"),
+	"state":        StateParser("state"),
+	"project":      IDParser("project", "project_id"),
+	"parent":       IDParser("parent", "parent_id"),
+	"root":         IsNullParser("root", "parent_id"),
+	"blocked":      BlockedParser("blocked"),
+	"archived":     BoolParser("archived", "archived"),
+	"assignee":     IDParser("assignee", "assignee_id"),
+	"unassigned":   IsNullParser("unassigned", "assignee_id"),
+	"priority":     PriorityParser("priority", ""),
+	"priority:gt":  PriorityParser("priority:gt", ">"),
+	"priority:gte": PriorityParser("priority:gte", ">="),
+	"priority:lt":  PriorityParser("priority:lt", "<"),
+	"priority:lte": PriorityParser("priority:lte", "<="),
+	"sort":         SortParser("sort"),
+	"group_by":     GroupParser("group_by"),
+	"undated":      UndatedParser("undated"),
+	"filter":       FilterParser("filter", time.UTC),
+	"page":         PageParser("page"),
+	"count":        CountParser("count"),
 }
 
 type IQueryParam interface {
@@ -52,17 +77,20 @@ func (r *DueDateQueryParam) Values() []interface{} {
 	return r.values
 }
 
-func DueDateParser(param string) ParamListParser {
+// DueDateParser parses due filters compared with op, e.g. "due >". Values are
+// RFC-3339 datetimes or relative dates evaluated in loc.
+func DueDateParser(param string, op string, loc *time.Location) ParamListParser {
 	return func(values []string) (IQueryParam, *apierror.Error) {
 		var ae *apierror.Error
 		results := []interface{}{}
 		errors := []*apierror.ErrorDetail{}
+		now := time.Now().In(loc)
 
 		for _, value := range values {
-			if t, err := time.Parse(time.RFC3339Nano, value); err != nil {
-				errors = append(errors, &apierror.ErrorDetail{Key: param, Value: value, Message: TimeErrorMessage})
+			if t, err := ParseRelativeTime(value, now); err != nil {
+				errors = append(errors, &apierror.ErrorDetail{Key: param, Value: value, Message: TimeErrorMessage + ": " + err.Error()})
 			} else {
-				results = append(results, t.UTC()) //t.UnixNano()) //t.Unix())
+				results = append(results, Timestamp(t))
 			}
 		}
 
@@ -73,7 +101,7 @@ func DueDateParser(param string) ParamListParser {
 				Errors:  errors,
 			}
 		}
-		return &DueDateQueryParam{param, results}, ae
+		return &DueDateQueryParam{op, results}, ae
 	}
 }
 
@@ -121,6 +149,496 @@ func StateParser(param string) ParamListParser {
 	}
 }
 
+type IDQueryParam struct {
+	name   string
+	values []interface{}
+}
+
+func NewIDQueryParam(name string, ids ...int64) *IDQueryParam {
+	values := make([]interface{}, len(ids), len(ids))
+	for i, id := range ids {
+		values[i] = id
+	}
+	return &IDQueryParam{name, values}
+}
+
+func (r *IDQueryParam) Name() string {
+	results := make([]string, len(r.values), len(r.values))
+	for i := 0; i < len(r.values); i++ {
+		results[i] = "?"
+	}
+
+	return fmt.Sprintf("%s IN (%s)", r.name, strings.Join(results, ", "))
+}
+
+func (r *IDQueryParam) Values() []interface{} {
+	return r.values
+}
+
+func IDParser(param string, column string) ParamListParser {
+	return func(values []string) (IQueryParam, *apierror.Error) {
+		var ae *apierror.Error
+		results := []interface{}{}
+		errors := []*apierror.ErrorDetail{}
+
+		for _, value := range values {
+			if result, err := strconv.ParseInt(value, 10, 64); err != nil || result < 1 {
+				errors = append(errors, &apierror.ErrorDetail{Key: param, Value: value, Message: IDErrorMessage})
+			} else {
+				results = append(results, result)
+			}
+		}
+
+		if len(errors) > 0 {
+			ae = &apierror.Error{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid query parameters",
+				Errors:  errors,
+			}
+		}
+
+		return &IDQueryParam{column, results}, ae
+	}
+}
+
+type IsNullQueryParam struct {
+	name   string
+	isNull bool
+}
+
+func (r *IsNullQueryParam) Name() string {
+	if r.isNull {
+		return fmt.Sprintf("%s IS NULL", r.name)
+	}
+	return fmt.Sprintf("%s IS NOT NULL", r.name)
+}
+
+func (r *IsNullQueryParam) Values() []interface{} {
+	return []interface{}{}
+}
+
+// IsNullParser matches rows where column is NULL for a true value and rows
+// where it is not NULL for a false value.
+func IsNullParser(param string, column string) ParamListParser {
+	return func(values []string) (IQueryParam, *apierror.Error) {
+		var ae *apierror.Error
+
+		result, err := strconv.ParseBool(values[0])
+		if err != nil {
+			ae = &apierror.Error{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid query parameters",
+				Errors: []*apierror.ErrorDetail{
+					&apierror.ErrorDetail{Key: param, Value: values[0], Message: BoolErrorMessage},
+				},
+			}
+		}
+
+		return &IsNullQueryParam{column, result}, ae
+	}
+}
+
+// BoolParser matches rows where the boolean column equals the value.
+func BoolParser(param string, column string) ParamListParser {
+	return func(values []string) (IQueryParam, *apierror.Error) {
+		var ae *apierror.Error
+
+		result, err := strconv.ParseBool(values[0])
+		if err != nil {
+			ae = &apierror.Error{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid query parameters",
+				Errors: []*apierror.ErrorDetail{
+					&apierror.ErrorDetail{Key: param, Value: values[0], Message: BoolErrorMessage},
+				},
+			}
+		}
+
+		return &ComparisonQueryParam{column + " =", []interface{}{result}}, ae
+	}
+}
+
+const blockedQuery = `rowid %s (SELECT d.todo_id FROM dependency d JOIN todo b ON b.rowid = d.blocker_id WHERE b.state != ?)`
+
+type BlockedQueryParam struct {
+	blocked bool
+}
+
+func (r *BlockedQueryParam) Name() string {
+	if r.blocked {
+		return fmt.Sprintf(blockedQuery, "IN")
+	}
+	return fmt.Sprintf(blockedQuery, "NOT IN")
+}
+
+func (r *BlockedQueryParam) Values() []interface{} {
+	return []interface{}{state.Done}
+}
+
+// BlockedParser matches todos with at least one blocker that is not done for a
+// true value and todos without open blockers for a false value.
+func BlockedParser(param string) ParamListParser {
+	return func(values []string) (IQueryParam, *apierror.Error) {
+		var ae *apierror.Error
+
+		result, err := strconv.ParseBool(values[0])
+		if err != nil {
+			ae = &apierror.Error{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid query parameters",
+				Errors: []*apierror.ErrorDetail{
+					&apierror.ErrorDetail{Key: param, Value: values[0], Message: BoolErrorMessage},
+				},
+			}
+		}
+
+		return &BlockedQueryParam{result}, ae
+	}
+}
+
+// InQueryParam matches rows where column equals any of values.
+type InQueryParam struct {
+	name   string
+	values []interface{}
+}
+
+func (r *InQueryParam) Name() string {
+	results := make([]string, len(r.values), len(r.values))
+	for i := 0; i < len(r.values); i++ {
+		results[i] = "?"
+	}
+
+	return fmt.Sprintf("%s IN (%s)", r.name, strings.Join(results, ", "))
+}
+
+func (r *InQueryParam) Values() []interface{} {
+	return r.values
+}
+
+// ComparisonQueryParam compares a column against every value with the
+// operator included in name, e.g. "priority >=".
+type ComparisonQueryParam struct {
+	name   string
+	values []interface{}
+}
+
+func (r *ComparisonQueryParam) Name() string {
+	results := make([]string, len(r.values), len(r.values))
+	for i := 0; i < len(r.values); i++ {
+		results[i] = fmt.Sprintf("%s ?", r.name)
+	}
+	return strings.Join(results, " AND ")
+}
+
+func (r *ComparisonQueryParam) Values() []interface{} {
+	return r.values
+}
+
+// PriorityParser compares priority using op, or matches any of the given
+// priorities when op is empty.
+func PriorityParser(param string, op string) ParamListParser {
+	return func(values []string) (IQueryParam, *apierror.Error) {
+		var ae *apierror.Error
+		results := []interface{}{}
+		errors := []*apierror.ErrorDetail{}
+
+		for _, value := range values {
+			if result, err := strconv.ParseInt(value, 10, 64); err != nil || result < 0 || result > 4 {
+				errors = append(errors, &apierror.ErrorDetail{Key: param, Value: value, Message: PriorityErrorMessage})
+			} else {
+				results = append(results, result)
+			}
+		}
+
+		if len(errors) > 0 {
+			ae = &apierror.Error{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid query parameters",
+				Errors:  errors,
+			}
+		}
+
+		if op == "" {
+			return &InQueryParam{"priority", results}, ae
+		}
+		return &ComparisonQueryParam{"priority " + op, results}, ae
+	}
+}
+
+var sortColumns = map[string]string{
+	"id":       "rowid",
+	"desc":     `"desc"`,
+	"due":      "due",
+	"state":    "state",
+	"priority": "priority",
+}
+
+type SortQueryParam struct {
+	columns []string
+	// undatedFirst orders todos without a due date before the others when
+	// sorting by due. They are last otherwise, in either direction.
+	undatedFirst bool
+}
+
+// NewSortQueryParam orders by columns and then rowid.
+func NewSortQueryParam(columns ...string) *SortQueryParam {
+	return &SortQueryParam{columns: columns}
+}
+
+// Name returns the ORDER BY clause. rowid is always the last sort key so that
+// pages are stable when the requested keys have ties.
+func (r *SortQueryParam) Name() string {
+	columns := []string{}
+	for _, column := range r.columns {
+		if strings.HasPrefix(column, "due ") {
+			columns = append(columns, undatedOrder(r.undatedFirst))
+		}
+		columns = append(columns, column)
+	}
+	return "ORDER BY " + strings.Join(append(columns, "rowid"), ", ")
+}
+
+// undatedOrder returns the sort key that places NULL due dates first or last.
+func undatedOrder(first bool) string {
+	if first {
+		return "due IS NOT NULL"
+	}
+	return "due IS NULL"
+}
+
+func (r *SortQueryParam) Values() []interface{} {
+	return []interface{}{}
+}
+
+// SortParser parses a comma separated list of sort keys. A key prefixed with
+// "-" sorts in descending order.
+func SortParser(param string) ParamListParser {
+	return func(values []string) (IQueryParam, *apierror.Error) {
+		var ae *apierror.Error
+		columns := []string{}
+		errors := []*apierror.ErrorDetail{}
+
+		for _, key := range strings.Split(values[0], ",") {
+			direction := "ASC"
+			if strings.HasPrefix(key, "-") {
+				direction = "DESC"
+				key = key[1:]
+			}
+
+			if column, ok := sortColumns[key]; !ok {
+				errors = append(errors, &apierror.ErrorDetail{Key: param, Value: values[0], Message: SortErrorMessage})
+				break
+			} else {
+				columns = append(columns, column+" "+direction)
+			}
+		}
+
+		if len(errors) > 0 {
+			ae = &apierror.Error{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid query parameters",
+				Errors:  errors,
+			}
+		}
+
+		return &SortQueryParam{columns: columns}, ae
+	}
+}
+
+// GroupQueryParam groups results by one of GroupColumns.
+type GroupQueryParam struct {
+	key  string
+	expr string
+	// undatedFirst orders the group of todos without a due date first when
+	// grouping by due.
+	undatedFirst bool
+}
+
+// Key returns the name of the grouped attribute.
+func (r *GroupQueryParam) Key() string {
+	return r.key
+}
+
+// Expr returns the SQL expression results are grouped by.
+func (r *GroupQueryParam) Expr() string {
+	return r.expr
+}
+
+func (r *GroupQueryParam) Name() string {
+	if strings.HasPrefix(r.key, "due:") {
+		return "GROUP BY " + r.expr + " ORDER BY " + undatedOrder(r.undatedFirst) + ", " + r.expr
+	}
+	return "GROUP BY " + r.expr + " ORDER BY " + r.expr
+}
+
+func (r *GroupQueryParam) Values() []interface{} {
+	return []interface{}{}
+}
+
+func GroupParser(param string) ParamListParser {
+	return func(values []string) (IQueryParam, *apierror.Error) {
+		if expr, ok := GroupColumns[values[0]]; ok {
+			return &GroupQueryParam{key: values[0], expr: expr}, nil
+		}
+
+		return nil, &apierror.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Invalid query parameters",
+			Errors: []*apierror.ErrorDetail{
+				&apierror.ErrorDetail{Key: param, Value: values[0], Message: GroupErrorMessage},
+			},
+		}
+	}
+}
+
+// UndatedQueryParam places todos without a due date first or last when
+// sorting or grouping by due. It adds no condition of its own.
+type UndatedQueryParam struct {
+	first bool
+}
+
+func (r *UndatedQueryParam) Name() string {
+	return ""
+}
+
+func (r *UndatedQueryParam) Values() []interface{} {
+	return []interface{}{}
+}
+
+func UndatedParser(param string) ParamListParser {
+	return func(values []string) (IQueryParam, *apierror.Error) {
+		switch values[0] {
+		case "first":
+			return &UndatedQueryParam{true}, nil
+		case "last":
+			return &UndatedQueryParam{false}, nil
+		}
+
+		return nil, &apierror.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Invalid query parameters",
+			Errors: []*apierror.ErrorDetail{
+				&apierror.ErrorDetail{Key: param, Value: values[0], Message: UndatedErrorMessage},
+			},
+		}
+	}
+}
+
+// GroupKeyQueryParam matches the todos of one group of a GroupQueryParam.
+type GroupKeyQueryParam struct {
+	expr string
+	key  interface{}
+}
+
+// NewGroupKeyQueryParam matches the todos whose value of group is key.
+func NewGroupKeyQueryParam(group *GroupQueryParam, key interface{}) *GroupKeyQueryParam {
+	return &GroupKeyQueryParam{group.expr, key}
+}
+
+func (r *GroupKeyQueryParam) Name() string {
+	if r.key == nil {
+		return r.expr + " IS NULL"
+	}
+	return r.expr + " = ?"
+}
+
+func (r *GroupKeyQueryParam) Values() []interface{} {
+	if r.key == nil {
+		return []interface{}{}
+	}
+	return []interface{}{r.key}
+}
+
+// CustomFieldPrefix starts the keys of custom field filters, e.g.
+// cf.story_points:gte=3.
+const CustomFieldPrefix = "cf."
+
+var customFieldOperators = map[string]string{
+	"":    "=",
+	"gt":  ">",
+	"gte": ">=",
+	"lt":  "<",
+	"lte": "<=",
+}
+
+// customFieldValue casts a bound string to the stored representation of the
+// field's type.
+const customFieldValue = `CASE f.type WHEN 'number' THEN CAST(? AS REAL) WHEN 'bool' THEN (? IN ('true', '1')) ELSE ? END`
+
+// CustomFieldName returns the field name of a custom field filter key.
+func CustomFieldName(key string) (string, bool) {
+	if !strings.HasPrefix(key, CustomFieldPrefix) {
+		return "", false
+	}
+	return strings.SplitN(strings.TrimPrefix(key, CustomFieldPrefix), ":", 2)[0], true
+}
+
+// CustomFieldQueryParam matches todos whose value of the named custom field
+// compares to every value with op, or equals any value for "=".
+type CustomFieldQueryParam struct {
+	field  string
+	op     string
+	values []interface{}
+}
+
+func (r *CustomFieldQueryParam) Name() string {
+	var condition string
+
+	if r.op == "=" {
+		results := make([]string, len(r.values), len(r.values))
+		for i := range r.values {
+			results[i] = customFieldValue
+		}
+		condition = fmt.Sprintf("v.value IN (%s)", strings.Join(results, ", "))
+	} else {
+		results := make([]string, len(r.values), len(r.values))
+		for i := range r.values {
+			results[i] = fmt.Sprintf("v.value %s %s", r.op, customFieldValue)
+		}
+		condition = strings.Join(results, " AND ")
+	}
+
+	return "rowid IN (SELECT v.todo_id FROM field_value v JOIN field f ON f.rowid = v.field_id WHERE f.name = ? AND " + condition + ")"
+}
+
+func (r *CustomFieldQueryParam) Values() []interface{} {
+	results := []interface{}{r.field}
+	for _, v := range r.values {
+		results = append(results, v, v, v)
+	}
+	return results
+}
+
+// CustomFieldParser parses filters keyed cf.<name> or cf.<name>:<op>.
+func CustomFieldParser(param string) ParamListParser {
+	return func(values []string) (IQueryParam, *apierror.Error) {
+		var ae *apierror.Error
+
+		parts := strings.SplitN(strings.TrimPrefix(param, CustomFieldPrefix), ":", 2)
+		if len(parts) == 1 {
+			parts = append(parts, "")
+		}
+
+		op, ok := customFieldOperators[parts[1]]
+		if !ok {
+			ae = &apierror.Error{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid query parameters",
+				Errors: []*apierror.ErrorDetail{
+					&apierror.ErrorDetail{Key: param, Value: values[0], Message: OperatorErrorMessage},
+				},
+			}
+		}
+
+		results := make([]interface{}, len(values), len(values))
+		for i, value := range values {
+			results[i] = value
+		}
+
+		return &CustomFieldQueryParam{parts[0], op, results}, ae
+	}
+}
+
 type PageQueryParam struct {
 	name   string
 	values []interface{}
@@ -8,6 +8,7 @@ import (
 	"net/url"
 	"sort"
 	"strings"
+	"time"
 )
 
 func All() *Query {
@@ -32,10 +33,23 @@ func (r *QueryParams) Limit() *CountQueryParam {
 	return nil
 }
 
+// Group returns the group_by parameter, or nil when results are not grouped.
+func (r *QueryParams) Group() *GroupQueryParam {
+	if val, ok := r.params["group_by"]; ok {
+		return val.(*GroupQueryParam)
+	}
+	return nil
+}
+
 func (r *QueryParams) Params() map[string]IQueryParam {
 	return r.params
 }
 
+func (r *QueryParams) Set(key string, param IQueryParam) *QueryParams {
+	r.params[key] = param
+	return r
+}
+
 func (r *QueryParams) Paginate(count int64) *QueryParams {
 	var limit *CountQueryParam
 
@@ -58,6 +72,14 @@ func (r *QueryParams) Paginate(count int64) *QueryParams {
 	return r
 }
 
+// SetPage selects the page of a query paginated with Paginate.
+func (r *QueryParams) SetPage(page int64) *QueryParams {
+	if offset := r.Offset(); offset != nil {
+		offset.values = []interface{}{page}
+	}
+	return r
+}
+
 func (r *QueryParams) Depaginate() *QueryParams {
 	delete(r.params, "count")
 	delete(r.params, "page")
@@ -94,12 +116,33 @@ func (r *QueryParams) Query() *Query {
 	// 	}
 	// }
 
+	// ORDER QUERY:
+	var order IQueryParam
+
+	first := false
+	if val, ok := r.params["undated"]; ok {
+		first = val.(*UndatedQueryParam).first
+	}
+
+	if val, ok := r.params["sort"]; ok {
+		sorted := *val.(*SortQueryParam)
+		sorted.undatedFirst = first
+		order = &sorted
+	}
+
+	// GROUP QUERY: groups are ordered by their key rather than by sort.
+	if val, ok := r.params["group_by"]; ok {
+		group := *val.(*GroupQueryParam)
+		group.undatedFirst = first
+		order = &group
+	}
+
 	queryFragments := []string{}
 	values := []interface{}{}
 
 	keys := []string{}
 	for k := range r.params {
-		if k != "page" && k != "count" {
+		if k != "page" && k != "count" && k != "sort" && k != "group_by" && k != "undated" {
 			keys = append(keys, k)
 		}
 	}
@@ -117,6 +160,10 @@ func (r *QueryParams) Query() *Query {
 		queryString = fmt.Sprintf(" WHERE %s", queryString)
 	}
 
+	if order != nil {
+		queryString = queryString + " " + order.Name()
+	}
+
 	if limit != nil {
 		queryString = queryString + " " + limit.Name()
 		values = append(values, limit.Values()...)
@@ -145,13 +192,103 @@ func (r *Query) Values() []interface{} {
 	return r.values
 }
 
+// Clause returns the query without its terminating semicolon so that it can be
+// embedded in a larger statement.
+func (r *Query) Clause() string {
+	return strings.TrimSuffix(r.query, ";")
+}
+
+// GroupColumns maps the names of groupable attributes to the SQL expressions
+// they group by. The due buckets start weeks on Monday.
+var GroupColumns = map[string]string{
+	"state":     "state",
+	"priority":  "priority",
+	"project":   "project_id",
+	"assignee":  "assignee_id",
+	"due:day":   "date(due)",
+	"due:week":  "date(due, 'weekday 0', '-6 days')",
+	"due:month": "strftime('%Y-%m', due)",
+}
+
+// GroupNames returns the sorted names of GroupColumns.
+func GroupNames() []string {
+	names := []string{}
+	for name := range GroupColumns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// dueOperators maps the due filters to their comparisons.
+var dueOperators = map[string]string{
+	"due:gt":  "due >",
+	"due:lt":  "due <",
+	"due:gte": "due >=",
+	"due:lte": "due <=",
+	"due":     "due =",
+}
+
+// ParseValues parses the todo filters in query, including custom field
+// filters. Relative dates are evaluated in the time zone named by tz.
 func ParseValues(query url.Values) (*QueryParams, *apierror.Error) {
+	parsers := parserMap
+	copied := false
+
+	set := func(key string, parser ParamListParser) {
+		if !copied {
+			parsers = make(map[string]ParamListParser, len(parserMap)+1)
+			for k, v := range parserMap {
+				parsers[k] = v
+			}
+			copied = true
+		}
+		parsers[key] = parser
+	}
+
+	loc, err := ParseLocation(query.Get("tz"))
+	if err != nil {
+		return &QueryParams{map[string]IQueryParam{}}, &apierror.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Invalid query parameters",
+			Errors: []*apierror.ErrorDetail{
+				&apierror.ErrorDetail{Key: "tz", Value: query.Get("tz"), Message: TZErrorMessage},
+			},
+		}
+	}
+
+	if loc != time.UTC {
+		for key, op := range dueOperators {
+			set(key, DueDateParser(key, op, loc))
+		}
+		set("filter", FilterParser("filter", loc))
+	}
+
+	for key := range query {
+		if _, ok := CustomFieldName(key); ok {
+			set(key, CustomFieldParser(key))
+		}
+	}
+
+	return parseValues(query, parsers)
+}
+
+// ParsePage parses only the page and count parameters, for lists of
+// resources other than todos.
+func ParsePage(query url.Values) (*QueryParams, *apierror.Error) {
+	return parseValues(query, map[string]ParamListParser{
+		"page":  parserMap["page"],
+		"count": parserMap["count"],
+	})
+}
+
+func parseValues(query url.Values, parsers map[string]ParamListParser) (*QueryParams, *apierror.Error) {
 	var ae *apierror.Error
 	queryParams := map[string]IQueryParam{}
 	errors := []*apierror.ErrorDetail{}
 
 	for key, values := range query {
-		if parser, ok := parserMap[key]; ok {
+		if parser, ok := parsers[key]; ok {
 			if parsed, err := parser(values); err != nil {
 				errors = append(errors, err.Errors...)
 			} else {
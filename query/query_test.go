@@ -38,7 +38,7 @@ func TestParseValues(t *testing.T) {
 		t.Errorf("%s != %s", expectedQuery, actualQuery)
 	}
 
-	expectedValues := []interface{}{gt.UTC(), lt.UTC(), state.Todo, int64(20), int64(0)}
+	expectedValues := []interface{}{Timestamp(gt.UTC()), Timestamp(lt.UTC()), state.Todo, int64(20), int64(0)}
 
 	actualValues := q.Values()
 
@@ -54,10 +54,110 @@ func TestParseValues(t *testing.T) {
 		t.Errorf("%s != %s", expectedQuery, actualQuery)
 	}
 
-	expectedValues = []interface{}{gt.UTC(), lt.UTC(), state.Todo}
+	expectedValues = []interface{}{Timestamp(gt.UTC()), Timestamp(lt.UTC()), state.Todo}
 	actualValues = q.Values()
 
 	if !reflect.DeepEqual(expectedValues, actualValues) {
 		t.Errorf("%s != %s", spew.Sdump(expectedValues), spew.Sdump(actualValues))
 	}
+
+	if actualValues = result.Paginate(20).SetPage(3).Query().Values(); !reflect.DeepEqual(actualValues[3:], []interface{}{int64(20), int64(40)}) {
+		t.Errorf("SetPage(3): %s", spew.Sdump(actualValues))
+	}
+}
+
+func TestParseSort(t *testing.T) {
+	values := url.Values{}
+	values.Set("priority:gte", "3")
+	values.Set("sort", "-priority,due")
+	values.Set("page", "2")
+
+	result, ae := ParseValues(values)
+	if ae != nil {
+		t.Fatalf("Unexpected error: %s", spew.Sdump(ae))
+	}
+
+	q := result.Paginate(10).Query()
+	expectedQuery := " WHERE priority >= ? ORDER BY priority DESC, due IS NULL, due ASC, rowid LIMIT ? OFFSET ?;"
+	if actualQuery := q.Query(); expectedQuery != actualQuery {
+		t.Errorf("%s != %s", expectedQuery, actualQuery)
+	}
+
+	expectedValues := []interface{}{int64(3), int64(10), int64(10)}
+	if actualValues := q.Values(); !reflect.DeepEqual(expectedValues, actualValues) {
+		t.Errorf("%s != %s", spew.Sdump(expectedValues), spew.Sdump(actualValues))
+	}
+
+	values.Set("undated", "first")
+	values.Set("due:isnull", "false")
+	if result, ae = ParseValues(values); ae != nil {
+		t.Fatalf("Unexpected error: %s", spew.Sdump(ae))
+	}
+
+	expectedQuery = " WHERE due IS NOT NULL AND priority >= ? ORDER BY priority DESC, due IS NOT NULL, due ASC, rowid;"
+	if actualQuery := result.Depaginate().Query().Query(); expectedQuery != actualQuery {
+		t.Errorf("%s != %s", expectedQuery, actualQuery)
+	}
+
+	for _, invalid := range []url.Values{{"sort": {"-bogus"}}, {"priority": {"5"}}, {"priority:lt": {"x"}}, {"undated": {"middle"}}, {"due:isnull": {"maybe"}}} {
+		if _, ae := ParseValues(invalid); ae == nil {
+			t.Errorf("ParseValues(%v) succeeded", invalid)
+		}
+	}
+}
+
+func TestParseCustomFields(t *testing.T) {
+	values := url.Values{}
+	values.Set("cf.story_points:gte", "3")
+
+	result, ae := ParseValues(values)
+	if ae != nil {
+		t.Fatalf("Unexpected error: %s", spew.Sdump(ae))
+	}
+
+	q := result.Query()
+	expectedQuery := " WHERE rowid IN (SELECT v.todo_id FROM field_value v JOIN field f ON f.rowid = v.field_id WHERE f.name = ? AND v.value >= " + customFieldValue + ");"
+	if actualQuery := q.Query(); expectedQuery != actualQuery {
+		t.Errorf("%s != %s", expectedQuery, actualQuery)
+	}
+
+	expectedValues := []interface{}{"story_points", "3", "3", "3"}
+	if actualValues := q.Values(); !reflect.DeepEqual(expectedValues, actualValues) {
+		t.Errorf("%s != %s", spew.Sdump(expectedValues), spew.Sdump(actualValues))
+	}
+
+	if _, ae := ParseValues(url.Values{"cf.story_points:near": {"3"}}); ae == nil {
+		t.Error("ParseValues(cf.story_points:near) succeeded")
+	}
+}
+
+func TestParseGroup(t *testing.T) {
+	values := url.Values{}
+	values.Set("state", "todo")
+	values.Set("group_by", "due:month")
+	values.Set("sort", "-due")
+
+	result, ae := ParseValues(values)
+	if ae != nil {
+		t.Fatalf("Unexpected error: %s", spew.Sdump(ae))
+	}
+
+	if group := result.Group(); group == nil || group.Key() != "due:month" {
+		t.Fatalf("group = %s", spew.Sdump(group))
+	}
+
+	q := result.Paginate(10).Query()
+	expectedQuery := " WHERE state IN (?) GROUP BY strftime('%Y-%m', due) ORDER BY due IS NULL, strftime('%Y-%m', due) LIMIT ? OFFSET ?;"
+	if actualQuery := q.Query(); expectedQuery != actualQuery {
+		t.Errorf("%s != %s", expectedQuery, actualQuery)
+	}
+
+	key := result.ShallowCopy().Depaginate().Set("group_key", NewGroupKeyQueryParam(result.Group(), "2019-11")).Query()
+	if actualValues := key.Values(); !reflect.DeepEqual([]interface{}{"2019-11", state.Todo}, actualValues) {
+		t.Errorf("values = %s", spew.Sdump(actualValues))
+	}
+
+	if _, ae := ParseValues(url.Values{"group_by": {"desc"}}); ae == nil {
+		t.Error("ParseValues(group_by=desc) succeeded")
+	}
 }
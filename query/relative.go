@@ -0,0 +1,151 @@
+package query
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ParseRelativeTime parses an RFC-3339 datetime or a relative date such as
+// now, today, startOf(week) or endOf(month), optionally followed by offsets
+// such as -1d or +2w. Relative dates are evaluated in the location of now.
+// Weeks start on Monday. A space before an offset is read as "+", which is how
+// an unescaped "+" arrives in a query string.
+func ParseRelativeTime(s string, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t.UTC(), nil
+	}
+
+	s = strings.TrimSpace(s)
+
+	var t time.Time
+	var rest string
+
+	switch {
+	case strings.HasPrefix(s, "now"):
+		t, rest = now, s[len("now"):]
+	case strings.HasPrefix(s, "today"):
+		t, rest = startOf(now, "day"), s[len("today"):]
+	case strings.HasPrefix(s, "startOf(") || strings.HasPrefix(s, "endOf("):
+		end := strings.Index(s, ")")
+		if end < 0 {
+			return t, fmt.Errorf("missing ) in %q", s)
+		}
+
+		open := strings.Index(s, "(")
+		unit := s[open+1 : end]
+		if _, ok := periods[unit]; !ok {
+			return t, fmt.Errorf("unknown period %q, expected day, week, month or year", unit)
+		}
+
+		t, rest = startOf(now, unit), s[end+1:]
+		if strings.HasPrefix(s, "endOf(") {
+			t = periods[unit](t).Add(-time.Nanosecond)
+		}
+	default:
+		return t, fmt.Errorf("unknown date %q, expected now, today, startOf(...) or endOf(...)", s)
+	}
+
+	for rest != "" {
+		sign := 1
+		switch rest[0] {
+		case '+', ' ':
+		case '-':
+			sign = -1
+		default:
+			return t, fmt.Errorf("expected an offset such as -1d, found %q", rest)
+		}
+
+		rest = strings.TrimLeft(rest[1:], " ")
+
+		i := 0
+		for i < len(rest) && isDigit(rest[i]) {
+			i++
+		}
+
+		if i == 0 || i == len(rest) {
+			return t, fmt.Errorf("expected an offset such as -1d, found %q", rest)
+		}
+
+		n, err := strconv.Atoi(rest[:i])
+		if err != nil {
+			return t, fmt.Errorf("invalid offset %q", rest[:i])
+		}
+		n *= sign
+
+		switch rest[i] {
+		case 's':
+			t = t.Add(time.Duration(n) * time.Second)
+		case 'm':
+			t = t.Add(time.Duration(n) * time.Minute)
+		case 'h':
+			t = t.Add(time.Duration(n) * time.Hour)
+		case 'd':
+			t = t.AddDate(0, 0, n)
+		case 'w':
+			t = t.AddDate(0, 0, 7*n)
+		case 'M':
+			t = t.AddDate(0, n, 0)
+		case 'y':
+			t = t.AddDate(n, 0, 0)
+		default:
+			return t, fmt.Errorf("unknown unit %q, expected s, m, h, d, w, M or y", rest[i:i+1])
+		}
+
+		rest = rest[i+1:]
+	}
+
+	return t.UTC(), nil
+}
+
+// periods advance the start of a period to the start of the next one.
+var periods = map[string]func(time.Time) time.Time{
+	"day":   func(t time.Time) time.Time { return t.AddDate(0, 0, 1) },
+	"week":  func(t time.Time) time.Time { return t.AddDate(0, 0, 7) },
+	"month": func(t time.Time) time.Time { return t.AddDate(0, 1, 0) },
+	"year":  func(t time.Time) time.Time { return t.AddDate(1, 0, 0) },
+}
+
+func startOf(t time.Time, period string) time.Time {
+	year, month, day := t.Date()
+
+	switch period {
+	case "week":
+		day -= (int(t.Weekday()) + 6) % 7
+	case "month":
+		day = 1
+	case "year":
+		month, day = time.January, 1
+	}
+
+	return time.Date(year, month, day, 0, 0, 0, 0, t.Location())
+}
+
+// locations caches the *time.Location of each zone name loaded by
+// LoadLocation, as time.LoadLocation reads the zoneinfo file on every call.
+var locations sync.Map
+
+// LoadLocation is time.LoadLocation with its results cached.
+func LoadLocation(name string) (*time.Location, error) {
+	if loc, ok := locations.Load(name); ok {
+		return loc.(*time.Location), nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, err
+	}
+
+	locations.Store(name, loc)
+	return loc, nil
+}
+
+// ParseLocation parses the tz query parameter, defaulting to UTC.
+func ParseLocation(value string) (*time.Location, error) {
+	if value == "" {
+		return time.UTC, nil
+	}
+	return LoadLocation(value)
+}
@@ -0,0 +1,53 @@
+package query
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRelativeTime(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	// Wednesday, three days after the end of daylight saving time.
+	now := time.Date(2019, 11, 6, 15, 30, 0, 0, loc)
+
+	tests := map[string]time.Time{
+		"2019-11-01T12:00:00+01:00": time.Date(2019, 11, 1, 11, 0, 0, 0, time.UTC),
+		"now":                       now.UTC(),
+		"now-1d":                    time.Date(2019, 11, 5, 20, 30, 0, 0, time.UTC),
+		"now+90m":                   time.Date(2019, 11, 6, 22, 0, 0, 0, time.UTC),
+		"today":                     time.Date(2019, 11, 6, 5, 0, 0, 0, time.UTC),
+		"today 2h":                  time.Date(2019, 11, 6, 7, 0, 0, 0, time.UTC),
+		"today-4d":                  time.Date(2019, 11, 2, 4, 0, 0, 0, time.UTC),
+		"startOf(week)":             time.Date(2019, 11, 4, 5, 0, 0, 0, time.UTC),
+		"endOf(day)":                time.Date(2019, 11, 7, 4, 59, 59, 999999999, time.UTC),
+		"endOf(month)":              time.Date(2019, 12, 1, 4, 59, 59, 999999999, time.UTC),
+		"startOf(year)+1M-1w":       time.Date(2019, 1, 25, 5, 0, 0, 0, time.UTC),
+	}
+
+	for value, expected := range tests {
+		if actual, err := ParseRelativeTime(value, now); err != nil {
+			t.Errorf("%s: %s", value, err)
+		} else if !actual.Equal(expected) || actual.Location() != time.UTC {
+			t.Errorf("%s: %s != %s", value, actual, expected)
+		}
+	}
+
+	errors := map[string]string{
+		"yesterday":       `unknown date "yesterday", expected now, today, startOf(...) or endOf(...)`,
+		"now-1q":          `unknown unit "q", expected s, m, h, d, w, M or y`,
+		"now-d":           `expected an offset such as -1d, found "d"`,
+		"now*2":           `expected an offset such as -1d, found "*2"`,
+		"startOf(decade)": `unknown period "decade", expected day, week, month or year`,
+		"endOf(week":      `missing ) in "endOf(week"`,
+	}
+
+	for value, expected := range errors {
+		if _, err := ParseRelativeTime(value, now); err == nil || err.Error() != expected {
+			t.Errorf("%s: %v != %s", value, err, expected)
+		}
+	}
+}
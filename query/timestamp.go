@@ -0,0 +1,20 @@
+package query
+
+import (
+	"database/sql/driver"
+	"time"
+)
+
+// TimestampFormat is how times are stored and compared: in UTC with a fixed
+// number of fractional digits, so that the text of two timestamps sorts in
+// the same order as the times themselves.
+const TimestampFormat = "2006-01-02 15:04:05.000000000-07:00"
+
+// Timestamp binds a time in TimestampFormat. go-sqlite3 binds a time.Time in
+// its own zone and trims trailing zeros from the fraction, which breaks text
+// comparisons between timestamps.
+type Timestamp time.Time
+
+func (r Timestamp) Value() (driver.Value, error) {
+	return time.Time(r).UTC().Format(TimestampFormat), nil
+}
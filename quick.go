@@ -0,0 +1,348 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// QuickAdd is the interpretation of a quick-add line such as
+// "Pay rent tomorrow 9am #finance !high".
+type QuickAdd struct {
+	Description string     `json:"desc"`
+	Due         *time.Time `json:"due"`
+	// AllDay is set when a date is given without a time.
+	AllDay   bool   `json:"all_day"`
+	Priority *int64 `json:"priority"`
+	// Tags are the names given by #tags. Project is the tag that names a
+	// project once resolved by QuickAddFunc, which leaves the other tags in
+	// Tags and in the description. Assignee is the user named by an @mention.
+	Tags     []string `json:"tags,omitempty"`
+	Project  string   `json:"project,omitempty"`
+	Assignee string   `json:"assignee,omitempty"`
+}
+
+var quickPriorities = map[string]int64{
+	"lowest": 0,
+	"low":    1,
+	"normal": 2,
+	"medium": 2,
+	"high":   3,
+	"urgent": 4,
+	"0":      0,
+	"1":      1,
+	"2":      2,
+	"3":      3,
+	"4":      4,
+}
+
+var quickWeekdays = map[string]time.Weekday{
+	"sunday":    time.Sunday,
+	"monday":    time.Monday,
+	"tuesday":   time.Tuesday,
+	"wednesday": time.Wednesday,
+	"thursday":  time.Thursday,
+	"friday":    time.Friday,
+	"saturday":  time.Saturday,
+}
+
+var quickMonths = map[string]time.Month{}
+
+var quickUnits = map[string]string{
+	"min": "m", "mins": "m", "minute": "m", "minutes": "m",
+	"hour": "h", "hours": "h",
+	"day": "d", "days": "d",
+	"week": "w", "weeks": "w",
+	"month": "M", "months": "M",
+}
+
+// quickPrepositions are dropped when they precede a date or time.
+var quickPrepositions = map[string]bool{"by": true, "on": true, "at": true, "due": true}
+
+var (
+	quickClock    = regexp.MustCompile(`^(\d{1,2})(?::(\d{2}))?(am|pm)?$`)
+	quickISODate  = regexp.MustCompile(`^\d{4}-\d{2}-\d{2}$`)
+	quickMonthDay = regexp.MustCompile(`^(\d{1,2})(st|nd|rd|th)?$`)
+)
+
+func init() {
+	for m := time.January; m <= time.December; m++ {
+		name := strings.ToLower(m.String())
+		quickMonths[name] = m
+		quickMonths[name[:3]] = m
+	}
+	quickMonths["sept"] = time.September
+}
+
+type quickParser struct {
+	now   time.Time
+	words []string
+	desc  []string
+	date  *time.Time
+	clock *time.Duration
+	exact *time.Time
+}
+
+// ParseQuickAdd interprets text relative to now, in the location of now. A
+// date without a time is all-day, due at the end of that day, and a time
+// without a date is due at its next occurrence.
+func ParseQuickAdd(text string, now time.Time) (*QuickAdd, error) {
+	p := &quickParser{now: now, words: strings.Fields(text)}
+	q := &QuickAdd{}
+
+	for i := 0; i < len(p.words); {
+		word := p.words[i]
+
+		switch {
+		case len(word) > 1 && word[0] == '#':
+			q.Tags = append(q.Tags, word[1:])
+			i++
+			continue
+		case len(word) > 1 && word[0] == '@':
+			if q.Assignee != "" {
+				return nil, fmt.Errorf("more than one assignee: @%s and %s", q.Assignee, word)
+			}
+			q.Assignee = word[1:]
+			i++
+			continue
+		case len(word) > 1 && word[0] == '!':
+			priority, ok := quickPriorities[strings.ToLower(word[1:])]
+			if !ok {
+				return nil, fmt.Errorf("unknown priority %s, expected !lowest, !low, !normal, !high, !urgent or !0 to !4", word)
+			}
+			q.Priority = &priority
+			i++
+			continue
+		}
+
+		if n := p.match(i); n > 0 {
+			if len(p.desc) > 0 && quickPrepositions[strings.ToLower(p.desc[len(p.desc)-1])] {
+				p.desc = p.desc[:len(p.desc)-1]
+			}
+			i += n
+			continue
+		}
+
+		p.desc = append(p.desc, word)
+		i++
+	}
+
+	q.Description = strings.Join(p.desc, " ")
+	if q.Description == "" {
+		return nil, fmt.Errorf("the description is empty")
+	}
+
+	q.Due = p.due()
+	q.AllDay = p.date != nil && p.clock == nil
+	return q, nil
+}
+
+// word returns the lowercased word at i without trailing punctuation.
+func (r *quickParser) word(i int) string {
+	if i >= len(r.words) {
+		return ""
+	}
+	return strings.TrimRight(strings.ToLower(r.words[i]), ",.;")
+}
+
+func (r *quickParser) today() time.Time {
+	year, month, day := r.now.Date()
+	return time.Date(year, month, day, 0, 0, 0, 0, r.now.Location())
+}
+
+// match consumes a date or time phrase starting at i and returns the number
+// of words it spans. Only the first date and the first time are consumed.
+func (r *quickParser) match(i int) int {
+	if r.date == nil && r.exact == nil {
+		if n, date := r.matchDate(i); n > 0 {
+			r.date = &date
+			return n
+		}
+
+		if n, t, exact := r.matchIn(i); n > 0 && exact {
+			r.exact = &t
+			return n
+		} else if n > 0 {
+			r.date = &t
+			return n
+		}
+	}
+
+	if r.clock == nil && r.exact == nil {
+		if n, clock := r.matchClock(i); n > 0 {
+			r.clock = &clock
+			return n
+		}
+	}
+
+	return 0
+}
+
+func (r *quickParser) matchDate(i int) (int, time.Time) {
+	today := r.today()
+	word := r.word(i)
+
+	switch word {
+	case "today", "tonight":
+		return 1, today
+	case "tomorrow":
+		return 1, today.AddDate(0, 0, 1)
+	case "next":
+		switch next := r.word(i + 1); next {
+		case "week":
+			return 2, today.AddDate(0, 0, 7-(int(today.Weekday())+6)%7)
+		case "month":
+			return 2, time.Date(today.Year(), today.Month()+1, 1, 0, 0, 0, 0, today.Location())
+		default:
+			if n, date := r.matchDate(i + 1); n > 0 {
+				if _, ok := quickWeekdays[next]; ok {
+					return n + 1, date
+				}
+			}
+		}
+		return 0, today
+	}
+
+	if weekday, ok := quickWeekdays[word]; ok {
+		days := (int(weekday) - int(today.Weekday()) + 7) % 7
+		if days == 0 {
+			days = 7
+		}
+		return 1, today.AddDate(0, 0, days)
+	}
+
+	if quickISODate.MatchString(word) {
+		if date, err := time.ParseInLocation("2006-01-02", word, today.Location()); err == nil {
+			return 1, date
+		}
+	}
+
+	// "nov 12" and "12 nov"
+	month, ok := quickMonths[word]
+	day := quickMonthDay.FindStringSubmatch(r.word(i + 1))
+	if !ok || day == nil {
+		month, ok = quickMonths[r.word(i+1)]
+		day = quickMonthDay.FindStringSubmatch(word)
+	}
+
+	if ok && day != nil {
+		d, _ := strconv.Atoi(day[1])
+		date := time.Date(today.Year(), month, d, 0, 0, 0, 0, today.Location())
+		if date.Day() != d {
+			return 0, today
+		}
+		if date.Before(today) {
+			date = date.AddDate(1, 0, 0)
+		}
+		return 2, date
+	}
+
+	return 0, today
+}
+
+// matchIn matches "in 3 days" or "in an hour". Minutes and hours give an
+// exact time, longer units a date.
+func (r *quickParser) matchIn(i int) (int, time.Time, bool) {
+	if r.word(i) != "in" {
+		return 0, r.now, false
+	}
+
+	var n int
+	switch count := r.word(i + 1); count {
+	case "a", "an":
+		n = 1
+	default:
+		var err error
+		if n, err = strconv.Atoi(count); err != nil || n < 0 {
+			return 0, r.now, false
+		}
+	}
+
+	switch quickUnits[r.word(i+2)] {
+	case "m":
+		return 3, r.now.Add(time.Duration(n) * time.Minute), true
+	case "h":
+		return 3, r.now.Add(time.Duration(n) * time.Hour), true
+	case "d":
+		return 3, r.today().AddDate(0, 0, n), false
+	case "w":
+		return 3, r.today().AddDate(0, 0, 7*n), false
+	case "M":
+		return 3, r.today().AddDate(0, n, 0), false
+	}
+
+	return 0, r.now, false
+}
+
+// matchClock matches "9am", "9:30pm", "17:00", "9 am", "noon" and "midnight".
+func (r *quickParser) matchClock(i int) (int, time.Duration) {
+	word := r.word(i)
+
+	switch word {
+	case "noon":
+		return 1, 12 * time.Hour
+	case "midnight":
+		return 1, 0
+	}
+
+	n := 1
+	if next := r.word(i + 1); (next == "am" || next == "pm") && !strings.HasSuffix(word, "m") {
+		word += next
+		n = 2
+	}
+
+	m := quickClock.FindStringSubmatch(word)
+	if m == nil || (m[2] == "" && m[3] == "") {
+		return 0, 0
+	}
+
+	hour, _ := strconv.Atoi(m[1])
+	minute := 0
+	if m[2] != "" {
+		minute, _ = strconv.Atoi(m[2])
+	}
+
+	if m[3] != "" {
+		if hour < 1 || hour > 12 {
+			return 0, 0
+		}
+		hour %= 12
+		if m[3] == "pm" {
+			hour += 12
+		}
+	}
+
+	if hour > 23 || minute > 59 {
+		return 0, 0
+	}
+
+	return n, time.Duration(hour)*time.Hour + time.Duration(minute)*time.Minute
+}
+
+// at returns the time clock past midnight on date, in wall clock time.
+func at(date time.Time, clock time.Duration) time.Time {
+	return time.Date(date.Year(), date.Month(), date.Day(), int(clock/time.Hour), int(clock%time.Hour/time.Minute), 0, 0, date.Location())
+}
+
+func (r *quickParser) due() *time.Time {
+	var due time.Time
+
+	switch {
+	case r.exact != nil:
+		due = *r.exact
+	case r.date != nil && r.clock != nil:
+		due = at(*r.date, *r.clock)
+	case r.date != nil:
+		due = endOfDay(r.date.Year(), r.date.Month(), r.date.Day(), r.date.Location())
+	case r.clock != nil:
+		if due = at(r.today(), *r.clock); due.Before(r.now) {
+			due = at(r.today().AddDate(0, 0, 1), *r.clock)
+		}
+	default:
+		return nil
+	}
+
+	return &due
+}
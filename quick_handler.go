@@ -0,0 +1,141 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/apierror"
+	"github.com/marcgwilson/todo/query"
+	"github.com/marcgwilson/todo/state"
+
+	"database/sql"
+	"net/http"
+	"time"
+)
+
+// QuickAddResponse pairs the interpretation of a quick-add line with the todo
+// created from it.
+type QuickAddResponse struct {
+	Parsed *QuickAdd `json:"parsed"`
+	Todo   *Todo     `json:"todo"`
+}
+
+// location returns the time zone named by the tz query parameter or the
+// X-Timezone header, defaulting to UTC.
+func (r *Handler) location(req *http.Request) (*time.Location, *apierror.Error) {
+	tz := req.URL.Query().Get("tz")
+	if tz == "" {
+		tz = req.Header.Get(TimezoneHeader)
+	}
+
+	loc, err := query.ParseLocation(tz)
+	if err != nil {
+		return nil, &apierror.Error{
+			Code:    http.StatusBadRequest,
+			Message: "Invalid query parameters",
+			Errors: []*apierror.ErrorDetail{
+				&apierror.ErrorDetail{Key: "tz", Value: tz, Message: query.TZErrorMessage},
+			},
+		}
+	}
+
+	return loc, nil
+}
+
+// QuickAddFunc creates a todo from a line of text such as
+// "Pay rent tomorrow 9am #finance !high". The parsed todo is validated and
+// created like the body of CreateFunc. Tags that name no project are kept in
+// the description.
+func (r *Handler) QuickAddFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		var data TodoMap
+		var ae *apierror.Error
+
+		if data, ae = UnmarshalJSONRequest(req); ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		if ae = validate(r.QuickAddValidator, data); ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		loc, ae := r.location(req)
+		if ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		text := data["text"].(string)
+		invalid := func(message string) {
+			writeError(w, &apierror.Error{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid quick-add text",
+				Errors: []*apierror.ErrorDetail{
+					&apierror.ErrorDetail{Key: "text", Value: text, Message: message},
+				},
+			})
+		}
+
+		parsed, err := ParseQuickAdd(text, time.Now().In(loc))
+		if err != nil {
+			invalid(err.Error())
+			return
+		}
+
+		todo := TodoMap{"desc": parsed.Description, "state": string(state.Todo)}
+
+		if loc != time.UTC {
+			todo["timezone"] = loc.String()
+		}
+
+		if parsed.Due != nil && parsed.AllDay {
+			todo["due"] = parsed.Due.Format(dayFormat)
+		} else if parsed.Due != nil {
+			todo["due"] = parsed.Due.Format(time.RFC3339)
+		}
+
+		if parsed.Priority != nil {
+			todo["priority"] = *parsed.Priority
+		}
+
+		// The first tag naming a project puts the todo in it; the other tags
+		// stay in the description, as todos have no free-form tags.
+		var tags []string
+		for _, tag := range parsed.Tags {
+			if parsed.Project == "" {
+				if p, err := r.PM.GetByName(tag); err == nil {
+					parsed.Project = tag
+					todo["project_id"] = p.ID
+					continue
+				} else if err != sql.ErrNoRows {
+					writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+					return
+				}
+			}
+			tags = append(tags, tag)
+			parsed.Description += " #" + tag
+		}
+		parsed.Tags = tags
+		todo["desc"] = parsed.Description
+
+		if parsed.Assignee != "" {
+			if u, err := r.UM.GetByName(parsed.Assignee); err != nil {
+				invalid("unknown user @" + parsed.Assignee)
+				return
+			} else {
+				todo["assignee_id"] = u.ID
+			}
+		}
+
+		create, _ := r.validators()
+
+		if ae = validate(create, todo); ae != nil {
+			writeError(w, ae)
+		} else if t, err := r.TM.Create(todo); err != nil {
+			writeError(w, managerError(err))
+		} else {
+			writeJSON(w, http.StatusCreated, &QuickAddResponse{parsed, t})
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"github.com/davecgh/go-spew/spew"
+
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseQuickAdd(t *testing.T) {
+	loc, err := time.LoadLocation("Europe/Paris")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	// Friday, the day before daylight saving time ends.
+	now := time.Date(2019, 10, 25, 15, 30, 0, 0, loc)
+
+	date := func(month time.Month, day int, hour int, minute int) *time.Time {
+		t := time.Date(2019, month, day, hour, minute, 0, 0, loc)
+		return &t
+	}
+
+	allDay := func(month time.Month, day int) *time.Time {
+		t := endOfDay(2019, month, day, loc)
+		return &t
+	}
+
+	tests := []struct {
+		text     string
+		desc     string
+		due      *time.Time
+		priority int64
+		tags     string
+		assignee string
+	}{
+		{"Pay rent tomorrow 9am #finance !high", "Pay rent", date(10, 26, 9, 0), 3, "finance", ""},
+		{"Call mom", "Call mom", nil, -1, "", ""},
+		{"Call mom at 4pm", "Call mom", date(10, 25, 16, 0), -1, "", ""},
+		{"Call mom at 9:15 am", "Call mom", date(10, 26, 9, 15), -1, "", ""},
+		{"Review budget by monday @bob !0", "Review budget", allDay(10, 28), 0, "", "bob"},
+		{"Weekly review next friday 17:00", "Weekly review", date(11, 1, 17, 0), -1, "", ""},
+		{"Plan sprint next week", "Plan sprint", allDay(10, 28), -1, "", ""},
+		{"Renew passport on 2019-12-02 noon", "Renew passport", date(12, 2, 12, 0), -1, "", ""},
+		{"Buy gifts dec 24th", "Buy gifts", allDay(12, 24), -1, "", ""},
+		{"File taxes 15 jan", "File taxes", &time.Time{}, -1, "", ""},
+		{"Stretch in 2 hours", "Stretch", date(10, 25, 17, 30), -1, "", ""},
+		{"Water plants in 3 days at 8am", "Water plants", date(10, 28, 8, 0), -1, "", ""},
+		{"Take 2 pills today, tomorrow", "Take 2 pills tomorrow", allDay(10, 25), -1, "", ""},
+		{"Sweep #home #garden", "Sweep", nil, -1, "home garden", ""},
+	}
+
+	next := endOfDay(2020, 1, 15, loc)
+	tests[9].due = &next
+
+	for _, test := range tests {
+		q, err := ParseQuickAdd(test.text, now)
+		if err != nil {
+			t.Errorf("%s: %s", test.text, err)
+			continue
+		}
+
+		if q.Description != test.desc || strings.Join(q.Tags, " ") != test.tags || q.Assignee != test.assignee {
+			t.Errorf("%s: %s", test.text, spew.Sdump(q))
+		}
+
+		if (q.Due == nil) != (test.due == nil) || q.Due != nil && !q.Due.Equal(*test.due) {
+			t.Errorf("%s: due = %v != %v", test.text, q.Due, test.due)
+		}
+
+		if q.AllDay != (test.due != nil && test.due.Second() == 59) {
+			t.Errorf("%s: all_day = %v", test.text, q.AllDay)
+		}
+
+		if (q.Priority == nil) != (test.priority < 0) || q.Priority != nil && *q.Priority != test.priority {
+			t.Errorf("%s: priority = %v != %d", test.text, q.Priority, test.priority)
+		}
+	}
+
+	for _, text := range []string{"#home", "Sweep !asap", "Sweep @bob @carol"} {
+		if q, err := ParseQuickAdd(text, now); err == nil {
+			t.Errorf("%s: %s", text, spew.Sdump(q))
+		}
+	}
+}
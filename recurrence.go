@@ -0,0 +1,82 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/rrule"
+	"github.com/marcgwilson/todo/state"
+
+	"time"
+)
+
+// spawnNext creates the next occurrence of the recurring todo t. It returns a
+// nil todo when the series has ended.
+func (r *TodoManager) spawnNext(t *Todo) (*Todo, error) {
+	rule, err := rrule.Parse(t.Recurrence)
+	if err != nil {
+		return nil, err
+	}
+
+	if rule.Count == 1 || t.Due == nil {
+		return nil, nil
+	}
+
+	next, ok := rule.Next(*t.Due, *t.Due)
+	if !ok {
+		return nil, nil
+	}
+
+	if rule.Count > 1 {
+		rule.Count--
+	}
+
+	data := map[string]interface{}{
+		"desc":       t.Description,
+		"due":        next,
+		"state":      state.Todo,
+		"recurrence": rule.String(),
+		"priority":   t.Priority,
+		"reminders":  t.Reminders,
+		"timezone":   t.Timezone,
+		"all_day":    t.AllDay,
+	}
+
+	if len(t.CustomFields) > 0 {
+		data["custom_fields"] = t.CustomFields
+	}
+
+	if t.ProjectID != nil {
+		data["project_id"] = *t.ProjectID
+	}
+
+	if t.ParentID != nil {
+		data["parent_id"] = *t.ParentID
+	}
+
+	if t.AssigneeID != nil {
+		data["assignee_id"] = *t.AssigneeID
+	}
+
+	if t.Estimate != nil {
+		data["estimate"] = *t.Estimate
+	}
+
+	return r.Create(data)
+}
+
+// Occurrences returns up to n occurrences of t, starting with its due date.
+// A todo without a due date has none.
+func (r *TodoManager) Occurrences(t *Todo, n int) ([]time.Time, error) {
+	if t.Due == nil {
+		return []time.Time{}, nil
+	}
+
+	if t.Recurrence == "" {
+		return []time.Time{*t.Due}, nil
+	}
+
+	rule, err := rrule.Parse(t.Recurrence)
+	if err != nil {
+		return nil, err
+	}
+
+	return rule.Occurrences(*t.Due, n), nil
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/notify"
+	"github.com/marcgwilson/todo/query"
+	"github.com/marcgwilson/todo/state"
+
+	"context"
+	"database/sql"
+	"log"
+	"time"
+)
+
+func setReminders(tx *sql.Tx, id int64, offsets []int64) error {
+	if _, err := tx.Exec("DELETE FROM reminder WHERE todo_id = ?;", id); err != nil {
+		return err
+	}
+
+	for _, offset := range offsets {
+		if _, err := tx.Exec("INSERT OR IGNORE INTO reminder(todo_id, offset_seconds) VALUES(?, ?);", id, offset); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// loadReminders sets Reminders on every todo in list.
+func (r *TodoManager) loadReminders(list TodoList) error {
+	if len(list) == 0 {
+		return nil
+	}
+
+	index := map[int64]*Todo{}
+	ids := make([]int64, len(list), len(list))
+	for i, t := range list {
+		t.Reminders = []int64{}
+		index[t.ID] = t
+		ids[i] = t.ID
+	}
+
+	bindvars, values := inClause(ids)
+
+	rows, err := r.Database.Query("SELECT todo_id, offset_seconds FROM reminder WHERE todo_id IN "+bindvars+" ORDER BY offset_seconds DESC", values...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var id, offset int64
+		if err = rows.Scan(&id, &offset); err != nil {
+			return err
+		}
+		index[id].Reminders = append(index[id].Reminders, offset)
+	}
+
+	return rows.Err()
+}
+
+// PendingNotifications returns the reminders and overdue notices for open
+// todos that are due at now and have not been recorded with MarkNotified.
+func (r *TodoManager) PendingNotifications(now time.Time) ([]*notify.Notification, error) {
+	results := []*notify.Notification{}
+
+	rows, err := r.Database.Query(`SELECT t.rowid, t.desc, t.due, t.state, r.offset_seconds, u.email
+FROM reminder r JOIN todo t ON t.rowid = r.todo_id LEFT JOIN user u ON u.rowid = t.assignee_id
+WHERE t.state != ? AND NOT t.archived AND t.due IS NOT NULL AND NOT EXISTS (
+    SELECT 1 FROM notification n
+    WHERE n.todo_id = t.rowid AND n.kind = ? AND n.offset_seconds = r.offset_seconds AND n.due = t.due
+)
+ORDER BY t.due, t.rowid`, state.Done, notify.Reminder)
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var email sql.NullString
+
+		n := &notify.Notification{Kind: notify.Reminder}
+		if err = rows.Scan(&n.TodoID, &n.Description, &n.Due, &n.State, &n.Offset, &email); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		n.To = recipients(email)
+
+		if !n.Due.Add(-time.Duration(n.Offset) * time.Second).After(now) {
+			results = append(results, n)
+		}
+	}
+	rows.Close()
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err = r.Database.Query(`SELECT t.rowid, t.desc, t.due, t.state, u.email
+FROM todo t LEFT JOIN user u ON u.rowid = t.assignee_id
+WHERE t.state != ? AND NOT t.archived AND t.due <= ? AND NOT EXISTS (
+    SELECT 1 FROM notification n
+    WHERE n.todo_id = t.rowid AND n.kind = ? AND n.due = t.due
+)
+ORDER BY t.due, t.rowid`, state.Done, query.Timestamp(now), notify.Overdue)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var email sql.NullString
+
+		n := &notify.Notification{Kind: notify.Overdue}
+		if err = rows.Scan(&n.TodoID, &n.Description, &n.Due, &n.State, &email); err != nil {
+			return nil, err
+		}
+		n.To = recipients(email)
+		results = append(results, n)
+	}
+
+	return results, rows.Err()
+}
+
+func recipients(email sql.NullString) []string {
+	if email.String == "" {
+		return nil
+	}
+	return []string{email.String}
+}
+
+// MarkNotified records that n was dispatched so that it is not repeated. A
+// notification fires again if the todo's due date changes.
+func (r *TodoManager) MarkNotified(n *notify.Notification, now time.Time) error {
+	_, err := r.Database.Exec("INSERT OR IGNORE INTO notification(todo_id, kind, offset_seconds, due, sent) VALUES(?, ?, ?, ?, ?);",
+		n.TodoID, n.Kind, n.Offset, query.Timestamp(*n.Due), now.UTC())
+	return err
+}
+
+// maxPendingChanges bounds the changed notifications being delivered at
+// once. Further changes are not notified until one of them finishes.
+const maxPendingChanges = 16
+
+// NotifyChanged tells r.Notifier that t was changed by the user identified by
+// caller, or by an anonymous client when caller is nil, without blocking the
+// caller. Only the assignee is notified, and not about their own changes.
+func (r *TodoManager) NotifyChanged(t *Todo, caller *int64) {
+	if r.Notifier == nil || t.AssigneeID == nil {
+		return
+	}
+
+	if caller != nil && *caller == *t.AssigneeID {
+		return
+	}
+
+	var email sql.NullString
+	row := r.Database.QueryRow("SELECT email FROM user WHERE rowid = ?", *t.AssigneeID)
+	if err := row.Scan(&email); err != nil || email.String == "" {
+		return
+	}
+
+	n := &notify.Notification{
+		Kind:        notify.Changed,
+		TodoID:      t.ID,
+		To:          recipients(email),
+		Description: t.Description,
+		Due:         t.Due,
+		State:       string(t.State),
+	}
+
+	select {
+	case r.pending <- struct{}{}:
+	default:
+		log.Printf("ERROR: dropping %s notification for todo %d: too many pending", n.Kind, n.TodoID)
+		return
+	}
+
+	go func() {
+		defer func() { <-r.pending }()
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+		defer cancel()
+
+		if err := r.Notifier.Notify(ctx, n); err != nil {
+			log.Printf("ERROR: notifying %s for todo %d: %s", n.Kind, n.TodoID, err)
+		}
+	}()
+}
@@ -8,8 +8,65 @@ func NewRouter(h *Handler) *mux.Router {
 	r := mux.NewRouter()
 	r.HandleFunc("/", h.CreateFunc()).Methods("POST")
 	r.HandleFunc("/", h.ListFunc()).Methods("GET")
+	r.HandleFunc("/quick/", h.QuickAddFunc()).Methods("POST")
+	r.HandleFunc("/stats/", h.StatsFunc()).Methods("GET")
+	r.HandleFunc("/calendar.ics", h.CalendarFunc()).Methods("GET")
+	r.HandleFunc("/export", h.ExportFunc()).Methods("GET")
+	r.HandleFunc("/import", h.ImportFunc()).Methods("POST")
+	r.HandleFunc("/admin/export", h.AdminExportFunc()).Methods("GET")
+	r.HandleFunc("/admin/import", h.AdminImportFunc()).Methods("POST")
+
+	r.HandleFunc("/.well-known/caldav", h.CalDAVWellKnownFunc())
+	for _, path := range []string{CalDAVRoot, CalDAVCalendar, CalDAVCalendar + "{name}"} {
+		r.HandleFunc(path, h.CalDAVOptionsFunc()).Methods("OPTIONS")
+		r.HandleFunc(path, h.CalDAVPropfindFunc()).Methods("PROPFIND")
+	}
+	r.HandleFunc(CalDAVCalendar, h.CalDAVReportFunc()).Methods("REPORT")
+	r.HandleFunc(CalDAVCalendar+"{name}", h.CalDAVGetFunc()).Methods("GET")
+	r.HandleFunc(CalDAVCalendar+"{name}", h.CalDAVPutFunc()).Methods("PUT")
+	r.HandleFunc(CalDAVCalendar+"{name}", h.CalDAVDeleteFunc()).Methods("DELETE")
+
 	r.HandleFunc("/{id:[0-9]+}/", h.RetrieveFunc()).Methods("GET")
 	r.HandleFunc("/{id:[0-9]+}/", h.UpdateFunc()).Methods("PATCH")
 	r.HandleFunc("/{id:[0-9]+}/", h.DeleteFunc()).Methods("DELETE")
+	r.HandleFunc("/{id:[0-9]+}/children/", h.ChildrenFunc()).Methods("GET")
+	r.HandleFunc("/{id:[0-9]+}/occurrences/", h.OccurrencesFunc()).Methods("GET")
+	r.HandleFunc("/{id:[0-9]+}/dependencies/", h.DependencyListFunc()).Methods("GET")
+	r.HandleFunc("/{id:[0-9]+}/dependencies/", h.DependencyCreateFunc()).Methods("POST")
+	r.HandleFunc("/{id:[0-9]+}/dependencies/{blocker:[0-9]+}/", h.DependencyDeleteFunc()).Methods("DELETE")
+	r.HandleFunc("/{id:[0-9]+}/comments/", h.CommentListFunc()).Methods("GET")
+	r.HandleFunc("/{id:[0-9]+}/comments/", h.CommentCreateFunc()).Methods("POST")
+	r.HandleFunc("/{id:[0-9]+}/comments/{cid:[0-9]+}/", h.CommentUpdateFunc()).Methods("PATCH")
+	r.HandleFunc("/{id:[0-9]+}/comments/{cid:[0-9]+}/", h.CommentDeleteFunc()).Methods("DELETE")
+	r.HandleFunc("/{id:[0-9]+}/timer/start/", h.TimerStartFunc()).Methods("POST")
+	r.HandleFunc("/{id:[0-9]+}/timer/stop/", h.TimerStopFunc()).Methods("POST")
+	r.HandleFunc("/{id:[0-9]+}/worklogs/", h.WorklogListFunc()).Methods("GET")
+	r.HandleFunc("/{id:[0-9]+}/worklogs/", h.WorklogCreateFunc()).Methods("POST")
+	r.HandleFunc("/{id:[0-9]+}/worklogs/{wid:[0-9]+}/", h.WorklogUpdateFunc()).Methods("PATCH")
+	r.HandleFunc("/{id:[0-9]+}/worklogs/{wid:[0-9]+}/", h.WorklogDeleteFunc()).Methods("DELETE")
+	r.HandleFunc("/{id:[0-9]+}/attachments/", h.AttachmentListFunc()).Methods("GET")
+	r.HandleFunc("/{id:[0-9]+}/attachments/", h.AttachmentCreateFunc()).Methods("POST")
+	r.HandleFunc("/{id:[0-9]+}/attachments/{aid:[0-9]+}/", h.AttachmentDownloadFunc()).Methods("GET")
+	r.HandleFunc("/{id:[0-9]+}/attachments/{aid:[0-9]+}/", h.AttachmentDeleteFunc()).Methods("DELETE")
+
+	r.HandleFunc("/projects/", h.ProjectCreateFunc()).Methods("POST")
+	r.HandleFunc("/projects/", h.ProjectListFunc()).Methods("GET")
+	r.HandleFunc("/projects/{id:[0-9]+}/", h.ProjectRetrieveFunc()).Methods("GET")
+	r.HandleFunc("/projects/{id:[0-9]+}/", h.ProjectUpdateFunc()).Methods("PATCH")
+	r.HandleFunc("/projects/{id:[0-9]+}/", h.ProjectDeleteFunc()).Methods("DELETE")
+	r.HandleFunc("/projects/{id:[0-9]+}/todos/", h.ProjectTodosFunc()).Methods("GET")
+
+	r.HandleFunc("/fields/", h.FieldCreateFunc()).Methods("POST")
+	r.HandleFunc("/fields/", h.FieldListFunc()).Methods("GET")
+	r.HandleFunc("/fields/{id:[0-9]+}/", h.FieldRetrieveFunc()).Methods("GET")
+	r.HandleFunc("/fields/{id:[0-9]+}/", h.FieldUpdateFunc()).Methods("PATCH")
+	r.HandleFunc("/fields/{id:[0-9]+}/", h.FieldDeleteFunc()).Methods("DELETE")
+
+	r.HandleFunc("/users/", h.UserCreateFunc()).Methods("POST")
+	r.HandleFunc("/users/", h.UserListFunc()).Methods("GET")
+	r.HandleFunc("/users/{id:[0-9]+}/", h.UserRetrieveFunc()).Methods("GET")
+	r.HandleFunc("/users/{id:[0-9]+}/", h.UserUpdateFunc()).Methods("PATCH")
+	r.HandleFunc("/users/{id:[0-9]+}/", h.UserDeleteFunc()).Methods("DELETE")
+	r.HandleFunc("/users/{id:[0-9]+}/feed/", h.UserFeedFunc()).Methods("POST")
 	return r
 }
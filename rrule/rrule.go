@@ -0,0 +1,316 @@
+package rrule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Frequency string
+
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+	Yearly  Frequency = "YEARLY"
+)
+
+var frequencies = map[string]Frequency{
+	"DAILY":   Daily,
+	"WEEKLY":  Weekly,
+	"MONTHLY": Monthly,
+	"YEARLY":  Yearly,
+}
+
+var weekdays = map[string]time.Weekday{
+	"SU": time.Sunday,
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+}
+
+var weekdayNames = []string{"SU", "MO", "TU", "WE", "TH", "FR", "SA"}
+
+var untilFormats = []string{
+	"20060102T150405Z",
+	"20060102T150405",
+	"20060102",
+	time.RFC3339Nano,
+}
+
+// maxDays bounds the search for the next occurrence.
+const maxDays = 366 * 100
+
+// Rule is the subset of an RFC 5545 recurrence rule supporting the FREQ,
+// INTERVAL, BYDAY, BYMONTHDAY, COUNT and UNTIL parts. A Count of 0 and a zero
+// Until mean the rule repeats forever.
+type Rule struct {
+	Freq       Frequency
+	Interval   int
+	ByDay      []time.Weekday
+	ByMonthDay []int
+	Count      int
+	Until      time.Time
+}
+
+func Parse(s string) (*Rule, error) {
+	r := &Rule{Interval: 1}
+
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	if s == "" {
+		return nil, fmt.Errorf("Empty recurrence rule")
+	}
+
+	for _, part := range strings.Split(s, ";") {
+		kv := strings.SplitN(part, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return nil, fmt.Errorf("Invalid rule part: %s", part)
+		}
+
+		key, value := strings.ToUpper(kv[0]), strings.ToUpper(kv[1])
+
+		switch key {
+		case "FREQ":
+			if freq, ok := frequencies[value]; !ok {
+				return nil, fmt.Errorf("Unsupported FREQ: %s", value)
+			} else {
+				r.Freq = freq
+			}
+		case "INTERVAL":
+			if interval, err := strconv.Atoi(value); err != nil || interval < 1 {
+				return nil, fmt.Errorf("Invalid INTERVAL: %s", value)
+			} else {
+				r.Interval = interval
+			}
+		case "BYDAY":
+			for _, day := range strings.Split(value, ",") {
+				if weekday, ok := weekdays[day]; !ok {
+					return nil, fmt.Errorf("Invalid BYDAY: %s", day)
+				} else {
+					r.ByDay = append(r.ByDay, weekday)
+				}
+			}
+		case "BYMONTHDAY":
+			for _, day := range strings.Split(value, ",") {
+				if d, err := strconv.Atoi(day); err != nil || d == 0 || d < -31 || d > 31 {
+					return nil, fmt.Errorf("Invalid BYMONTHDAY: %s", day)
+				} else {
+					r.ByMonthDay = append(r.ByMonthDay, d)
+				}
+			}
+		case "COUNT":
+			if count, err := strconv.Atoi(value); err != nil || count < 1 {
+				return nil, fmt.Errorf("Invalid COUNT: %s", value)
+			} else {
+				r.Count = count
+			}
+		case "UNTIL":
+			if until, err := parseUntil(kv[1]); err != nil {
+				return nil, err
+			} else {
+				r.Until = until
+			}
+		default:
+			return nil, fmt.Errorf("Unsupported rule part: %s", key)
+		}
+	}
+
+	if r.Freq == "" {
+		return nil, fmt.Errorf("FREQ is required")
+	}
+
+	if r.Count > 0 && !r.Until.IsZero() {
+		return nil, fmt.Errorf("COUNT and UNTIL are mutually exclusive")
+	}
+
+	return r, nil
+}
+
+func parseUntil(value string) (time.Time, error) {
+	for _, format := range untilFormats {
+		if t, err := time.Parse(format, value); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("Invalid UNTIL: %s", value)
+}
+
+func (r *Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+
+	if r.Interval > 1 {
+		parts = append(parts, fmt.Sprintf("INTERVAL=%d", r.Interval))
+	}
+
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay), len(r.ByDay))
+		for i, d := range r.ByDay {
+			days[i] = weekdayNames[d]
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+
+	if len(r.ByMonthDay) > 0 {
+		days := make([]string, len(r.ByMonthDay), len(r.ByMonthDay))
+		for i, d := range r.ByMonthDay {
+			days[i] = strconv.Itoa(d)
+		}
+		parts = append(parts, "BYMONTHDAY="+strings.Join(days, ","))
+	}
+
+	if r.Count > 0 {
+		parts = append(parts, fmt.Sprintf("COUNT=%d", r.Count))
+	}
+
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format("20060102T150405Z"))
+	}
+
+	return strings.Join(parts, ";")
+}
+
+// Next returns the first occurrence strictly after after for a series that
+// starts at start. The second return value is false when the series has ended.
+// Count is not considered since it depends on how many occurrences came before.
+func (r *Rule) Next(start time.Time, after time.Time) (time.Time, bool) {
+	if after.Before(start) {
+		after = start.Add(-time.Nanosecond)
+	}
+
+	after = after.In(start.Location())
+	day := dateOf(after)
+
+	for i := 0; i <= maxDays; i++ {
+		candidate := time.Date(day.Year(), day.Month(), day.Day()+i,
+			start.Hour(), start.Minute(), start.Second(), start.Nanosecond(), start.Location())
+
+		if !r.Until.IsZero() && candidate.After(r.Until) {
+			return time.Time{}, false
+		}
+
+		if candidate.After(after) && r.matches(start, candidate) {
+			return candidate, true
+		}
+	}
+
+	return time.Time{}, false
+}
+
+// Occurrences returns up to n occurrences of the series starting at start,
+// including start itself, honoring Count and Until.
+func (r *Rule) Occurrences(start time.Time, n int) []time.Time {
+	if r.Count > 0 && r.Count < n {
+		n = r.Count
+	}
+
+	results := []time.Time{}
+	if n < 1 || (!r.Until.IsZero() && start.After(r.Until)) {
+		return results
+	}
+
+	results = append(results, start)
+	current := start
+
+	for len(results) < n {
+		next, ok := r.Next(start, current)
+		if !ok {
+			break
+		}
+		results = append(results, next)
+		current = next
+	}
+
+	return results
+}
+
+func (r *Rule) matches(start time.Time, candidate time.Time) bool {
+	s, c := dateOf(start), dateOf(candidate)
+
+	switch r.Freq {
+	case Daily:
+		if daysBetween(s, c)%r.Interval != 0 {
+			return false
+		}
+		return r.matchesDay(c)
+	case Weekly:
+		if (daysBetween(weekStart(s), weekStart(c))/7)%r.Interval != 0 {
+			return false
+		}
+		if len(r.ByDay) == 0 {
+			return c.Weekday() == s.Weekday() && r.matchesMonthDay(c)
+		}
+		return r.matchesDay(c)
+	case Monthly:
+		if monthsBetween(s, c)%r.Interval != 0 {
+			return false
+		}
+		return r.matchesDayOfMonth(s, c)
+	case Yearly:
+		if (c.Year()-s.Year())%r.Interval != 0 || c.Month() != s.Month() {
+			return false
+		}
+		return r.matchesDayOfMonth(s, c)
+	}
+
+	return false
+}
+
+// matchesDayOfMonth applies BYMONTHDAY and BYDAY, defaulting to the day of the
+// month of the start of the series when neither is set.
+func (r *Rule) matchesDayOfMonth(s time.Time, c time.Time) bool {
+	if len(r.ByDay) == 0 && len(r.ByMonthDay) == 0 {
+		return c.Day() == s.Day()
+	}
+	return r.matchesDay(c)
+}
+
+func (r *Rule) matchesDay(c time.Time) bool {
+	if len(r.ByDay) > 0 {
+		found := false
+		for _, d := range r.ByDay {
+			if c.Weekday() == d {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return r.matchesMonthDay(c)
+}
+
+func (r *Rule) matchesMonthDay(c time.Time) bool {
+	if len(r.ByMonthDay) == 0 {
+		return true
+	}
+
+	last := time.Date(c.Year(), c.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+	for _, d := range r.ByMonthDay {
+		if d == c.Day() || (d < 0 && last+d+1 == c.Day()) {
+			return true
+		}
+	}
+	return false
+}
+
+func dateOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC)
+}
+
+func weekStart(t time.Time) time.Time {
+	offset := (int(t.Weekday()) + 6) % 7
+	return t.AddDate(0, 0, -offset)
+}
+
+func daysBetween(a time.Time, b time.Time) int {
+	return int(b.Sub(a).Hours() / 24)
+}
+
+func monthsBetween(a time.Time, b time.Time) int {
+	return (b.Year()-a.Year())*12 + int(b.Month()) - int(a.Month())
+}
@@ -0,0 +1,131 @@
+package rrule
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func date(year int, month time.Month, day int) time.Time {
+	return time.Date(year, month, day, 9, 30, 0, 0, time.UTC)
+}
+
+func TestParse(t *testing.T) {
+	cases := map[string]string{
+		"FREQ=DAILY": "FREQ=DAILY",
+		"RRULE:freq=weekly;interval=2;byday=mo,we":     "FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,WE",
+		"FREQ=MONTHLY;BYMONTHDAY=1,-1;COUNT=3":         "FREQ=MONTHLY;BYMONTHDAY=1,-1;COUNT=3",
+		"FREQ=YEARLY;UNTIL=20201231":                   "FREQ=YEARLY;UNTIL=20201231T000000Z",
+		"FREQ=DAILY;INTERVAL=1;UNTIL=20191105T120000Z": "FREQ=DAILY;UNTIL=20191105T120000Z",
+	}
+
+	for input, expected := range cases {
+		if r, err := Parse(input); err != nil {
+			t.Errorf("Parse(%q): %s", input, err)
+		} else if actual := r.String(); actual != expected {
+			t.Errorf("Parse(%q).String() = %s != %s", input, actual, expected)
+		}
+	}
+
+	invalid := []string{
+		"",
+		"INTERVAL=2",
+		"FREQ=HOURLY",
+		"FREQ=DAILY;INTERVAL=0",
+		"FREQ=WEEKLY;BYDAY=XX",
+		"FREQ=MONTHLY;BYMONTHDAY=32",
+		"FREQ=DAILY;COUNT=2;UNTIL=20201231",
+		"FREQ=DAILY;BYSETPOS=1",
+	}
+
+	for _, input := range invalid {
+		if _, err := Parse(input); err == nil {
+			t.Errorf("Parse(%q) succeeded", input)
+		}
+	}
+}
+
+func TestOccurrences(t *testing.T) {
+	cases := []struct {
+		rule     string
+		start    time.Time
+		n        int
+		expected []time.Time
+	}{
+		{
+			"FREQ=DAILY;INTERVAL=3",
+			date(2019, 11, 4),
+			3,
+			[]time.Time{date(2019, 11, 4), date(2019, 11, 7), date(2019, 11, 10)},
+		},
+		{
+			// Monday 2019-11-04.
+			"FREQ=WEEKLY;INTERVAL=2;BYDAY=MO,FR",
+			date(2019, 11, 4),
+			4,
+			[]time.Time{date(2019, 11, 4), date(2019, 11, 8), date(2019, 11, 18), date(2019, 11, 22)},
+		},
+		{
+			"FREQ=WEEKLY",
+			date(2019, 11, 6),
+			3,
+			[]time.Time{date(2019, 11, 6), date(2019, 11, 13), date(2019, 11, 20)},
+		},
+		{
+			"FREQ=MONTHLY;BYMONTHDAY=-1",
+			date(2020, 1, 31),
+			3,
+			[]time.Time{date(2020, 1, 31), date(2020, 2, 29), date(2020, 3, 31)},
+		},
+		{
+			"FREQ=MONTHLY",
+			date(2019, 1, 31),
+			3,
+			[]time.Time{date(2019, 1, 31), date(2019, 3, 31), date(2019, 5, 31)},
+		},
+		{
+			"FREQ=YEARLY;COUNT=2",
+			date(2019, 11, 4),
+			5,
+			[]time.Time{date(2019, 11, 4), date(2020, 11, 4)},
+		},
+		{
+			"FREQ=DAILY;UNTIL=20191106T093000Z",
+			date(2019, 11, 4),
+			5,
+			[]time.Time{date(2019, 11, 4), date(2019, 11, 5), date(2019, 11, 6)},
+		},
+	}
+
+	for _, c := range cases {
+		r, err := Parse(c.rule)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		actual := r.Occurrences(c.start, c.n)
+
+		if !reflect.DeepEqual(c.expected, actual) {
+			t.Errorf("%s: %v != %v", c.rule, actual, c.expected)
+		}
+	}
+}
+
+func TestNextAcrossDST(t *testing.T) {
+	loc, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip(err)
+	}
+
+	r, _ := Parse("FREQ=DAILY")
+	start := time.Date(2019, 11, 2, 9, 0, 0, 0, loc)
+
+	next, ok := r.Next(start, start)
+	if !ok {
+		t.Fatal("series ended")
+	}
+
+	if next.Hour() != 9 || next.Day() != 3 {
+		t.Errorf("next = %s", next)
+	}
+}
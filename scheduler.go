@@ -0,0 +1,207 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/notify"
+
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+// Scheduler periodically dispatches reminders and overdue notices for todos
+// through a Notifier. Notifiers that are also a notify.DigestSender receive a
+// daily digest of all todos once the local hour reaches DigestHour, plus one
+// per user with an email address for the todos assigned to them.
+type Scheduler struct {
+	TM       *TodoManager
+	UM       *UserManager
+	Notifier notify.Notifier
+	Clock    Clock
+	Interval time.Duration
+	// DigestHour is the hour of day digests are sent. Negative disables them.
+	DigestHour int
+
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+func NewScheduler(tm *TodoManager, notifier notify.Notifier, interval time.Duration) *Scheduler {
+	return &Scheduler{
+		TM:       tm,
+		UM:       NewUserManager(tm.Database),
+		Notifier: notifier,
+		Clock:    systemClock{},
+		Interval: interval,
+
+		DigestHour: 8,
+	}
+}
+
+// Start runs the scheduler in a new goroutine until Stop is called.
+func (r *Scheduler) Start() {
+	var ctx context.Context
+	ctx, r.cancel = context.WithCancel(context.Background())
+
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+
+		ticker := time.NewTicker(r.Interval)
+		defer ticker.Stop()
+
+		for {
+			if err := r.Tick(ctx); err != nil {
+				log.Printf("ERROR: scheduler: %s", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// Stop signals the scheduler to exit and waits for the current tick to finish.
+func (r *Scheduler) Stop() {
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.wg.Wait()
+}
+
+// Tick dispatches every pending notification once. Notifications that fail
+// to send are retried on the next tick.
+func (r *Scheduler) Tick(ctx context.Context) error {
+	now := r.Clock.Now()
+
+	pending, err := r.TM.PendingNotifications(now)
+	if err != nil {
+		return err
+	}
+
+	for _, n := range pending {
+		if ctx.Err() != nil {
+			return nil
+		}
+
+		if err = r.Notifier.Notify(ctx, n); err != nil {
+			log.Printf("ERROR: notifying %s for todo %d: %s", n.Kind, n.TodoID, err)
+			continue
+		}
+
+		if err = r.TM.MarkNotified(n, now); err != nil {
+			return err
+		}
+	}
+
+	return r.digest(ctx, now)
+}
+
+// digest sends today's digests if they are due and have not been sent yet.
+func (r *Scheduler) digest(ctx context.Context, now time.Time) error {
+	sender, ok := r.Notifier.(notify.DigestSender)
+	if !ok || r.DigestHour < 0 || now.Hour() < r.DigestHour {
+		return nil
+	}
+
+	if err := r.sendDigest(ctx, sender, now, nil); err != nil {
+		return err
+	}
+
+	users, err := r.UM.List()
+	if err != nil {
+		return err
+	}
+
+	for _, u := range users {
+		if u.Email == "" {
+			continue
+		}
+
+		if err = r.sendDigest(ctx, sender, now, u); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sendDigest sends the digest for u, or the team digest for a nil u. Empty
+// personal digests are skipped.
+func (r *Scheduler) sendDigest(ctx context.Context, sender notify.DigestSender, now time.Time, u *User) error {
+	if ctx.Err() != nil {
+		return nil
+	}
+
+	var assignee *int64
+
+	if u != nil {
+		assignee = &u.ID
+	}
+
+	sent, err := r.TM.DigestSent(assignee, now)
+	if err != nil || sent {
+		return err
+	}
+
+	d, err := r.TM.Digest(now, assignee)
+	if err != nil {
+		return err
+	}
+
+	if u != nil {
+		if len(d.Overdue) == 0 && len(d.DueToday) == 0 {
+			return nil
+		}
+		d.To = []string{u.Email}
+	}
+
+	if err = sender.SendDigest(ctx, d); err != nil {
+		log.Printf("ERROR: sending digest: %s", err)
+		return nil
+	}
+
+	return r.TM.MarkDigestSent(assignee, now, now)
+}
+
+// NewNotifier builds the notifier selected by config.
+func NewNotifier(config *Config) (notify.Notifier, error) {
+	switch config.Notifier {
+	case "log":
+		return &notify.LogNotifier{}, nil
+	case "webhook":
+		if config.WebhookURL == "" {
+			return nil, fmt.Errorf("TODO_WEBHOOK_URL is required for the webhook notifier")
+		}
+		return &notify.WebhookNotifier{URL: config.WebhookURL, Client: &http.Client{Timeout: 10 * time.Second}}, nil
+	case "smtp":
+		if config.SMTP.Host == "" || config.SMTP.From == "" || len(config.SMTP.To) == 0 {
+			return nil, fmt.Errorf("TODO_SMTP_HOST, TODO_SMTP_FROM and TODO_SMTP_TO are required for the smtp notifier")
+		}
+		switch config.SMTP.TLS {
+		case "none", "starttls", "tls":
+		default:
+			return nil, fmt.Errorf("Unknown TODO_SMTP_TLS mode: %s", config.SMTP.TLS)
+		}
+		return &notify.SMTPNotifier{Config: &config.SMTP}, nil
+	case "none":
+		return notify.Multi{}, nil
+	default:
+		return nil, fmt.Errorf("Unknown notifier: %s", config.Notifier)
+	}
+}
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/notify"
+	"github.com/marcgwilson/todo/state"
+
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type fakeClock struct {
+	now time.Time
+}
+
+func (r *fakeClock) Now() time.Time {
+	return r.now
+}
+
+type recordingNotifier struct {
+	notifications []*notify.Notification
+	err           error
+}
+
+func (r *recordingNotifier) Notify(ctx context.Context, n *notify.Notification) error {
+	if r.err != nil {
+		return r.err
+	}
+	r.notifications = append(r.notifications, n)
+	return nil
+}
+
+func (r *recordingNotifier) kinds() []notify.Kind {
+	results := []notify.Kind{}
+	for _, n := range r.notifications {
+		results = append(results, n.Kind)
+	}
+	return results
+}
+
+func TestScheduler(t *testing.T) {
+	db, err := OpenDB(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tm := NewManager(db, DefaultConfig())
+
+	due := time.Date(2019, 11, 4, 12, 0, 0, 0, time.UTC)
+	data := map[string]interface{}{
+		"desc":      "Reminded TODO",
+		"due":       due,
+		"state":     state.Todo,
+		"reminders": []int64{3600},
+	}
+
+	todo, err := tm.Create(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clock := &fakeClock{due.Add(-2 * time.Hour)}
+	notifier := &recordingNotifier{}
+	scheduler := NewScheduler(tm, notifier, time.Minute)
+	scheduler.Clock = clock
+
+	ctx := context.Background()
+
+	steps := []struct {
+		now      time.Time
+		expected int
+	}{
+		{due.Add(-2 * time.Hour), 0},
+		{due.Add(-time.Hour), 1},
+		{due.Add(-time.Minute), 1},
+		{due, 2},
+		{due.Add(time.Hour), 2},
+	}
+
+	for i, step := range steps {
+		clock.now = step.now
+		if err = scheduler.Tick(ctx); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(notifier.notifications) != step.expected {
+			t.Fatalf("%d: %d notifications != %d", i, len(notifier.notifications), step.expected)
+		}
+	}
+
+	if kinds := notifier.kinds(); kinds[0] != notify.Reminder || kinds[1] != notify.Overdue {
+		t.Errorf("kinds = %v", kinds)
+	}
+
+	if n := notifier.notifications[0]; n.TodoID != todo.ID || n.Offset != 3600 || !n.Due.Equal(due) {
+		t.Errorf("notification = %#v", n)
+	}
+
+	// Moving the due date re-arms the reminder; failed deliveries are retried.
+	later := due.Add(24 * time.Hour)
+	if _, err = tm.Update(todo.ID, map[string]interface{}{"due": later}); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.now = later.Add(-30 * time.Minute)
+	notifier.err = errors.New("unavailable")
+	if err = scheduler.Tick(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	notifier.err = nil
+	if err = scheduler.Tick(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(notifier.notifications) != 3 || notifier.notifications[2].Kind != notify.Reminder {
+		t.Errorf("kinds = %v", notifier.kinds())
+	}
+
+	if _, err = tm.Update(todo.ID, map[string]interface{}{"state": state.Done}); err != nil {
+		t.Fatal(err)
+	}
+
+	clock.now = later.Add(time.Hour)
+	if err = scheduler.Tick(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(notifier.notifications) != 3 {
+		t.Errorf("done todo notified: %v", notifier.kinds())
+	}
+}
+
+func TestSchedulerStop(t *testing.T) {
+	db, err := OpenDB(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	scheduler := NewScheduler(NewManager(db, DefaultConfig()), &recordingNotifier{}, time.Millisecond)
+	scheduler.Start()
+
+	stopped := make(chan struct{})
+	go func() {
+		scheduler.Stop()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("scheduler did not stop")
+	}
+}
+
+type digestNotifier struct {
+	recordingNotifier
+	digests []*notify.Digest
+}
+
+func (r *digestNotifier) SendDigest(ctx context.Context, d *notify.Digest) error {
+	r.digests = append(r.digests, d)
+	return nil
+}
+
+func TestSchedulerDigest(t *testing.T) {
+	db, err := OpenDB(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	tm := NewManager(db, DefaultConfig())
+	um := NewUserManager(db)
+
+	alice, err := um.Create(map[string]interface{}{"name": "alice", "email": "alice@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = um.Create(map[string]interface{}{"name": "bob", "email": "bob@example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	morning := time.Date(2019, 11, 4, 7, 0, 0, 0, time.UTC)
+	todos := []map[string]interface{}{
+		{"desc": "Yesterday", "due": morning.Add(-24 * time.Hour), "state": state.Todo},
+		{"desc": "Today", "due": morning.Add(10 * time.Hour), "state": state.InProgress, "assignee_id": alice.ID},
+		{"desc": "Tomorrow", "due": morning.Add(24 * time.Hour), "state": state.Todo},
+		{"desc": "Finished", "due": morning.Add(-time.Hour), "state": state.Done},
+	}
+
+	for _, data := range todos {
+		if _, err = tm.Create(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	clock := &fakeClock{morning}
+	notifier := &digestNotifier{}
+	scheduler := NewScheduler(tm, notifier, time.Minute)
+	scheduler.Clock = clock
+
+	ctx := context.Background()
+
+	for i, now := range []time.Time{morning, morning.Add(time.Hour), morning.Add(2 * time.Hour)} {
+		// Renaming a user does not send their digest again.
+		if i == 1 {
+			if _, err = um.Update(alice.ID, map[string]interface{}{"name": "alicia"}); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		clock.now = now
+		if err = scheduler.Tick(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The team digest and alice's; bob has nothing due.
+	if len(notifier.digests) != 2 {
+		t.Fatalf("%d digests != 2", len(notifier.digests))
+	}
+
+	d := notifier.digests[0]
+	if len(d.Overdue) != 1 || d.Overdue[0].Description != "Yesterday" {
+		t.Errorf("overdue = %v", d.Overdue)
+	}
+	if len(d.DueToday) != 1 || d.DueToday[0].Description != "Today" {
+		t.Errorf("due today = %v", d.DueToday)
+	}
+
+	d = notifier.digests[1]
+	if len(d.To) != 1 || d.To[0] != "alice@example.com" || len(d.Overdue) != 0 || len(d.DueToday) != 1 {
+		t.Errorf("digest = %#v", d)
+	}
+
+	clock.now = morning.Add(25 * time.Hour)
+	if err = scheduler.Tick(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// The next day "Today" is overdue for both the team and alice.
+	if len(notifier.digests) != 4 {
+		t.Errorf("%d digests != 4", len(notifier.digests))
+	}
+}
+
+type channelNotifier chan *notify.Notification
+
+func (r channelNotifier) Notify(ctx context.Context, n *notify.Notification) error {
+	r <- n
+	return nil
+}
+
+func TestNotifyChanged(t *testing.T) {
+	db, err := OpenDB(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	notifier := make(channelNotifier, 1)
+	tm := NewManager(db, DefaultConfig())
+	tm.Notifier = notifier
+
+	um := NewUserManager(db)
+	alice, err := um.Create(map[string]interface{}{"name": "alice", "email": "alice@example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bob, err := um.Create(map[string]interface{}{"name": "bob"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unassigned, err := tm.Create(map[string]interface{}{"desc": "Unassigned", "state": state.Todo})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mailless, err := tm.Create(map[string]interface{}{"desc": "Bob's", "state": state.Todo, "assignee_id": bob.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	assigned, err := tm.Create(map[string]interface{}{"desc": "Alice's", "state": state.Todo, "assignee_id": alice.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tm.NotifyChanged(unassigned, nil)
+	tm.NotifyChanged(mailless, &alice.ID)
+	tm.NotifyChanged(assigned, &alice.ID)
+	tm.NotifyChanged(assigned, &bob.ID)
+
+	select {
+	case n := <-notifier:
+		if n.Kind != notify.Changed || n.TodoID != assigned.ID || len(n.To) != 1 || n.To[0] != "alice@example.com" {
+			t.Errorf("notification = %#v", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no notification sent")
+	}
+
+	select {
+	case n := <-notifier:
+		t.Errorf("unexpected notification = %#v", n)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"github.com/marcgwilson/todo/query"
+	"github.com/marcgwilson/todo/rrule"
+
 	"github.com/xeipuuv/gojsonschema"
 
+	"encoding/json"
 	"time"
 )
 
@@ -14,15 +18,51 @@ const CreateSchema = `{
       "type": "string"
     },
     "due": {
-	  "type": "string",
-      "format": "rfc3339"
+      "type": ["string", "null"],
+      "format": "rfc3339-or-date"
+    },
+    "timezone": {
+      "type": "string",
+      "format": "timezone"
+    },
+    "all_day": {
+      "type": "boolean"
     },
     "state": {
       "type": "string",
       "enum": ["todo", "in_progress", "done"]
+    },
+    "project_id": {
+      "type": ["integer", "null"]
+    },
+    "parent_id": {
+      "type": ["integer", "null"]
+    },
+    "recurrence": {
+      "type": ["string", "null"],
+      "format": "rrule"
+    },
+    "priority": {
+      "type": "integer",
+      "minimum": 0,
+      "maximum": 4
+    },
+    "assignee_id": {
+      "type": ["integer", "null"]
+    },
+    "estimate": {
+      "type": ["integer", "null"],
+      "minimum": 0
+    },
+    "reminders": {
+      "type": ["array", "null"],
+      "items": {
+        "type": "integer",
+        "minimum": 0
+      }
     }
   },
-  "required": ["desc", "due", "state"],
+  "required": ["desc", "state"],
   "additionalProperties": false
 }`
 
@@ -34,19 +74,289 @@ const UpdateSchema = `{
       "type": "string"
     },
     "due": {
+      "type": ["string", "null"],
+      "format": "rfc3339-or-date"
+    },
+    "timezone": {
       "type": "string",
-      "format": "rfc3339"
+      "format": "timezone"
+    },
+    "all_day": {
+      "type": "boolean"
+    },
+    "archived": {
+      "type": "boolean"
     },
     "state": {
       "type": "string",
       "enum": ["todo", "in_progress", "done"]
+    },
+    "project_id": {
+      "type": ["integer", "null"]
+    },
+    "parent_id": {
+      "type": ["integer", "null"]
+    },
+    "recurrence": {
+      "type": ["string", "null"],
+      "format": "rrule"
+    },
+    "priority": {
+      "type": "integer",
+      "minimum": 0,
+      "maximum": 4
+    },
+    "assignee_id": {
+      "type": ["integer", "null"]
+    },
+    "estimate": {
+      "type": ["integer", "null"],
+      "minimum": 0
+    },
+    "reminders": {
+      "type": ["array", "null"],
+      "items": {
+        "type": "integer",
+        "minimum": 0
+      }
+    }
+  },
+  "additionalProperties": false
+}`
+
+const ProjectCreateSchema = `{
+  "title": "Project Create Schema",
+  "type": "object",
+  "properties": {
+    "name": {
+      "type": "string",
+      "minLength": 1
+    },
+    "desc": {
+      "type": "string"
+    },
+    "archived": {
+      "type": "boolean"
+    }
+  },
+  "required": ["name"],
+  "additionalProperties": false
+}`
+
+const ProjectUpdateSchema = `{
+  "title": "Project Update Schema",
+  "type": "object",
+  "properties": {
+    "name": {
+      "type": "string",
+      "minLength": 1
+    },
+    "desc": {
+      "type": "string"
+    },
+    "archived": {
+      "type": "boolean"
+    }
+  },
+  "additionalProperties": false
+}`
+
+const UserCreateSchema = `{
+  "title": "User Create Schema",
+  "type": "object",
+  "properties": {
+    "name": {
+      "type": "string",
+      "minLength": 1
+    },
+    "email": {
+      "type": "string",
+      "format": "email"
     }
   },
+  "required": ["name"],
   "additionalProperties": false
 }`
 
+const UserUpdateSchema = `{
+  "title": "User Update Schema",
+  "type": "object",
+  "properties": {
+    "name": {
+      "type": "string",
+      "minLength": 1
+    },
+    "email": {
+      "type": "string",
+      "format": "email"
+    }
+  },
+  "additionalProperties": false
+}`
+
+const CommentSchema = `{
+  "title": "Comment Schema",
+  "type": "object",
+  "properties": {
+    "body": {
+      "type": "string",
+      "minLength": 1
+    }
+  },
+  "required": ["body"],
+  "additionalProperties": false
+}`
+
+const QuickAddSchema = `{
+  "title": "Quick Add Schema",
+  "type": "object",
+  "properties": {
+    "text": {
+      "type": "string",
+      "minLength": 1,
+      "maxLength": 1000
+    }
+  },
+  "required": ["text"],
+  "additionalProperties": false
+}`
+
+const WorklogCreateSchema = `{
+  "title": "Worklog Create Schema",
+  "type": "object",
+  "properties": {
+    "started": {
+      "type": "string",
+      "format": "rfc3339"
+    },
+    "ended": {
+      "type": "string",
+      "format": "rfc3339"
+    },
+    "note": {
+      "type": "string"
+    }
+  },
+  "required": ["started", "ended"],
+  "additionalProperties": false
+}`
+
+const WorklogUpdateSchema = `{
+  "title": "Worklog Update Schema",
+  "type": "object",
+  "properties": {
+    "started": {
+      "type": "string",
+      "format": "rfc3339"
+    },
+    "ended": {
+      "type": "string",
+      "format": "rfc3339"
+    },
+    "note": {
+      "type": "string"
+    }
+  },
+  "additionalProperties": false
+}`
+
+const FieldCreateSchema = `{
+  "title": "Field Create Schema",
+  "type": "object",
+  "properties": {
+    "name": {
+      "type": "string",
+      "pattern": "^[a-z][a-z0-9_]*$"
+    },
+    "type": {
+      "type": "string",
+      "enum": ["string", "number", "bool", "date", "enum"]
+    },
+    "required": {
+      "type": "boolean"
+    },
+    "options": {
+      "type": "array",
+      "items": {
+        "type": "string"
+      },
+      "uniqueItems": true
+    }
+  },
+  "required": ["name", "type"],
+  "additionalProperties": false
+}`
+
+const FieldUpdateSchema = `{
+  "title": "Field Update Schema",
+  "type": "object",
+  "properties": {
+    "required": {
+      "type": "boolean"
+    },
+    "options": {
+      "type": "array",
+      "items": {
+        "type": "string"
+      },
+      "uniqueItems": true
+    }
+  },
+  "additionalProperties": false
+}`
+
+const DependencySchema = `{
+  "title": "Dependency Schema",
+  "type": "object",
+  "properties": {
+    "blocker_id": {
+      "type": "integer"
+    }
+  },
+  "required": ["blocker_id"],
+  "additionalProperties": false
+}`
+
+// TodoSchema extends base, CreateSchema or UpdateSchema, with a custom_fields
+// property describing fields. With create set, required fields must be given.
+func TodoSchema(base string, fields FieldList, create bool) (*gojsonschema.Schema, error) {
+	var schema map[string]interface{}
+	if err := json.Unmarshal([]byte(base), &schema); err != nil {
+		return nil, err
+	}
+
+	properties := map[string]interface{}{}
+	required := []interface{}{}
+
+	for _, f := range fields {
+		properties[f.Name] = f.schema()
+		if f.Required {
+			required = append(required, f.Name)
+		}
+	}
+
+	customFields := map[string]interface{}{
+		"type":                 []interface{}{"object", "null"},
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+
+	if create && len(required) > 0 {
+		customFields["type"] = "object"
+		customFields["required"] = required
+		schema["required"] = append(schema["required"].([]interface{}), "custom_fields")
+	}
+
+	schema["properties"].(map[string]interface{})["custom_fields"] = customFields
+
+	return gojsonschema.NewSchema(gojsonschema.NewGoLoader(schema))
+}
+
 func init() {
 	gojsonschema.FormatCheckers.Add("rfc3339", RFC3339FormatChecker{})
+	gojsonschema.FormatCheckers.Add("rfc3339-or-date", DueFormatChecker{})
+	gojsonschema.FormatCheckers.Add("timezone", TimezoneFormatChecker{})
+	gojsonschema.FormatCheckers.Add("rrule", RRuleFormatChecker{})
 }
 
 var CreateValidator = gojsonschema.NewStringLoader(CreateSchema)
@@ -72,3 +382,48 @@ func (f RFC3339FormatChecker) IsFormat(input interface{}) bool {
 
 	return false
 }
+
+// DueFormatChecker accepts an RFC-3339 datetime or a date without a time.
+type DueFormatChecker struct{}
+
+func (f DueFormatChecker) IsFormat(input interface{}) bool {
+	asString, ok := input.(string)
+	if !ok {
+		return false
+	}
+
+	if _, err := time.Parse(dayFormat, asString); err == nil {
+		return true
+	}
+
+	return RFC3339FormatChecker{}.IsFormat(input)
+}
+
+// TimezoneFormatChecker accepts IANA time zone names such as Europe/Paris.
+type TimezoneFormatChecker struct{}
+
+func (f TimezoneFormatChecker) IsFormat(input interface{}) bool {
+	asString, ok := input.(string)
+	if !ok {
+		return false
+	}
+
+	_, err := query.LoadLocation(asString)
+	return err == nil && asString != "Local"
+}
+
+type RRuleFormatChecker struct{}
+
+func (f RRuleFormatChecker) IsFormat(input interface{}) bool {
+	asString, ok := input.(string)
+	if !ok {
+		return false
+	}
+
+	if asString == "" {
+		return true
+	}
+
+	_, err := rrule.Parse(asString)
+	return err == nil
+}
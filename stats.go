@@ -0,0 +1,170 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/query"
+	"github.com/marcgwilson/todo/state"
+
+	"time"
+)
+
+// DayCount is the number of todos completed on a day.
+type DayCount struct {
+	Date  string `json:"date"`
+	Count int64  `json:"count"`
+}
+
+type Stats struct {
+	Total   int64                 `json:"total"`
+	States  map[state.State]int64 `json:"states"`
+	Overdue int64                 `json:"overdue"`
+	// Completed counts the todos completed on each day of the requested range.
+	Completed []*DayCount `json:"completed"`
+	// AverageCompletion is the mean number of seconds from creation to done.
+	// It is nil when no todo has been completed.
+	AverageCompletion *float64                 `json:"average_completion"`
+	Groups            map[string][]*GroupCount `json:"groups"`
+}
+
+// Stats summarizes the todos matching filter. Completions are counted per day
+// from the day of from through the day of to, and groups names attributes of
+// query.GroupColumns to count todos by.
+func (r *TodoManager) Stats(filter *query.Query, from time.Time, to time.Time, groups []string, now time.Time) (*Stats, error) {
+	with := "WITH filtered AS (SELECT rowid AS id, * FROM todo" + filter.Clause() + ") "
+	values := func(v ...interface{}) []interface{} {
+		return append(append([]interface{}{}, filter.Values()...), v...)
+	}
+
+	stats := &Stats{
+		States:    map[state.State]int64{},
+		Completed: []*DayCount{},
+		Groups:    map[string][]*GroupCount{},
+	}
+
+	for _, s := range state.States {
+		stats.States[s] = 0
+	}
+
+	rows, err := r.Database.Query(with+"SELECT state, COUNT(*) FROM filtered GROUP BY state;", values()...)
+	if err != nil {
+		return nil, err
+	}
+
+	for rows.Next() {
+		var s state.State
+		var count int64
+		if err = rows.Scan(&s, &count); err != nil {
+			rows.Close()
+			return nil, err
+		}
+		stats.States[s] = count
+		stats.Total += count
+	}
+
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	row := r.Database.QueryRow(with+"SELECT COUNT(*) FROM filtered WHERE state != ? AND due < ?;", values(state.Done, query.Timestamp(now))...)
+	if err = row.Scan(&stats.Overdue); err != nil {
+		return nil, err
+	}
+
+	if stats.Completed, err = r.completedPerDay(with, values, from, to); err != nil {
+		return nil, err
+	}
+
+	if stats.AverageCompletion, err = r.averageCompletion(with, values); err != nil {
+		return nil, err
+	}
+
+	for _, group := range groups {
+		if stats.Groups[group], err = r.groupCounts(with, values, query.GroupColumns[group]); err != nil {
+			return nil, err
+		}
+	}
+
+	return stats, nil
+}
+
+func (r *TodoManager) completedPerDay(with string, values func(...interface{}) []interface{}, from time.Time, to time.Time) ([]*DayCount, error) {
+	from = time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, time.UTC)
+	to = time.Date(to.Year(), to.Month(), to.Day(), 0, 0, 0, 0, time.UTC)
+
+	results := []*DayCount{}
+	index := map[string]*DayCount{}
+
+	for day := from; !day.After(to); day = day.AddDate(0, 0, 1) {
+		dc := &DayCount{Date: day.Format("2006-01-02")}
+		results = append(results, dc)
+		index[dc.Date] = dc
+	}
+
+	rows, err := r.Database.Query(with+"SELECT completed FROM filtered WHERE completed >= ? AND completed < ?;", values(query.Timestamp(from), query.Timestamp(to.AddDate(0, 0, 1)))...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var completed time.Time
+		if err = rows.Scan(&completed); err != nil {
+			return nil, err
+		}
+		if dc, ok := index[completed.UTC().Format("2006-01-02")]; ok {
+			dc.Count++
+		}
+	}
+
+	return results, rows.Err()
+}
+
+func (r *TodoManager) averageCompletion(with string, values func(...interface{}) []interface{}) (*float64, error) {
+	rows, err := r.Database.Query(with+"SELECT created, completed FROM filtered WHERE state = ? AND created IS NOT NULL AND completed IS NOT NULL;", values(state.Done)...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var total float64
+	var count int64
+
+	for rows.Next() {
+		var created, completed time.Time
+		if err = rows.Scan(&created, &completed); err != nil {
+			return nil, err
+		}
+		total += completed.Sub(created).Seconds()
+		count++
+	}
+
+	if err = rows.Err(); err != nil || count == 0 {
+		return nil, err
+	}
+
+	average := total / float64(count)
+	return &average, nil
+}
+
+func (r *TodoManager) groupCounts(with string, values func(...interface{}) []interface{}, column string) ([]*GroupCount, error) {
+	rows, err := r.Database.Query(with+"SELECT "+column+", COUNT(*) FROM filtered GROUP BY "+column+" ORDER BY "+column+";", values()...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := []*GroupCount{}
+
+	for rows.Next() {
+		gc := &GroupCount{}
+		if err = rows.Scan(&gc.Key, &gc.Count); err != nil {
+			return nil, err
+		}
+		if b, ok := gc.Key.([]byte); ok {
+			gc.Key = string(b)
+		}
+		results = append(results, gc)
+	}
+
+	return results, rows.Err()
+}
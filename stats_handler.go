@@ -0,0 +1,96 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/apierror"
+	"github.com/marcgwilson/todo/query"
+
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// maxStatsDays bounds the range of the completion histogram.
+const maxStatsDays = 366
+
+// parseStatsValues parses the from and to dates of the completion histogram,
+// defaulting to the 30 days ending today, and the group_by attributes,
+// defaulting to all of query.GroupColumns.
+func parseStatsValues(values url.Values, now time.Time) (time.Time, time.Time, []string, *apierror.Error) {
+	errors := []*apierror.ErrorDetail{}
+
+	to := now.UTC()
+	if value := values.Get("to"); value != "" {
+		if t, err := time.Parse("2006-01-02", value); err != nil {
+			errors = append(errors, &apierror.ErrorDetail{Key: "to", Value: value, Message: "value must be a date formatted as YYYY-MM-DD"})
+		} else {
+			to = t
+		}
+	}
+
+	from := to.AddDate(0, 0, -29)
+	if value := values.Get("from"); value != "" {
+		if t, err := time.Parse("2006-01-02", value); err != nil {
+			errors = append(errors, &apierror.ErrorDetail{Key: "from", Value: value, Message: "value must be a date formatted as YYYY-MM-DD"})
+		} else {
+			from = t
+		}
+	}
+
+	if len(errors) == 0 && (from.After(to) || to.Sub(from) >= maxStatsDays*24*time.Hour) {
+		errors = append(errors, &apierror.ErrorDetail{Key: "from", Value: values.Get("from"), Message: "range must span between 1 and 366 days"})
+	}
+
+	groups := []string{}
+	if value := values.Get("group_by"); value != "" {
+		for _, group := range strings.Split(value, ",") {
+			if _, ok := query.GroupColumns[group]; !ok {
+				errors = append(errors, &apierror.ErrorDetail{Key: "group_by", Value: value, Message: "value must be a comma separated list of " + strings.Join(query.GroupNames(), ", ")})
+				break
+			}
+			groups = append(groups, group)
+		}
+	} else {
+		groups = query.GroupNames()
+	}
+
+	if len(errors) > 0 {
+		return from, to, nil, &apierror.Error{Code: http.StatusBadRequest, Message: "Invalid query parameters", Errors: errors}
+	}
+
+	return from, to, groups, nil
+}
+
+// StatsFunc summarizes the todos matching the list filters.
+func (r *Handler) StatsFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		now := time.Now()
+
+		values := req.URL.Query()
+
+		from, to, groups, ae := parseStatsValues(values, now)
+		if ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		values.Del("group_by")
+
+		result, ae := r.parseValues(req, values)
+		if ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		result.Depaginate()
+		delete(result.Params(), "sort")
+
+		if stats, err := r.TM.Stats(result.Query(), from, to, groups, now); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+		} else {
+			writeJSON(w, http.StatusOK, stats)
+		}
+	}
+}
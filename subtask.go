@@ -0,0 +1,94 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/state"
+
+	"database/sql"
+)
+
+// checkParent verifies that parent refers to an existing todo and that making
+// it the parent of the todo identified by id would not create a cycle. An id
+// of 0 denotes a todo that has not been created yet.
+func (r *TodoManager) checkParent(id int64, parent interface{}) error {
+	if parent == nil {
+		return nil
+	}
+
+	current := parent.(int64)
+
+	next, err := r.parentOf(current)
+	if err == sql.ErrNoRows {
+		return ErrParentNotFound
+	} else if err != nil {
+		return err
+	}
+
+	for {
+		if current == id {
+			return ErrParentCycle
+		}
+
+		if !next.Valid {
+			return nil
+		}
+
+		current = next.Int64
+
+		if next, err = r.parentOf(current); err == sql.ErrNoRows {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+func (r *TodoManager) parentOf(id int64) (sql.NullInt64, error) {
+	var parent sql.NullInt64
+
+	row := r.Database.QueryRow("SELECT parent_id FROM todo WHERE rowid = ?", id)
+	err := row.Scan(&parent)
+	return parent, err
+}
+
+func (r *TodoManager) openChildren(id int64) (int64, error) {
+	var count int64
+
+	row := r.Database.QueryRow("SELECT COUNT(*) FROM todo WHERE parent_id = ? AND state != ?", id, state.Done)
+	err := row.Scan(&count)
+	return count, err
+}
+
+// loadProgress sets Progress on every todo in list that has children.
+func (r *TodoManager) loadProgress(list TodoList) error {
+	if len(list) == 0 {
+		return nil
+	}
+
+	index := map[int64]*Todo{}
+	ids := make([]int64, len(list), len(list))
+	for i, t := range list {
+		index[t.ID] = t
+		ids[i] = t.ID
+	}
+
+	bindvars, values := inClause(ids)
+	values = append([]interface{}{state.Done}, values...)
+
+	rows, err := r.Database.Query("SELECT parent_id, COUNT(*), SUM(state = ?) FROM todo WHERE parent_id IN "+bindvars+" GROUP BY parent_id", values...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var parentID, total, done int64
+		if err = rows.Scan(&parentID, &total, &done); err != nil {
+			return err
+		}
+
+		progress := float64(done) / float64(total)
+		index[parentID].Progress = &progress
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/query"
+
+	"time"
+)
+
+// Location returns the time zone of r, falling back to UTC for a zone that
+// is unknown to this system.
+func (r *Todo) Location() *time.Location {
+	if loc, err := query.LoadLocation(r.Timezone); err == nil {
+		return loc
+	}
+	return time.UTC
+}
+
+// endOfDay returns the last second of the day in loc.
+func endOfDay(year int, month time.Month, day int, loc *time.Location) time.Time {
+	return time.Date(year, month, day, 23, 59, 59, 0, loc)
+}
+
+// localizeDue resolves the due date of d, transformed data for the todo prev
+// or for a new todo when prev is nil, against its time zone. A date without a
+// time makes the todo all-day unless all_day is given. The due date of an
+// all-day todo is moved to the end of its day, keeping the day when only the
+// time zone changes.
+func localizeDue(d TodoMap, prev *Todo) error {
+	var due interface{}
+	var allDay bool
+	loc := time.UTC
+
+	if prev != nil {
+		allDay, loc = prev.AllDay, prev.Location()
+		if prev.Due != nil {
+			due = *prev.Due
+		}
+	}
+
+	newDue, hasDue := d["due"]
+	tz, hasTimezone := d["timezone"]
+	newAllDay, hasAllDay := d["all_day"]
+
+	if hasTimezone {
+		var err error
+		if loc, err = query.LoadLocation(tz.(string)); err != nil {
+			return err
+		}
+	}
+
+	if hasAllDay {
+		allDay = newAllDay.(bool)
+	}
+
+	if !hasDue && !hasAllDay && !(hasTimezone && allDay) {
+		return nil
+	}
+
+	if hasDue {
+		switch v := newDue.(type) {
+		case civilDate:
+			if !hasAllDay {
+				allDay = true
+				d["all_day"] = true
+			}
+			if allDay {
+				d["due"] = endOfDay(v.year, v.month, v.day, loc)
+			} else {
+				d["due"] = time.Date(v.year, v.month, v.day, 0, 0, 0, 0, loc)
+			}
+			return nil
+		case time.Time:
+			due = v.In(loc)
+		default:
+			due = nil
+		}
+	}
+
+	if t, ok := due.(time.Time); ok && allDay {
+		year, month, day := t.Date()
+		d["due"] = endOfDay(year, month, day, loc)
+	}
+
+	return nil
+}
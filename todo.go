@@ -1,6 +1,7 @@
 package main
 
 import (
+	"github.com/marcgwilson/todo/notify"
 	"github.com/marcgwilson/todo/query"
 	"github.com/marcgwilson/todo/state"
 
@@ -8,6 +9,7 @@ import (
 
 	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"time"
@@ -16,14 +18,144 @@ import (
 var CreateStmt = `CREATE TABLE IF NOT EXISTS todo (
     desc TEXT,
     due TIMESTAMP,
-    state TEXT
+    state TEXT,
+    project_id INTEGER,
+    parent_id INTEGER,
+    recurrence TEXT,
+    priority INTEGER DEFAULT 2,
+    assignee_id INTEGER,
+    estimate INTEGER,
+    created TIMESTAMP,
+    completed TIMESTAMP,
+    timezone TEXT DEFAULT '',
+    all_day BOOLEAN DEFAULT 0,
+    modified TIMESTAMP,
+    archived BOOLEAN DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS reminder (
+    todo_id INTEGER,
+    offset_seconds INTEGER,
+    UNIQUE(todo_id, offset_seconds)
+);
+CREATE TABLE IF NOT EXISTS notification (
+    todo_id INTEGER,
+    kind TEXT,
+    offset_seconds INTEGER DEFAULT 0,
+    due TIMESTAMP,
+    sent TIMESTAMP,
+    UNIQUE(todo_id, kind, offset_seconds, due)
+);
+CREATE TABLE IF NOT EXISTS digest_sent (
+    user_id INTEGER,
+    day TEXT,
+    sent TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS project (
+    name TEXT,
+    desc TEXT DEFAULT '',
+    archived BOOLEAN DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS user (
+    name TEXT UNIQUE,
+    email TEXT DEFAULT '',
+    feed_token TEXT DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS comment (
+    todo_id INTEGER,
+    author_id INTEGER,
+    body TEXT,
+    created TIMESTAMP,
+    edited TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS attachment (
+    todo_id INTEGER,
+    name TEXT,
+    content_type TEXT,
+    size INTEGER,
+    hash TEXT,
+    created TIMESTAMP
+);
+CREATE TABLE IF NOT EXISTS worklog (
+    todo_id INTEGER,
+    user_id INTEGER,
+    started TIMESTAMP,
+    ended TIMESTAMP,
+    note TEXT DEFAULT ''
+);
+CREATE UNIQUE INDEX IF NOT EXISTS worklog_running ON worklog(user_id) WHERE ended IS NULL;
+CREATE TABLE IF NOT EXISTS field (
+    name TEXT UNIQUE,
+    type TEXT,
+    required BOOLEAN DEFAULT 0,
+    options TEXT DEFAULT ''
+);
+CREATE TABLE IF NOT EXISTS field_value (
+    todo_id INTEGER,
+    field_id INTEGER,
+    value,
+    UNIQUE(todo_id, field_id)
+);
+CREATE TABLE IF NOT EXISTS dependency (
+    todo_id INTEGER,
+    blocker_id INTEGER,
+    UNIQUE(todo_id, blocker_id)
+);
+CREATE TABLE IF NOT EXISTS calendar_object (
+    todo_id INTEGER UNIQUE,
+    name TEXT UNIQUE,
+    uid TEXT
 );`
 
+var (
+	ErrProjectNotFound = errors.New("project does not exist")
+	ErrProjectArchived = errors.New("project is archived")
+	ErrParentNotFound  = errors.New("parent todo does not exist")
+	ErrParentCycle     = errors.New("todo cannot be its own ancestor")
+	ErrOpenSubtasks    = errors.New("todo has open subtasks")
+	ErrBlockerNotFound = errors.New("blocking todo does not exist")
+	ErrDependencyCycle = errors.New("dependency would create a cycle")
+	ErrBlocked         = errors.New("todo is blocked by open todos")
+	ErrUserNotFound    = errors.New("user does not exist")
+	ErrUserExists      = errors.New("user name is already taken")
+)
+
+const todoColumns = "rowid, desc, due, state, project_id, parent_id, recurrence, priority, assignee_id, estimate, created, completed, timezone, all_day, modified, archived"
+
 type Todo struct {
 	ID          int64       `db:"id" json:"id"`
 	Description string      `db:"desc" json:"desc"`
-	Due         time.Time   `db:"due" json:"due"`
+	Due         *time.Time  `db:"due" json:"due"`
 	State       state.State `db:"state" json:"state"`
+	ProjectID   *int64      `db:"project_id" json:"project_id"`
+	ParentID    *int64      `db:"parent_id" json:"parent_id"`
+	Recurrence  string      `db:"recurrence" json:"recurrence"`
+	Priority    int64       `db:"priority" json:"priority"`
+	AssigneeID  *int64      `db:"assignee_id" json:"assignee_id"`
+	// Estimate is the expected effort in seconds.
+	Estimate *int64 `db:"estimate" json:"estimate"`
+	// Created, Completed and Modified are set by the server when the todo is
+	// created, when it moves to state.Done and whenever it changes.
+	Created   *time.Time `db:"created" json:"created"`
+	Completed *time.Time `db:"completed" json:"completed"`
+	Modified  *time.Time `db:"modified" json:"modified"`
+	// Timezone is the IANA name of the zone Due is given in, UTC when empty.
+	// The due date of an AllDay todo is the last second of its day there.
+	Timezone string `db:"timezone" json:"timezone"`
+	AllDay   bool   `db:"all_day" json:"all_day"`
+	// Archived todos belonged to a deleted project and are left out of lists
+	// unless archived=true is given.
+	Archived bool `db:"archived" json:"archived"`
+	// Progress is the fraction of children in state.Done. It is nil for
+	// todos without children.
+	Progress  *float64 `db:"-" json:"progress,omitempty"`
+	BlockedBy []int64  `db:"-" json:"blocked_by"`
+	Blocks    []int64  `db:"-" json:"blocks"`
+	// Reminders are offsets in seconds before Due at which a reminder is sent.
+	Reminders []int64 `db:"-" json:"reminders"`
+	// TimeSpent is the number of seconds logged on the todo and its subtasks.
+	TimeSpent int64 `db:"-" json:"time_spent"`
+	// CustomFields holds the values of user-defined fields by name.
+	CustomFields map[string]interface{} `db:"-" json:"custom_fields"`
 }
 
 func (r *Todo) Equal(t *Todo) bool {
@@ -35,7 +167,7 @@ func (r *Todo) Equal(t *Todo) bool {
 		return false
 	}
 
-	if !r.Due.Equal(t.Due) {
+	if !equalTime(r.Due, t.Due) {
 		return false
 	}
 
@@ -43,9 +175,59 @@ func (r *Todo) Equal(t *Todo) bool {
 		return false
 	}
 
+	if !equalID(r.ProjectID, t.ProjectID) {
+		return false
+	}
+
+	if !equalID(r.ParentID, t.ParentID) {
+		return false
+	}
+
+	if r.Recurrence != t.Recurrence {
+		return false
+	}
+
+	if r.Priority != t.Priority {
+		return false
+	}
+
+	if !equalID(r.AssigneeID, t.AssigneeID) {
+		return false
+	}
+
+	if !equalID(r.Estimate, t.Estimate) {
+		return false
+	}
+
+	if r.Timezone != t.Timezone {
+		return false
+	}
+
+	if r.AllDay != t.AllDay {
+		return false
+	}
+
+	if r.Archived != t.Archived {
+		return false
+	}
+
 	return true
 }
 
+func equalID(a, b *int64) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return *a == *b
+}
+
+func equalTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
 type TodoList []*Todo
 
 func (r TodoList) Equal(t TodoList) bool {
@@ -74,8 +256,8 @@ func UnmarshalTodo(b []byte) (*Todo, error) {
 	return t, nil
 }
 
-func NewTodo(desc string, due time.Time, s state.State) *Todo {
-	return &Todo{-1, desc, due, s}
+func NewTodo(desc string, due *time.Time, s state.State) *Todo {
+	return &Todo{ID: -1, Description: desc, Due: due, State: s}
 }
 
 func UnmarshalTodoList(b []byte) (TodoList, error) {
@@ -86,12 +268,80 @@ func UnmarshalTodoList(b []byte) (TodoList, error) {
 	return t, nil
 }
 
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func scanTodo(s scanner) (*Todo, error) {
+	var projectID sql.NullInt64
+	var parentID sql.NullInt64
+	var recurrence sql.NullString
+	var assigneeID sql.NullInt64
+	var estimate sql.NullInt64
+	var created sql.NullTime
+	var due sql.NullTime
+	var completed sql.NullTime
+	var timezone sql.NullString
+	var allDay sql.NullBool
+	var modified sql.NullTime
+	var archived sql.NullBool
+
+	t := &Todo{}
+	if err := s.Scan(&t.ID, &t.Description, &due, &t.State, &projectID, &parentID, &recurrence, &t.Priority, &assigneeID, &estimate, &created, &completed, &timezone, &allDay, &modified, &archived); err != nil {
+		return nil, err
+	}
+
+	t.Timezone = timezone.String
+	t.AllDay = allDay.Bool
+	t.Archived = archived.Bool
+	if due.Valid {
+		local := due.Time.In(t.Location())
+		t.Due = &local
+	}
+
+	t.Recurrence = recurrence.String
+
+	if projectID.Valid {
+		t.ProjectID = &projectID.Int64
+	}
+
+	if parentID.Valid {
+		t.ParentID = &parentID.Int64
+	}
+
+	if assigneeID.Valid {
+		t.AssigneeID = &assigneeID.Int64
+	}
+
+	if estimate.Valid {
+		t.Estimate = &estimate.Int64
+	}
+
+	if created.Valid {
+		t.Created = &created.Time
+	}
+
+	if completed.Valid {
+		t.Completed = &completed.Time
+	}
+
+	if modified.Valid {
+		t.Modified = &modified.Time
+	}
+
+	return t, nil
+}
+
 type TodoManager struct {
 	Database *sql.DB
+	Config   *Config
+	// Notifier, if set, is told about todos changed through NotifyChanged.
+	Notifier notify.Notifier
+	pending  chan struct{}
 }
 
-func NewManager(db *sql.DB) *TodoManager {
-	return &TodoManager{db}
+func NewManager(db *sql.DB, config *Config) *TodoManager {
+	return &TodoManager{Database: db, Config: config, pending: make(chan struct{}, maxPendingChanges)}
 }
 
 func (r *TodoManager) Get(id int64) (*Todo, error) {
@@ -99,28 +349,48 @@ func (r *TodoManager) Get(id int64) (*Todo, error) {
 	var row *sql.Row
 	var err error
 
-	if stmt, err = r.Database.Prepare("SELECT rowid, desc, due, state FROM todo WHERE rowid = ?"); err != nil {
+	if stmt, err = r.Database.Prepare("SELECT " + todoColumns + " FROM todo WHERE rowid = ?"); err != nil {
 		return nil, err
 	}
 	defer stmt.Close()
 
-	t := &Todo{}
 	row = stmt.QueryRow(id)
 
-	err = row.Scan(&t.ID, &t.Description, &t.Due, &t.State)
+	t, err := scanTodo(row)
 	if err != nil {
 		return nil, err
 	}
 
+	if err = r.decorate(TodoList{t}); err != nil {
+		return nil, err
+	}
+
 	return t, nil
 }
 
+// decorate fills in the computed attributes of each todo in list.
+func (r *TodoManager) decorate(list TodoList) error {
+	if err := r.loadProgress(list); err != nil {
+		return err
+	}
+	if err := r.loadDependencies(list); err != nil {
+		return err
+	}
+	if err := r.loadTimeSpent(list); err != nil {
+		return err
+	}
+	if err := r.loadCustomFields(list); err != nil {
+		return err
+	}
+	return r.loadReminders(list)
+}
+
 func (r *TodoManager) Query(filter *query.Query) (TodoList, error) {
 	var stmt *sql.Stmt
 	var rows *sql.Rows
 	var err error
 
-	q := "SELECT rowid, desc, due, state FROM todo" + filter.Query()
+	q := "SELECT " + todoColumns + " FROM todo" + filter.Query()
 
 	log.Println(q)
 	log.Printf("%#v\n", filter.Values())
@@ -139,8 +409,8 @@ func (r *TodoManager) Query(filter *query.Query) (TodoList, error) {
 	results := []*Todo{}
 
 	for rows.Next() {
-		t := &Todo{}
-		if err = rows.Scan(&t.ID, &t.Description, &t.Due, &t.State); err != nil {
+		var t *Todo
+		if t, err = scanTodo(rows); err != nil {
 			return nil, err
 		}
 
@@ -151,6 +421,10 @@ func (r *TodoManager) Query(filter *query.Query) (TodoList, error) {
 		return nil, err
 	}
 
+	if err = r.decorate(results); err != nil {
+		return nil, err
+	}
+
 	return results, nil
 }
 
@@ -176,6 +450,13 @@ func (r *TodoManager) Count(filter *query.Query) (int64, error) {
 }
 
 func (r *TodoManager) Create(data map[string]interface{}) (*Todo, error) {
+	return r.create(data, nil)
+}
+
+// create inserts a todo and, when fn is not nil, calls fn with the id of the
+// todo in the same transaction so that the todo is only created if fn
+// succeeds.
+func (r *TodoManager) create(data map[string]interface{}, fn func(tx *sql.Tx, id int64) error) (*Todo, error) {
 	var err error
 	var id int64
 	var result sql.Result
@@ -188,6 +469,36 @@ func (r *TodoManager) Create(data map[string]interface{}) (*Todo, error) {
 		return nil, err
 	}
 
+	if _, ok := d["priority"]; !ok {
+		d["priority"] = r.Config.Priority
+	}
+
+	if err = localizeDue(d, nil); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	d["created"] = now
+	d["modified"] = now
+	if d["state"] == string(state.Done) {
+		d["completed"] = now
+	}
+
+	if err = r.checkProject(d["project_id"]); err != nil {
+		return nil, err
+	}
+
+	if err = r.checkParent(0, d["parent_id"]); err != nil {
+		return nil, err
+	}
+
+	if err = r.checkAssignee(d["assignee_id"]); err != nil {
+		return nil, err
+	}
+
+	reminders, hasReminders := d.Pop("reminders")
+	customFields, hasCustomFields := d.Pop("custom_fields")
+
 	insert := d.InsertVars()
 	sql := fmt.Sprintf("INSERT INTO todo(%s) VALUES(%s)", insert.Names, insert.Bindvars)
 
@@ -209,16 +520,38 @@ func (r *TodoManager) Create(data map[string]interface{}) (*Todo, error) {
 		return nil, err
 	}
 
+	if hasReminders {
+		if err = setReminders(tx, id, reminders.([]int64)); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if hasCustomFields {
+		if err = setCustomFields(tx, id, customFields.(map[string]interface{})); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
+	if fn != nil {
+		if err = fn(tx, id); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
+
 	if err = tx.Commit(); err != nil {
 		return nil, err
 	}
 
-	return &Todo{id, d.Description(), d.Due(), d.State()}, nil
+	return r.Get(id)
 }
 
 func (r *TodoManager) Update(id int64, data map[string]interface{}) (*Todo, error) {
 	var err error
 	var todo *Todo
+	var prev *Todo
 	var tx *sql.Tx
 	var stmt *sql.Stmt
 
@@ -228,6 +561,56 @@ func (r *TodoManager) Update(id int64, data map[string]interface{}) (*Todo, erro
 		return nil, err
 	}
 
+	if prev, err = r.Get(id); err != nil {
+		return nil, err
+	}
+
+	if err = localizeDue(d, prev); err != nil {
+		return nil, err
+	}
+
+	if err = r.checkProject(d["project_id"]); err != nil {
+		return nil, err
+	}
+
+	if err = r.checkParent(id, d["parent_id"]); err != nil {
+		return nil, err
+	}
+
+	if err = r.checkAssignee(d["assignee_id"]); err != nil {
+		return nil, err
+	}
+
+	if d["state"] == string(state.InProgress) {
+		if open, err := r.openBlockers(id); err != nil {
+			return nil, err
+		} else if open > 0 {
+			return nil, ErrBlocked
+		}
+	}
+
+	if d["state"] == string(state.Done) && r.Config.StrictSubtasks {
+		if open, err := r.openChildren(id); err != nil {
+			return nil, err
+		} else if open > 0 {
+			return nil, ErrOpenSubtasks
+		}
+	}
+
+	now := time.Now().UTC()
+	d["modified"] = now
+
+	if s, ok := d["state"]; ok {
+		if s != string(state.Done) {
+			d["completed"] = nil
+		} else if prev.State != state.Done {
+			d["completed"] = now
+		}
+	}
+
+	reminders, hasReminders := d.Pop("reminders")
+	customFields, hasCustomFields := d.Pop("custom_fields")
+
 	update := d.UpdateVars()
 	query := fmt.Sprintf("UPDATE todo SET %s WHERE rowid = ?;", update.Bindvars)
 
@@ -235,16 +618,34 @@ func (r *TodoManager) Update(id int64, data map[string]interface{}) (*Todo, erro
 		return nil, err
 	}
 
-	if stmt, err = tx.Prepare(query); err != nil {
-		return nil, err
+	if len(d) > 0 {
+		if stmt, err = tx.Prepare(query); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+
+		defer stmt.Close()
+
+		values := update.Values
+		values = append(values, id)
+		if _, err = stmt.Exec(values...); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
 	}
 
-	defer stmt.Close()
+	if hasReminders {
+		if err = setReminders(tx, id, reminders.([]int64)); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
+	}
 
-	values := update.Values
-	values = append(values, id)
-	if _, err = stmt.Exec(values...); err != nil {
-		return nil, err
+	if hasCustomFields {
+		if err = setCustomFields(tx, id, customFields.(map[string]interface{})); err != nil {
+			tx.Rollback()
+			return nil, err
+		}
 	}
 
 	if err = tx.Commit(); err != nil {
@@ -255,9 +656,55 @@ func (r *TodoManager) Update(id int64, data map[string]interface{}) (*Todo, erro
 		return nil, err
 	}
 
+	if prev.State != state.Done && todo.State == state.Done && todo.Recurrence != "" {
+		if _, err = r.spawnNext(todo); err != nil {
+			log.Printf("ERROR: spawning next occurrence of todo %d: %s", todo.ID, err)
+		}
+	}
+
 	return todo, nil
 }
 
+func (r *TodoManager) checkProject(id interface{}) error {
+	if id == nil {
+		return nil
+	}
+
+	var archived bool
+
+	row := r.Database.QueryRow("SELECT archived FROM project WHERE rowid = ?", id)
+	if err := row.Scan(&archived); err == sql.ErrNoRows {
+		return ErrProjectNotFound
+	} else if err != nil {
+		return err
+	}
+
+	if archived {
+		return ErrProjectArchived
+	}
+
+	return nil
+}
+
+func (r *TodoManager) checkAssignee(id interface{}) error {
+	if id == nil {
+		return nil
+	}
+
+	var count int64
+
+	row := r.Database.QueryRow("SELECT COUNT(*) FROM user WHERE rowid = ?", id)
+	if err := row.Scan(&count); err != nil {
+		return err
+	}
+
+	if count == 0 {
+		return ErrUserNotFound
+	}
+
+	return nil
+}
+
 func (r *TodoManager) Delete(id int64) error {
 	var err error
 
@@ -268,6 +715,51 @@ func (r *TodoManager) Delete(id int64) error {
 		return err
 	}
 
+	if _, err = tx.Exec("UPDATE todo SET parent_id = NULL WHERE parent_id = ?;", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec("DELETE FROM dependency WHERE todo_id = ? OR blocker_id = ?;", id, id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec("DELETE FROM reminder WHERE todo_id = ?;", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec("DELETE FROM notification WHERE todo_id = ?;", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec("DELETE FROM comment WHERE todo_id = ?;", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec("DELETE FROM attachment WHERE todo_id = ?;", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec("DELETE FROM worklog WHERE todo_id = ?;", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec("DELETE FROM field_value WHERE todo_id = ?;", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec("DELETE FROM calendar_object WHERE todo_id = ?;", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
 	if stmt, err = tx.Prepare("DELETE FROM todo WHERE rowid=?;"); err != nil {
 		return err
 	}
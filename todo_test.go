@@ -11,6 +11,10 @@ import (
 	"time"
 )
 
+func timePtr(t time.Time) *time.Time {
+	return &t
+}
+
 func TestTime(t *testing.T) {
 	t1 := time.Now()
 	t2 := time.Unix(t1.Unix(), 0)
@@ -33,13 +37,14 @@ func TestTodoManager(t *testing.T) {
 
 	defer db.Close()
 
-	tm := &TodoManager{db}
+	tm := NewManager(db, DefaultConfig())
 
 	expected := &Todo{
 		ID:          0,
 		Description: "todo 1",
-		Due:         time.Now().UTC(),
+		Due:         timePtr(time.Now().UTC()),
 		State:       state.Todo,
+		Priority:    tm.Config.Priority,
 	}
 
 	t.Logf("rfc3339: %s", expected.Due.Format(time.RFC3339Nano))
@@ -65,8 +70,9 @@ func TestTodoManager(t *testing.T) {
 	expected2 := &Todo{
 		ID:          0,
 		Description: "todo 2",
-		Due:         time.Now().UTC(),
+		Due:         timePtr(time.Now().UTC()),
 		State:       state.Todo,
+		Priority:    tm.Config.Priority,
 	}
 
 	data2 := map[string]interface{}{
@@ -101,8 +107,9 @@ func TestTodoManager(t *testing.T) {
 	expected3 := &Todo{
 		ID:          0,
 		Description: "todo 3",
-		Due:         time.Now().UTC(),
+		Due:         timePtr(time.Now().UTC()),
 		State:       state.Todo,
+		Priority:    tm.Config.Priority,
 	}
 
 	data3 := map[string]interface{}{
@@ -150,7 +157,7 @@ func TestTodo(t *testing.T) {
 	expected := &Todo{
 		ID:          1,
 		Description: "My Todo",
-		Due:         time.Now(),
+		Due:         timePtr(time.Now()),
 		State:       state.Todo,
 	}
 
@@ -165,3 +172,87 @@ func TestTodo(t *testing.T) {
 		}
 	}
 }
+
+func TestNormalizeTimestamps(t *testing.T) {
+	db, err := OpenDB(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	due := time.Date(2019, 11, 2, 12, 0, 0, 500000000, time.UTC)
+
+	// Rows as stored by earlier versions: in the zone they were given in, with
+	// a trimmed fraction, and as nanoseconds.
+	for _, v := range []interface{}{"2019-11-02 13:00:00.5+01:00", due.UnixNano()} {
+		if _, err = db.Exec("INSERT INTO todo(desc, due, state) VALUES('old', ?, 'todo')", v); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = migrate(db); err != nil {
+		t.Fatal(err)
+	}
+
+	tm := NewManager(db, DefaultConfig())
+
+	filter, ae := query.ParseValues(map[string][]string{"due": {due.Format(time.RFC3339Nano)}})
+	if ae != nil {
+		t.Fatal(spew.Sdump(ae))
+	}
+
+	list, err := tm.Query(filter.Query())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 2 {
+		t.Fatalf("actual: %s", spew.Sdump(list))
+	}
+
+	for _, todo := range list {
+		if !todo.Due.Equal(due) || todo.Due.Location() != time.UTC {
+			t.Errorf("due = %s != %s", todo.Due, due)
+		}
+	}
+}
+
+func TestDeleteUserStopsTimers(t *testing.T) {
+	db, err := OpenDB(":memory:")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer db.Close()
+
+	tm := NewManager(db, DefaultConfig())
+	um := NewUserManager(db)
+
+	u, err := um.Create(map[string]interface{}{"name": "frank"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	todo, err := tm.Create(map[string]interface{}{"desc": "Timed", "state": "todo"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = tm.StartTimer(todo.ID, u.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = um.Delete(u.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := tm.Worklogs(todo.ID)
+	if err != nil || len(list) != 1 {
+		t.Fatalf("worklogs = %v, err = %v", list, err)
+	}
+
+	if w := list[0]; w.UserID != nil || w.Ended == nil {
+		t.Errorf("worklog = %#v", w)
+	}
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/state"
+
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// TodoTxt is a task in the todo.txt format, see
+// https://github.com/todotxt/todo.txt, e.g.
+// "x 2019-11-10 2019-11-01 Call mom +family @phone due:2019-11-12".
+type TodoTxt struct {
+	Done bool
+	// Priority is a letter from A, the most urgent, to Z, or empty.
+	Priority    string
+	Completed   *time.Time
+	Created     *time.Time
+	Description string
+	Projects    []string
+	Contexts    []string
+	// Due is the date of the due: tag.
+	Due *time.Time
+}
+
+// todoTxtPriorities are the letters of priorities 4 to 0.
+const todoTxtPriorities = "EDCBA"
+
+// todoTxtTag returns name as a +project or @context tag, which cannot hold
+// spaces.
+func todoTxtTag(name string) string {
+	return strings.Join(strings.Fields(name), "-")
+}
+
+func localTime(t *time.Time, loc *time.Location) *time.Time {
+	if t == nil {
+		return nil
+	}
+	local := t.In(loc)
+	return &local
+}
+
+func parseTodoTxtDate(word string) (*time.Time, bool) {
+	if t, err := time.Parse(dayFormat, word); err == nil {
+		return &t, true
+	}
+	return nil, false
+}
+
+// ParseTodoTxt parses a line of a todo.txt file. Projects, contexts, the due
+// tag and the pri tag some clients keep on completed tasks are removed from
+// the description.
+func ParseTodoTxt(line string) (*TodoTxt, error) {
+	r := &TodoTxt{}
+	words := strings.Fields(line)
+
+	if len(words) > 0 && words[0] == "x" {
+		r.Done = true
+		words = words[1:]
+	} else if len(words) > 0 && len(words[0]) == 3 && words[0][0] == '(' && words[0][2] == ')' && words[0][1] >= 'A' && words[0][1] <= 'Z' {
+		r.Priority = words[0][1:2]
+		words = words[1:]
+	}
+
+	if len(words) > 0 {
+		if t, ok := parseTodoTxtDate(words[0]); ok {
+			words = words[1:]
+			if r.Done {
+				r.Completed = t
+				if len(words) > 0 {
+					if t, ok = parseTodoTxtDate(words[0]); ok {
+						r.Created = t
+						words = words[1:]
+					}
+				}
+			} else {
+				r.Created = t
+			}
+		}
+	}
+
+	desc := []string{}
+	for _, word := range words {
+		switch {
+		case len(word) > 1 && word[0] == '+':
+			r.Projects = append(r.Projects, word[1:])
+		case len(word) > 1 && word[0] == '@':
+			r.Contexts = append(r.Contexts, word[1:])
+		case strings.HasPrefix(word, "due:"):
+			t, ok := parseTodoTxtDate(word[len("due:"):])
+			if !ok {
+				return nil, fmt.Errorf("invalid due date %s, expected due:YYYY-MM-DD", word)
+			}
+			r.Due = t
+		case strings.HasPrefix(word, "pri:") && len(word) == 5 && unicode.IsUpper(rune(word[4])):
+			r.Priority = word[4:]
+		default:
+			desc = append(desc, word)
+		}
+	}
+
+	r.Description = strings.Join(desc, " ")
+
+	return r, nil
+}
+
+// String formats r as a line of a todo.txt file. The priority of a completed
+// task is given by a pri tag.
+func (r *TodoTxt) String() string {
+	words := []string{}
+
+	if r.Done {
+		words = append(words, "x")
+		if r.Completed != nil {
+			words = append(words, r.Completed.Format(dayFormat))
+		}
+	} else if r.Priority != "" {
+		words = append(words, "("+r.Priority+")")
+	}
+
+	if r.Created != nil && (!r.Done || r.Completed != nil) {
+		words = append(words, r.Created.Format(dayFormat))
+	}
+
+	words = append(words, strings.Fields(r.Description)...)
+
+	for _, p := range r.Projects {
+		words = append(words, "+"+todoTxtTag(p))
+	}
+
+	for _, c := range r.Contexts {
+		words = append(words, "@"+todoTxtTag(c))
+	}
+
+	if r.Due != nil {
+		words = append(words, "due:"+r.Due.Format(dayFormat))
+	}
+
+	if r.Done && r.Priority != "" {
+		words = append(words, "pri:"+r.Priority)
+	}
+
+	return strings.Join(words, " ")
+}
+
+// todoTxtPriority returns the priority of a todo.txt priority letter; E to Z
+// are all 0.
+func todoTxtPriority(letter string) int64 {
+	if i := strings.Index(todoTxtPriorities, letter); i >= 0 {
+		return int64(i)
+	}
+	return 0
+}
+
+// NewTodoTxt returns t as a todo.txt task with the names of its project and
+// assignee. Dates are given in the time zone of t.
+func NewTodoTxt(t *Todo, project string, assignee string) *TodoTxt {
+	r := &TodoTxt{Done: t.State == state.Done, Description: t.Description}
+
+	if t.Priority >= 0 && t.Priority < int64(len(todoTxtPriorities)) {
+		r.Priority = todoTxtPriorities[t.Priority : t.Priority+1]
+	}
+
+	loc := t.Location()
+	r.Created = localTime(t.Created, loc)
+	r.Completed = localTime(t.Completed, loc)
+	r.Due = localTime(t.Due, loc)
+
+	if project != "" {
+		r.Projects = []string{project}
+	}
+
+	if assignee != "" {
+		r.Contexts = []string{assignee}
+	}
+
+	return r
+}
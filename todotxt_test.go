@@ -0,0 +1,50 @@
+package main
+
+import (
+	"github.com/davecgh/go-spew/spew"
+
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseTodoTxt(t *testing.T) {
+	date := func(month time.Month, day int) *time.Time {
+		t := time.Date(2019, month, day, 0, 0, 0, 0, time.UTC)
+		return &t
+	}
+
+	tests := []struct {
+		line     string
+		expected *TodoTxt
+	}{
+		{"(A) Call mom +family @phone", &TodoTxt{Priority: "A", Description: "Call mom", Projects: []string{"family"}, Contexts: []string{"phone"}}},
+		{"x 2019-11-10 2019-11-01 File taxes due:2019-11-15 pri:B", &TodoTxt{Done: true, Priority: "B", Completed: date(11, 10), Created: date(11, 1), Description: "File taxes", Due: date(11, 15)}},
+		{"2019-11-01 Buy milk", &TodoTxt{Created: date(11, 1), Description: "Buy milk"}},
+		{"x Done already", &TodoTxt{Done: true, Description: "Done already"}},
+		{"(a) lowercase is not a priority", &TodoTxt{Description: "(a) lowercase is not a priority"}},
+		{"Email alice@example.com about +", &TodoTxt{Description: "Email alice@example.com about +"}},
+	}
+
+	for _, test := range tests {
+		actual, err := ParseTodoTxt(test.line)
+		if err != nil {
+			t.Errorf("%s: %s", test.line, err)
+		} else if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("%s: %s != %s", test.line, spew.Sdump(actual), spew.Sdump(test.expected))
+		}
+	}
+
+	if _, err := ParseTodoTxt("Pay rent due:tomorrow"); err == nil {
+		t.Error("invalid due date accepted")
+	}
+
+	for _, line := range []string{
+		"(A) 2019-11-01 Call mom +family @phone due:2019-11-15",
+		"x 2019-11-10 2019-11-01 File taxes due:2019-11-15 pri:B",
+	} {
+		if task, err := ParseTodoTxt(line); err != nil || task.String() != line {
+			t.Errorf("%s != %s", task, line)
+		}
+	}
+}
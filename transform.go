@@ -1,6 +1,8 @@
 package main
 
 import (
+	"github.com/marcgwilson/todo/query"
+	"github.com/marcgwilson/todo/rrule"
 	"github.com/marcgwilson/todo/state"
 
 	"fmt"
@@ -10,19 +12,50 @@ import (
 
 type AttrTransform func(interface{}) (interface{}, error)
 
+// civilDate is a due date given without a time. Its day is interpreted in the
+// time zone of the todo.
+type civilDate struct {
+	year  int
+	month time.Month
+	day   int
+}
+
+// TransformDue returns datetimes in UTC, integers being nanoseconds since the
+// Unix epoch, and dates without a time as a civilDate. A nil due date removes
+// the deadline.
 func TransformDue(i interface{}) (interface{}, error) {
 	switch v := i.(type) {
+	case nil:
+		return nil, nil
 	case int:
-		return int64(v), nil
+		return time.Unix(0, int64(v)).UTC(), nil
 	case int64:
-		return v, nil
+		return time.Unix(0, v).UTC(), nil
 	case string:
-		if t, err := time.Parse(time.RFC3339Nano, v); err != nil {
-			return nil, fmt.Errorf("Invalid datetime format")
-		} else {
-			return t, nil
+		if t, err := time.Parse(time.RFC3339Nano, v); err == nil {
+			return t.UTC(), nil
+		}
+		if t, err := time.Parse(dayFormat, v); err == nil {
+			return civilDate{t.Year(), t.Month(), t.Day()}, nil
 		}
+		return nil, fmt.Errorf("Invalid datetime format")
 	case time.Time:
+		return v.UTC(), nil
+	case civilDate:
+		return v, nil
+	default:
+		return v, fmt.Errorf("Invalid type")
+	}
+}
+
+func TransformTimezone(i interface{}) (interface{}, error) {
+	switch v := i.(type) {
+	case nil:
+		return "", nil
+	case string:
+		if _, err := query.LoadLocation(v); err != nil || v == "Local" {
+			return nil, fmt.Errorf("Invalid time zone")
+		}
 		return v, nil
 	default:
 		return v, fmt.Errorf("Invalid type")
@@ -40,9 +73,85 @@ func TransformState(i interface{}) (interface{}, error) {
 	}
 }
 
+func TransformInt(i interface{}) (interface{}, error) {
+	switch v := i.(type) {
+	case nil:
+		return nil, nil
+	case int:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case float64:
+		return int64(v), nil
+	default:
+		return v, fmt.Errorf("Invalid type")
+	}
+}
+
+func TransformRecurrence(i interface{}) (interface{}, error) {
+	switch v := i.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		if v == "" {
+			return nil, nil
+		}
+		if rule, err := rrule.Parse(v); err != nil {
+			return nil, err
+		} else {
+			return rule.String(), nil
+		}
+	default:
+		return v, fmt.Errorf("Invalid type")
+	}
+}
+
+func TransformReminders(i interface{}) (interface{}, error) {
+	switch v := i.(type) {
+	case nil:
+		return []int64{}, nil
+	case []int64:
+		return v, nil
+	case []interface{}:
+		results := make([]int64, len(v), len(v))
+		for index, elem := range v {
+			if offset, err := TransformInt(elem); err != nil {
+				return nil, err
+			} else {
+				results[index] = offset.(int64)
+			}
+		}
+		return results, nil
+	default:
+		return v, fmt.Errorf("Invalid type")
+	}
+}
+
+func TransformCustomFields(i interface{}) (interface{}, error) {
+	switch v := i.(type) {
+	case nil:
+		return map[string]interface{}{}, nil
+	case map[string]interface{}:
+		return v, nil
+	default:
+		return v, fmt.Errorf("Invalid type")
+	}
+}
+
 var attrMap = map[string]AttrTransform{
-	"due":   TransformDue,
-	"state": TransformState,
+	"due":           TransformDue,
+	"timezone":      TransformTimezone,
+	"state":         TransformState,
+	"project_id":    TransformInt,
+	"parent_id":     TransformInt,
+	"recurrence":    TransformRecurrence,
+	"priority":      TransformInt,
+	"assignee_id":   TransformInt,
+	"estimate":      TransformInt,
+	"started":       TransformDue,
+	"ended":         TransformDue,
+	"reminders":     TransformReminders,
+	"custom_fields": TransformCustomFields,
 }
 
 type TodoMap map[string]interface{}
@@ -51,13 +160,22 @@ func (r TodoMap) State() state.State {
 	return state.State(r["state"].(string))
 }
 
-func (r TodoMap) Due() time.Time {
+// Due returns the due date of r, or nil when it has none or is not a time.
+func (r TodoMap) Due() *time.Time {
+	var t time.Time
+
 	switch v := r["due"].(type) {
 	case time.Time:
-		return v
+		t = v
+	case civilDate:
+		t = time.Date(v.year, v.month, v.day, 0, 0, 0, 0, time.UTC)
+	case int64:
+		t = time.Unix(0, v)
 	default:
-		return time.Unix(0, v.(int64))
+		return nil
 	}
+
+	return &t
 }
 
 func (r TodoMap) Description() string {
@@ -86,6 +204,13 @@ func Transform(data TodoMap) (TodoMap, error) {
 	return result, nil
 }
 
+// Pop removes key from r, returning its value and whether it was present.
+func (r TodoMap) Pop(key string) (interface{}, bool) {
+	v, ok := r[key]
+	delete(r, key)
+	return v, ok
+}
+
 func (r TodoMap) InsertVars() *SQLData {
 	length := len(r)
 
@@ -95,7 +220,7 @@ func (r TodoMap) InsertVars() *SQLData {
 	i := 0
 	for k, v := range r {
 		keys[i] = k
-		values[i] = v
+		values[i] = bindValue(v)
 		bindvars[i] = "?"
 		i++
 	}
@@ -109,10 +234,19 @@ func (r TodoMap) UpdateVars() *SQLData {
 	values := make([]interface{}, length, length)
 	i := 0
 	for k, v := range r {
-		values[i] = v
+		values[i] = bindValue(v)
 		bindvars[i] = fmt.Sprintf("%s = ?", k)
 		i++
 	}
 
 	return &SQLData{"", strings.Join(bindvars, ", "), values}
 }
+
+// bindValue binds times as a query.Timestamp so that they compare correctly
+// with the filters of the query package.
+func bindValue(v interface{}) interface{} {
+	if t, ok := v.(time.Time); ok {
+		return query.Timestamp(t)
+	}
+	return v
+}
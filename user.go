@@ -0,0 +1,201 @@
+package main
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+)
+
+const userColumns = "rowid, name, email"
+
+type User struct {
+	ID    int64  `db:"id" json:"id"`
+	Name  string `db:"name" json:"name"`
+	Email string `db:"email" json:"email"`
+}
+
+func (r *User) Equal(t *User) bool {
+	return *r == *t
+}
+
+type UserList []*User
+
+type UserManager struct {
+	Database *sql.DB
+}
+
+func NewUserManager(db *sql.DB) *UserManager {
+	return &UserManager{db}
+}
+
+func scanUser(s scanner) (*User, error) {
+	u := &User{}
+	if err := s.Scan(&u.ID, &u.Name, &u.Email); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+func (r *UserManager) Get(id int64) (*User, error) {
+	row := r.Database.QueryRow("SELECT "+userColumns+" FROM user WHERE rowid = ?", id)
+	return scanUser(row)
+}
+
+func (r *UserManager) GetByName(name string) (*User, error) {
+	row := r.Database.QueryRow("SELECT "+userColumns+" FROM user WHERE name = ?", name)
+	return scanUser(row)
+}
+
+// GetByFeedToken returns the user whose calendar feed is token.
+func (r *UserManager) GetByFeedToken(token string) (*User, error) {
+	if token == "" {
+		return nil, sql.ErrNoRows
+	}
+	row := r.Database.QueryRow("SELECT "+userColumns+" FROM user WHERE feed_token = ?", token)
+	return scanUser(row)
+}
+
+// ResetFeedToken gives the user a new secret calendar feed token, revoking the
+// previous one.
+func (r *UserManager) ResetFeedToken(id int64) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b)
+
+	result, err := r.Database.Exec("UPDATE user SET feed_token = ? WHERE rowid = ?;", token, id)
+	if err != nil {
+		return "", err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return "", err
+	} else if n == 0 {
+		return "", sql.ErrNoRows
+	}
+
+	return token, nil
+}
+
+func (r *UserManager) List() (UserList, error) {
+	rows, err := r.Database.Query("SELECT " + userColumns + " FROM user ORDER BY rowid;")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := UserList{}
+
+	for rows.Next() {
+		var u *User
+		if u, err = scanUser(rows); err != nil {
+			return nil, err
+		}
+		results = append(results, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+func (r *UserManager) checkName(id int64, name interface{}) error {
+	if name == nil {
+		return nil
+	}
+
+	if u, err := r.GetByName(name.(string)); err == sql.ErrNoRows {
+		return nil
+	} else if err != nil {
+		return err
+	} else if u.ID != id {
+		return ErrUserExists
+	}
+
+	return nil
+}
+
+func (r *UserManager) Create(data map[string]interface{}) (*User, error) {
+	var result sql.Result
+	var id int64
+	var err error
+
+	if err = r.checkName(0, data["name"]); err != nil {
+		return nil, err
+	}
+
+	insert := TodoMap(data).InsertVars()
+	q := fmt.Sprintf("INSERT INTO user(%s) VALUES(%s)", insert.Names, insert.Bindvars)
+
+	if result, err = r.Database.Exec(q, insert.Values...); err != nil {
+		return nil, err
+	}
+
+	if id, err = result.LastInsertId(); err != nil {
+		return nil, err
+	}
+
+	return r.Get(id)
+}
+
+func (r *UserManager) Update(id int64, data map[string]interface{}) (*User, error) {
+	if len(data) == 0 {
+		return r.Get(id)
+	}
+
+	if err := r.checkName(id, data["name"]); err != nil {
+		return nil, err
+	}
+
+	update := TodoMap(data).UpdateVars()
+	q := fmt.Sprintf("UPDATE user SET %s WHERE rowid = ?;", update.Bindvars)
+
+	if _, err := r.Database.Exec(q, append(update.Values, id)...); err != nil {
+		return nil, err
+	}
+
+	return r.Get(id)
+}
+
+// Delete removes a user, unassigns their todos, stops their running timers
+// and makes their comments and worklogs anonymous.
+func (r *UserManager) Delete(id int64) error {
+	var tx *sql.Tx
+	var err error
+
+	if tx, err = r.Database.Begin(); err != nil {
+		return err
+	}
+
+	if _, err = tx.Exec("UPDATE todo SET assignee_id = NULL WHERE assignee_id = ?;", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec("UPDATE comment SET author_id = NULL WHERE author_id = ?;", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec("UPDATE worklog SET ended = ? WHERE user_id = ? AND ended IS NULL;", time.Now().UTC(), id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec("UPDATE worklog SET user_id = NULL WHERE user_id = ?;", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err = tx.Exec("DELETE FROM user WHERE rowid = ?;", id); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/apierror"
+	"github.com/marcgwilson/todo/query"
+	"github.com/marcgwilson/todo/state"
+
+	"database/sql"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// UserHeader names the request header that identifies the calling user.
+const UserHeader = "X-User"
+
+// TimezoneHeader names the request header that sets the time zone of relative
+// dates when the tz query parameter is absent.
+const TimezoneHeader = "X-Timezone"
+
+// caller returns the user named by the X-User header, or nil when the header
+// is absent.
+func (r *Handler) caller(req *http.Request) (*User, *apierror.Error) {
+	name := req.Header.Get(UserHeader)
+	if name == "" {
+		return nil, nil
+	}
+
+	if u, err := r.UM.GetByName(name); err == sql.ErrNoRows {
+		return nil, &apierror.Error{Code: http.StatusUnauthorized, Message: "Unknown user: " + name}
+	} else if err != nil {
+		return nil, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()}
+	} else {
+		return u, nil
+	}
+}
+
+// callerID returns the id of the caller, or nil for anonymous requests and
+// unknown users.
+func (r *Handler) callerID(req *http.Request) *int64 {
+	if u, _ := r.caller(req); u != nil {
+		return &u.ID
+	}
+	return nil
+}
+
+// requireCaller is caller for requests that must identify a user.
+func (r *Handler) requireCaller(req *http.Request) (*User, *apierror.Error) {
+	u, ae := r.caller(req)
+	if ae == nil && u == nil {
+		ae = &apierror.Error{Code: http.StatusUnauthorized, Message: "The " + UserHeader + " header is required"}
+	}
+	return u, ae
+}
+
+// parseQuery parses the todo filters of req, substituting the caller's id for
+// assignee=me and leaving out archived todos unless archived is given.
+func (r *Handler) parseQuery(req *http.Request) (*query.QueryParams, *apierror.Error) {
+	return r.parseValues(req, req.URL.Query())
+}
+
+// parseValues is parseQuery for filters other than those in the URL of req.
+func (r *Handler) parseValues(req *http.Request, values url.Values) (*query.QueryParams, *apierror.Error) {
+	if tz := req.Header.Get(TimezoneHeader); tz != "" && values.Get("tz") == "" {
+		values.Set("tz", tz)
+	}
+
+	if values.Get("archived") == "" {
+		values.Set("archived", "false")
+	}
+
+	if values.Get("undated") == "" && r.Config.Undated != "" {
+		values.Set("undated", r.Config.Undated)
+	}
+
+	for i, value := range values["assignee"] {
+		if value != "me" {
+			continue
+		}
+
+		u, ae := r.caller(req)
+		if ae != nil {
+			return nil, ae
+		}
+
+		if u == nil {
+			return nil, &apierror.Error{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid query parameters",
+				Errors: []*apierror.ErrorDetail{
+					&apierror.ErrorDetail{Key: "assignee", Value: value, Message: "the " + UserHeader + " header is required"},
+				},
+			}
+		}
+
+		values["assignee"][i] = strconv.FormatInt(u.ID, 10)
+	}
+
+	if ae := r.checkCustomFieldFilters(values); ae != nil {
+		return nil, ae
+	}
+
+	return query.ParseValues(values)
+}
+
+// checkCustomFieldFilters rejects cf. filters naming unknown fields.
+func (r *Handler) checkCustomFieldFilters(values url.Values) *apierror.Error {
+	var fields FieldList
+
+	for key, v := range values {
+		name, ok := query.CustomFieldName(key)
+		if !ok {
+			continue
+		}
+
+		if fields == nil {
+			var err error
+			if fields, err = r.FM.List(); err != nil {
+				return &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()}
+			}
+		}
+
+		found := false
+		for _, f := range fields {
+			if f.Name == name {
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return &apierror.Error{
+				Code:    http.StatusBadRequest,
+				Message: "Invalid query parameters",
+				Errors: []*apierror.ErrorDetail{
+					&apierror.ErrorDetail{Key: key, Value: v[0], Message: "unknown custom field"},
+				},
+			}
+		}
+	}
+
+	return nil
+}
+
+// autoAssign assigns the caller to an unassigned todo moving to in_progress
+// when Config.AutoAssign is set.
+func (r *Handler) autoAssign(req *http.Request, id int64, data TodoMap) *apierror.Error {
+	if !r.Config.AutoAssign || data["state"] != string(state.InProgress) {
+		return nil
+	}
+
+	if _, ok := data["assignee_id"]; ok {
+		return nil
+	}
+
+	u, ae := r.caller(req)
+	if ae != nil || u == nil {
+		return ae
+	}
+
+	if t, err := r.TM.Get(id); err == nil && t.AssigneeID == nil {
+		data["assignee_id"] = u.ID
+	}
+
+	return nil
+}
+
+// defaultTimezone gives a new todo the caller's time zone, from the tz query
+// parameter or the X-Timezone header, unless it names one itself.
+func (r *Handler) defaultTimezone(req *http.Request, data TodoMap) *apierror.Error {
+	if _, ok := data["timezone"]; ok {
+		return nil
+	}
+
+	loc, ae := r.location(req)
+	if ae != nil {
+		return ae
+	}
+
+	if loc != time.UTC {
+		data["timezone"] = loc.String()
+	}
+
+	return nil
+}
+
+func (r *Handler) UserListFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if list, err := r.UM.List(); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+		} else {
+			writeJSON(w, http.StatusOK, list)
+		}
+	}
+}
+
+func (r *Handler) UserCreateFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		var data TodoMap
+		var ae *apierror.Error
+
+		if data, ae = UnmarshalJSONRequest(req); ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		if ae = validate(r.UserCreateValidator, data); ae != nil {
+			writeError(w, ae)
+		} else if u, err := r.UM.Create(data); err != nil {
+			writeError(w, managerError(err))
+		} else {
+			writeJSON(w, http.StatusCreated, u)
+		}
+	}
+}
+
+func (r *Handler) UserRetrieveFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		if u, err := r.UM.Get(pathID(req, "id")); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+		} else {
+			writeJSON(w, http.StatusOK, u)
+		}
+	}
+}
+
+func (r *Handler) UserUpdateFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		id := pathID(req, "id")
+
+		var data TodoMap
+		var ae *apierror.Error
+
+		if _, err := r.UM.Get(id); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+			return
+		}
+
+		if data, ae = UnmarshalJSONRequest(req); ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		if ae = validate(r.UserUpdateValidator, data); ae != nil {
+			writeError(w, ae)
+		} else if u, err := r.UM.Update(id, data); err != nil {
+			writeError(w, managerError(err))
+		} else {
+			writeJSON(w, http.StatusOK, u)
+		}
+	}
+}
+
+// UserDeleteFunc deletes a user. Todos assigned to the user become unassigned.
+func (r *Handler) UserDeleteFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		id := pathID(req, "id")
+
+		if _, err := r.UM.Get(id); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+			return
+		}
+
+		if err := r.UM.Delete(id); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/ical"
+	"github.com/marcgwilson/todo/state"
+
+	"fmt"
+	"strings"
+	"time"
+)
+
+const calendarProductID = "-//marcgwilson//todo//EN"
+
+var vtodoStatus = map[state.State]string{
+	state.Todo:       "NEEDS-ACTION",
+	state.InProgress: "IN-PROCESS",
+	state.Done:       "COMPLETED",
+}
+
+// vtodoPriority maps priorities, 4 being the most urgent, to iCalendar
+// priorities, 1 being the most urgent.
+var vtodoPriority = map[int64]string{
+	4: "1",
+	3: "3",
+	2: "5",
+	1: "7",
+	0: "9",
+}
+
+// todoUID returns the iCalendar UID of the todo id, which does not change
+// when the todo does.
+func todoUID(id int64) string {
+	return fmt.Sprintf("todo-%d@todo", id)
+}
+
+func newCalendar() *ical.Component {
+	cal := ical.NewComponent("VCALENDAR")
+	cal.Add("VERSION", "2.0")
+	cal.Add("PRODID", calendarProductID)
+	cal.Add("CALSCALE", "GREGORIAN")
+	return cal
+}
+
+// vtodo returns t as a VTODO with the given UID and DTSTAMP. The due date of
+// an all-day todo is given as a date in its time zone.
+func vtodo(t *Todo, uid string, stamp time.Time) *ical.Component {
+	c := ical.NewComponent("VTODO")
+	c.Add("UID", uid)
+	c.AddTime("DTSTAMP", stamp)
+	c.AddText("SUMMARY", t.Description)
+	c.Add("STATUS", vtodoStatus[t.State])
+
+	if p, ok := vtodoPriority[t.Priority]; ok {
+		c.Add("PRIORITY", p)
+	}
+
+	if t.Due != nil {
+		if t.AllDay {
+			c.Add("DUE", t.Due.In(t.Location()).Format(ical.DateFormat), "VALUE", "DATE")
+		} else {
+			c.AddTime("DUE", *t.Due)
+		}
+	}
+
+	if t.Recurrence != "" {
+		c.Add("RRULE", strings.TrimPrefix(strings.TrimSpace(t.Recurrence), "RRULE:"))
+	}
+
+	if t.Created != nil {
+		c.AddTime("CREATED", *t.Created)
+	}
+
+	if t.Completed != nil {
+		c.AddTime("COMPLETED", *t.Completed)
+	}
+
+	if t.Modified != nil {
+		c.AddTime("LAST-MODIFIED", *t.Modified)
+	} else if t.Created != nil {
+		c.AddTime("LAST-MODIFIED", *t.Created)
+	}
+
+	return c
+}
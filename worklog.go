@@ -0,0 +1,273 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/state"
+
+	"github.com/mattn/go-sqlite3"
+
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrTimerRunning  = errors.New("user already has a running timer")
+	ErrNoTimer       = errors.New("no running timer for this todo")
+	ErrWorklogRange  = errors.New("worklog must end after it starts")
+	ErrWorklogActive = errors.New("running timers must be stopped, not edited")
+)
+
+const worklogColumns = "rowid, todo_id, user_id, started, ended, note"
+
+// Worklog is a span of work on a todo. Ended is nil while its timer runs.
+type Worklog struct {
+	ID      int64      `db:"id" json:"id"`
+	TodoID  int64      `db:"todo_id" json:"todo_id"`
+	UserID  *int64     `db:"user_id" json:"user_id"`
+	Started time.Time  `db:"started" json:"started"`
+	Ended   *time.Time `db:"ended" json:"ended"`
+	Note    string     `db:"note" json:"note"`
+	// Duration is the length of the entry in seconds, up to now for running
+	// timers.
+	Duration int64 `db:"-" json:"duration"`
+}
+
+type WorklogList []*Worklog
+
+func scanWorklog(s scanner) (*Worklog, error) {
+	var userID sql.NullInt64
+	var ended sql.NullTime
+
+	w := &Worklog{}
+	if err := s.Scan(&w.ID, &w.TodoID, &userID, &w.Started, &ended, &w.Note); err != nil {
+		return nil, err
+	}
+
+	if userID.Valid {
+		w.UserID = &userID.Int64
+	}
+
+	if ended.Valid {
+		w.Ended = &ended.Time
+		w.Duration = int64(ended.Time.Sub(w.Started) / time.Second)
+	} else {
+		w.Duration = int64(time.Since(w.Started) / time.Second)
+	}
+
+	return w, nil
+}
+
+func (r *TodoManager) Worklog(todoID int64, id int64) (*Worklog, error) {
+	row := r.Database.QueryRow("SELECT "+worklogColumns+" FROM worklog WHERE rowid = ? AND todo_id = ?", id, todoID)
+	return scanWorklog(row)
+}
+
+func (r *TodoManager) Worklogs(todoID int64) (WorklogList, error) {
+	rows, err := r.Database.Query("SELECT "+worklogColumns+" FROM worklog WHERE todo_id = ? ORDER BY started, rowid", todoID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	results := WorklogList{}
+
+	for rows.Next() {
+		var w *Worklog
+		if w, err = scanWorklog(rows); err != nil {
+			return nil, err
+		}
+		results = append(results, w)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// StartTimer starts a timer on the todo identified by id for user. A user can
+// only run one timer at a time, which the worklog_running index enforces
+// against concurrent starts. With Config.TimerStartsTodo a todo in state todo
+// moves to in_progress.
+func (r *TodoManager) StartTimer(id int64, user int64) (*Worklog, error) {
+	var running int64
+
+	row := r.Database.QueryRow("SELECT COUNT(*) FROM worklog WHERE user_id = ? AND ended IS NULL", user)
+	if err := row.Scan(&running); err != nil {
+		return nil, err
+	}
+
+	if running > 0 {
+		return nil, ErrTimerRunning
+	}
+
+	if r.Config.TimerStartsTodo {
+		if t, err := r.Get(id); err != nil {
+			return nil, err
+		} else if t.State == state.Todo {
+			if _, err = r.Update(id, map[string]interface{}{"state": state.InProgress}); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	w, err := r.insertWorklog(id, &user, time.Now().UTC(), nil, "")
+	if e, ok := err.(sqlite3.Error); ok && e.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return nil, ErrTimerRunning
+	}
+	return w, err
+}
+
+// StopTimer ends the running timer of user on the todo identified by id.
+func (r *TodoManager) StopTimer(id int64, user int64) (*Worklog, error) {
+	result, err := r.Database.Exec("UPDATE worklog SET ended = ? WHERE todo_id = ? AND user_id = ? AND ended IS NULL;",
+		time.Now().UTC(), id, user)
+	if err != nil {
+		return nil, err
+	}
+
+	if n, err := result.RowsAffected(); err != nil {
+		return nil, err
+	} else if n == 0 {
+		return nil, ErrNoTimer
+	}
+
+	row := r.Database.QueryRow("SELECT "+worklogColumns+" FROM worklog WHERE todo_id = ? AND user_id = ? ORDER BY ended DESC LIMIT 1", id, user)
+	return scanWorklog(row)
+}
+
+// CreateWorklog records finished work on the todo identified by id.
+func (r *TodoManager) CreateWorklog(id int64, user *int64, data map[string]interface{}) (*Worklog, error) {
+	d, err := Transform(data)
+	if err != nil {
+		return nil, err
+	}
+
+	started := d["started"].(time.Time)
+	ended := d["ended"].(time.Time)
+
+	if !ended.After(started) {
+		return nil, ErrWorklogRange
+	}
+
+	note, _ := d["note"].(string)
+
+	return r.insertWorklog(id, user, started.UTC(), &ended, note)
+}
+
+func (r *TodoManager) insertWorklog(id int64, user *int64, started time.Time, ended *time.Time, note string) (*Worklog, error) {
+	var end interface{}
+	if ended != nil {
+		end = ended.UTC()
+	}
+
+	result, err := r.Database.Exec("INSERT INTO worklog(todo_id, user_id, started, ended, note) VALUES(?, ?, ?, ?, ?);",
+		id, user, started, end, note)
+	if err != nil {
+		return nil, err
+	}
+
+	wid, err := result.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	return r.Worklog(id, wid)
+}
+
+func (r *TodoManager) UpdateWorklog(w *Worklog, data map[string]interface{}) (*Worklog, error) {
+	if w.Ended == nil {
+		return nil, ErrWorklogActive
+	}
+
+	d, err := Transform(data)
+	if err != nil {
+		return nil, err
+	}
+
+	started, ended := w.Started, *w.Ended
+
+	if v, ok := d["started"]; ok {
+		started = v.(time.Time).UTC()
+		d["started"] = started
+	}
+
+	if v, ok := d["ended"]; ok {
+		ended = v.(time.Time).UTC()
+		d["ended"] = ended
+	}
+
+	if !ended.After(started) {
+		return nil, ErrWorklogRange
+	}
+
+	if len(d) == 0 {
+		return w, nil
+	}
+
+	update := d.UpdateVars()
+	q := fmt.Sprintf("UPDATE worklog SET %s WHERE rowid = ?;", update.Bindvars)
+
+	if _, err = r.Database.Exec(q, append(update.Values, w.ID)...); err != nil {
+		return nil, err
+	}
+
+	return r.Worklog(w.TodoID, w.ID)
+}
+
+func (r *TodoManager) DeleteWorklog(w *Worklog) error {
+	_, err := r.Database.Exec("DELETE FROM worklog WHERE rowid = ?;", w.ID)
+	return err
+}
+
+// loadTimeSpent sets TimeSpent on every todo in list to the seconds logged on
+// it and all of its descendants, counting running timers up to now.
+func (r *TodoManager) loadTimeSpent(list TodoList) error {
+	if len(list) == 0 {
+		return nil
+	}
+
+	index := map[int64]*Todo{}
+	ids := make([]int64, len(list), len(list))
+	for i, t := range list {
+		t.TimeSpent = 0
+		index[t.ID] = t
+		ids[i] = t.ID
+	}
+
+	bindvars, values := inClause(ids)
+
+	rows, err := r.Database.Query(`WITH RECURSIVE tree(root, id) AS (
+    SELECT rowid, rowid FROM todo WHERE rowid IN `+bindvars+`
+    UNION ALL
+    SELECT tree.root, todo.rowid FROM todo JOIN tree ON todo.parent_id = tree.id
+)
+SELECT tree.root, w.started, w.ended FROM tree JOIN worklog w ON w.todo_id = tree.id`, values...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+
+	for rows.Next() {
+		var root int64
+		var started time.Time
+		var ended sql.NullTime
+
+		if err = rows.Scan(&root, &started, &ended); err != nil {
+			return err
+		}
+
+		end := now
+		if ended.Valid {
+			end = ended.Time
+		}
+
+		index[root].TimeSpent += int64(end.Sub(started) / time.Second)
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"github.com/marcgwilson/todo/apierror"
+
+	"net/http"
+)
+
+func (r *Handler) TimerStartFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		id := pathID(req, "id")
+
+		if _, err := r.TM.Get(id); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+			return
+		}
+
+		u, ae := r.requireCaller(req)
+		if ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		if wl, err := r.TM.StartTimer(id, u.ID); err != nil {
+			writeError(w, managerError(err))
+		} else {
+			writeJSON(w, http.StatusCreated, wl)
+		}
+	}
+}
+
+func (r *Handler) TimerStopFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		id := pathID(req, "id")
+
+		if _, err := r.TM.Get(id); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+			return
+		}
+
+		u, ae := r.requireCaller(req)
+		if ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		if wl, err := r.TM.StopTimer(id, u.ID); err != nil {
+			writeError(w, managerError(err))
+		} else {
+			writeJSON(w, http.StatusOK, wl)
+		}
+	}
+}
+
+func (r *Handler) WorklogListFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		id := pathID(req, "id")
+
+		if _, err := r.TM.Get(id); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+		} else if list, err := r.TM.Worklogs(id); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+		} else {
+			writeJSON(w, http.StatusOK, list)
+		}
+	}
+}
+
+// WorklogCreateFunc records finished work by the caller, or anonymous work
+// when the request has no X-User header.
+func (r *Handler) WorklogCreateFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		id := pathID(req, "id")
+
+		var data TodoMap
+		var ae *apierror.Error
+		var u *User
+
+		if _, err := r.TM.Get(id); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"})
+			return
+		}
+
+		if u, ae = r.caller(req); ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		if data, ae = UnmarshalJSONRequest(req); ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		var userID *int64
+		if u != nil {
+			userID = &u.ID
+		}
+
+		if ae = validate(r.WorklogCreateValidator, data); ae != nil {
+			writeError(w, ae)
+		} else if wl, err := r.TM.CreateWorklog(id, userID, data); err != nil {
+			writeError(w, managerError(err))
+		} else {
+			writeJSON(w, http.StatusCreated, wl)
+		}
+	}
+}
+
+// worklog looks up the worklog addressed by req and checks that the caller
+// may change it: worklogs with a user can only be changed by that user.
+func (r *Handler) worklog(req *http.Request) (*Worklog, *apierror.Error) {
+	wl, err := r.TM.Worklog(pathID(req, "id"), pathID(req, "wid"))
+	if err != nil {
+		return nil, &apierror.Error{Code: http.StatusNotFound, Message: "Not found"}
+	}
+
+	u, ae := r.caller(req)
+	if ae != nil {
+		return nil, ae
+	}
+
+	if wl.UserID != nil && (u == nil || u.ID != *wl.UserID) {
+		return nil, &apierror.Error{Code: http.StatusForbidden, Message: "Only the user who logged work can change it"}
+	}
+
+	return wl, nil
+}
+
+func (r *Handler) WorklogUpdateFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		var data TodoMap
+		var wl *Worklog
+		var ae *apierror.Error
+
+		if wl, ae = r.worklog(req); ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		if data, ae = UnmarshalJSONRequest(req); ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		if ae = validate(r.WorklogUpdateValidator, data); ae != nil {
+			writeError(w, ae)
+		} else if wl, err := r.TM.UpdateWorklog(wl, data); err != nil {
+			writeError(w, managerError(err))
+		} else {
+			writeJSON(w, http.StatusOK, wl)
+		}
+	}
+}
+
+func (r *Handler) WorklogDeleteFunc() http.HandlerFunc {
+	return func(w http.ResponseWriter, req *http.Request) {
+		defer req.Body.Close()
+
+		wl, ae := r.worklog(req)
+		if ae != nil {
+			writeError(w, ae)
+			return
+		}
+
+		if err := r.TM.DeleteWorklog(wl); err != nil {
+			writeError(w, &apierror.Error{Code: http.StatusInternalServerError, Message: err.Error()})
+		} else {
+			w.WriteHeader(http.StatusNoContent)
+		}
+	}
+}